@@ -6,6 +6,7 @@ import (
 
 	"github.com/Lyianu/sdfs/log"
 	"github.com/Lyianu/sdfs/master"
+	"github.com/Lyianu/sdfs/pkg/settings"
 )
 
 func main() {
@@ -13,6 +14,7 @@ func main() {
 	connect := flag.String("c", "", "specify a server to connect")
 	listen := flag.String("l", ":8080", "listen address")
 	addr := flag.String("a", "", "server address")
+	flag.StringVar(&settings.MetaStorePath, "m", settings.MetaStorePath, "metadata store file, empty to keep metadata in memory")
 	flag.Parse()
 
 	m, err := master.NewMaster(*listen, *connect, *addr)
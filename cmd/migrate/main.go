@@ -0,0 +1,24 @@
+// main.go generates binary that migrates the hashstore of a node to the
+// current on-disk layout, the node should not be running
+package main
+
+import (
+	"flag"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+func main() {
+	log.SetLevel(log.INFO)
+	dir := flag.String("d", settings.DataPathPrefix, "hashstore directory of the node")
+	flag.Parse()
+
+	n, err := sdfs.MigrateLayout(*dir)
+	if err != nil {
+		log.Errorf("failed to migrate %s after moving %d objects, error: %q", *dir, n, err)
+		return
+	}
+	log.Infof("migrated %s to layout version %d, %d objects moved", *dir, sdfs.LayoutVersion, n)
+}
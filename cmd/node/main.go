@@ -2,9 +2,12 @@ package main
 
 import (
 	"flag"
+	"strings"
 
 	"github.com/Lyianu/sdfs/log"
 	"github.com/Lyianu/sdfs/node"
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/sdfs"
 )
 
 func main() {
@@ -12,8 +15,21 @@ func main() {
 	master = flag.String("m", "", "master address(including port)")
 	addr = flag.String("a", "", "node address")
 	port = flag.String("p", "8080", "node port")
+	dirs := flag.String("d", settings.DataPathPrefix, "comma separated data directories, each on a disk of its own")
+	flag.StringVar(&settings.HashIndexPath, "i", settings.HashIndexPath, "hashstore index file, empty to rebuild the hashstore from data on start")
+	flag.StringVar(&settings.KeyFile, "k", settings.KeyFile, "keyfile with the master keys new objects are encrypted with, created if it does not exist, empty to store them unencrypted")
+	flag.StringVar(&settings.Compression, "z", settings.Compression, "codec to compress new objects with: gzip, empty to store them as they are")
 	flag.Parse()
+	settings.DataDirs = strings.Split(*dirs, ",")
 	log.SetLevel(log.DEBUG)
-	n := node.NewNode(*port, *master, *addr)
+	if err := sdfs.CheckCodec(settings.Compression); err != nil {
+		log.Errorf("invalid compression: %q", err)
+		return
+	}
+	n, err := node.NewNode(*port, *master, *addr)
+	if err != nil {
+		log.Errorf("failed to create Node, error: %q", err)
+		return
+	}
 	n.Start()
 }
@@ -0,0 +1,60 @@
+// main.go generates binary that rotates the master key of the hashstore of
+// a node and encrypts its objects with it, the node should not be running
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/pkg/kms"
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+func main() {
+	log.SetLevel(log.INFO)
+	dirs := flag.String("d", settings.DataPathPrefix, "comma separated data directories of the node")
+	index := flag.String("i", settings.HashIndexPath, "hashstore index file of the node, empty if it has none")
+	keyfile := flag.String("k", settings.KeyFile, "keyfile of the node")
+	rotate := flag.Bool("rotate", false, "add a new master key and wrap the data keys of all objects with it")
+	reencrypt := flag.Bool("reencrypt", false, "encrypt every object again under a new data key")
+	flag.Parse()
+	if *keyfile == "" {
+		log.Errorf("no keyfile")
+		return
+	}
+
+	keys, err := kms.OpenFile(*keyfile)
+	if err != nil {
+		log.Errorf("failed to open keyfile %s, error: %q", *keyfile, err)
+		return
+	}
+	if *rotate {
+		id, err := keys.Rotate()
+		if err != nil {
+			log.Errorf("failed to rotate master key, error: %q", err)
+			return
+		}
+		log.Infof("rotated master key to %s", id)
+	}
+	var idx sdfs.MetaStore
+	if *index != "" {
+		if idx, err = sdfs.OpenHashIndex(*index); err != nil {
+			log.Errorf("failed to open index %s, error: %q", *index, err)
+			return
+		}
+	}
+	hs, err := sdfs.OpenEncryptedHashStore(strings.Split(*dirs, ","), idx, keys)
+	if err != nil {
+		log.Errorf("failed to open hashstore, error: %q", err)
+		return
+	}
+	defer hs.Close()
+	n, err := hs.Rekey(*reencrypt)
+	if err != nil {
+		log.Errorf("failed to rekey hashstore after %d objects, error: %q", n, err)
+		return
+	}
+	log.Infof("rekeyed %d objects with master key %s", n, keys.Current())
+}
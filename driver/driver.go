@@ -1,9 +1,11 @@
 package driver
 
 import (
-	"bytes"
-	"fmt"
+	"crypto/sha256"
+	"hash"
 	"io"
+
+	"github.com/Lyianu/sdfs/sdfs"
 )
 
 type File struct {
@@ -17,10 +19,21 @@ type File struct {
 	location string
 	bufSize  int64
 	buf      []byte
+	// bufStart is the file-wise offset of buf, bufLen the number of bytes
+	// in it
+	bufStart int64
+	bufLen   int64
+
+	// digest is the hash the node sent in Repr-Digest, sum hashes the
+	// content read in order from the start of the file up to summed
+	digest string
+	sum    hash.Hash
+	summed int64
 }
 
 func newFile(bufSize int64, location string) *File {
-	return &File{f_offset: 0, f_pos: 0, buf: make([]byte, bufSize), location: location, bufSize: bufSize}
+	// the size is unknown until the buffer is filled
+	return &File{f_offset: 0, f_pos: 0, f_size: -1, buf: make([]byte, bufSize), location: location, bufSize: bufSize, sum: sha256.New()}
 }
 
 func (f *File) Close() error {
@@ -39,35 +52,62 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekCurrent:
 		f.f_offset += offset
 	case io.SeekEnd:
-		f.f_offset = f.f_size
+		f.f_offset = f.f_size + offset
+	}
+	if f.f_offset >= f.bufStart && f.f_offset < f.bufStart+f.bufLen {
+		f.f_pos = f.f_offset - f.bufStart
+	} else {
+		// the buffer is filled again on the next read
+		f.f_pos = f.bufLen
 	}
-	// TODO: check if offset is buffered
-	f.flushBuffer()
-	return f.f_pos, nil
+	return f.f_offset, nil
 }
+
+// Read reads from the file, reading it in order from the start to the end
+// verifies the content against the digest the node sent, the last read
+// fails with an error matching sdfs.ErrChecksumMismatch if it does not match
 func (f *File) Read(p []byte) (n int, err error) {
-	r := bytes.NewReader(f.buf[f.f_pos:])
-	i, err := io.Copy(bytes.NewBuffer(p), r)
-	if err != nil {
-		return 0, err
+	if f.f_pos >= f.bufLen && (f.f_size < 0 || f.f_offset < f.f_size) {
+		if _, err := f.flushBuffer(); err != nil {
+			return 0, err
+		}
+		if f.bufLen == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	if f.f_offset >= f.f_size {
+		return 0, io.EOF
 	}
-	f.f_pos += i
-	f.f_offset += i
-	if f.f_pos >= f.bufSize*3/4 {
-		f.flushBuffer()
+	n = copy(p, f.buf[f.f_pos:f.bufLen])
+	err = f.verify(p[:n])
+	f.f_pos += int64(n)
+	f.f_offset += int64(n)
+	return n, err
+}
+
+// verify hashes p, the bytes read at f.f_offset, if they continue the
+// content hashed so far, the content is checked once the end is hashed
+func (f *File) verify(p []byte) error {
+	if f.digest == "" || f.f_offset != f.summed {
+		return nil
+	}
+	f.sum.Write(p)
+	f.summed += int64(len(p))
+	if f.summed == f.f_size && sdfs.SumHash(f.sum.Sum(nil)) != f.digest {
+		return sdfs.NewError(sdfs.ErrChecksumMismatch, "content of %s does not match its digest %s", f.location, f.digest)
 	}
-	fmt.Printf("READ %d bytes\n", i)
-	return 0, nil
+	return nil
 }
 
 func Open(path, svr string) (*File, error) {
-	url := getFileDownloadLink(path, svr)
-	fmt.Println(url)
-	if url == "" {
-		return nil, fmt.Errorf("failed to get download link")
+	url, err := getFileDownloadLink(path, svr)
+	if err != nil {
+		return nil, err
 	}
 	f := newFile(1024*1024, url)
-	f.flushBuffer()
+	if _, err := f.flushBuffer(); err != nil {
+		return nil, err
+	}
 	return f, nil
 }
 
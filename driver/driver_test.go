@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// serve starts a server that acts as both the master and the node, it
+// sends content with the digest of want
+func serve(t *testing.T, content, want string) string {
+	sum := sha256.Sum256([]byte(want))
+	var addr string
+	mux := http.NewServeMux()
+	mux.HandleFunc(settings.URLSDFSDownload, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "http://"+addr+"/file")
+	})
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(sdfs.HeaderReprDigest, sdfs.ReprDigest(sdfs.SumHash(sum[:])))
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	})
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	addr = strings.TrimPrefix(s.URL, "http://")
+	return addr
+}
+
+func TestFileRead(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	svr := serve(t, content, content)
+	f, err := Open("/a", svr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(f); err != nil || string(b) != content {
+		t.Errorf("want content read, have: %q, %v", b, err)
+	}
+
+	// a small buffer is filled many times, seeks within it are served from
+	// it
+	url, _ := getFileDownloadLink("/a", svr)
+	f = newFile(16, url)
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, f, 20); err != nil {
+		t.Fatal(err)
+	}
+	f.Seek(18, io.SeekStart)
+	if _, err := io.Copy(&buf, f); err != nil {
+		t.Errorf("want rest of content read, have: %v", err)
+	}
+	if want := content[:20] + content[18:]; buf.String() != want {
+		t.Errorf("want %q, have: %q", want, buf.String())
+	}
+}
+
+func TestFileReadCorrupt(t *testing.T) {
+	svr := serve(t, "corrupt content", "the content")
+	f, err := Open("/a", svr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(f); !errors.Is(err, sdfs.ErrChecksumMismatch) {
+		t.Errorf("want ErrChecksumMismatch, have: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// ErrNotLeader is matched by errors from masters that are not the leader,
+// Error.Leader tells the address of the leader if known
+var ErrNotLeader = errors.New("not leader")
+
+// codeErrors maps error codes in master responses to Go errors
+var codeErrors = map[string]error{
+	"not_found":           sdfs.ErrNotFound,
+	"already_exists":      sdfs.ErrAlreadyExists,
+	"conflict":            sdfs.ErrConflict,
+	"busy":                sdfs.ErrBusy,
+	"not_leader":          ErrNotLeader,
+	"no_capacity":         sdfs.ErrNoCapacity,
+	"invalid_path":        sdfs.ErrInvalidPath,
+	"invalid_argument":    sdfs.ErrInvalidArgument,
+	"precondition_failed": sdfs.ErrPreconditionFailed,
+	"checksum_mismatch":   sdfs.ErrChecksumMismatch,
+}
+
+// Error is an error returned by SDFS, it matches the error of its code with
+// errors.Is, e.g. errors.Is(err, sdfs.ErrNotFound)
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Leader     string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("sdfs: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+func (e *Error) Unwrap() error {
+	return codeErrors[e.Code]
+}
+
+// decodeError reads the error body of a failed response, bodies that are not
+// JSON error bodies are kept as the message
+func decodeError(resp *http.Response) error {
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	body := struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+			Leader  string `json:"leader"`
+		} `json:"error"`
+	}{}
+	e := &Error{StatusCode: resp.StatusCode}
+	if err := json.Unmarshal(b, &body); err == nil && body.Error.Code != "" {
+		e.Code = body.Error.Code
+		e.Message = body.Error.Message
+		e.Leader = body.Error.Leader
+	} else {
+		e.Message = string(b)
+	}
+	return e
+}
@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+func TestDecodeError(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Body:       io.NopCloser(strings.NewReader(`{"error":{"code":"not_found","message":"file not exist"}}`)),
+	}
+	err := decodeError(resp)
+	if !errors.Is(err, sdfs.ErrNotFound) {
+		t.Errorf("want error matching sdfs.ErrNotFound, have: %v", err)
+	}
+	resp = &http.Response{
+		StatusCode: http.StatusTemporaryRedirect,
+		Body:       io.NopCloser(strings.NewReader(`{"error":{"code":"not_leader","message":"not leader","leader":"m1:9000"}}`)),
+	}
+	err = decodeError(resp)
+	var e *Error
+	if !errors.Is(err, ErrNotLeader) || !errors.As(err, &e) || e.Leader != "m1:9000" {
+		t.Errorf("want not leader error with leader m1:9000, have: %v", err)
+	}
+	resp = &http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Body:       io.NopCloser(strings.NewReader("Internal Server Error")),
+	}
+	if err := decodeError(resp); !errors.As(err, &e) || e.Message != "Internal Server Error" {
+		t.Errorf("want plain message kept, have: %v", err)
+	}
+}
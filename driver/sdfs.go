@@ -4,33 +4,39 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
 
 	"github.com/Lyianu/sdfs/log"
 	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/sdfs"
 )
 
-func getFileDownloadLink(path, svr string) string {
-	url := settings.URLSDFSScheme + svr + settings.URLSDFSDownload + "?path=" + path
+func getFileDownloadLink(path, svr string) (string, error) {
+	url := settings.URLSDFSScheme + svr + settings.URLSDFSDownload + "?path=" + neturl.QueryEscape(path)
 	//fmt.Println(url)
 	resp, err := http.Get(url)
 	if err != nil {
 		log.Errorf("failed to get download link: %s", err)
-		return ""
+		return "", err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return ""
+		return "", decodeError(resp)
 	}
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Errorf("failed to read master response: %s", err)
-		return ""
+		return "", err
 	}
-	return string(b)
+	return string(b), nil
 }
 
+// flushBuffer fills the buffer with the content at f.f_offset, the size of
+// the file and its digest are taken from the response
 func (f *File) flushBuffer() (int64, error) {
-	fmt.Printf("flushing buffer: %d\n", f.f_offset)
+	log.Debugf("flushing buffer: %d", f.f_offset)
 	req, err := http.NewRequest("GET", f.location, nil)
 	if err != nil {
 		return 0, err
@@ -41,13 +47,36 @@ func (f *File) flushBuffer() (int64, error) {
 		return 0, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// Content-Range: bytes <start>-<end>/<size>
+		cr := resp.Header.Get("Content-Range")
+		size, err := strconv.ParseInt(cr[strings.LastIndex(cr, "/")+1:], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Content-Range from server: %s", cr)
+		}
+		f.f_size = size
+	case http.StatusOK:
+		// the whole file is sent
+		if f.f_offset != 0 {
+			return 0, fmt.Errorf("server ignored range request")
+		}
+		f.f_size = resp.ContentLength
+	default:
 		return 0, fmt.Errorf("failed to get file from server: %s", resp.Status)
 	}
+	if hash, ok := sdfs.ParseDigest(resp.Header.Get(sdfs.HeaderReprDigest)); ok {
+		if f.digest != "" && f.digest != hash {
+			return 0, sdfs.NewError(sdfs.ErrChecksumMismatch, "digest of %s changed from %s to %s", f.location, f.digest, hash)
+		}
+		f.digest = hash
+	}
 	n, err := io.ReadFull(resp.Body, f.buf)
-	if err != nil {
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
 		return 0, err
 	}
 	f.f_pos = 0
+	f.bufStart = f.f_offset
+	f.bufLen = int64(n)
 	return int64(n), nil
 }
@@ -4,6 +4,7 @@ go 1.18
 
 require (
 	github.com/shirou/gopsutil v3.21.11+incompatible
+	go.etcd.io/bbolt v1.3.7
 	google.golang.org/grpc v1.53.0
 	google.golang.org/protobuf v1.28.1
 )
@@ -19,6 +19,18 @@ type Master struct {
 }
 
 func NewMaster(listenAddr, connect, addr string) (*Master, error) {
+	if settings.MetaStorePath != "" {
+		store, err := sdfs.OpenBoltStore(settings.MetaStorePath)
+		if err != nil {
+			return nil, err
+		}
+		fs, err := sdfs.OpenFS(store)
+		if err != nil {
+			store.Close()
+			return nil, err
+		}
+		sdfs.Fs = fs
+	}
 	s, err := raft.NewServer(settings.RaftRPCListenPort, connect, addr)
 	if err != nil {
 		return nil, err
@@ -5,17 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/fs"
 	"net/http"
-	"path/filepath"
 	"time"
 
 	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/pkg/kms"
 	"github.com/Lyianu/sdfs/pkg/settings"
 	"github.com/Lyianu/sdfs/router"
 	"github.com/Lyianu/sdfs/sdfs"
 	"github.com/shirou/gopsutil/cpu"
-	"github.com/shirou/gopsutil/disk"
 	"github.com/shirou/gopsutil/mem"
 )
 
@@ -32,9 +30,14 @@ type Node struct {
 	id string
 }
 
-func NewNode(port string, masterAddr string, addr string) *Node {
+func NewNode(port string, masterAddr string, addr string) (*Node, error) {
 	if HS == nil {
-		HS = sdfs.NewHashStore()
+		hs, err := LoadHS()
+		if err != nil {
+			return nil, err
+		}
+		HS = hs
+		sdfs.Hs = hs
 	}
 	n := &Node{
 		r:    router.NewRouter(masterAddr, addr+":"+port),
@@ -42,14 +45,18 @@ func NewNode(port string, masterAddr string, addr string) *Node {
 		HS:   HS,
 		Addr: addr,
 	}
-	n.LoadHS()
-	return n
+	n.r.Scrubber = sdfs.NewScrubber(HS, settings.ScrubRate, settings.ScrubInterval, func(hash string) error {
+		return router.ReportCorruptToMaster(n.r.MasterAddr, hash, n.r.NodeAddr)
+	})
+	return n, nil
 }
 
 func (n *Node) Start() error {
 	listen := fmt.Sprintf(":%s", n.Port)
 	log.Infof("Starting heartbeat to %s", n.r.MasterAddr)
 	go n.StartHeartbeat()
+	log.Infof("Starting scrubber at %d bytes per second", n.r.Scrubber.Rate)
+	go n.r.Scrubber.Run(nil)
 	log.Infof("Starting Node, Listening on %s", listen)
 	return http.ListenAndServe(listen, n.r)
 }
@@ -61,11 +68,6 @@ func (n *Node) SendHeartbeat(url string) error {
 		log.Errorf("failed to get info: %s", err)
 		return err
 	}
-	d, err := disk.Usage(settings.DataPathPrefix)
-	if err != nil {
-		log.Errorf("failed to get info: %s", err)
-		return err
-	}
 	c, err := cpu.Percent(time.Second, false)
 	if err != nil {
 		log.Errorf("failed to get info: %s", err)
@@ -73,13 +75,23 @@ func (n *Node) SendHeartbeat(url string) error {
 	}
 
 	size := n.HS.GetSize()
+	// only healthy disks take new objects
+	disks := n.HS.DiskStats()
+	var free uint64
+	for _, d := range disks {
+		if d.Healthy {
+			free += d.Free
+		}
+	}
 
 	request := router.H{
-		"host":   fmt.Sprintf("%s:%s", n.Addr, n.Port),
-		"cpu":    c[0],
-		"size":   size,
-		"memory": v.UsedPercent,
-		"disk":   int64(d.Free),
+		"host":        fmt.Sprintf("%s:%s", n.Addr, n.Port),
+		"cpu":         c[0],
+		"size":        size,
+		"memory":      v.UsedPercent,
+		"disk":        int64(free),
+		"disks":       disks,
+		"compression": n.HS.CompressionStats(),
 	}
 	j, err := json.Marshal(request)
 	if err != nil {
@@ -122,17 +134,39 @@ func (n *Node) StartHeartbeat() {
 	}
 }
 
-func (n *Node) LoadHS() {
-	filepath.WalkDir(settings.DataPathPrefix, func(path string, d fs.DirEntry, err error) error {
+// LoadHS opens the hashstore in settings.DataDirs with the index at
+// settings.HashIndexPath, objects are encrypted with the keys of
+// settings.KeyFile if it is set
+func LoadHS() (*sdfs.HashStore, error) {
+	dirs := settings.DataDirs
+	if len(dirs) == 0 {
+		dirs = []string{settings.DataPathPrefix}
+	}
+	var index sdfs.MetaStore
+	if settings.HashIndexPath != "" {
+		var err error
+		index, err = sdfs.OpenHashIndex(settings.HashIndexPath)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		i, err := d.Info()
+	}
+	var keys kms.KMS
+	if settings.KeyFile != "" {
+		f, err := kms.OpenFile(settings.KeyFile)
 		if err != nil {
-			return err
+			if index != nil {
+				index.Close()
+			}
+			return nil, err
 		}
-		log.Debugf("load file: %s", d.Name())
-		n.HS.AddLocal(d.Name(), i.Size())
-		return nil
-	})
+		keys = f
+	}
+	hs, err := sdfs.OpenEncryptedHashStore(dirs, index, keys)
+	if err != nil {
+		if index != nil {
+			index.Close()
+		}
+		return nil, err
+	}
+	return hs, nil
 }
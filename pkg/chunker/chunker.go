@@ -0,0 +1,147 @@
+// Package chunker splits streams into chunks, either of a fixed size or at
+// boundaries defined by the content itself (FastCDC), so that an edit of a
+// large file only changes the chunks around it
+package chunker
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Chunker returns the chunks of a stream in order
+type Chunker interface {
+	// Next returns the next chunk, or io.EOF after the last one. The chunk
+	// is only valid until the next call
+	Next() ([]byte, error)
+}
+
+type fixed struct {
+	r   io.Reader
+	buf []byte
+}
+
+// NewFixed returns a chunker that splits r into chunks of size bytes, the
+// last chunk may be smaller
+func NewFixed(r io.Reader, size int) Chunker {
+	return &fixed{r: r, buf: make([]byte, size)}
+}
+
+func (c *fixed) Next() ([]byte, error) {
+	n, err := io.ReadFull(c.r, c.buf)
+	if n == 0 && err == io.EOF {
+		return nil, io.EOF
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return c.buf[:n], err
+}
+
+// gear maps bytes to random values that are rolled into the fingerprint,
+// it must never change or chunk boundaries of stored files move
+var gear [256]uint64
+
+func init() {
+	// splitmix64 with a fixed seed
+	x := uint64(0x5344465343444321)
+	for i := range gear {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+type fastCDC struct {
+	r             io.Reader
+	min, avg, max int
+	// maskS is used before the average size is reached, it has more bits
+	// than maskL so that cuts are less likely, which keeps chunk sizes
+	// close to the average (normalized chunking)
+	maskS, maskL uint64
+
+	buf        []byte
+	start, end int // unread data is buf[start:end]
+	eof        bool
+}
+
+// NewFastCDC returns a chunker that cuts r at content-defined boundaries
+// with FastCDC, chunks are between min and max bytes and avg bytes on
+// average, avg should be a power of two
+func NewFastCDC(r io.Reader, min, avg, max int) (Chunker, error) {
+	if min <= 0 || min > avg || avg > max {
+		return nil, errors.New("chunker: invalid chunk sizes")
+	}
+	b := bits.Len(uint(avg)) - 1
+	return &fastCDC{
+		r:     r,
+		min:   min,
+		avg:   avg,
+		max:   max,
+		maskS: topBits(b + 2),
+		maskL: topBits(b - 2),
+		buf:   make([]byte, max),
+	}, nil
+}
+
+// topBits returns a mask of the n most significant bits, the fingerprint is
+// shifted left for every byte so its top bits depend on the most bytes
+func topBits(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	return ^uint64(0) << (64 - n)
+}
+
+func (c *fastCDC) Next() ([]byte, error) {
+	if c.end-c.start < c.max && !c.eof {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+		n, err := io.ReadFull(c.r, c.buf[c.end:])
+		c.end += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// cut returns the length of the chunk at the start of src
+func (c *fastCDC) cut(src []byte) int {
+	n := len(src)
+	if n <= c.min {
+		return n
+	}
+	if n > c.max {
+		n = c.max
+	}
+	normal := c.avg
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := c.min
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[src[i]]
+		if fp&c.maskS == 0 {
+			return i
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[src[i]]
+		if fp&c.maskL == 0 {
+			return i
+		}
+	}
+	return n
+}
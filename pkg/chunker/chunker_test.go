@@ -0,0 +1,79 @@
+package chunker_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/Lyianu/sdfs/pkg/chunker"
+)
+
+func chunks(t *testing.T, c chunker.Chunker) [][]byte {
+	t.Helper()
+	var l [][]byte
+	for {
+		b, err := c.Next()
+		if err == io.EOF {
+			return l
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		l = append(l, append([]byte(nil), b...))
+	}
+}
+
+func TestFixed(t *testing.T) {
+	l := chunks(t, chunker.NewFixed(bytes.NewReader(make([]byte, 10)), 4))
+	if len(l) != 3 || len(l[0]) != 4 || len(l[2]) != 2 {
+		t.Errorf("want chunks of 4, 4 and 2 bytes, have: %d chunks", len(l))
+	}
+	if l := chunks(t, chunker.NewFixed(bytes.NewReader(nil), 4)); len(l) != 0 {
+		t.Errorf("want no chunks of empty stream, have: %d", len(l))
+	}
+}
+
+func TestFastCDC(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	split := func(data []byte) [][]byte {
+		c, err := chunker.NewFastCDC(bytes.NewReader(data), 2<<10, 8<<10, 32<<10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chunks(t, c)
+	}
+	l := split(data)
+	if !bytes.Equal(bytes.Join(l, nil), data) {
+		t.Fatalf("chunks do not add up to the stream")
+	}
+	for i, b := range l {
+		if len(b) > 32<<10 || len(b) < 2<<10 && i != len(l)-1 {
+			t.Errorf("chunk %d has %d bytes", i, len(b))
+		}
+	}
+	if avg := len(data) / len(l); avg < 4<<10 || avg > 16<<10 {
+		t.Errorf("want chunks of about 8KiB, have %d chunks of %d bytes on average", len(l), avg)
+	}
+
+	// a byte inserted in the middle changes only the chunks around it
+	edited := append(append(append([]byte(nil), data[:len(data)/2]...), 'x'), data[len(data)/2:]...)
+	seen := make(map[string]bool)
+	for _, b := range l {
+		seen[string(b)] = true
+	}
+	changed := 0
+	for _, b := range split(edited) {
+		if !seen[string(b)] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Errorf("want at most 2 chunks changed by an insert, have %d of %d", changed, len(l))
+	}
+
+	if _, err := chunker.NewFastCDC(nil, 10, 5, 20); err == nil {
+		t.Errorf("want error for invalid sizes")
+	}
+}
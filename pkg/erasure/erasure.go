@@ -0,0 +1,268 @@
+// package erasure implements systematic Reed-Solomon erasure codes over
+// GF(2^8), content split into data shards is extended by parity shards and
+// can be rebuilt from any data shards of the shards
+package erasure
+
+import (
+	"errors"
+)
+
+var (
+	// ErrTooFewShards is returned when fewer shards than data shards are
+	// left to rebuild the others
+	ErrTooFewShards = errors.New("erasure: too few shards")
+	// ErrShardSize is returned when shards differ in size
+	ErrShardSize = errors.New("erasure: shards differ in size")
+)
+
+// tables of GF(2^8) with the polynomial x^8+x^4+x^3+x^2+1
+var (
+	expTable [510]byte
+	logTable [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		expTable[i] = byte(x)
+		expTable[i+255] = byte(x)
+		logTable[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func inv(a byte) byte {
+	return expTable[255-int(logTable[a])]
+}
+
+// pow returns a to the power of n
+func pow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%255]
+}
+
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for i := range m {
+		m[i] = make([]byte, cols)
+	}
+	return m
+}
+
+func (m matrix) mul(o matrix) matrix {
+	r := newMatrix(len(m), len(o[0]))
+	for i := range m {
+		for j := range o[0] {
+			var v byte
+			for k := range o {
+				v ^= mul(m[i][k], o[k][j])
+			}
+			r[i][j] = v
+		}
+	}
+	return r
+}
+
+// invert returns the inverse of the square matrix m by Gauss-Jordan
+// elimination, m is left untouched
+func (m matrix) invert() (matrix, error) {
+	n := len(m)
+	w := newMatrix(n, 2*n)
+	for i := range m {
+		copy(w[i], m[i])
+		w[i][n+i] = 1
+	}
+	for c := 0; c < n; c++ {
+		p := c
+		for p < n && w[p][c] == 0 {
+			p++
+		}
+		if p == n {
+			return nil, errors.New("erasure: singular matrix")
+		}
+		w[c], w[p] = w[p], w[c]
+		if v := w[c][c]; v != 1 {
+			iv := inv(v)
+			for j := range w[c] {
+				w[c][j] = mul(w[c][j], iv)
+			}
+		}
+		for i := 0; i < n; i++ {
+			if i == c || w[i][c] == 0 {
+				continue
+			}
+			f := w[i][c]
+			for j := range w[i] {
+				w[i][j] ^= mul(f, w[c][j])
+			}
+		}
+	}
+	r := newMatrix(n, n)
+	for i := range r {
+		copy(r[i], w[i][n:])
+	}
+	return r, nil
+}
+
+// Code is a Reed-Solomon code of data data shards and parity parity shards
+type Code struct {
+	data   int
+	parity int
+	// matrix maps the data shards to all shards, its top rows are the
+	// identity so data shards are kept as they are
+	matrix matrix
+}
+
+// New returns a code of data data shards and parity parity shards, there
+// are at most 256 shards
+func New(data, parity int) (*Code, error) {
+	if data <= 0 || parity <= 0 || data+parity > 256 {
+		return nil, errors.New("erasure: invalid number of shards")
+	}
+	// any data rows of a Vandermonde matrix are independent, multiplying it
+	// by the inverse of its top makes the code systematic and keeps that
+	v := newMatrix(data+parity, data)
+	for i := range v {
+		for j := range v[i] {
+			v[i][j] = pow(byte(i), j)
+		}
+	}
+	top, err := v[:data].invert()
+	if err != nil {
+		return nil, err
+	}
+	return &Code{data: data, parity: parity, matrix: v.mul(top)}, nil
+}
+
+// DataShards returns the number of data shards of c
+func (c *Code) DataShards() int {
+	return c.data
+}
+
+// ParityShards returns the number of parity shards of c
+func (c *Code) ParityShards() int {
+	return c.parity
+}
+
+// shardSize returns the size of the shards that are not nil
+func shardSize(shards [][]byte) (int, error) {
+	size := -1
+	for _, s := range shards {
+		if s == nil {
+			continue
+		}
+		if size != -1 && len(s) != size {
+			return 0, ErrShardSize
+		}
+		size = len(s)
+	}
+	return size, nil
+}
+
+// encode sets out to the rows of the matrix applied to in
+func encode(rows matrix, in, out [][]byte) {
+	for i, row := range rows {
+		o := out[i]
+		for b := range o {
+			o[b] = 0
+		}
+		for j, f := range row {
+			if f == 0 {
+				continue
+			}
+			for b, v := range in[j] {
+				o[b] ^= mul(f, v)
+			}
+		}
+	}
+}
+
+// Encode computes the parity shards of the data shards, shards holds the
+// data shards followed by the parity shards, all of the same size. Parity
+// shards that are nil are allocated
+func (c *Code) Encode(shards [][]byte) error {
+	if len(shards) != c.data+c.parity {
+		return errors.New("erasure: wrong number of shards")
+	}
+	size, err := shardSize(shards[:c.data])
+	if err != nil {
+		return err
+	}
+	if size == -1 {
+		return ErrTooFewShards
+	}
+	for i := c.data; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+		} else if len(shards[i]) != size {
+			return ErrShardSize
+		}
+	}
+	encode(c.matrix[c.data:], shards[:c.data], shards[c.data:])
+	return nil
+}
+
+// Reconstruct rebuilds the shards that are nil from the others, at least
+// as many shards as there are data shards should be left
+func (c *Code) Reconstruct(shards [][]byte) error {
+	if len(shards) != c.data+c.parity {
+		return errors.New("erasure: wrong number of shards")
+	}
+	size, err := shardSize(shards)
+	if err != nil {
+		return err
+	}
+	var rows matrix
+	var in [][]byte
+	for i, s := range shards {
+		if s != nil && len(rows) < c.data {
+			rows = append(rows, c.matrix[i])
+			in = append(in, s)
+		}
+	}
+	if len(rows) < c.data {
+		return ErrTooFewShards
+	}
+	decode, err := rows.invert()
+	if err != nil {
+		return err
+	}
+	// the data shards are the inverse applied to the shards left
+	var missing matrix
+	var out [][]byte
+	for i := 0; i < c.data; i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+			missing = append(missing, decode[i])
+			out = append(out, shards[i])
+		}
+	}
+	encode(missing, in, out)
+	missing, out = nil, nil
+	for i := c.data; i < len(shards); i++ {
+		if shards[i] == nil {
+			shards[i] = make([]byte, size)
+			missing = append(missing, c.matrix[i])
+			out = append(out, shards[i])
+		}
+	}
+	encode(missing, shards[:c.data], out)
+	return nil
+}
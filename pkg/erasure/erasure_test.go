@@ -0,0 +1,77 @@
+package erasure_test
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/Lyianu/sdfs/pkg/erasure"
+)
+
+func shards(t *testing.T, c *erasure.Code, size int) [][]byte {
+	t.Helper()
+	rnd := rand.New(rand.NewSource(1))
+	s := make([][]byte, c.DataShards()+c.ParityShards())
+	for i := 0; i < c.DataShards(); i++ {
+		s[i] = make([]byte, size)
+		rnd.Read(s[i])
+	}
+	if err := c.Encode(s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestReconstruct(t *testing.T) {
+	c, err := erasure.New(6, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := shards(t, c, 1000)
+	// every combination of up to 3 lost shards is rebuilt
+	for a := 0; a < 9; a++ {
+		for b := a; b < 9; b++ {
+			for d := b; d < 9; d++ {
+				s := make([][]byte, 9)
+				for i := range s {
+					s[i] = append([]byte(nil), want[i]...)
+				}
+				s[a], s[b], s[d] = nil, nil, nil
+				if err := c.Reconstruct(s); err != nil {
+					t.Fatalf("lost %d, %d, %d: %v", a, b, d, err)
+				}
+				for i := range s {
+					if !bytes.Equal(s[i], want[i]) {
+						t.Fatalf("lost %d, %d, %d: shard %d rebuilt wrong", a, b, d, i)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestReconstructTooFew(t *testing.T) {
+	c, _ := erasure.New(4, 2)
+	s := shards(t, c, 10)
+	s[0], s[2], s[5] = nil, nil, nil
+	if err := c.Reconstruct(s); !errors.Is(err, erasure.ErrTooFewShards) {
+		t.Errorf("want too few shards, have: %v", err)
+	}
+	s = shards(t, c, 10)
+	s[1] = s[1][:5]
+	if err := c.Reconstruct(s); !errors.Is(err, erasure.ErrShardSize) {
+		t.Errorf("want shard size mismatch, have: %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range [][2]int{{0, 1}, {1, 0}, {200, 57}} {
+		if _, err := erasure.New(tt[0], tt[1]); err == nil {
+			t.Errorf("want %d+%d rejected", tt[0], tt[1])
+		}
+	}
+	if _, err := erasure.New(200, 56); err != nil {
+		t.Errorf("want 256 shards accepted, have: %v", err)
+	}
+}
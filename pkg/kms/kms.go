@@ -0,0 +1,184 @@
+// package kms keeps the master keys that the data keys of encrypted objects
+// are wrapped with, data keys are only ever stored wrapped
+package kms
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeySize is the size of master keys and data keys, both are AES-256 keys
+const KeySize = 32
+
+var (
+	// ErrUnknownKey is returned when a key is wrapped with a master key the
+	// KMS does not have
+	ErrUnknownKey = errors.New("kms: unknown master key")
+	// ErrUnwrap is returned when a wrapped key does not unwrap with the
+	// master key it names
+	ErrUnwrap = errors.New("kms: key does not unwrap")
+)
+
+// KMS wraps data keys with master keys. New keys are wrapped with the current
+// master key, master keys rotated out still unwrap the keys wrapped with them
+type KMS interface {
+	// Wrap wraps key with the current master key, it returns the id of the
+	// master key and the wrapped key
+	Wrap(key []byte) (id string, wrapped []byte, err error)
+	// Unwrap unwraps key wrapped with the master key id
+	Unwrap(id string, wrapped []byte) ([]byte, error)
+	// Current returns the id of the current master key
+	Current() string
+}
+
+// NewKey returns a random key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// File is a KMS that keeps its master keys in a local keyfile, a line per
+// key of its id and its base64 encoding separated by a space. The last key
+// of the file is the current one
+type File struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// OpenFile opens the keyfile at path, a keyfile with a new master key is
+// created if it does not exist
+func OpenFile(path string) (*File, error) {
+	f := &File{path: path, keys: make(map[string]cipher.AEAD)}
+	r, err := os.Open(path)
+	if os.IsNotExist(err) {
+		if _, err := f.Rotate(); err != nil {
+			return nil, err
+		}
+		return f, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(text, " ")
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if !ok || err != nil || len(key) != KeySize {
+			return nil, fmt.Errorf("kms: %s:%d: invalid key", path, line)
+		}
+		if err := f.add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if f.current == "" {
+		return nil, fmt.Errorf("kms: %s has no keys", path)
+	}
+	return f, nil
+}
+
+// add adds the master key with the given id and makes it the current one
+func (f *File) add(id string, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	f.keys[id] = aead
+	f.current = id
+	return nil
+}
+
+// Rotate adds a new master key to the keyfile and makes it the current one,
+// it returns the id of the key
+func (f *File) Rotate() (string, error) {
+	key, err := NewKey()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintf(w, "%s %s\n", id, base64.StdEncoding.EncodeToString(key)); err != nil {
+		w.Close()
+		return "", err
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return id, f.add(id, key)
+}
+
+func (f *File) Current() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.current
+}
+
+// Wrap wraps key with the current master key, the wrapped key is a random
+// nonce followed by the key sealed with AES-GCM
+func (f *File) Wrap(key []byte) (string, []byte, error) {
+	f.mu.RLock()
+	id, aead := f.current, f.keys[f.current]
+	f.mu.RUnlock()
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", nil, err
+	}
+	return id, aead.Seal(nonce, nonce, key, []byte(id)), nil
+}
+
+func (f *File) Unwrap(id string, wrapped []byte) ([]byte, error) {
+	f.mu.RLock()
+	aead, ok := f.keys[id]
+	f.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownKey, id)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrUnwrap
+	}
+	n := aead.NonceSize()
+	key, err := aead.Open(nil, wrapped[:n], wrapped[n:], []byte(id))
+	if err != nil {
+		return nil, ErrUnwrap
+	}
+	return key, nil
+}
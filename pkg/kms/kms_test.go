@@ -0,0 +1,65 @@
+package kms_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Lyianu/sdfs/pkg/kms"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	f, err := kms.OpenFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("want keyfile created readable by its owner only, have: %v, %v", fi, err)
+	}
+	key, _ := kms.NewKey()
+	first, wrapped, err := f.Wrap(key)
+	if err != nil || first != f.Current() || bytes.Contains(wrapped, key) {
+		t.Fatalf("key not wrapped with the current master key: %s, %v", first, err)
+	}
+	if k, err := f.Unwrap(first, wrapped); err != nil || !bytes.Equal(k, key) {
+		t.Errorf("key not unwrapped: %v", err)
+	}
+
+	// keys rotated out still unwrap, also after the keyfile is opened again
+	second, err := f.Rotate()
+	if err != nil || second == first || f.Current() != second {
+		t.Fatalf("want a new current master key, have: %s, %v", second, err)
+	}
+	if f, err = kms.OpenFile(path); err != nil || f.Current() != second {
+		t.Fatalf("want %s current after reopening, have: %v", second, err)
+	}
+	if k, err := f.Unwrap(first, wrapped); err != nil || !bytes.Equal(k, key) {
+		t.Errorf("key of rotated master key not unwrapped: %v", err)
+	}
+
+	if _, err := f.Unwrap(second, wrapped); !errors.Is(err, kms.ErrUnwrap) {
+		t.Errorf("want key wrapped with another master key rejected, have: %v", err)
+	}
+	if _, err := f.Unwrap("nope", wrapped); !errors.Is(err, kms.ErrUnknownKey) {
+		t.Errorf("want unknown master key, have: %v", err)
+	}
+	wrapped[len(wrapped)-1] ^= 1
+	if _, err := f.Unwrap(first, wrapped); !errors.Is(err, kms.ErrUnwrap) {
+		t.Errorf("want tampered key rejected, have: %v", err)
+	}
+}
+
+func TestOpenFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	os.WriteFile(path, []byte("k1 c2hvcnQ=\n"), 0600)
+	if _, err := kms.OpenFile(path); err == nil {
+		t.Errorf("want short key rejected")
+	}
+	os.WriteFile(path, []byte("# no keys\n"), 0600)
+	if _, err := kms.OpenFile(path); err == nil {
+		t.Errorf("want keyfile without keys rejected")
+	}
+}
@@ -3,18 +3,101 @@ package settings
 const (
 	// path for client to download
 	URLDownload = "/api/download"
-	// path for client to request upload
+	// path for client to request upload, overwrite=true replaces an existing
+	// file, If-Match: "<checksum>" replaces it only if it has that checksum and
+	// If-None-Match: * only creates new files. chunking=fastcdc or fixed
+	// stores the content in chunks that are deduplicated and replicated on
+	// their own, erasure=6+3 stores it erasure-coded and erasure=none
+	// replicated regardless of the directory
 	URLUpload = "/api/upload"
 	// path for client to request delete
 	URLDelete = "/api/delete"
+	// path for client to get info of a file or directory
+	URLStat = "/api/stat"
+	// path for client to list a directory
+	URLList = "/api/list"
+	// path for client to get, set(POST) or remove(DELETE) extended attributes
+	URLXattr = "/api/xattr"
+	// path for client to create a hard link
+	URLLink = "/api/link"
+	// path for client to create or read a symbolic link
+	URLSymlink  = "/api/symlink"
+	URLReadlink = "/api/readlink"
+	// path for client to move a file or symlink
+	URLRename = "/api/rename"
+	// path for client to copy a file or directory tree, no content is moved
+	URLCopy = "/api/copy"
+	// path for client to watch changes of the namespace as Server-Sent
+	// Events, the id of events is their raft index
+	URLWatch = "/api/watch"
+	// path for client to list, create(POST) or delete(DELETE) snapshots of a
+	// directory, files in snapshots are downloaded with paths of the form
+	// <dir>@<snapshot>/<path in dir>
+	URLSnapshot = "/api/snapshot"
+	// path for client to compare a snapshot with another or the live tree
+	URLSnapshotDiff = "/api/snapshot/diff"
+	// path for client to list the trash of a namespace or purge(DELETE) an
+	// entry of it, deleted files are kept in the trash until they expire
+	URLTrash = "/api/trash"
+	// path for client to restore a file from the trash
+	URLTrashRestore = "/api/trash/restore"
+	// path for client to set how long the trash of a namespace keeps files
+	URLTrashRetention = "/api/trash/retention"
+	// path for client to list the versions of a file, downloads and deletes
+	// take a version query to target a single version
+	URLVersions = "/api/versions"
+	// path for client to set how many prior versions are kept for files in
+	// a directory
+	URLVersioning = "/api/versioning"
+	// path for client to set the storage class of files uploaded to a
+	// directory, e.g. erasure=6+3, erasure=none falls back to the parent
+	URLErasure = "/api/erasure"
+	// path for client to set(POST) or remove(DELETE) the quota of a
+	// directory
+	URLQuota = "/api/quota"
+	// path for client to get the usage and the quota of a directory
+	URLUsage = "/api/usage"
+	// path for client to get how well the objects of the nodes compress
+	URLCompression = "/api/compression"
+	// path for client to remove objects that no file references from the
+	// nodes, dry_run=true reports them without removing anything and grace
+	// overrides how long they are kept, e.g. grace=1h
+	URLGC = "/api/gc"
+	// path for client to search files by prefix, glob and filters, results
+	// are streamed as newline delimited JSON
+	URLSearch = "/api/search"
+	// path for client to list, create(POST) or delete(DELETE) namespaces,
+	// other path based APIs take the namespace in query "ns"
+	URLNamespace = "/api/ns"
 	// path for sdfs master to request download
 	URLSDFSDownload = "/api/sdfs/download"
-	// path for sdfs master to request delete
+	// path for sdfs master to request delete, all=true removes the file
+	// regardless of its references
 	URLSDFSDelete = "/api/sdfs/delete"
+	// path for sdfs master to add references to a file after copying it
+	URLSDFSRef = "/api/sdfs/ref"
+	// path for sdfs master to send a node the objects it should keep, the
+	// node removes its other objects
+	URLSDFSGC = "/api/sdfs/gc"
 	// path for sdfs master to request upload
 	URLSDFSUpload = "/api/sdfs/upload"
+	// path for sdfs node to fetch a chunk of a chunked download from another
+	// node, ranges of the chunk are requested with the Range header
+	URLSDFSChunk = "/api/sdfs/chunk"
+	// path for sdfs node to place a shard of an erasure-coded upload on
+	// another node
+	URLSDFSShard = "/api/sdfs/shard"
+	// path for sdfs master to request a node to rebuild a lost shard of an
+	// erasure-coded file from the other shards
+	URLSDFSReconstruct = "/api/sdfs/reconstruct"
 	// path for sdfs node to send heartbeat to
 	URLSDFSHeartbeat = "/api/sdfs/heartbeat"
+	// path for sdfs node to report a corrupt object it quarantined, the
+	// leader replicates the file again from a healthy copy
+	URLSDFSScrubReport = "/api/sdfs/scrub/report"
+	// path for client to get the progress and stats of the scrubber of a
+	// node
+	URLSDFSScrub = "/api/sdfs/scrub"
 
 	// not implemented
 	URLSDFSWrite = "/api/sdfs/write"
@@ -29,6 +112,10 @@ const (
 
 	URLUploadCallback = "/api/callback/upload"
 
+	// upload request headers with this prefix are stored as extended
+	// attributes of the uploaded file, e.g. X-Sdfs-Meta-Source: ingest-7
+	HeaderXattrPrefix = "X-Sdfs-Meta-"
+
 	// the scheme that sdfs master uses, http or https
 	URLSDFSScheme = "http://"
 )
@@ -1,9 +1,49 @@
 package settings
 
+import "time"
+
 var (
-	DataPathPrefix    = "./data/"
+	DataPathPrefix = "./data/"
+	// data directories of a node, each should be on a disk of its own, the
+	// node uses DataPathPrefix if it is empty
+	DataDirs          []string
 	RaftRPCListenPort = ":9000"
 	// actual replica count will be determined on the fly with a adaptive
 	// algorithm implemented
 	DefaultReplicaCount = 3
+	// file that master keeps the namespace in, the namespace lives only in
+	// memory if it is empty
+	MetaStorePath = "./meta.db"
+	// number of files and symlinks master keeps in memory, the entries of
+	// the directories used least recently are read from the store again
+	MetaCacheEntries = 1 << 20
+	// file that nodes keep the index of their hashstore in, the hashstore is
+	// rebuilt from DataPathPrefix on every start if it is empty
+	HashIndexPath = "./hashstore.db"
+	// bytes per second the scrubber of a node reads at, 0 for no limit
+	ScrubRate int64 = 8 << 20
+	// how long an object is left alone after the scrubber verified it
+	ScrubInterval = 7 * 24 * time.Hour
+	// size of the chunks of uploads chunked with fixed size chunking and
+	// the average size of content-defined chunks, which are between a
+	// quarter and four times of it
+	ChunkSize = 1 << 20
+	// codec nodes compress new objects with, empty to store them as they
+	// are. Objects that do not get smaller are stored as they are anyway
+	Compression = ""
+	// bytes of content compressed into each frame of a compressed object,
+	// reading a range decompresses the frames it covers
+	CompressionFrame int64 = 1 << 20
+	// keyfile with the master keys that nodes wrap the data keys of new
+	// objects with, objects are stored unencrypted if it is empty
+	KeyFile = ""
+	// how often the leader purges expired trash entries
+	TrashPurgeInterval = time.Minute
+	// how long nodes keep objects that no file references and files of
+	// unfinished writes before the garbage collector removes them, uploads
+	// and replicas the master has not recorded yet should be younger
+	GCGracePeriod = 24 * time.Hour
+	// how often the leader collects garbage on nodes, 0 to collect only on
+	// request
+	GCInterval time.Duration = 0
 )
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"io"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/sdfs"
 )
 
 type AddNodeStruct struct {
@@ -42,4 +45,7 @@ func AddNodeExecutor(v interface{}) {
 	}
 	Raft.nodeAddr[a.NodeAddr] = n
 	Raft.nodes[a.ID] = n
+	if err := sdfs.Fs.AddNode(a.ID, a.NodeAddr); err != nil {
+		log.Errorf("failed to record id %d of node %s: %q", a.ID, a.NodeAddr, err)
+	}
 }
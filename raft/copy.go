@@ -0,0 +1,95 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// CopyStruct copies the file or directory tree at Src to Dst
+type CopyStruct struct {
+	Src string
+	Dst string
+}
+
+func CopyStructToEntry(v interface{}) (e *Entry) {
+	a := v.(CopyStruct)
+	buf := new(bytes.Buffer)
+	writeString(buf, a.Src)
+	writeString(buf, a.Dst)
+	e = &Entry{
+		Type: 21,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToCopyStruct(e *Entry) interface{} {
+	a := CopyStruct{}
+	r := bytes.NewReader(e.Data)
+	a.Src = readString(r)
+	a.Dst = readString(r)
+	return a
+}
+
+func CopyExecutor(v interface{}) {
+	a := v.(CopyStruct)
+	log.Debugf("copying from AppendEntries rpc call, %q -> %q", a.Src, a.Dst)
+	if _, err := sdfs.Fs.Copy(a.Src, a.Dst); err != nil {
+		log.Errorf("failed to copy from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// Copy copies the file or directory tree at src to dst and replicates it,
+// nodes holding the copied files are told about the new references. It
+// returns the number of files copied
+func (s *Server) Copy(src, dst string) (int, error) {
+	var files []*sdfs.File
+	err := s.execute(CopyStruct{Src: src, Dst: dst}, func() error {
+		var err error
+		files, err = sdfs.Fs.Copy(src, dst)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := s.refNodes(files); err != nil {
+		log.Errorf("failed to add references of files copied to %s: %q", dst, err)
+	}
+	return len(files), nil
+}
+
+// refNodes adds a reference on the nodes holding each of files
+func (s *Server) refNodes(files []*sdfs.File) error {
+	refs := make(map[*sdfs.File]int)
+	for _, f := range files {
+		refs[f]++
+	}
+	failed := 0
+	for f, n := range refs {
+		for _, v := range f.Host {
+			h := s.NodeAddr(v)
+			url := fmt.Sprintf("%s%s%s?hash=%s&n=%d", settings.URLSDFSScheme, h, settings.URLSDFSRef, url.QueryEscape(f.Checksum), n)
+			resp, err := http.Get(url)
+			if err != nil {
+				log.Errorf("error sending ref request to node: %q", err)
+				failed++
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				log.Errorf("err sending ref request to node: statusCode mismatch, expected: %d, get: %d", http.StatusOK, resp.StatusCode)
+				failed++
+			}
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("failed to add references on %d nodes", failed)
+	}
+	return nil
+}
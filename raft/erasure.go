@@ -0,0 +1,149 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// SetErasureStruct sets the storage class of files uploaded to the
+// directory at Path
+type SetErasureStruct struct {
+	Data   int32
+	Parity int32
+	Path   string
+}
+
+func SetErasureStructToEntry(v interface{}) (e *Entry) {
+	a := v.(SetErasureStruct)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, a.Data)
+	binary.Write(buf, binary.LittleEndian, a.Parity)
+	writeString(buf, a.Path)
+	e = &Entry{
+		Type: 23,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToSetErasureStruct(e *Entry) interface{} {
+	a := SetErasureStruct{}
+	r := bytes.NewReader(e.Data)
+	binary.Read(r, binary.LittleEndian, &a.Data)
+	binary.Read(r, binary.LittleEndian, &a.Parity)
+	a.Path = readString(r)
+	return a
+}
+
+func SetErasureExecutor(v interface{}) {
+	a := v.(SetErasureStruct)
+	log.Debugf("setting erasure coding from AppendEntries rpc call, path: %s, erasure: %d+%d", a.Path, a.Data, a.Parity)
+	if err := sdfs.Fs.SetErasure(a.Path, sdfs.Erasure{Data: int(a.Data), Parity: int(a.Parity)}); err != nil {
+		log.Errorf("failed to set erasure coding from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// SetErasure sets the storage class of files uploaded to the directory at
+// path and replicates it
+func (s *Server) SetErasure(path string, e sdfs.Erasure) error {
+	return s.execute(SetErasureStruct{Data: int32(e.Data), Parity: int32(e.Parity), Path: path}, func() error {
+		return sdfs.Fs.SetErasure(path, e)
+	})
+}
+
+// shardTask describes a shard to rebuild
+type shardTask struct {
+	File  *sdfs.File
+	Index int
+}
+
+// shardNodes returns the addresses of the nodes that hold shards of file
+func (s *Server) shardNodes(file *sdfs.File) []string {
+	var addrs []string
+	for _, c := range sdfs.Fs.Manifest(file) {
+		for _, h := range c.Host {
+			addrs = append(addrs, s.NodeAddr(h))
+		}
+	}
+	return addrs
+}
+
+// reconstructShard schedules the rebuild of shard i of file on a node that
+// holds no other shard of it if there is one
+func (s *Server) reconstructShard(file *sdfs.File, i int) {
+	hash := file.Chunks[i].Hash
+	nodes := s.pickNodes(1, s.shardNodes(file)...)
+	if len(nodes) == 0 {
+		nodes = s.pickNodes(1)
+	}
+	if len(nodes) == 0 {
+		log.Errorf("no nodes available to rebuild shard %d of %s", i, file.Checksum)
+		return
+	}
+	log.Infof("rebuilding shard %d of %s on %s", i, file.Checksum, nodes[0])
+	s.ReplicaMngr.AddTask(replicaTask{
+		ReplicatedNodes: nodes,
+		Hash:            hash,
+		Shard:           &shardTask{File: file, Index: i},
+		TTL:             3,
+	})
+}
+
+// shardSource is a shard of an erasure-coded file and the nodes it can be
+// fetched from
+type shardSource struct {
+	Hash  string   `json:"hash"`
+	Size  uint64   `json:"size"`
+	Hosts []string `json:"hosts"`
+}
+
+// reconstructRequest asks a node to rebuild the shard Index of the file Hash
+// from Shards
+type reconstructRequest struct {
+	Hash    string        `json:"hash"`
+	Index   int           `json:"index"`
+	Erasure sdfs.Erasure  `json:"erasure"`
+	Shards  []shardSource `json:"shards"`
+}
+
+// requestReconstruct requests nodes to rebuild the shard of task, they
+// fetch the other shards from the nodes that hold them now
+func requestReconstruct(task replicaTask) error {
+	file := task.Shard.File
+	request := reconstructRequest{
+		Hash:    task.Hash,
+		Index:   task.Shard.Index,
+		Erasure: file.Erasure,
+	}
+	for _, c := range sdfs.Fs.Manifest(file) {
+		hosts := []string{}
+		for _, h := range c.Host {
+			hosts = append(hosts, Raft.NodeAddr(h))
+		}
+		request.Shards = append(request.Shards, shardSource{Hash: c.Hash, Size: c.Size, Hosts: hosts})
+	}
+	b, _ := json.Marshal(request)
+	var errResult error
+	for _, v := range task.ReplicatedNodes {
+		url := fmt.Sprintf("%s%s%s", settings.URLSDFSScheme, v, settings.URLSDFSReconstruct)
+		resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			log.Errorf("failed to request rebuild of %s on %s: %q", task.Hash, v, err)
+			errResult = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			errResult = fmt.Errorf("error requesting rebuild: status code mismatch: expected: %d, have: %d", http.StatusOK, resp.StatusCode)
+			log.Errorf("%s", errResult)
+		}
+	}
+	return errResult
+}
@@ -0,0 +1,31 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotLeader is matched by errors of operations that can only be done by
+// the leader
+var ErrNotLeader = errors.New("not leader")
+
+// NotLeaderError is returned when a non-leader master is asked to change the
+// cluster state, Leader is the address of the current leader if known
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "not leader, leader unknown"
+	}
+	return fmt.Sprintf("not leader, leader: %s", e.Leader)
+}
+
+func (e *NotLeaderError) Is(target error) bool {
+	return target == ErrNotLeader
+}
+
+func (s *Server) notLeader() error {
+	return &NotLeaderError{Leader: s.PeerAddr(s.cm.CurrentLeader())}
+}
@@ -3,25 +3,69 @@ package raft
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
 
 	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/pkg/settings"
 	"github.com/Lyianu/sdfs/sdfs"
 )
 
 type AddFileStruct struct {
 	HostNum    int32
 	PathLength int32
-	Host       []int32
-	Path       string
-	Hash       string
+	Size       uint64
+	ModTime    int64 // unix nanoseconds
+	Flags      int32
+	// IfMatch is the checksum the file at Path must have to be replaced
+	IfMatch string
+	// Chunks is the manifest of the file if it was uploaded in chunks or
+	// its shards if it is erasure-coded
+	Chunks  []sdfs.ChunkRef
+	Erasure sdfs.Erasure
+	// Xattrs are set on Path with the file
+	Xattrs map[string]string
+	Host   []int32
+	Path   string
+	Hash   string
 }
 
+// flags of AddFileStruct
+const (
+	addFileOverwrite int32 = 1 << iota
+	addFileIfNoneMatch
+)
+
 func AddFileStructToEntry(v interface{}) (e *Entry) {
 	a := v.(AddFileStruct)
 	buf := new(bytes.Buffer)
 	binary.Write(buf, binary.LittleEndian, a.HostNum)
 	binary.Write(buf, binary.LittleEndian, a.PathLength)
+	binary.Write(buf, binary.LittleEndian, a.Size)
+	binary.Write(buf, binary.LittleEndian, a.ModTime)
+	binary.Write(buf, binary.LittleEndian, a.Flags)
+	writeString(buf, a.IfMatch)
+	binary.Write(buf, binary.LittleEndian, int32(len(a.Chunks)))
+	for _, c := range a.Chunks {
+		writeString(buf, c.Hash)
+		binary.Write(buf, binary.LittleEndian, c.Size)
+	}
+	binary.Write(buf, binary.LittleEndian, int32(a.Erasure.Data))
+	binary.Write(buf, binary.LittleEndian, int32(a.Erasure.Parity))
+	keys := make([]string, 0, len(a.Xattrs))
+	for k := range a.Xattrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	binary.Write(buf, binary.LittleEndian, int32(len(keys)))
+	for _, k := range keys {
+		writeString(buf, k)
+		writeString(buf, a.Xattrs[k])
+	}
 	for _, v := range a.Host {
 		binary.Write(buf, binary.LittleEndian, v)
 	}
@@ -39,6 +83,30 @@ func EntryToAddFileStruct(e *Entry) interface{} {
 	r := bytes.NewReader(e.Data)
 	binary.Read(r, binary.LittleEndian, &a.HostNum)
 	binary.Read(r, binary.LittleEndian, &a.PathLength)
+	binary.Read(r, binary.LittleEndian, &a.Size)
+	binary.Read(r, binary.LittleEndian, &a.ModTime)
+	binary.Read(r, binary.LittleEndian, &a.Flags)
+	a.IfMatch = readString(r)
+	var chunks int32
+	binary.Read(r, binary.LittleEndian, &chunks)
+	for i := 0; i < int(chunks); i++ {
+		c := sdfs.ChunkRef{Hash: readString(r)}
+		binary.Read(r, binary.LittleEndian, &c.Size)
+		a.Chunks = append(a.Chunks, c)
+	}
+	var data, parity int32
+	binary.Read(r, binary.LittleEndian, &data)
+	binary.Read(r, binary.LittleEndian, &parity)
+	a.Erasure = sdfs.Erasure{Data: int(data), Parity: int(parity)}
+	var xattrs int32
+	binary.Read(r, binary.LittleEndian, &xattrs)
+	if xattrs > 0 {
+		a.Xattrs = make(map[string]string, xattrs)
+	}
+	for i := 0; i < int(xattrs); i++ {
+		k := readString(r)
+		a.Xattrs[k] = readString(r)
+	}
 	for i := 0; i < int(a.HostNum); i++ {
 		var host int32
 		binary.Read(r, binary.LittleEndian, &host)
@@ -55,7 +123,277 @@ func EntryToAddFileStruct(e *Entry) interface{} {
 func AddFileExecutor(v interface{}) {
 	a := v.(AddFileStruct)
 	log.Debugf("adding file from AppendEntries rpc call, file: %q", a.Path)
-	sdfs.Fs.AddFile(a.Path, a.Hash)
-	f, _ := sdfs.Fs.GetFile(a.Path)
-	f.Host = append(f.Host, a.Host...)
+	if err := a.apply(); err != nil {
+		log.Errorf("failed to add file from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// apply adds the file to sdfs.Fs and records its hosts
+func (a AddFileStruct) apply() error {
+	_, err := a.write()
+	return err
+}
+
+// options returns the write options carried by a
+func (a AddFileStruct) options() sdfs.WriteOptions {
+	return sdfs.WriteOptions{
+		Overwrite:   a.Flags&addFileOverwrite != 0,
+		IfMatch:     a.IfMatch,
+		IfNoneMatch: a.Flags&addFileIfNoneMatch != 0,
+		Xattrs:      a.Xattrs,
+	}
+}
+
+// setOptions sets the fields of a that carry opts
+func (a *AddFileStruct) setOptions(opts sdfs.WriteOptions) {
+	a.Flags = 0
+	if opts.Overwrite {
+		a.Flags |= addFileOverwrite
+	}
+	if opts.IfNoneMatch {
+		a.Flags |= addFileIfNoneMatch
+	}
+	a.IfMatch = opts.IfMatch
+	a.Xattrs = opts.Xattrs
+}
+
+// write is apply that also returns the files left unreferenced by the write,
+// the replaced file or versions dropped from the path, their content should
+// be deleted from nodes by the leader
+func (a AddFileStruct) write() ([]*sdfs.File, error) {
+	var orphans []*sdfs.File
+	var err error
+	if a.Erasure.IsZero() {
+		_, orphans, err = sdfs.Fs.WriteChunked(a.Path, a.Hash, a.Size, time.Unix(0, a.ModTime), a.Chunks, a.options())
+	} else {
+		_, orphans, err = sdfs.Fs.WriteErasure(a.Path, a.Hash, a.Size, time.Unix(0, a.ModTime), a.Chunks, a.Erasure, a.options())
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range a.Host {
+		if err := sdfs.Fs.AddHost(a.Hash, h); err != nil {
+			return orphans, err
+		}
+	}
+	return orphans, nil
+}
+
+// AddHostStruct records that the node HostID holds a replica of the file
+// with checksum Hash
+type AddHostStruct struct {
+	HostID int32
+	Hash   string
+}
+
+func AddHostStructToEntry(v interface{}) (e *Entry) {
+	a := v.(AddHostStruct)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, a.HostID)
+	buf.Write([]byte(a.Hash))
+	e = &Entry{
+		Type: 9,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToAddHostStruct(e *Entry) interface{} {
+	a := AddHostStruct{}
+	r := bytes.NewReader(e.Data)
+	binary.Read(r, binary.LittleEndian, &a.HostID)
+	hash, _ := io.ReadAll(r)
+	a.Hash = string(hash)
+	return a
+}
+
+func AddHostExecutor(v interface{}) {
+	a := v.(AddHostStruct)
+	if err := sdfs.Fs.AddHost(a.Hash, a.HostID); err != nil {
+		log.Errorf("failed to add host from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// AddHost records a replica of the file with the given hash on the node at
+// addr, it is called when a replication task finishes
+func (s *Server) AddHost(hash, addr string) error {
+	id := s.NodeID(addr)
+	if id == -1 {
+		return sdfs.NewError(sdfs.ErrNotFound, "node not found")
+	}
+	return s.execute(AddHostStruct{HostID: id, Hash: hash}, func() error {
+		return sdfs.Fs.AddHost(hash, id)
+	})
+}
+
+// RemoveHostStruct records that the node HostID lost its replica of the
+// file with checksum Hash
+type RemoveHostStruct struct {
+	HostID int32
+	Hash   string
+}
+
+func RemoveHostStructToEntry(v interface{}) (e *Entry) {
+	a := v.(RemoveHostStruct)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, a.HostID)
+	buf.Write([]byte(a.Hash))
+	e = &Entry{
+		Type: 22,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToRemoveHostStruct(e *Entry) interface{} {
+	a := RemoveHostStruct{}
+	r := bytes.NewReader(e.Data)
+	binary.Read(r, binary.LittleEndian, &a.HostID)
+	hash, _ := io.ReadAll(r)
+	a.Hash = string(hash)
+	return a
+}
+
+func RemoveHostExecutor(v interface{}) {
+	a := v.(RemoveHostStruct)
+	log.Debugf("removing host %d of %s", a.HostID, a.Hash)
+	if _, err := sdfs.Fs.RemoveHost(a.Hash, a.HostID); err != nil {
+		log.Errorf("failed to remove host from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// DeleteFileStruct removes the file or symlink at Path from the namespace
+type DeleteFileStruct struct {
+	Path string
+}
+
+// RenameStruct moves the file or symlink at Src to Dst
+type RenameStruct struct {
+	Src string
+	Dst string
+}
+
+func DeleteFileStructToEntry(v interface{}) (e *Entry) {
+	a := v.(DeleteFileStruct)
+	e = &Entry{
+		Type: 10,
+		Data: []byte(a.Path),
+	}
+	return e
+}
+
+func EntryToDeleteFileStruct(e *Entry) interface{} {
+	return DeleteFileStruct{Path: string(e.Data)}
+}
+
+func DeleteFileExecutor(v interface{}) {
+	a := v.(DeleteFileStruct)
+	log.Debugf("deleting file from AppendEntries rpc call, file: %q", a.Path)
+	if err := sdfs.Fs.DeleteFile(a.Path); err != nil {
+		log.Errorf("failed to delete file from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+func RenameStructToEntry(v interface{}) (e *Entry) {
+	a := v.(RenameStruct)
+	buf := new(bytes.Buffer)
+	writeString(buf, a.Src)
+	writeString(buf, a.Dst)
+	e = &Entry{
+		Type: 11,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToRenameStruct(e *Entry) interface{} {
+	a := RenameStruct{}
+	r := bytes.NewReader(e.Data)
+	a.Src = readString(r)
+	a.Dst = readString(r)
+	return a
+}
+
+func RenameExecutor(v interface{}) {
+	a := v.(RenameStruct)
+	log.Debugf("renaming file from AppendEntries rpc call, %q -> %q", a.Src, a.Dst)
+	if err := sdfs.Fs.Rename(a.Src, a.Dst); err != nil {
+		log.Errorf("failed to rename file from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// DeleteFile removes the file or symlink at path from the namespace and
+// replicates the removal, objects on nodes are not touched
+func (s *Server) DeleteFile(path string) error {
+	return s.execute(DeleteFileStruct{Path: path}, func() error {
+		return sdfs.Fs.DeleteFile(path)
+	})
+}
+
+// Rename moves the file or symlink at src to dst and replicates it
+func (s *Server) Rename(src, dst string) error {
+	return s.execute(RenameStruct{Src: src, Dst: dst}, func() error {
+		return sdfs.Fs.Rename(src, dst)
+	})
+}
+
+// DeleteOrphans deletes the content of files removed from the FS from the
+// nodes that hold it
+func (s *Server) DeleteOrphans(orphans []*sdfs.File) error {
+	failed := 0
+	for _, f := range orphans {
+		if hosts := s.DeleteFromNodes(f); len(hosts) != 0 {
+			log.Errorf("failed to delete %s on %d nodes", f.Checksum, len(hosts))
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("failed to delete %d of %d files from nodes", failed, len(orphans))
+	}
+	return nil
+}
+
+// DeleteFromNodes deletes the content of f from the nodes that hold it, it
+// returns the nodes that failed to delete it. Chunks of f that other files
+// still contain are kept
+func (s *Server) DeleteFromNodes(f *sdfs.File) []int32 {
+	if f.Chunks == nil {
+		if sdfs.Fs.InUse(f.Checksum) {
+			return nil
+		}
+		return s.deleteObject(f.Checksum, f.Host)
+	}
+	var failed []int32
+	for _, c := range f.Chunks {
+		if sdfs.Fs.InUse(c.Hash) {
+			continue
+		}
+		failed = append(failed, s.deleteObject(c.Hash, c.Host)...)
+	}
+	return failed
+}
+
+// deleteObject deletes the object with the given hash from hosts, it
+// returns the hosts that failed to delete it
+func (s *Server) deleteObject(hash string, hosts []int32) []int32 {
+	var failed []int32
+	// TODO: use multiple goroutine
+	for _, v := range hosts {
+		h := s.NodeAddr(v)
+		url := fmt.Sprintf("%s%s%s?hash=%s&all=true", settings.URLSDFSScheme, h, settings.URLSDFSDelete, url.QueryEscape(hash))
+		log.Infof("deleting URL: %s", url)
+		resp, err := http.Get(url)
+		if err != nil {
+			log.Errorf("error sending delete request to node: %q", err)
+			failed = append(failed, v)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("err sending delete request to node: statusCode mismatch, expected: %d, get: %d", http.StatusOK, resp.StatusCode)
+			log.Errorf("%s", err)
+			failed = append(failed, v)
+		}
+	}
+	return failed
 }
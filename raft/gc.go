@@ -0,0 +1,121 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// CollectGarbage sends every node the objects it should keep according to
+// the FS, nodes remove their other objects that were not touched within
+// grace. Nothing is removed if dryRun is true, from nodes that host no file,
+// or from any node while some objects are hosted by ids of unknown address.
+// It returns the reports of the nodes by their addresses and the addresses
+// of the nodes that failed
+func (s *Server) CollectGarbage(grace time.Duration, dryRun bool) (map[string]sdfs.GCReport, []string, error) {
+	if s.cm.State() != LEADER {
+		return nil, nil, s.notLeader()
+	}
+	s.cm.mu.Lock()
+	nodes := make([]string, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		nodes = append(nodes, n.Addr)
+	}
+	s.cm.mu.Unlock()
+
+	live, unknown, err := sdfs.Fs.LiveObjects()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(unknown) != 0 && !dryRun {
+		log.Errorf("objects are hosted by nodes %v of unknown address, collecting garbage as a dry run", unknown)
+		dryRun = true
+	}
+	reports := make(map[string]sdfs.GCReport, len(nodes))
+	var failed []string
+	for _, addr := range nodes {
+		// a node hosting no file is more likely to be one whose objects are
+		// recorded under an id it no longer has than an empty one, so it is
+		// never swept
+		hashes, ok := live[addr]
+		report, err := requestCollect(addr, hashes, grace, dryRun || !ok)
+		if err != nil {
+			log.Errorf("failed to collect garbage on %s: %q", addr, err)
+			failed = append(failed, addr)
+			continue
+		}
+		reports[addr] = report
+	}
+	sort.Strings(failed)
+	return reports, failed, nil
+}
+
+// collectRequest asks a node to remove its objects that are not in Live
+type collectRequest struct {
+	Live   []string      `json:"live"`
+	Grace  time.Duration `json:"grace"`
+	DryRun bool          `json:"dry_run"`
+}
+
+// requestCollect requests the node at addr to remove the objects not in
+// live, it returns the report of the node
+func requestCollect(addr string, live []string, grace time.Duration, dryRun bool) (sdfs.GCReport, error) {
+	if live == nil {
+		// a node without live objects is told so, a missing list is rejected
+		live = []string{}
+	}
+	b, _ := json.Marshal(collectRequest{Live: live, Grace: grace, DryRun: dryRun})
+	url := fmt.Sprintf("%s%s%s", settings.URLSDFSScheme, addr, settings.URLSDFSGC)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return sdfs.GCReport{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return sdfs.GCReport{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return sdfs.GCReport{}, fmt.Errorf("error collecting garbage: status code mismatch: expected: %d, have: %d: %s", http.StatusOK, resp.StatusCode, body)
+	}
+	var result struct {
+		GC sdfs.GCReport `json:"gc"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return sdfs.GCReport{}, err
+	}
+	return result.GC, nil
+}
+
+// garbageCollector collects garbage on nodes periodically while the server
+// is the leader
+func (s *Server) garbageCollector() {
+	ticker := time.NewTicker(settings.GCInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.cm.State() != LEADER {
+			continue
+		}
+		reports, failed, err := s.CollectGarbage(settings.GCGracePeriod, false)
+		if err != nil {
+			log.Errorf("failed to collect garbage: %q", err)
+			continue
+		}
+		for addr, r := range reports {
+			if len(r.Objects) != 0 || len(r.Temp) != 0 {
+				log.Infof("collected %d orphaned objects of %d bytes and %d unfinished writes on %s", len(r.Objects), r.Size, len(r.Temp), addr)
+			}
+		}
+		if len(failed) != 0 {
+			log.Errorf("failed to collect garbage on %d of %d nodes", len(failed), len(failed)+len(reports))
+		}
+	}
+}
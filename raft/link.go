@@ -0,0 +1,92 @@
+package raft
+
+import (
+	"bytes"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// LinkStruct creates a hard link at Dst to the file at Src
+type LinkStruct struct {
+	Src string
+	Dst string
+}
+
+// SymlinkStruct creates a symbolic link at Path pointing to Target
+type SymlinkStruct struct {
+	Target string
+	Path   string
+}
+
+func LinkStructToEntry(v interface{}) (e *Entry) {
+	a := v.(LinkStruct)
+	buf := new(bytes.Buffer)
+	writeString(buf, a.Src)
+	writeString(buf, a.Dst)
+	e = &Entry{
+		Type: 5,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToLinkStruct(e *Entry) interface{} {
+	a := LinkStruct{}
+	r := bytes.NewReader(e.Data)
+	a.Src = readString(r)
+	a.Dst = readString(r)
+	return a
+}
+
+func LinkExecutor(v interface{}) {
+	a := v.(LinkStruct)
+	log.Debugf("adding link from AppendEntries rpc call, %q -> %q", a.Dst, a.Src)
+	if _, err := sdfs.Fs.Link(a.Src, a.Dst); err != nil {
+		log.Errorf("failed to add link from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+func SymlinkStructToEntry(v interface{}) (e *Entry) {
+	a := v.(SymlinkStruct)
+	buf := new(bytes.Buffer)
+	writeString(buf, a.Target)
+	writeString(buf, a.Path)
+	e = &Entry{
+		Type: 6,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToSymlinkStruct(e *Entry) interface{} {
+	a := SymlinkStruct{}
+	r := bytes.NewReader(e.Data)
+	a.Target = readString(r)
+	a.Path = readString(r)
+	return a
+}
+
+func SymlinkExecutor(v interface{}) {
+	a := v.(SymlinkStruct)
+	log.Debugf("adding symlink from AppendEntries rpc call, %q -> %q", a.Path, a.Target)
+	if err := sdfs.Fs.Symlink(a.Target, a.Path); err != nil {
+		log.Errorf("failed to add symlink from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// Link creates a hard link at dst to the file at src and replicates it
+func (s *Server) Link(src, dst string) error {
+	return s.execute(LinkStruct{Src: src, Dst: dst}, func() error {
+		_, err := sdfs.Fs.Link(src, dst)
+		return err
+	})
+}
+
+// Symlink creates a symbolic link at path pointing to target and replicates
+// it
+func (s *Server) Symlink(target, path string) error {
+	return s.execute(SymlinkStruct{Target: target, Path: path}, func() error {
+		return sdfs.Fs.Symlink(target, path)
+	})
+}
@@ -0,0 +1,87 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+type CreateNamespaceStruct struct {
+	Quota        uint64
+	ReplicaCount int32
+	Name         string
+}
+
+type DeleteNamespaceStruct struct {
+	Name string
+}
+
+func CreateNamespaceStructToEntry(v interface{}) (e *Entry) {
+	a := v.(CreateNamespaceStruct)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, a.Quota)
+	binary.Write(buf, binary.LittleEndian, a.ReplicaCount)
+	buf.Write([]byte(a.Name))
+	e = &Entry{
+		Type: 7,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToCreateNamespaceStruct(e *Entry) interface{} {
+	a := CreateNamespaceStruct{}
+	r := bytes.NewReader(e.Data)
+	binary.Read(r, binary.LittleEndian, &a.Quota)
+	binary.Read(r, binary.LittleEndian, &a.ReplicaCount)
+	name, _ := io.ReadAll(r)
+	a.Name = string(name)
+	return a
+}
+
+func CreateNamespaceExecutor(v interface{}) {
+	a := v.(CreateNamespaceStruct)
+	log.Debugf("creating namespace from AppendEntries rpc call, namespace: %q", a.Name)
+	if _, err := sdfs.Fs.CreateNamespace(a.Name, a.Quota, int(a.ReplicaCount)); err != nil {
+		log.Errorf("failed to create namespace from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+func DeleteNamespaceStructToEntry(v interface{}) (e *Entry) {
+	a := v.(DeleteNamespaceStruct)
+	e = &Entry{
+		Type: 8,
+		Data: []byte(a.Name),
+	}
+	return e
+}
+
+func EntryToDeleteNamespaceStruct(e *Entry) interface{} {
+	return DeleteNamespaceStruct{Name: string(e.Data)}
+}
+
+func DeleteNamespaceExecutor(v interface{}) {
+	a := v.(DeleteNamespaceStruct)
+	log.Debugf("deleting namespace from AppendEntries rpc call, namespace: %q", a.Name)
+	if err := sdfs.Fs.DeleteNamespace(a.Name); err != nil {
+		log.Errorf("failed to delete namespace from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// CreateNamespace creates a namespace and replicates it
+func (s *Server) CreateNamespace(name string, quota uint64, replicaCount int) error {
+	return s.execute(CreateNamespaceStruct{Quota: quota, ReplicaCount: int32(replicaCount), Name: name}, func() error {
+		_, err := sdfs.Fs.CreateNamespace(name, quota, replicaCount)
+		return err
+	})
+}
+
+// DeleteNamespace deletes an empty namespace and replicates the deletion
+func (s *Server) DeleteNamespace(name string) error {
+	return s.execute(DeleteNamespaceStruct{Name: name}, func() error {
+		return sdfs.Fs.DeleteNamespace(name)
+	})
+}
@@ -0,0 +1,62 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// SetQuotaStruct sets the quota of the directory at Path, a zero quota removes
+// the limits
+type SetQuotaStruct struct {
+	Bytes         uint64
+	PhysicalBytes uint64
+	Files         uint64
+	Path          string
+}
+
+func (a SetQuotaStruct) quota() sdfs.DirQuota {
+	return sdfs.DirQuota{Bytes: a.Bytes, PhysicalBytes: a.PhysicalBytes, Files: a.Files}
+}
+
+func SetQuotaStructToEntry(v interface{}) (e *Entry) {
+	a := v.(SetQuotaStruct)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, a.Bytes)
+	binary.Write(buf, binary.LittleEndian, a.PhysicalBytes)
+	binary.Write(buf, binary.LittleEndian, a.Files)
+	writeString(buf, a.Path)
+	e = &Entry{
+		Type: 14,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToSetQuotaStruct(e *Entry) interface{} {
+	a := SetQuotaStruct{}
+	r := bytes.NewReader(e.Data)
+	binary.Read(r, binary.LittleEndian, &a.Bytes)
+	binary.Read(r, binary.LittleEndian, &a.PhysicalBytes)
+	binary.Read(r, binary.LittleEndian, &a.Files)
+	a.Path = readString(r)
+	return a
+}
+
+func SetQuotaExecutor(v interface{}) {
+	a := v.(SetQuotaStruct)
+	log.Debugf("setting quota from AppendEntries rpc call, path: %s, quota: %+v", a.Path, a.quota())
+	if err := sdfs.Fs.SetQuota(a.Path, a.quota()); err != nil {
+		log.Errorf("failed to set quota from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// SetQuota sets the quota of the directory at path and replicates it
+func (s *Server) SetQuota(path string, q sdfs.DirQuota) error {
+	cmd := SetQuotaStruct{Bytes: q.Bytes, PhysicalBytes: q.PhysicalBytes, Files: q.Files, Path: path}
+	return s.execute(cmd, func() error {
+		return sdfs.Fs.SetQuota(path, q)
+	})
+}
@@ -124,6 +124,15 @@ func (cm *ConsensusModule) lastLogIndexAndTerm() (uint64, uint64) {
 // Submit tries to append entry to the log, it returns leader id on failure
 // TODO: Refactor to return leader address on failure
 func (cm *ConsensusModule) Submit(cmd interface{}) (res bool, id int32) {
+	res, id, _ = cm.submit(cmd, nil)
+	return res, id
+}
+
+// submit is Submit with a change of the leader's state made by apply, cmd is
+// appended only if apply succeeds. Both happen under cm.mu, so leadership can
+// not be lost in between and entries are appended in the order their changes
+// were made
+func (cm *ConsensusModule) submit(cmd interface{}, apply func() error) (res bool, id int32, err error) {
 	log.Debugf("Submit requested")
 	defer log.Debugf("Submit result: %t", res)
 	cm.mu.Lock()
@@ -131,16 +140,26 @@ func (cm *ConsensusModule) Submit(cmd interface{}) (res bool, id int32) {
 
 	log.Debugf("Submit received by %v: %v", cm.state, cmd)
 	if cm.state == LEADER {
+		if apply != nil {
+			if err := apply(); err != nil {
+				return false, cm.id, err
+			}
+		}
 		log.Debugf("log=%v", cm.log)
 		cm.log = append(cm.log, LogEntry{Command: cmd, Term: cm.currentTerm})
 		e := Serialize(LogEntry{Command: cmd, Term: cm.currentTerm})
 		enc := gob.NewEncoder(cm.server.logFile)
 		_ = enc.Encode(e)
+		// without peers an entry is committed once it is appended
+		if len(cm.peerIds) == 0 {
+			cm.commitIndex = uint64(len(cm.log) - 1)
+			cm.server.feed.notify()
+		}
 
-		return true, cm.id
+		return true, cm.id, nil
 	}
 	log.Debugf("cm is not leader, leader ID: %d", cm.currentLeader)
-	return false, cm.currentLeader
+	return false, cm.currentLeader, nil
 }
 
 func (cm *ConsensusModule) runElectionTimer() {
@@ -372,6 +391,7 @@ func (cm *ConsensusModule) leaderSendHeartbeats() {
 						}
 						if cm.commitIndex != savedCommitIndex {
 							//cm.newCommitReadyChan <- struct{}{}
+							cm.server.feed.notify()
 						}
 					} else {
 						cm.nextIndex[peerId] = ni - 1
@@ -446,6 +466,7 @@ func (cm *ConsensusModule) AppendEntries(req *AppendEntriesRequest) (*AppendEntr
 				cm.commitIndex = uint64(len(cm.log) - 1)
 				cm.newCommitReadyChan <- struct{}{}
 			}
+			cm.server.feed.notify()
 		}
 	}
 
@@ -25,6 +25,7 @@ type RaftClient interface {
 	RequestVote(ctx context.Context, in *RequestVoteRequest, opts ...grpc.CallOption) (*RequestVoteResponse, error)
 	AppendEntries(ctx context.Context, in *AppendEntriesRequest, opts ...grpc.CallOption) (*AppendEntriesResponse, error)
 	RegisterMaster(ctx context.Context, in *RegisterMasterRequest, opts ...grpc.CallOption) (*RegisterMasterResponse, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Raft_WatchClient, error)
 }
 
 type raftClient struct {
@@ -62,6 +63,38 @@ func (c *raftClient) RegisterMaster(ctx context.Context, in *RegisterMasterReque
 	return out, nil
 }
 
+func (c *raftClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Raft_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Raft_ServiceDesc.Streams[0], "/Raft/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &raftWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Raft_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type raftWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *raftWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // RaftServer is the server API for Raft service.
 // All implementations must embed UnimplementedRaftServer
 // for forward compatibility
@@ -69,6 +102,7 @@ type RaftServer interface {
 	RequestVote(context.Context, *RequestVoteRequest) (*RequestVoteResponse, error)
 	AppendEntries(context.Context, *AppendEntriesRequest) (*AppendEntriesResponse, error)
 	RegisterMaster(context.Context, *RegisterMasterRequest) (*RegisterMasterResponse, error)
+	Watch(*WatchRequest, Raft_WatchServer) error
 	mustEmbedUnimplementedRaftServer()
 }
 
@@ -85,6 +119,9 @@ func (UnimplementedRaftServer) AppendEntries(context.Context, *AppendEntriesRequ
 func (UnimplementedRaftServer) RegisterMaster(context.Context, *RegisterMasterRequest) (*RegisterMasterResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RegisterMaster not implemented")
 }
+func (UnimplementedRaftServer) Watch(*WatchRequest, Raft_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
 func (UnimplementedRaftServer) mustEmbedUnimplementedRaftServer() {}
 
 // UnsafeRaftServer may be embedded to opt out of forward compatibility for this service.
@@ -152,6 +189,27 @@ func _Raft_RegisterMaster_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Raft_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RaftServer).Watch(m, &raftWatchServer{stream})
+}
+
+type Raft_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type raftWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *raftWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // Raft_ServiceDesc is the grpc.ServiceDesc for Raft service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -172,6 +230,12 @@ var Raft_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _Raft_RegisterMaster_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Raft_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "raft/raft.proto",
 }
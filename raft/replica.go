@@ -3,8 +3,10 @@ package raft
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/Lyianu/sdfs/pkg/queue"
 	"github.com/Lyianu/sdfs/pkg/settings"
 	"github.com/Lyianu/sdfs/router"
+	"github.com/Lyianu/sdfs/sdfs"
 )
 
 type replicaManager struct {
@@ -31,6 +34,10 @@ type replicaTask struct {
 
 	FailedNodes []string
 
+	// Shard is set if Hash is a lost shard of an erasure-coded file, the
+	// node rebuilds it from the other shards instead of copying it
+	Shard *shardTask
+
 	// when TTL equals 0, the task is dropped and error message will be logged
 	TTL int
 }
@@ -52,17 +59,19 @@ func (r *replicaManager) Start() {
 
 // replicaMngrPoller loops until receives from stop
 func (r *replicaManager) replicaMngrPoller() {
-	select {
-	case <-r.stop:
-		return
-	default:
-		task, ok := r.q.Pop().(replicaTask)
-		if !ok {
-		} else {
-			r.tickets <- struct{}{}
-			go r.ExecuteTask(task)
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+			task, ok := r.q.Pop().(replicaTask)
+			if !ok {
+			} else {
+				r.tickets <- struct{}{}
+				go r.ExecuteTask(task)
+			}
+			time.Sleep(10 * time.Millisecond)
 		}
-		time.Sleep(10 * time.Millisecond)
 	}
 }
 
@@ -85,12 +94,144 @@ func (r *replicaManager) ExecuteTask(task replicaTask) {
 			log.Errorf("remove 0TTL task from queue: %+v", task)
 		}
 	}
-	r.pending.Store(task, struct{}{})
+	r.pending.Store(task.Hash, struct{}{})
 	<-r.tickets
 }
 
+// replicateFile schedules replication of the file with the given hash from
+// host, so that count nodes hold the file
+func (s *Server) replicateFile(hash, host string, count int) {
+	if count <= 1 {
+		return
+	}
+	nodes := s.pickNodes(count-1, host)
+	if len(nodes) == 0 {
+		log.Errorf("no nodes available to replicate %s", hash)
+		return
+	}
+	s.ReplicaMngr.AddTask(replicaTask{
+		Host:            host,
+		ReplicatedNodes: nodes,
+		Hash:            hash,
+		TTL:             3,
+	})
+}
+
+// replicateChunks schedules replication of chunks from host, so that count
+// nodes hold each of them. Chunks are spread over the nodes instead of all
+// going to the ones with the most spare space
+func (s *Server) replicateChunks(chunks []sdfs.ChunkRef, host string, count int) {
+	if count <= 1 {
+		return
+	}
+	s.cm.mu.Lock()
+	all := len(s.nodes)
+	s.cm.mu.Unlock()
+	candidates := s.pickNodes(all, host)
+	if len(candidates) == 0 {
+		log.Errorf("no nodes available to replicate chunks from %s", host)
+		return
+	}
+	n := count - 1
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	for i, c := range chunks {
+		nodes := make([]string, 0, n)
+		for j := 0; j < n; j++ {
+			nodes = append(nodes, candidates[(i+j)%len(candidates)])
+		}
+		s.ReplicaMngr.AddTask(replicaTask{
+			Host:            host,
+			ReplicatedNodes: nodes,
+			Hash:            c.Hash,
+			TTL:             3,
+		})
+	}
+}
+
+// ReportCorrupt handles a corrupt replica of the file with the given hash
+// that the node at addr found and quarantined, the node is no longer
+// recorded as a host and the file is replicated again from a healthy copy
+func (s *Server) ReportCorrupt(hash, addr string) error {
+	id := s.NodeID(addr)
+	if id == -1 {
+		return sdfs.NewError(sdfs.ErrNotFound, "node not found")
+	}
+	var hosts []int32
+	err := s.execute(RemoveHostStruct{HostID: id, Hash: hash}, func() (err error) {
+		hosts, err = sdfs.Fs.RemoveHost(hash, id)
+		return err
+	})
+	if errors.Is(err, sdfs.ErrNotFound) {
+		log.Infof("corrupt replica of %s on %s is no longer referenced", hash, addr)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if len(hosts) == 0 {
+		if file, i, ok := sdfs.Fs.Shard(hash); ok {
+			s.reconstructShard(file, i)
+			return nil
+		}
+		log.Errorf("no healthy replica of %s is left", hash)
+		return nil
+	}
+	healthy := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		healthy = append(healthy, s.NodeAddr(h))
+	}
+	nodes := s.pickNodes(1, append(healthy, addr)...)
+	if len(nodes) == 0 {
+		// the reporting node is the only one that can take the replica
+		nodes = s.pickNodes(1, healthy...)
+	}
+	if len(nodes) == 0 {
+		log.Errorf("no nodes available to replicate %s", hash)
+		return nil
+	}
+	s.ReplicaMngr.AddTask(replicaTask{
+		Host:            healthy[0],
+		ReplicatedNodes: nodes,
+		Hash:            hash,
+		TTL:             3,
+	})
+	return nil
+}
+
+// pickNodes returns the addresses of at most n nodes with the most spare
+// space, except the ones in exclude
+func (s *Server) pickNodes(n int, exclude ...string) []string {
+	s.cm.mu.Lock()
+	defer s.cm.mu.Unlock()
+	var nodes []*Node
+	for _, node := range s.nodes {
+		excluded := false
+		for _, e := range exclude {
+			if node.Addr == e {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Disk > nodes[j].Disk
+	})
+	var addrs []string
+	for i := 0; i < n && i < len(nodes); i++ {
+		addrs = append(addrs, nodes[i].Addr)
+	}
+	return addrs
+}
+
 // RequestReplica requests nodes to create replica
 func RequestReplica(task replicaTask) error {
+	if task.Shard != nil {
+		return requestReconstruct(task)
+	}
 	addr, err := router.HTTPGetFileDownloadAddress(task.Host, task.Hash, "a")
 	if err != nil {
 		log.Errorf("failed to create download for replication task")
@@ -41,6 +41,8 @@ type Server struct {
 	ReplicaMngr *replicaManager
 
 	logFile *os.File
+	// feed notifies watchers of newly committed log entries
+	feed *feed
 
 	// sdfs as raft client
 	FS *sdfs.FS
@@ -60,6 +62,10 @@ type Node struct {
 	Size     int64 // size already used by hashstore
 	Disk     int64 // remaining disk space
 	RX, TX   int64
+	// Disks are the data directories of the node
+	Disks []sdfs.DiskStat
+	// Compression describes how well the objects of the node compress
+	Compression sdfs.CompressionStats
 
 	// timestamp of the last heartheat
 	LastHeartbeat int64
@@ -107,36 +113,67 @@ func (s *Server) NodeID(addr string) int32 {
 	return n.ID
 }
 
+// Compression returns how well the objects of every node compress by the
+// addresses of the nodes, as of their last heartbeats. Only the leader
+// receives heartbeats
+func (s *Server) Compression() (map[string]sdfs.CompressionStats, error) {
+	if s.cm.State() != LEADER {
+		return nil, s.notLeader()
+	}
+	s.cm.mu.Lock()
+	defer s.cm.mu.Unlock()
+	stats := make(map[string]sdfs.CompressionStats, len(s.nodes))
+	for _, n := range s.nodes {
+		stats[n.Addr] = n.Compression
+	}
+	return stats, nil
+}
+
 // UpdateNode updates node's info
-func (s *Server) UpdateNode(addr string, cpu, memory float64, size, disk int64) (string, error) {
+func (s *Server) UpdateNode(addr string, cpu, memory float64, size, disk int64, disks []sdfs.DiskStat, compression sdfs.CompressionStats) (string, error) {
 	s.cm.mu.Lock()
-	if _, ok := s.nodeAddr[addr]; ok {
-		s.nodeAddr[addr].CpuUsage = cpu
-		s.nodeAddr[addr].MemUsage = memory
-		s.nodeAddr[addr].Size = size
-		s.nodeAddr[addr].Disk = disk
+	if n, ok := s.nodeAddr[addr]; ok {
+		n.CpuUsage = cpu
+		n.MemUsage = memory
+		n.Size = size
+		n.Disk = disk
+		logFailedDisks(n, n.Disks, disks)
+		n.Disks = disks
+		n.Compression = compression
 		s.cm.mu.Unlock()
 		return "", nil
 	}
 
-	ok := false
-	rnd := rand.Int31()
-	for !ok {
-		_, ok = s.nodes[rnd]
-		if !ok {
-			break
-		}
+	// a node keeps its id across restarts of the master, ids recorded in
+	// the hosts of files would name another node otherwise
+	rnd, ok := sdfs.Fs.NodeID(addr)
+	if !ok {
+		used := sdfs.Fs.Nodes()
 		rnd = rand.Int31()
+		for {
+			_, live := s.nodes[rnd]
+			if _, known := used[rnd]; !live && !known {
+				break
+			}
+			rnd = rand.Int31()
+		}
+		if err := sdfs.Fs.AddNode(rnd, addr); err != nil {
+			s.cm.mu.Unlock()
+			return "", err
+		}
 	}
 
 	n := &Node{
-		Addr:     addr,
-		ID:       rnd,
-		CpuUsage: cpu,
-		MemUsage: memory,
-		Size:     size,
-		Disk:     disk,
+		Addr:        addr,
+		ID:          rnd,
+		CpuUsage:    cpu,
+		MemUsage:    memory,
+		Size:        size,
+		Disk:        disk,
+		Disks:       disks,
+		Compression: compression,
 	}
+	logFailedDisks(n, nil, disks)
 	s.nodes[rnd] = n
 	s.nodeAddr[addr] = n
 	s.cm.mu.Unlock()
@@ -145,7 +182,7 @@ func (s *Server) UpdateNode(addr string, cpu, memory float64, size, disk int64)
 		NodeAddr: addr,
 	})
 	if !res {
-		return Raft.PeerAddr(id), errors.New("failed to add node to cluster")
+		return Raft.PeerAddr(id), &NotLeaderError{Leader: Raft.PeerAddr(id)}
 	}
 	log.Infof("add node to the cluster: %s", n.Addr)
 	// add node to priority queue for later use
@@ -153,6 +190,36 @@ func (s *Server) UpdateNode(addr string, cpu, memory float64, size, disk int64)
 	return "", nil
 }
 
+// logFailedDisks logs the disks of n that are unhealthy in cur but were not
+// in prev
+func logFailedDisks(n *Node, prev, cur []sdfs.DiskStat) {
+	healthy := make(map[string]bool, len(prev))
+	for _, d := range prev {
+		healthy[d.Dir] = d.Healthy
+	}
+	for _, d := range cur {
+		if was, ok := healthy[d.Dir]; !d.Healthy && (was || !ok) {
+			log.Errorf("disk %s of node %s failed: %s", d.Dir, n.Addr, d.Error)
+		}
+	}
+}
+
+// execute applies a change to the leader's state with apply, then appends cmd
+// to the log so that followers apply the same change from AppendEntries. The
+// consensus module is locked meanwhile, so a change is never made without its
+// entry, apply should only change the FS
+func (s *Server) execute(cmd interface{}, apply func() error) error {
+	res, id, err := s.cm.submit(cmd, apply)
+	if err != nil {
+		return err
+	}
+	if !res {
+		log.Debugf("command sent to non leader master, leader is %d", id)
+		return s.notLeader()
+	}
+	return nil
+}
+
 // listen specifies the address at which server listens, connect specifies the
 // server to connect(to receive AE rpcs) at first, if connect is empty
 // it start as the first node in raft cluster
@@ -184,14 +251,20 @@ func NewServer(listen, connect, addr string) (*Server, error) {
 		peerAddr:    make(map[int32]string),
 		nodes:       make(map[int32]*Node),
 		nodeAddr:    make(map[string]*Node),
-		FS:          sdfs.NewFS(),
+		FS:          sdfs.Fs,
 		UploadMngr:  newUploadManager(),
 		ReplicaMngr: newReplicaMngr(),
 		logFile:     f,
+		feed:        newFeed(),
 	}
 	s.UploadMngr.svr = s
 	s.cm.server = s
 	Raft = s
+	s.ReplicaMngr.Start()
+	go s.trashPurger()
+	if settings.GCInterval > 0 {
+		go s.garbageCollector()
+	}
 	// temporarily disable log due to lack of the operation of remove old master ID when master restarts
 	// s.LoadLog()
 
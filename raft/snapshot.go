@@ -0,0 +1,107 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// CreateSnapshotStruct takes a snapshot named Name of the directory at Path
+type CreateSnapshotStruct struct {
+	Created int64 // unix nanoseconds
+	Path    string
+	Name    string
+}
+
+// DeleteSnapshotStruct deletes the snapshot named Name of the directory at
+// Path
+type DeleteSnapshotStruct struct {
+	Path string
+	Name string
+}
+
+func CreateSnapshotStructToEntry(v interface{}) (e *Entry) {
+	a := v.(CreateSnapshotStruct)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, a.Created)
+	writeString(buf, a.Path)
+	writeString(buf, a.Name)
+	e = &Entry{
+		Type: 12,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToCreateSnapshotStruct(e *Entry) interface{} {
+	a := CreateSnapshotStruct{}
+	r := bytes.NewReader(e.Data)
+	binary.Read(r, binary.LittleEndian, &a.Created)
+	a.Path = readString(r)
+	a.Name = readString(r)
+	return a
+}
+
+func CreateSnapshotExecutor(v interface{}) {
+	a := v.(CreateSnapshotStruct)
+	log.Debugf("creating snapshot from AppendEntries rpc call, snapshot: %s@%s", a.Path, a.Name)
+	if _, err := sdfs.Fs.CreateSnapshot(a.Path, a.Name, time.Unix(0, a.Created)); err != nil {
+		log.Errorf("failed to create snapshot from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+func DeleteSnapshotStructToEntry(v interface{}) (e *Entry) {
+	a := v.(DeleteSnapshotStruct)
+	buf := new(bytes.Buffer)
+	writeString(buf, a.Path)
+	writeString(buf, a.Name)
+	e = &Entry{
+		Type: 13,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToDeleteSnapshotStruct(e *Entry) interface{} {
+	a := DeleteSnapshotStruct{}
+	r := bytes.NewReader(e.Data)
+	a.Path = readString(r)
+	a.Name = readString(r)
+	return a
+}
+
+func DeleteSnapshotExecutor(v interface{}) {
+	a := v.(DeleteSnapshotStruct)
+	log.Debugf("deleting snapshot from AppendEntries rpc call, snapshot: %s@%s", a.Path, a.Name)
+	if _, err := sdfs.Fs.DeleteSnapshot(a.Path, a.Name); err != nil {
+		log.Errorf("failed to delete snapshot from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// CreateSnapshot takes a snapshot named name of the directory at path and
+// replicates it
+func (s *Server) CreateSnapshot(path, name string) (*sdfs.Snapshot, error) {
+	var snap *sdfs.Snapshot
+	cmd := CreateSnapshotStruct{Created: time.Now().UnixNano(), Path: path, Name: name}
+	err := s.execute(cmd, func() error {
+		var err error
+		snap, err = sdfs.Fs.CreateSnapshot(path, name, time.Unix(0, cmd.Created))
+		return err
+	})
+	return snap, err
+}
+
+// DeleteSnapshot deletes a snapshot and replicates it, the returned files are
+// no longer referenced and their content should be deleted from nodes
+func (s *Server) DeleteSnapshot(path, name string) ([]*sdfs.File, error) {
+	var orphans []*sdfs.File
+	err := s.execute(DeleteSnapshotStruct{Path: path, Name: name}, func() error {
+		var err error
+		orphans, err = sdfs.Fs.DeleteSnapshot(path, name)
+		return err
+	})
+	return orphans, err
+}
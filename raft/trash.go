@@ -0,0 +1,232 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// TrashFileStruct moves the file at Path to the trash, Deleted identifies the
+// trash entry
+type TrashFileStruct struct {
+	Deleted int64 // unix nanoseconds
+	Path    string
+}
+
+// RestoreTrashStruct puts the file of a trash entry back at Path
+type RestoreTrashStruct struct {
+	Namespace string
+	ID        string
+	Path      string
+}
+
+// PurgeTrashStruct removes a trash entry
+type PurgeTrashStruct struct {
+	Namespace string
+	ID        string
+}
+
+// SetTrashRetentionStruct sets the trash retention of a namespace
+type SetTrashRetentionStruct struct {
+	Retention int64 // nanoseconds
+	Namespace string
+}
+
+func TrashFileStructToEntry(v interface{}) (e *Entry) {
+	a := v.(TrashFileStruct)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, a.Deleted)
+	writeString(buf, a.Path)
+	e = &Entry{
+		Type: 15,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToTrashFileStruct(e *Entry) interface{} {
+	a := TrashFileStruct{}
+	r := bytes.NewReader(e.Data)
+	binary.Read(r, binary.LittleEndian, &a.Deleted)
+	a.Path = readString(r)
+	return a
+}
+
+func TrashFileExecutor(v interface{}) {
+	a := v.(TrashFileStruct)
+	log.Debugf("moving file to the trash from AppendEntries rpc call, path: %s", a.Path)
+	if _, err := sdfs.Fs.TrashFile(a.Path, time.Unix(0, a.Deleted)); err != nil {
+		log.Errorf("failed to move file to the trash from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+func RestoreTrashStructToEntry(v interface{}) (e *Entry) {
+	a := v.(RestoreTrashStruct)
+	buf := new(bytes.Buffer)
+	writeString(buf, a.Namespace)
+	writeString(buf, a.ID)
+	writeString(buf, a.Path)
+	e = &Entry{
+		Type: 16,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToRestoreTrashStruct(e *Entry) interface{} {
+	a := RestoreTrashStruct{}
+	r := bytes.NewReader(e.Data)
+	a.Namespace = readString(r)
+	a.ID = readString(r)
+	a.Path = readString(r)
+	return a
+}
+
+func RestoreTrashExecutor(v interface{}) {
+	a := v.(RestoreTrashStruct)
+	log.Debugf("restoring trash entry from AppendEntries rpc call, entry: %s/%s, path: %s", a.Namespace, a.ID, a.Path)
+	if _, err := sdfs.Fs.RestoreTrash(a.Namespace, a.ID, a.Path); err != nil {
+		log.Errorf("failed to restore trash entry from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+func PurgeTrashStructToEntry(v interface{}) (e *Entry) {
+	a := v.(PurgeTrashStruct)
+	buf := new(bytes.Buffer)
+	writeString(buf, a.Namespace)
+	writeString(buf, a.ID)
+	e = &Entry{
+		Type: 17,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToPurgeTrashStruct(e *Entry) interface{} {
+	a := PurgeTrashStruct{}
+	r := bytes.NewReader(e.Data)
+	a.Namespace = readString(r)
+	a.ID = readString(r)
+	return a
+}
+
+func PurgeTrashExecutor(v interface{}) {
+	a := v.(PurgeTrashStruct)
+	log.Debugf("purging trash entry from AppendEntries rpc call, entry: %s/%s", a.Namespace, a.ID)
+	if _, err := sdfs.Fs.PurgeTrash(a.Namespace, a.ID); err != nil {
+		log.Errorf("failed to purge trash entry from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+func SetTrashRetentionStructToEntry(v interface{}) (e *Entry) {
+	a := v.(SetTrashRetentionStruct)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, a.Retention)
+	writeString(buf, a.Namespace)
+	e = &Entry{
+		Type: 18,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToSetTrashRetentionStruct(e *Entry) interface{} {
+	a := SetTrashRetentionStruct{}
+	r := bytes.NewReader(e.Data)
+	binary.Read(r, binary.LittleEndian, &a.Retention)
+	a.Namespace = readString(r)
+	return a
+}
+
+func SetTrashRetentionExecutor(v interface{}) {
+	a := v.(SetTrashRetentionStruct)
+	log.Debugf("setting trash retention from AppendEntries rpc call, namespace: %s, retention: %v", a.Namespace, time.Duration(a.Retention))
+	if err := sdfs.Fs.SetTrashRetention(a.Namespace, time.Duration(a.Retention)); err != nil {
+		log.Errorf("failed to set trash retention from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// TrashFile moves the file at path to the trash and replicates it
+func (s *Server) TrashFile(path string) (*sdfs.TrashEntry, error) {
+	var e *sdfs.TrashEntry
+	cmd := TrashFileStruct{Deleted: time.Now().UnixNano(), Path: path}
+	err := s.execute(cmd, func() error {
+		var err error
+		e, err = sdfs.Fs.TrashFile(path, time.Unix(0, cmd.Deleted))
+		return err
+	})
+	return e, err
+}
+
+// RestoreTrash puts the file of a trash entry back at path, or where it was
+// deleted from if path is empty, and replicates it
+func (s *Server) RestoreTrash(ns, id, path string) (*sdfs.File, error) {
+	if path == "" {
+		// followers are sent the actual path so that watchers see it
+		e, err := sdfs.Fs.GetTrash(ns, id)
+		if err != nil {
+			return nil, err
+		}
+		path = e.Path
+	}
+	var f *sdfs.File
+	err := s.execute(RestoreTrashStruct{Namespace: ns, ID: id, Path: path}, func() error {
+		var err error
+		f, err = sdfs.Fs.RestoreTrash(ns, id, path)
+		return err
+	})
+	return f, err
+}
+
+// PurgeTrash removes a trash entry and replicates it, the returned files are
+// no longer referenced and their content should be deleted from nodes
+func (s *Server) PurgeTrash(ns, id string) ([]*sdfs.File, error) {
+	var orphans []*sdfs.File
+	err := s.execute(PurgeTrashStruct{Namespace: ns, ID: id}, func() error {
+		var err error
+		orphans, err = sdfs.Fs.PurgeTrash(ns, id)
+		return err
+	})
+	return orphans, err
+}
+
+// SetTrashRetention sets the trash retention of namespace ns and replicates
+// it
+func (s *Server) SetTrashRetention(ns string, retention time.Duration) error {
+	cmd := SetTrashRetentionStruct{Retention: int64(retention), Namespace: ns}
+	return s.execute(cmd, func() error {
+		return sdfs.Fs.SetTrashRetention(ns, retention)
+	})
+}
+
+// trashPurger purges expired trash entries periodically while the server is
+// the leader
+func (s *Server) trashPurger() {
+	ticker := time.NewTicker(settings.TrashPurgeInterval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if s.cm.State() != LEADER {
+			continue
+		}
+		s.purgeExpired(now)
+	}
+}
+
+// purgeExpired purges the trash entries that expire before now and deletes
+// the content no longer referenced from nodes
+func (s *Server) purgeExpired(now time.Time) {
+	for _, e := range sdfs.Fs.ExpiredTrash(now) {
+		orphans, err := s.PurgeTrash(e.Namespace, e.ID)
+		if err != nil {
+			log.Errorf("failed to purge trash entry %s/%s: %q", e.Namespace, e.ID, err)
+			continue
+		}
+		if err := s.DeleteOrphans(orphans); err != nil {
+			log.Errorf("failed to purge trash entry %s/%s from nodes: %q", e.Namespace, e.ID, err)
+		}
+	}
+}
@@ -2,6 +2,9 @@
 package raft
 
 import (
+	"bytes"
+	"encoding/binary"
+	"io"
 	"reflect"
 
 	"github.com/Lyianu/sdfs/log"
@@ -33,6 +36,27 @@ func init() {
 
 	RegisterCommandConversionHandler(3, AddNodeStruct{}, AddNodeStructToEntry, EntryToAddNodeStruct, AddNodeExecutor)
 	RegisterCommandConversionHandler(1, AddServerStruct{}, AddServerStructToEntry, EntryToAddServerStruct, AddServerExecutor)
+	RegisterCommandConversionHandler(2, AddFileStruct{}, AddFileStructToEntry, EntryToAddFileStruct, AddFileExecutor)
+	RegisterCommandConversionHandler(4, SetXattrStruct{}, SetXattrStructToEntry, EntryToSetXattrStruct, SetXattrExecutor)
+	RegisterCommandConversionHandler(5, LinkStruct{}, LinkStructToEntry, EntryToLinkStruct, LinkExecutor)
+	RegisterCommandConversionHandler(6, SymlinkStruct{}, SymlinkStructToEntry, EntryToSymlinkStruct, SymlinkExecutor)
+	RegisterCommandConversionHandler(7, CreateNamespaceStruct{}, CreateNamespaceStructToEntry, EntryToCreateNamespaceStruct, CreateNamespaceExecutor)
+	RegisterCommandConversionHandler(8, DeleteNamespaceStruct{}, DeleteNamespaceStructToEntry, EntryToDeleteNamespaceStruct, DeleteNamespaceExecutor)
+	RegisterCommandConversionHandler(9, AddHostStruct{}, AddHostStructToEntry, EntryToAddHostStruct, AddHostExecutor)
+	RegisterCommandConversionHandler(10, DeleteFileStruct{}, DeleteFileStructToEntry, EntryToDeleteFileStruct, DeleteFileExecutor)
+	RegisterCommandConversionHandler(11, RenameStruct{}, RenameStructToEntry, EntryToRenameStruct, RenameExecutor)
+	RegisterCommandConversionHandler(12, CreateSnapshotStruct{}, CreateSnapshotStructToEntry, EntryToCreateSnapshotStruct, CreateSnapshotExecutor)
+	RegisterCommandConversionHandler(13, DeleteSnapshotStruct{}, DeleteSnapshotStructToEntry, EntryToDeleteSnapshotStruct, DeleteSnapshotExecutor)
+	RegisterCommandConversionHandler(14, SetQuotaStruct{}, SetQuotaStructToEntry, EntryToSetQuotaStruct, SetQuotaExecutor)
+	RegisterCommandConversionHandler(15, TrashFileStruct{}, TrashFileStructToEntry, EntryToTrashFileStruct, TrashFileExecutor)
+	RegisterCommandConversionHandler(16, RestoreTrashStruct{}, RestoreTrashStructToEntry, EntryToRestoreTrashStruct, RestoreTrashExecutor)
+	RegisterCommandConversionHandler(17, PurgeTrashStruct{}, PurgeTrashStructToEntry, EntryToPurgeTrashStruct, PurgeTrashExecutor)
+	RegisterCommandConversionHandler(18, SetTrashRetentionStruct{}, SetTrashRetentionStructToEntry, EntryToSetTrashRetentionStruct, SetTrashRetentionExecutor)
+	RegisterCommandConversionHandler(19, SetVersioningStruct{}, SetVersioningStructToEntry, EntryToSetVersioningStruct, SetVersioningExecutor)
+	RegisterCommandConversionHandler(20, DeleteVersionStruct{}, DeleteVersionStructToEntry, EntryToDeleteVersionStruct, DeleteVersionExecutor)
+	RegisterCommandConversionHandler(21, CopyStruct{}, CopyStructToEntry, EntryToCopyStruct, CopyExecutor)
+	RegisterCommandConversionHandler(22, RemoveHostStruct{}, RemoveHostStructToEntry, EntryToRemoveHostStruct, RemoveHostExecutor)
+	RegisterCommandConversionHandler(23, SetErasureStruct{}, SetErasureStructToEntry, EntryToSetErasureStruct, SetErasureExecutor)
 }
 
 func Serialize(le LogEntry) *Entry {
@@ -69,3 +93,22 @@ func EntryType(v interface{}) int32 {
 	t := reflect.TypeOf(v)
 	return cmdEntryId[t]
 }
+
+// writeString writes a length-prefixed string to buf, commands with more than
+// one variable-length field use it to keep fields apart
+func writeString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.LittleEndian, int32(len(s)))
+	buf.WriteString(s)
+}
+
+// readString reads a string written by writeString
+func readString(r *bytes.Reader) string {
+	var l int32
+	binary.Read(r, binary.LittleEndian, &l)
+	if l <= 0 {
+		return ""
+	}
+	b := make([]byte, l)
+	io.ReadFull(r, b)
+	return string(b)
+}
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/Lyianu/sdfs/log"
 	"github.com/Lyianu/sdfs/pkg/pqueue"
@@ -40,34 +43,117 @@ type upload struct {
 	Host string // node address
 	Path string
 	Hash string
-	Size int
+	Size uint64
+	// Replicas is the replication factor of the namespace the file is
+	// uploaded to
+	Replicas int
+
+	// Opts controls how a file already at Path is treated and carries the
+	// xattrs set on Path with the file
+	Opts sdfs.WriteOptions
+	// Chunking is how the node splits the content into chunks
+	Chunking string
+	// Erasure is the storage class of the file, the node places the shards
+	// of erasure-coded files on Targets
+	Erasure sdfs.Erasure
+	Targets []string
 }
 
-func (u *uploadManager) AddUpload(path string) (id, node string, err error) {
+// AddUpload selects a node for the upload, size is the expected size of the
+// file(0 if unknown) which is checked against the quota of the namespace and
+// the quotas of the directories above path, xattrs will be attached to the
+// file when the upload finishes, opts is checked against the file at path
+// now and again when the upload finishes, chunking is the chunking mode the
+// node stores the content with. erasure is the storage class of the file,
+// e.g. 6+3 or none, the storage class of the directory of path is used if it
+// is empty
+func (u *uploadManager) AddUpload(path string, size uint64, xattrs map[string]string, opts sdfs.WriteOptions, chunking, erasure string) (id, node string, err error) {
+	if err := sdfs.CheckChunking(chunking); err != nil {
+		return "", "", err
+	}
+	var e sdfs.Erasure
+	switch erasure {
+	case "":
+		if e, err = sdfs.Fs.ErasureOf(path); err != nil {
+			return "", "", err
+		}
+		if chunking != sdfs.ChunkNone {
+			// chunking asked for by the upload wins over the directory
+			e = sdfs.Erasure{}
+		}
+	case "none":
+	default:
+		if e, err = sdfs.ParseErasure(erasure); err != nil {
+			return "", "", err
+		}
+		if chunking != sdfs.ChunkNone {
+			return "", "", sdfs.NewError(sdfs.ErrInvalidArgument, "chunked uploads can not be erasure-coded")
+		}
+	}
+	for k, v := range xattrs {
+		if err := sdfs.ValidateXattr(k, v); err != nil {
+			return "", "", err
+		}
+	}
+	opts.Xattrs = xattrs
+	if err := sdfs.Fs.CheckWrite(path, opts); err != nil {
+		return "", "", err
+	}
+	ns, err := sdfs.Fs.NamespaceOf(path)
+	if err != nil {
+		return "", "", err
+	}
+	if err := ns.CheckQuota(size); err != nil {
+		return "", "", err
+	}
+	replicas := ns.ReplicaCount
+	if !e.IsZero() {
+		// the overhead of erasure coding rounded up
+		replicas = (e.Shards() + e.Data - 1) / e.Data
+	}
+	if err := sdfs.Fs.CheckQuota(path, size, replicas); err != nil {
+		return "", "", err
+	}
 	u.mu.Lock()
 	defer u.mu.Unlock()
 	if _, ok := u.pending[path]; ok {
-		return "", "", errors.New("upload already in progress")
+		return "", "", sdfs.NewError(sdfs.ErrConflict, "upload already in progress")
 	}
 	u.pending[path] = pendingKey{}
 	n, ok := u.uploadNodes.First().(*Node)
 	if !ok {
-		return "", "", errors.New("no nodes available")
+		return "", "", sdfs.NewError(sdfs.ErrNoCapacity, "no nodes available")
 	}
 	rnd := util.RandomString(8)
 	for _, ok := u.uploads[rnd]; ok; _, ok = u.uploads[rnd] {
 		rnd = util.RandomString(8)
 	}
 	up := upload{
-		ID:   rnd,
-		Host: n.Addr,
-		Path: path,
-		Size: 0,
+		ID:       rnd,
+		Host:     n.Addr,
+		Path:     path,
+		Size:     size,
+		Replicas: replicas,
+		Opts:     opts,
+		Chunking: chunking,
+		Erasure:  e,
+	}
+	if !e.IsZero() {
+		// the uploading node keeps the first shard, the others go to
+		// distinct nodes as long as there are enough of them
+		up.Targets = append([]string{n.Addr}, u.svr.pickNodes(e.Shards()-1, n.Addr)...)
+		if len(up.Targets) < e.Shards() {
+			log.Infof("%d nodes for %d shards of %s, some nodes hold more than one", len(up.Targets), e.Shards(), path)
+		}
 	}
 	u.uploads[rnd] = up
 	u.pending[path] = pendingKey{}
 
-	url := fmt.Sprintf("%s%s%s?id=%s", settings.URLSDFSScheme, n.Addr, settings.URLSDFSUpload, rnd)
+	query := fmt.Sprintf("id=%s&chunking=%s", rnd, chunking)
+	if !e.IsZero() {
+		query += fmt.Sprintf("&erasure=%s&targets=%s", url.QueryEscape(e.String()), url.QueryEscape(strings.Join(up.Targets, ",")))
+	}
+	url := fmt.Sprintf("%s%s%s?%s", settings.URLSDFSScheme, n.Addr, settings.URLSDFSUpload, query)
 	resp, err := http.Get(url)
 	if err != nil {
 		log.Errorf("add upload error: %q", err)
@@ -81,17 +167,67 @@ func (u *uploadManager) AddUpload(path string) (id, node string, err error) {
 	return rnd, n.Addr, nil
 }
 
-func (u *uploadManager) FinishUpload(id, hash string) error {
+// FinishUpload adds the uploaded file to the FS, size is the actual size of
+// the file reported by the node and chunks its manifest if the node stored
+// it in chunks or its shards and the nodes they were placed on if it is
+// erasure-coded
+func (u *uploadManager) FinishUpload(id, hash string, size uint64, chunks []sdfs.ChunkRef) error {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 	up, ok := u.uploads[id]
 	if !ok {
-		return errors.New("upload not found")
+		return sdfs.NewError(sdfs.ErrNotFound, "upload not found")
 	}
-	sdfs.Fs.AddFile(up.Path, hash)
-	f, _ := sdfs.Fs.GetFile(up.Path)
-	f.Host = append(f.Host, u.svr.NodeID(up.Host))
 	delete(u.uploads, id)
 	delete(u.pending, up.Path)
+	ns, err := sdfs.Fs.NamespaceOf(up.Path)
+	if err != nil {
+		return err
+	}
+	if err := ns.CheckQuota(size); err != nil {
+		return err
+	}
+	if err := sdfs.Fs.CheckQuota(up.Path, size, up.Replicas); err != nil {
+		return err
+	}
+	cmd := AddFileStruct{
+		HostNum:    1,
+		PathLength: int32(len(up.Path)),
+		Size:       size,
+		ModTime:    time.Now().UnixNano(),
+		Chunks:     chunks,
+		Erasure:    up.Erasure,
+		Host:       []int32{u.svr.NodeID(up.Host)},
+		Path:       up.Path,
+		Hash:       hash,
+	}
+	if !up.Erasure.IsZero() {
+		// shards are recorded on the nodes they were placed on below
+		cmd.HostNum, cmd.Host = 0, nil
+	}
+	cmd.setOptions(up.Opts)
+	var orphans []*sdfs.File
+	err = u.svr.execute(cmd, func() error {
+		var err error
+		orphans, err = cmd.write()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := u.svr.DeleteOrphans(orphans); err != nil {
+		log.Errorf("failed to delete files of %s replaced by the upload: %q", up.Path, err)
+	}
+	if !up.Erasure.IsZero() {
+		for _, c := range chunks {
+			if err := u.svr.AddHost(c.Hash, c.Host); err != nil {
+				log.Errorf("failed to record shard %s of %s on %s: %q", c.Hash, up.Path, c.Host, err)
+			}
+		}
+	} else if len(chunks) != 0 {
+		u.svr.replicateChunks(chunks, up.Host, up.Replicas)
+	} else {
+		u.svr.replicateFile(hash, up.Host, up.Replicas)
+	}
 	return nil
 }
@@ -0,0 +1,99 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// SetVersioningStruct sets the number of prior versions kept for files in
+// the directory at Path
+type SetVersioningStruct struct {
+	Keep int32
+	Path string
+}
+
+// DeleteVersionStruct deletes the version ID of the file at Path
+type DeleteVersionStruct struct {
+	Path string
+	ID   string
+}
+
+func SetVersioningStructToEntry(v interface{}) (e *Entry) {
+	a := v.(SetVersioningStruct)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, a.Keep)
+	writeString(buf, a.Path)
+	e = &Entry{
+		Type: 19,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToSetVersioningStruct(e *Entry) interface{} {
+	a := SetVersioningStruct{}
+	r := bytes.NewReader(e.Data)
+	binary.Read(r, binary.LittleEndian, &a.Keep)
+	a.Path = readString(r)
+	return a
+}
+
+func SetVersioningExecutor(v interface{}) {
+	a := v.(SetVersioningStruct)
+	log.Debugf("setting versioning from AppendEntries rpc call, path: %s, keep: %d", a.Path, a.Keep)
+	if err := sdfs.Fs.SetVersioning(a.Path, int(a.Keep)); err != nil {
+		log.Errorf("failed to set versioning from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+func DeleteVersionStructToEntry(v interface{}) (e *Entry) {
+	a := v.(DeleteVersionStruct)
+	buf := new(bytes.Buffer)
+	writeString(buf, a.Path)
+	writeString(buf, a.ID)
+	e = &Entry{
+		Type: 20,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToDeleteVersionStruct(e *Entry) interface{} {
+	a := DeleteVersionStruct{}
+	r := bytes.NewReader(e.Data)
+	a.Path = readString(r)
+	a.ID = readString(r)
+	return a
+}
+
+func DeleteVersionExecutor(v interface{}) {
+	a := v.(DeleteVersionStruct)
+	log.Debugf("deleting version from AppendEntries rpc call, path: %s, version: %s", a.Path, a.ID)
+	if _, err := sdfs.Fs.DeleteVersion(a.Path, a.ID); err != nil {
+		log.Errorf("failed to delete version from AppendEntries rpc call, error: %q", err)
+	}
+}
+
+// SetVersioning sets the number of prior versions kept for files in the
+// directory at path and replicates it
+func (s *Server) SetVersioning(path string, keep int) error {
+	return s.execute(SetVersioningStruct{Keep: int32(keep), Path: path}, func() error {
+		return sdfs.Fs.SetVersioning(path, keep)
+	})
+}
+
+// DeleteVersion deletes a version of the file at path and replicates it, the
+// returned files are no longer referenced and their content should be
+// deleted from nodes
+func (s *Server) DeleteVersion(path, id string) ([]*sdfs.File, error) {
+	var orphans []*sdfs.File
+	err := s.execute(DeleteVersionStruct{Path: path, ID: id}, func() error {
+		var err error
+		orphans, err = sdfs.Fs.DeleteVersion(path, id)
+		return err
+	})
+	return orphans, err
+}
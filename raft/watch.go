@@ -0,0 +1,182 @@
+package raft
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// types of WatchEvent
+const (
+	EventCreate   = "create"
+	EventDelete   = "delete"
+	EventRename   = "rename"
+	EventMetadata = "metadata"
+)
+
+// feed wakes up watchers when the commit index advances
+type feed struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newFeed() *feed {
+	return &feed{ch: make(chan struct{})}
+}
+
+// wait returns a channel that is closed by the next notify
+func (f *feed) wait() <-chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ch
+}
+
+func (f *feed) notify() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.ch)
+	f.ch = make(chan struct{})
+}
+
+// commandEvents returns the changes of the namespace made by cmd, Index and
+// Term are left to the caller
+func commandEvents(cmd interface{}) []*WatchEvent {
+	switch a := cmd.(type) {
+	case AddFileStruct:
+		events := []*WatchEvent{{Type: EventCreate, Path: a.Path, Checksum: a.Hash, Size: a.Size}}
+		keys := make([]string, 0, len(a.Xattrs))
+		for k := range a.Xattrs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			events = append(events, &WatchEvent{Type: EventMetadata, Path: a.Path, Key: k, Value: a.Xattrs[k]})
+		}
+		return events
+	case DeleteFileStruct:
+		return []*WatchEvent{{Type: EventDelete, Path: a.Path}}
+	case RenameStruct:
+		return []*WatchEvent{{Type: EventRename, Path: a.Src, Target: a.Dst}}
+	case LinkStruct:
+		return []*WatchEvent{{Type: EventCreate, Path: a.Dst, Target: a.Src}}
+	case CopyStruct:
+		return []*WatchEvent{{Type: EventCreate, Path: a.Dst, Target: a.Src}}
+	case SymlinkStruct:
+		return []*WatchEvent{{Type: EventCreate, Path: a.Path, Target: a.Target}}
+	case SetXattrStruct:
+		e := &WatchEvent{Type: EventMetadata, Path: a.Path, Key: a.Key, Value: a.Value}
+		if a.Remove {
+			e.Value = ""
+		}
+		return []*WatchEvent{e}
+	case CreateNamespaceStruct:
+		return []*WatchEvent{{Type: EventCreate, Path: sdfs.JoinNamespace(a.Name, "/")}}
+	case DeleteNamespaceStruct:
+		return []*WatchEvent{{Type: EventDelete, Path: sdfs.JoinNamespace(a.Name, "/")}}
+	case CreateSnapshotStruct:
+		return []*WatchEvent{{Type: EventCreate, Path: a.Path + "@" + a.Name}}
+	case DeleteSnapshotStruct:
+		return []*WatchEvent{{Type: EventDelete, Path: a.Path + "@" + a.Name}}
+	case TrashFileStruct:
+		return []*WatchEvent{{Type: EventDelete, Path: a.Path}}
+	case RestoreTrashStruct:
+		return []*WatchEvent{{Type: EventCreate, Path: a.Path}}
+	case DeleteVersionStruct:
+		return []*WatchEvent{{Type: EventMetadata, Path: a.Path, Key: "version", Value: a.ID}}
+	}
+	return nil
+}
+
+// matches reports whether e changes a path under prefix, renames match if
+// either of their paths does. prefix is a cleaned path, empty matches all
+func (e *WatchEvent) matches(prefix string) bool {
+	if under(e.Path, prefix) {
+		return true
+	}
+	return e.Type == EventRename && under(e.Target, prefix)
+}
+
+// under reports whether path is prefix or a path below it, snapshots of
+// prefix as path@name are below it too
+func under(path, prefix string) bool {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		// roots end with a slash
+		return strings.HasPrefix(path, prefix)
+	}
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	rest := path[len(prefix):]
+	return rest == "" || rest[0] == '/' || rest[0] == '@'
+}
+
+// eventsFrom returns the events of the committed log entries from index
+// start that match prefix, and the index to continue from
+func (s *Server) eventsFrom(start uint64, prefix string) ([]*WatchEvent, uint64) {
+	s.cm.mu.Lock()
+	defer s.cm.mu.Unlock()
+	n := s.cm.commitIndex + 1
+	if l := uint64(len(s.cm.log)); n > l {
+		n = l
+	}
+	var events []*WatchEvent
+	for i := start; i < n; i++ {
+		le := s.cm.log[i]
+		for _, e := range commandEvents(le.Command) {
+			if !e.matches(prefix) {
+				continue
+			}
+			e.Index = i
+			e.Term = le.Term
+			events = append(events, e)
+		}
+	}
+	if start > n {
+		return events, start
+	}
+	return events, n
+}
+
+// WatchEvents calls send with the changes of the namespace under prefix
+// from raft index start in log order once they are committed, an empty
+// prefix watches all namespaces and start 0 means only changes committed
+// after the call. It returns when ctx is done or send fails
+func (s *Server) WatchEvents(ctx context.Context, prefix string, start uint64, send func(e *WatchEvent) error) error {
+	if prefix != "" {
+		p, err := sdfs.CleanPath(prefix)
+		if err != nil {
+			return err
+		}
+		prefix = p
+	}
+	if start == 0 {
+		s.cm.mu.Lock()
+		start = s.cm.commitIndex + 1
+		s.cm.mu.Unlock()
+	}
+	for {
+		// take the channel before reading the log so that no entry committed
+		// in between is missed
+		wait := s.feed.wait()
+		events, next := s.eventsFrom(start, prefix)
+		for _, e := range events {
+			if err := send(e); err != nil {
+				return err
+			}
+		}
+		start = next
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+// Watch implements the streaming RPC of WatchEvents
+func (s *Server) Watch(req *WatchRequest, stream Raft_WatchServer) error {
+	return s.WatchEvents(stream.Context(), req.Prefix, req.StartIndex, stream.Send)
+}
@@ -0,0 +1,93 @@
+package raft
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+func TestWatchEventMatches(t *testing.T) {
+	tests := []struct {
+		prefix string
+		e      *WatchEvent
+		want   bool
+	}{
+		{"/logs", &WatchEvent{Type: EventCreate, Path: "/logs"}, true},
+		{"/logs", &WatchEvent{Type: EventCreate, Path: "/logs/a"}, true},
+		{"/logs", &WatchEvent{Type: EventCreate, Path: "/logs@snap"}, true},
+		{"/logs", &WatchEvent{Type: EventCreate, Path: "/logs2/a"}, false},
+		{"/logs", &WatchEvent{Type: EventCreate, Path: "/log"}, false},
+		{"/logs", &WatchEvent{Type: EventRename, Path: "/tmp/a", Target: "/logs/a"}, true},
+		{"/logs", &WatchEvent{Type: EventRename, Path: "/tmp/a", Target: "/logs2/a"}, false},
+		{"/logs", &WatchEvent{Type: EventCreate, Path: "/tmp/a", Target: "/logs/a"}, false},
+		{"/", &WatchEvent{Type: EventCreate, Path: "/a"}, true},
+		{"/", &WatchEvent{Type: EventCreate, Path: "ns:/a"}, false},
+		{"ns:/", &WatchEvent{Type: EventCreate, Path: "ns:/a"}, true},
+		{"ns:/logs", &WatchEvent{Type: EventCreate, Path: "ns:/logs2"}, false},
+		{"", &WatchEvent{Type: EventCreate, Path: "ns:/a"}, true},
+	}
+	for _, tt := range tests {
+		if have := tt.e.matches(tt.prefix); have != tt.want {
+			t.Errorf("%+v matches %q = %t, want %t", tt.e, tt.prefix, have, tt.want)
+		}
+	}
+}
+
+// TestWatchSingleNode runs a master without peers, whose entries are
+// committed as soon as they are appended
+func TestWatchSingleNode(t *testing.T) {
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	sdfs.Fs = sdfs.NewFS()
+	maxRTT = 20
+	s, err := NewServer("127.0.0.1:0", "", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); s.cm.State() != LEADER; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no leader elected")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.cm.mu.Lock()
+	start := s.cm.commitIndex + 1
+	s.cm.mu.Unlock()
+	events := make(chan *WatchEvent, 8)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.WatchEvents(ctx, "/logs/", start, func(e *WatchEvent) error {
+			events <- e
+			return nil
+		})
+	}()
+	if err := s.Symlink("/target", "/logs2/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Symlink("/target", "/logs/a"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e.Type != EventCreate || e.Path != "/logs/a" || e.Index == 0 {
+			t.Errorf("want the creation of /logs/a, have: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event sent")
+	}
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("want the watch canceled, have: %v", err)
+	}
+	if err := s.WatchEvents(context.Background(), "ns:/../a", 0, nil); err == nil {
+		t.Errorf("want an invalid prefix refused")
+	}
+}
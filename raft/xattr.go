@@ -0,0 +1,71 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// SetXattrStruct sets(or removes when Remove is true) an extended attribute
+// of the file at Path
+type SetXattrStruct struct {
+	Path   string
+	Key    string
+	Value  string
+	Remove bool
+}
+
+func SetXattrStructToEntry(v interface{}) (e *Entry) {
+	a := v.(SetXattrStruct)
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, a.Remove)
+	writeString(buf, a.Path)
+	writeString(buf, a.Key)
+	writeString(buf, a.Value)
+	e = &Entry{
+		Type: 4,
+		Data: buf.Bytes(),
+	}
+	return e
+}
+
+func EntryToSetXattrStruct(e *Entry) interface{} {
+	a := SetXattrStruct{}
+	r := bytes.NewReader(e.Data)
+	binary.Read(r, binary.LittleEndian, &a.Remove)
+	a.Path = readString(r)
+	a.Key = readString(r)
+	a.Value = readString(r)
+	return a
+}
+
+func SetXattrExecutor(v interface{}) {
+	a := v.(SetXattrStruct)
+	var err error
+	if a.Remove {
+		err = sdfs.Fs.RemoveXattr(a.Path, a.Key)
+	} else {
+		err = sdfs.Fs.SetXattr(a.Path, a.Key, a.Value)
+	}
+	if err != nil {
+		log.Errorf("failed to apply xattr from AppendEntries rpc call, file: %q, error: %q", a.Path, err)
+	}
+}
+
+// SetXattr sets an extended attribute of the file at path and replicates the
+// change to other masters
+func (s *Server) SetXattr(path, key, value string) error {
+	return s.execute(SetXattrStruct{Path: path, Key: key, Value: value}, func() error {
+		return sdfs.Fs.SetXattr(path, key, value)
+	})
+}
+
+// RemoveXattr removes an extended attribute of the file at path and
+// replicates the change to other masters
+func (s *Server) RemoveXattr(path, key string) error {
+	return s.execute(SetXattrStruct{Path: path, Key: key, Remove: true}, func() error {
+		return sdfs.Fs.RemoveXattr(path, key)
+	})
+}
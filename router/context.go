@@ -129,9 +129,13 @@ func (c *Context) ParseRange(size int64) ([]Range, error) {
 			return nil, fmt.Errorf("invalid range header: %s", rangeHeader)
 		}
 
-		if start >= size || end >= size {
+		if start >= size || start < 0 {
 			return nil, fmt.Errorf("invalid range header: %s", rangeHeader)
 		}
+		// ranges past the end are cut to the size of the file
+		if end >= size {
+			end = size - 1
+		}
 
 		result = append(result, Range{Start: start, End: end + 1})
 	}
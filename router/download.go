@@ -5,18 +5,32 @@ import (
 	"time"
 
 	"github.com/Lyianu/sdfs/pkg/util"
+	"github.com/Lyianu/sdfs/sdfs"
 )
 
 type download struct {
 	ID       string
 	Hash     string
 	FileName string
+	// Size and Chunks are set for downloads of chunked files, which are
+	// assembled from chunks, and erasure-coded files, whose Chunks are the
+	// shards of Erasure
+	Size    int64
+	Chunks  []chunkSource
+	Erasure sdfs.Erasure
 
 	ExpireTime    time.Time
 	DownloadCount uint
 	mu            sync.Mutex
 }
 
+// chunkSource is a chunk of a chunked download and the nodes that hold it
+type chunkSource struct {
+	Hash  string   `json:"hash"`
+	Size  int64    `json:"size"`
+	Hosts []string `json:"hosts"`
+}
+
 func NewDownload(hash, filename string) (*download, error) {
 	d := new(download)
 	d.ID = util.RandomString(8)
@@ -54,7 +68,28 @@ func (r *Router) RequestDownload(hash, filename string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	r.addDownload(d)
+	return d.ID, nil
+}
 
+// RequestChunkedDownload adds a download of a chunked file assembled from
+// chunks or an erasure-coded file if e is set, it returns the ID of the
+// download
+func (r *Router) RequestChunkedDownload(hash, filename string, size int64, chunks []chunkSource, e sdfs.Erasure) (string, error) {
+	r.UpdateQueue()
+	d, err := NewDownload(hash, filename)
+	if err != nil {
+		return "", err
+	}
+	d.Size = size
+	d.Chunks = chunks
+	d.Erasure = e
+	r.addDownload(d)
+	return d.ID, nil
+}
+
+// addDownload adds d to the downloads
+func (r *Router) addDownload(d *download) {
 	r.mu.Lock()
 	for _, ok := r.downloads[d.ID]; ok; {
 		d.ID = util.RandomString(8)
@@ -62,5 +97,4 @@ func (r *Router) RequestDownload(hash, filename string) (string, error) {
 	r.downloads[d.ID] = d
 	r.downloadsQueue = append(r.downloadsQueue, d)
 	r.mu.Unlock()
-	return d.ID, nil
 }
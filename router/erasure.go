@@ -0,0 +1,278 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/pkg/erasure"
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/raft"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// shardBlock is the number of bytes of each shard rebuilt at a time
+const shardBlock = 1 << 20
+
+// MasterSetErasure sets the storage class of files uploaded to the
+// directory at path, e.g. erasure=6+3, erasure=none falls back to the
+// parent directory
+func (r *Router) MasterSetErasure(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var e sdfs.Erasure
+	if v := c.Query("erasure"); v != "none" {
+		if e, err = sdfs.ParseErasure(v); err != nil || e.IsZero() {
+			c.BadRequest("invalid erasure")
+			return
+		}
+	}
+	if err := raft.Raft.SetErasure(path, e); err != nil {
+		log.Errorf("failed to set erasure coding of %s: %q", path, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// erasureDownload adds a download of an erasure-coded file on a node that
+// holds a data shard of it and returns its URL, shards that are lost are
+// rebuilt from the others while the file is read
+func erasureDownload(hash, name string, size uint64, shards []sdfs.Chunk, e sdfs.Erasure) (string, error) {
+	sources := make([]chunkSource, len(shards))
+	available := 0
+	host := int32(-1)
+	for i, s := range shards {
+		sources[i] = chunkSource{Hash: s.Hash, Size: int64(s.Size), Hosts: []string{}}
+		for _, h := range s.Host {
+			sources[i].Hosts = append(sources[i].Hosts, raft.Raft.NodeAddr(h))
+		}
+		if len(s.Host) == 0 {
+			continue
+		}
+		available++
+		// data shards come first, the file is read from them unless
+		// they are lost
+		if host == -1 {
+			host = s.Host[0]
+		}
+	}
+	if available < e.Data {
+		return "", sdfs.NewError(sdfs.ErrNotFound, "%d of %d shards of %s available, %d needed", available, len(shards), hash, e.Data)
+	}
+	return HTTPGetChunkedDownloadAddress(raft.Raft.NodeAddr(host), hash, name, int64(size), sources, e)
+}
+
+// placeShards moves the shards of an erasure-coded upload to targets, shard
+// i goes to targets[i%len(targets)]. Shards that can not be moved stay on
+// the node, Host of every shard is set to the node that holds it
+func (r *Router) placeShards(shards []sdfs.ChunkRef, targets []string) {
+	for i := range shards {
+		shards[i].Host = r.NodeAddr
+		if len(targets) == 0 {
+			continue
+		}
+		target := targets[i%len(targets)]
+		if target == r.NodeAddr {
+			continue
+		}
+		if err := r.pushShard(target, shards[i].Hash); err != nil {
+			log.Errorf("failed to place shard %s on %s: %q", shards[i].Hash, target, err)
+			continue
+		}
+		sdfs.Hs.Remove(shards[i].Hash)
+		shards[i].Host = target
+	}
+}
+
+// pushShard stores the object with the given hash on the node at host
+func (r *Router) pushShard(host, hash string) error {
+	f, err := sdfs.Hs.Get(hash)
+	if err != nil {
+		return err
+	}
+	defer atomic.AddInt32(&f.OpenCount, -1)
+	rc, err := sdfs.Hs.Open(f)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s%s%s?hash=%s", settings.URLSDFSScheme, host, settings.URLSDFSShard, url.QueryEscape(hash)), rc)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = f.Size
+	req.Header.Set(sdfs.HeaderReprDigest, sdfs.ReprDigest(hash))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("shard statuscode mismatch, get: %d, expected: %d", resp.StatusCode, http.StatusOK)
+	}
+	return nil
+}
+
+// Shard stores a shard another node places on the node
+func (r *Router) Shard(c *Context) {
+	hash := c.Query("hash")
+	if hash == "" {
+		c.String(http.StatusBadRequest, "Bad Request: hash not found")
+		return
+	}
+	defer c.req.Body.Close()
+	if _, err := sdfs.Hs.AddChecked(c.req.Body, hash); err != nil {
+		log.Errorf("failed to store shard %s: %q", hash, err)
+		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// Reconstruct rebuilds a lost shard of an erasure-coded file from the other
+// shards, like CreateReplica the result is reported to the master
+func (r *Router) Reconstruct(c *Context) {
+	var request struct {
+		Hash    string        `json:"hash"`
+		Index   int           `json:"index"`
+		Erasure sdfs.Erasure  `json:"erasure"`
+		Shards  []chunkSource `json:"shards"`
+	}
+	b, err := io.ReadAll(c.req.Body)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		return
+	}
+	defer c.req.Body.Close()
+	if err := json.Unmarshal(b, &request); err != nil {
+		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		return
+	}
+	code, err := request.Erasure.Code()
+	if err != nil || len(request.Shards) != request.Erasure.Shards() || request.Index < 0 || request.Index >= len(request.Shards) {
+		c.String(http.StatusBadRequest, "Bad Request: invalid shards")
+		return
+	}
+	c.String(http.StatusOK, "reconstruct task added")
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(r.rebuildShard(pw, request.Shards, code, request.Index, 0, request.Shards[request.Index].Size))
+	}()
+	_, err = sdfs.Hs.AddChecked(pr, request.Hash)
+	pr.CloseWithError(err)
+	if err != nil {
+		log.Errorf("failed to rebuild shard %s: %q", request.Hash, err)
+		ReportReplicationToMaster(r.MasterAddr, request.Hash, "FAILED", r.NodeAddr)
+		return
+	}
+	ReportReplicationToMaster(r.MasterAddr, request.Hash, "OK", r.NodeAddr)
+}
+
+// serveErasure sends a download of an erasure-coded file, data shards are
+// read like chunks and rebuilt from the other shards if they can not be
+// read. The first range of the request is sent if it has any
+func (r *Router) serveErasure(c *Context, d *download) {
+	code, err := d.Erasure.Code()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
+		return
+	}
+	ranges, err := c.ParseRange(d.Size)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
+		return
+	}
+	setDigest(c, d.Hash)
+	start, end := int64(0), d.Size
+	status := http.StatusOK
+	if len(ranges) != 0 {
+		start, end = ranges[0].Start, ranges[0].End
+		c.SetHeader("Accept-Ranges", "bytes")
+		c.SetHeader("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, d.Size))
+		status = http.StatusPartialContent
+	}
+	c.SetHeader("Content-Length", fmt.Sprintf("%d", end-start))
+	c.w.WriteHeader(c.StatusCode(status))
+	shardSize := d.Chunks[0].Size
+	for i := 0; i < d.Erasure.Data; i++ {
+		off := int64(i) * shardSize
+		if off >= end {
+			break
+		}
+		if off+shardSize <= start {
+			continue
+		}
+		from, to := start-off, end-off
+		if from < 0 {
+			from = 0
+		}
+		if to > shardSize {
+			to = shardSize
+		}
+		w := &countingWriter{w: c.w}
+		err := r.copyChunk(w, d.Chunks[i], from, to)
+		if err != nil && w.n == 0 {
+			log.Errorf("download of %s: shard %d: %q, rebuilding it", d.Hash, i, err)
+			err = r.rebuildShard(c.w, d.Chunks, code, i, from, to)
+		}
+		if err != nil {
+			log.Errorf("download of %s: shard %d: %q", d.Hash, i, err)
+			// the headers are sent, the client should see the response
+			// fail instead of a short body
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// rebuildShard writes the bytes from..to of shard i to w, rebuilt from the
+// same bytes of the shards that can be read
+func (r *Router) rebuildShard(w io.Writer, shards []chunkSource, code *erasure.Code, i int, from, to int64) error {
+	for off := from; off < to; off += shardBlock {
+		n := to - off
+		if n > shardBlock {
+			n = shardBlock
+		}
+		blocks := make([][]byte, len(shards))
+		left := code.DataShards()
+		for j := range shards {
+			if j == i || left == 0 {
+				continue
+			}
+			var buf bytes.Buffer
+			if err := r.copyChunk(&buf, shards[j], off, off+n); err != nil {
+				log.Errorf("failed to read shard %d of %d: %q", j, len(shards), err)
+				continue
+			}
+			blocks[j] = buf.Bytes()
+			left--
+		}
+		if err := code.Reconstruct(blocks); err != nil {
+			return err
+		}
+		if _, err := w.Write(blocks[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
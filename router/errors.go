@@ -0,0 +1,79 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Lyianu/sdfs/raft"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// error codes sent to clients in the "code" field of error bodies, the
+// driver package maps them back to Go errors
+const (
+	CodeNotFound        = "not_found"
+	CodeAlreadyExists   = "already_exists"
+	CodeConflict        = "conflict"
+	CodeBusy            = "busy"
+	CodeNotLeader       = "not_leader"
+	CodeNoCapacity      = "no_capacity"
+	CodeInvalidPath     = "invalid_path"
+	CodeInvalidArgument = "invalid_argument"
+	CodePrecondition    = "precondition_failed"
+	CodeChecksum        = "checksum_mismatch"
+	CodeInternal        = "internal"
+)
+
+type errorKind struct {
+	err    error
+	status int
+	code   string
+}
+
+var errorKinds = []errorKind{
+	{sdfs.ErrNotFound, http.StatusNotFound, CodeNotFound},
+	{sdfs.ErrAlreadyExists, http.StatusConflict, CodeAlreadyExists},
+	{sdfs.ErrConflict, http.StatusConflict, CodeConflict},
+	{sdfs.ErrBusy, http.StatusServiceUnavailable, CodeBusy},
+	{raft.ErrNotLeader, http.StatusTemporaryRedirect, CodeNotLeader},
+	{sdfs.ErrNoCapacity, http.StatusInsufficientStorage, CodeNoCapacity},
+	{sdfs.ErrInvalidPath, http.StatusBadRequest, CodeInvalidPath},
+	{sdfs.ErrInvalidArgument, http.StatusBadRequest, CodeInvalidArgument},
+	{sdfs.ErrPreconditionFailed, http.StatusPreconditionFailed, CodePrecondition},
+	{sdfs.ErrChecksumMismatch, http.StatusBadRequest, CodeChecksum},
+}
+
+// ErrorStatus returns the HTTP status code and the error code of err
+func ErrorStatus(err error) (int, string) {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.status, k.code
+		}
+	}
+	return http.StatusInternalServerError, CodeInternal
+}
+
+// Error writes err to the client as a JSON error body with the status code
+// of its kind, the leader address is included for not leader errors
+func (c *Context) Error(err error) error {
+	status, code := ErrorStatus(err)
+	body := H{
+		"code":    code,
+		"message": err.Error(),
+	}
+	var nle *raft.NotLeaderError
+	if errors.As(err, &nle) {
+		body["leader"] = nle.Leader
+	}
+	if status == http.StatusServiceUnavailable {
+		c.SetHeader("Retry-After", "1")
+	}
+	return c.JSON(status, H{
+		"error": body,
+	})
+}
+
+// BadRequest writes an invalid argument error with the given message
+func (c *Context) BadRequest(format string, a ...interface{}) error {
+	return c.Error(sdfs.NewError(sdfs.ErrInvalidArgument, format, a...))
+}
@@ -1,11 +1,15 @@
 package router
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Lyianu/sdfs/log"
 	"github.com/Lyianu/sdfs/pkg/settings"
@@ -23,6 +27,39 @@ func NewMasterRouter() *Router {
 	r.addRoute("GET", settings.URLSDFSUpload, r.MasterRequestUpload)
 	r.addRoute("GET", settings.URLSDFSDelete, r.MasterDelete)
 	r.addRoute("POST", settings.URLUploadCallback, HTTPUploadCallbackServer)
+	r.addRoute("GET", settings.URLStat, r.MasterStat)
+	r.addRoute("GET", settings.URLList, r.MasterList)
+	r.addRoute("GET", settings.URLSearch, r.MasterSearch)
+	r.addRoute("GET", settings.URLWatch, r.MasterWatch)
+	r.addRoute("GET", settings.URLSnapshot, r.MasterListSnapshots)
+	r.addRoute("POST", settings.URLSnapshot, r.MasterCreateSnapshot)
+	r.addRoute("DELETE", settings.URLSnapshot, r.MasterDeleteSnapshot)
+	r.addRoute("GET", settings.URLSnapshotDiff, r.MasterDiffSnapshot)
+	r.addRoute("GET", settings.URLTrash, r.MasterListTrash)
+	r.addRoute("DELETE", settings.URLTrash, r.MasterPurgeTrash)
+	r.addRoute("POST", settings.URLTrashRestore, r.MasterRestoreTrash)
+	r.addRoute("POST", settings.URLTrashRetention, r.MasterSetTrashRetention)
+	r.addRoute("GET", settings.URLVersions, r.MasterListVersions)
+	r.addRoute("POST", settings.URLVersioning, r.MasterSetVersioning)
+	r.addRoute("POST", settings.URLErasure, r.MasterSetErasure)
+	r.addRoute("POST", settings.URLQuota, r.MasterSetQuota)
+	r.addRoute("DELETE", settings.URLQuota, r.MasterRemoveQuota)
+	r.addRoute("GET", settings.URLUsage, r.MasterUsage)
+	r.addRoute("GET", settings.URLCompression, r.MasterCompression)
+	r.addRoute("POST", settings.URLGC, r.MasterGC)
+	r.addRoute("GET", settings.URLXattr, r.MasterGetXattr)
+	r.addRoute("POST", settings.URLXattr, r.MasterSetXattr)
+	r.addRoute("DELETE", settings.URLXattr, r.MasterRemoveXattr)
+	r.addRoute("POST", settings.URLLink, r.MasterLink)
+	r.addRoute("POST", settings.URLRename, r.MasterRename)
+	r.addRoute("POST", settings.URLCopy, r.MasterCopy)
+	r.addRoute("POST", settings.URLSymlink, r.MasterSymlink)
+	r.addRoute("GET", settings.URLReadlink, r.MasterReadlink)
+	r.addRoute("GET", settings.URLNamespace, r.MasterListNamespaces)
+	r.addRoute("POST", settings.URLNamespace, r.MasterCreateNamespace)
+	r.addRoute("DELETE", settings.URLNamespace, r.MasterDeleteNamespace)
+	r.addRoute("GET", settings.URLSDFSReplicaCallback, r.CreateReplicaCallback)
+	r.addRoute("GET", settings.URLSDFSScrubReport, r.ScrubReport)
 
 	r.addRoute("GET", settings.URLDebugPrintSDFS, r.DebugPrintFS)
 	return r
@@ -31,75 +68,85 @@ func NewMasterRouter() *Router {
 // MasterDownload gets request from client, parse the request, request the file
 // on the Node server and return the URL of the requested file to the client
 func (r *Router) MasterDownload(c *Context) {
-	path := c.Query("path")
-	if path == "" {
-		c.String(http.StatusBadRequest, "Bad Request: path not found")
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
 		return
 	}
-	f, err := sdfs.Fs.GetFile(path)
+	// paths of the form <dir>@<snapshot>/<path> read from snapshots
+	var f *sdfs.File
+	if id := c.Query("version"); id != "" {
+		f, err = sdfs.Fs.GetVersion(path, id)
+	} else {
+		f, err = sdfs.Fs.Resolve(path)
+	}
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
+		c.Error(err)
 		return
 	}
 	hash := f.Checksum
+	c.SetHeader("ETag", `"`+hash+`"`)
+	if chunks := sdfs.Fs.Manifest(f); chunks != nil {
+		var url string
+		if f.Erasure.IsZero() {
+			url, err = chunkedDownload(hash, sdfs.ParseFileName(path), f.Size, chunks)
+		} else {
+			url, err = erasureDownload(hash, sdfs.ParseFileName(path), f.Size, chunks, f.Erasure)
+		}
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.String(http.StatusOK, url)
+		return
+	}
+	if len(f.Host) == 0 {
+		c.Error(sdfs.NewError(sdfs.ErrNotFound, "no replica of %s available", path))
+		return
+	}
 	// TODO: Load Balance
 	host := raft.Raft.NodeAddr(f.Host[0])
 
 	url, err := HTTPGetFileDownloadAddress(host, hash, sdfs.ParseFileName(path))
 	if err != nil {
-		c.String(http.StatusInternalServerError, "Internal Server Error: %q", err)
+		c.Error(err)
 		return
 	}
 	c.String(http.StatusOK, url)
 }
 
+// MasterDelete moves the file at path to the trash of its namespace, its
+// content stays on nodes until the trash entry is purged, symlinks are
+// deleted right away. A single version is deleted if version is given
 func (r *Router) MasterDelete(c *Context) {
-	path := c.Query("path")
-	if path == "" {
-		c.String(http.StatusBadRequest, "Bad Request: path not found")
-		return
-	}
-	f, err := sdfs.Fs.GetFile(path)
+	path, err := pathQuery(c, "path")
 	if err != nil {
-		log.Errorf("error handling file delete request, sdfs error: %q", err)
-		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
+		c.Error(err)
 		return
 	}
-	f.Lock()
-	var failed []int32
-	// TODO: use multiple goroutine
-	for _, v := range f.Host {
-		h := raft.Raft.NodeAddr(v)
-		url := fmt.Sprintf("%s%s%s?hash=%s", settings.URLSDFSScheme, h, settings.URLSDFSDelete, f.Checksum)
-		log.Infof("deleting URL: %s", url)
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Errorf("error sending delete request to node: %q", err)
-			failed = append(failed, v)
-			continue
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			err = fmt.Errorf("err sending delete request to node: statusCode mismatch, expected: %d, get: %d", http.StatusOK, resp.StatusCode)
-			log.Errorf("%s", err)
-			failed = append(failed, v)
-		}
+	if id := c.Query("version"); id != "" {
+		r.masterDeleteVersion(c, path, id)
+		return
 	}
-	// TODO: swap fs delete and hs delete to restore when error occurs
-	if len(failed) == 0 {
-		f.Unlock()
-		err = sdfs.Fs.DeleteFile(path)
-		if err != nil {
+	// symlinks only live in the namespace, there is nothing to restore
+	if _, err := sdfs.Fs.Readlink(path); err == nil {
+		if err := raft.Raft.DeleteFile(path); err != nil {
 			log.Errorf("failed to delete %s: sdfs error: %q", path, err)
-			c.String(http.StatusInternalServerError, "Internal Server Error")
+			c.Error(err)
 			return
 		}
 		c.String(http.StatusOK, "Success")
 		return
 	}
-	f.Host = failed
-	f.Unlock()
-	c.String(http.StatusInternalServerError, "Internal Server Error")
+	e, err := raft.Raft.TrashFile(path)
+	if err != nil {
+		log.Errorf("failed to move %s to the trash: sdfs error: %q", path, err)
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, H{
+		"trash": e.Info(),
+	})
 }
 
 // MasterRequestUpload is called when a client wants to upload a file to the
@@ -108,16 +155,29 @@ func (r *Router) MasterDelete(c *Context) {
 // TODO: with upload spikes Node server could be "penetrated"
 // maintain 2 Pqueues to split "busy" servers and "idle" servers to fix
 func (r *Router) MasterRequestUpload(c *Context) {
-	path := c.Query("path")
-	if path == "" {
-		c.String(http.StatusBadRequest, "Bad Request")
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var size uint64
+	if s := c.Query("size"); s != "" {
+		var err error
+		size, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			c.BadRequest("invalid size")
+			return
+		}
+	}
+	opts, err := uploadOptions(c)
+	if err != nil {
+		c.Error(err)
 		return
 	}
-	id, node, err := raft.Raft.UploadMngr.AddUpload(path)
+	id, node, err := raft.Raft.UploadMngr.AddUpload(path, size, uploadXattrs(c), opts, c.Query("chunking"), c.Query("erasure"))
 	if err != nil {
-		// TODO: return error type to the client
 		log.Errorf("reqeust upload error: %q", err)
-		c.String(http.StatusInternalServerError, "Internal Server Error")
+		c.Error(err)
 		return
 	}
 	c.JSON(http.StatusOK, H{
@@ -126,11 +186,266 @@ func (r *Router) MasterRequestUpload(c *Context) {
 	})
 }
 
+// pathQuery returns the canonical form of the path in query key, qualified by
+// the namespace in query ns
+func pathQuery(c *Context, key string) (string, error) {
+	path := c.Query(key)
+	if path == "" {
+		return "", sdfs.NewError(sdfs.ErrInvalidArgument, "%s not found", key)
+	}
+	return sdfs.CleanPath(sdfs.JoinNamespace(c.Query("ns"), path))
+}
+
+// uploadOptions reads how an existing file is treated by an upload: the
+// overwrite query replaces it, If-Match replaces it only if its checksum
+// matches and If-None-Match: * only creates new files
+func uploadOptions(c *Context) (sdfs.WriteOptions, error) {
+	var opts sdfs.WriteOptions
+	if o := c.Query("overwrite"); o != "" {
+		var err error
+		opts.Overwrite, err = strconv.ParseBool(o)
+		if err != nil {
+			return opts, sdfs.NewError(sdfs.ErrInvalidArgument, "invalid overwrite")
+		}
+	}
+	if m := c.Header("If-Match"); m != "" {
+		opts.IfMatch = strings.Trim(strings.TrimPrefix(m, "W/"), `"`)
+	}
+	if m := c.Header("If-None-Match"); m != "" {
+		if m != "*" {
+			return opts, sdfs.NewError(sdfs.ErrInvalidArgument, "only If-None-Match: * is supported")
+		}
+		opts.IfNoneMatch = true
+	}
+	return opts, nil
+}
+
+// uploadXattrs collects extended attributes from upload request headers,
+// header names are case-insensitive so keys are lowercased
+func uploadXattrs(c *Context) map[string]string {
+	xattrs := make(map[string]string)
+	for k, v := range c.req.Header {
+		if len(v) == 0 || !strings.HasPrefix(k, settings.HeaderXattrPrefix) {
+			continue
+		}
+		xattrs[strings.ToLower(k[len(settings.HeaderXattrPrefix):])] = v[0]
+	}
+	return xattrs
+}
+
+// MasterStat returns the info of a file or directory, including its
+// extended attributes
+func (r *Router) MasterStat(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	info, err := sdfs.Fs.Stat(path)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, H{
+		"info": info,
+	})
+}
+
+// MasterList lists entries directly under a directory
+func (r *Router) MasterList(c *Context) {
+	raw := c.Query("path")
+	if raw == "" {
+		raw = "/"
+	}
+	path, err := sdfs.CleanPath(sdfs.JoinNamespace(c.Query("ns"), raw))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	infos, err := sdfs.Fs.List(path)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, H{
+		"path":    path,
+		"entries": infos,
+	})
+}
+
+// MasterGetXattr returns all extended attributes of a file, or only the one
+// specified by key
+func (r *Router) MasterGetXattr(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	xattrs, err := sdfs.Fs.Xattrs(path)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if key := c.Query("key"); key != "" {
+		v, ok := xattrs[key]
+		if !ok {
+			c.Error(sdfs.NewError(sdfs.ErrNotFound, "xattr not exist"))
+			return
+		}
+		xattrs = map[string]string{key: v}
+	}
+	c.JSON(http.StatusOK, H{
+		"path":   path,
+		"xattrs": xattrs,
+	})
+}
+
+// MasterSetXattr sets an extended attribute of a file
+func (r *Router) MasterSetXattr(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	key := c.Query("key")
+	if key == "" {
+		c.BadRequest("key not found")
+		return
+	}
+	err = raft.Raft.SetXattr(path, key, c.Query("value"))
+	if err != nil {
+		log.Errorf("failed to set xattr %q on %s: %q", key, path, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterRemoveXattr removes an extended attribute of a file
+func (r *Router) MasterRemoveXattr(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	key := c.Query("key")
+	if key == "" {
+		c.BadRequest("key not found")
+		return
+	}
+	err = raft.Raft.RemoveXattr(path, key)
+	if err != nil {
+		log.Errorf("failed to remove xattr %q on %s: %q", key, path, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterLink creates a hard link at dst to the file at src
+func (r *Router) MasterLink(c *Context) {
+	src, err := pathQuery(c, "src")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	dst, err := pathQuery(c, "dst")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if err := raft.Raft.Link(src, dst); err != nil {
+		log.Errorf("failed to link %s to %s: %q", dst, src, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterRename moves the file or symlink at src to dst
+func (r *Router) MasterRename(c *Context) {
+	src, err := pathQuery(c, "src")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	dst, err := pathQuery(c, "dst")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if err := raft.Raft.Rename(src, dst); err != nil {
+		log.Errorf("failed to rename %s to %s: %q", src, dst, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterCopy copies the file or directory tree at src to dst, the copies
+// share their content with the originals
+func (r *Router) MasterCopy(c *Context) {
+	src, err := pathQuery(c, "src")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	dst, err := pathQuery(c, "dst")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	n, err := raft.Raft.Copy(src, dst)
+	if err != nil {
+		log.Errorf("failed to copy %s to %s: %q", src, dst, err)
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, H{
+		"files": n,
+	})
+}
+
+// MasterSymlink creates a symbolic link at path pointing to target
+func (r *Router) MasterSymlink(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	target := c.Query("target")
+	if target == "" {
+		c.BadRequest("target not found")
+		return
+	}
+	if err := raft.Raft.Symlink(target, path); err != nil {
+		log.Errorf("failed to symlink %s to %s: %q", path, target, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterReadlink returns the target of a symbolic link
+func (r *Router) MasterReadlink(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	target, err := sdfs.Fs.Readlink(path)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "%s", target)
+}
+
 // HTTP API, could be refactored to use RPC in the future
 // HTTPGetFileDownloadAddress contacts Node server so that requested file will
 // be exposed, then it returns the URL of the requested file
 func HTTPGetFileDownloadAddress(hostname, fileHash, fileName string) (string, error) {
-	URL := fmt.Sprintf("%s%s%s?hash=%s&name=%s", settings.URLSDFSScheme, hostname, settings.URLSDFSDownload, fileHash, fileName)
+	URL := fmt.Sprintf("%s%s%s?hash=%s&name=%s", settings.URLSDFSScheme, hostname, settings.URLSDFSDownload, url.QueryEscape(fileHash), url.QueryEscape(fileName))
 	resp, err := http.Get(URL)
 	if err != nil {
 		return "", err
@@ -144,6 +459,62 @@ func HTTPGetFileDownloadAddress(hostname, fileHash, fileName string) (string, er
 	return resultURL, err
 }
 
+// chunkedDownload adds a download of a chunked file on the node that holds
+// the most of its content and returns its URL, the node fetches the other
+// chunks from the nodes that hold them
+func chunkedDownload(hash, name string, size uint64, chunks []sdfs.Chunk) (string, error) {
+	held := make(map[int32]uint64)
+	sources := make([]chunkSource, len(chunks))
+	for i, ch := range chunks {
+		if len(ch.Host) == 0 {
+			return "", sdfs.NewError(sdfs.ErrNotFound, "no replica of chunk %s available", ch.Hash)
+		}
+		sources[i] = chunkSource{Hash: ch.Hash, Size: int64(ch.Size)}
+		for _, h := range ch.Host {
+			held[h] += ch.Size
+			sources[i].Hosts = append(sources[i].Hosts, raft.Raft.NodeAddr(h))
+		}
+	}
+	best := chunks[0].Host[0]
+	for h, n := range held {
+		if n > held[best] {
+			best = h
+		}
+	}
+	return HTTPGetChunkedDownloadAddress(raft.Raft.NodeAddr(best), hash, name, int64(size), sources, sdfs.Erasure{})
+}
+
+// HTTPGetChunkedDownloadAddress sends the chunks of a chunked file or the
+// shards of a file erasure-coded with e to the node at hostname and returns
+// the URL of the download it adds
+func HTTPGetChunkedDownloadAddress(hostname, fileHash, fileName string, size int64, chunks []chunkSource, e sdfs.Erasure) (string, error) {
+	b, err := json.Marshal(H{
+		"hash":    fileHash,
+		"name":    fileName,
+		"size":    size,
+		"chunks":  chunks,
+		"erasure": e,
+	})
+	if err != nil {
+		return "", err
+	}
+	URL := fmt.Sprintf("%s%s%s", settings.URLSDFSScheme, hostname, settings.URLSDFSDownload)
+	resp, err := http.Post(URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to add download on %s: %s", hostname, resp.Status)
+	}
+	resultURL := fmt.Sprintf("%s%s%s?id=%s", settings.URLSDFSScheme, hostname, settings.URLDownload, string(b))
+	return resultURL, nil
+}
+
 // HTTP API, could be refactor to use RPC in the future
 // HTTPUploadCallbackServer registers successful upload from Nodes
 func HTTPUploadCallbackServer(c *Context) {
@@ -161,20 +532,35 @@ func HTTPUploadCallbackServer(c *Context) {
 	b, err := io.ReadAll(c.req.Body)
 	if err != nil {
 		log.Errorf("callback error opening request body: %q", err)
-		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		c.BadRequest("failed to read request body: %v", err)
 		return
 	}
 	defer c.req.Body.Close()
 	err = json.Unmarshal(b, &request)
 	if err != nil {
 		log.Errorf("callback error parsing request: %q", err)
-		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		c.BadRequest("invalid request body: %v", err)
+		return
+	}
+	id, ok := request["id"].(string)
+	if !ok {
+		c.BadRequest("invalid id")
+		return
+	}
+	hash, ok := request["hash"].(string)
+	if !ok {
+		c.BadRequest("invalid hash")
 		return
 	}
-	err = raft.Raft.UploadMngr.FinishUpload(request["id"].(string), request["hash"].(string))
+	size, _ := request["size"].(float64)
+	var manifest struct {
+		Chunks []sdfs.ChunkRef `json:"chunks"`
+	}
+	json.Unmarshal(b, &manifest)
+	err = raft.Raft.UploadMngr.FinishUpload(id, hash, uint64(size), manifest.Chunks)
 	if err != nil {
 		log.Errorf("callback error uploadmanager: %q", err)
-		c.String(http.StatusInternalServerError, "Internal Server Error")
+		c.Error(err)
 		return
 	}
 	c.String(http.StatusOK, "Success")
@@ -182,7 +568,99 @@ func HTTPUploadCallbackServer(c *Context) {
 
 // endpoint for node to call after replication complete
 func (r *Router) CreateReplicaCallback(c *Context) {
+	hash, host := c.Query("hash"), c.Query("host")
+	if c.Query("result") != "OK" {
+		log.Errorf("replication of %s on %s failed", hash, host)
+		c.String(http.StatusOK, "Success")
+		return
+	}
+	if err := raft.Raft.AddHost(hash, host); err != nil {
+		log.Errorf("failed to record replica of %s on %s: %q", hash, host, err)
+		c.String(http.StatusInternalServerError, "Internal Server Error")
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// endpoint for node to report a corrupt object found by its scrubber
+func (r *Router) ScrubReport(c *Context) {
+	if raft.Raft.CM().State() != raft.LEADER {
+		c.String(http.StatusTemporaryRedirect, raft.Raft.PeerAddr(raft.Raft.CM().CurrentLeader()))
+		return
+	}
+	hash, host := c.Query("hash"), c.Query("host")
+	if hash == "" || host == "" {
+		c.BadRequest("hash or host not found")
+		return
+	}
+	log.Errorf("node %s found a corrupt replica of %s", host, hash)
+	if err := raft.Raft.ReportCorrupt(hash, host); err != nil {
+		log.Errorf("failed to handle corrupt replica of %s on %s: %q", hash, host, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterListNamespaces lists all namespaces
+func (r *Router) MasterListNamespaces(c *Context) {
+	var l []H
+	for _, ns := range sdfs.Fs.ListNamespaces() {
+		l = append(l, H{
+			"name":          ns.Name,
+			"quota":         ns.Quota,
+			"replica_count": ns.ReplicaCount,
+			"size":          ns.Root.TotalSize(),
+		})
+	}
+	c.JSON(http.StatusOK, H{
+		"namespaces": l,
+	})
+}
+
+// MasterCreateNamespace creates a namespace, quota and replicas are optional
+func (r *Router) MasterCreateNamespace(c *Context) {
+	name := c.Query("name")
+	if name == "" {
+		c.BadRequest("name not found")
+		return
+	}
+	var quota uint64
+	var replicas int
+	var err error
+	if q := c.Query("quota"); q != "" {
+		if quota, err = strconv.ParseUint(q, 10, 64); err != nil {
+			c.BadRequest("invalid quota")
+			return
+		}
+	}
+	if rc := c.Query("replicas"); rc != "" {
+		if replicas, err = strconv.Atoi(rc); err != nil {
+			c.BadRequest("invalid replicas")
+			return
+		}
+	}
+	if err := raft.Raft.CreateNamespace(name, quota, replicas); err != nil {
+		log.Errorf("failed to create namespace %s: %q", name, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
 
+// MasterDeleteNamespace deletes an empty namespace
+func (r *Router) MasterDeleteNamespace(c *Context) {
+	name := c.Query("name")
+	if name == "" {
+		c.BadRequest("name not found")
+		return
+	}
+	if err := raft.Raft.DeleteNamespace(name); err != nil {
+		log.Errorf("failed to delete namespace %s: %q", name, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
 }
 
 func (r *Router) HeartbeatHandler(c *Context) {
@@ -193,27 +671,29 @@ func (r *Router) HeartbeatHandler(c *Context) {
 		return
 	}
 	request := struct {
-		Host   string  `json:"host"`
-		CPU    float64 `json:"cpu"`
-		Size   int64   `json:"size"`
-		Memory float64 `json:"memory"`
-		Disk   int64   `json:"disk"`
+		Host        string                `json:"host"`
+		CPU         float64               `json:"cpu"`
+		Size        int64                 `json:"size"`
+		Memory      float64               `json:"memory"`
+		Disk        int64                 `json:"disk"`
+		Disks       []sdfs.DiskStat       `json:"disks"`
+		Compression sdfs.CompressionStats `json:"compression"`
 	}{}
 	b, err := io.ReadAll(c.req.Body)
 	if err != nil {
 		log.Errorf("heartbeat error opening request body: %q", err)
-		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		c.BadRequest("failed to read request body: %v", err)
 		return
 	}
 	defer c.req.Body.Close()
 	err = json.Unmarshal(b, &request)
 	if err != nil {
 		log.Errorf("heartbeat error parsing request: %q", err)
-		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		c.BadRequest("invalid request body: %v", err)
 		return
 	}
 	log.Debugf("heartbeat received from %s", request.Host)
-	addr, err := raft.Raft.UpdateNode(request.Host, request.CPU, request.Memory, request.Size, request.Disk)
+	addr, err := raft.Raft.UpdateNode(request.Host, request.CPU, request.Memory, request.Size, request.Disk, request.Disks, request.Compression)
 	if err != nil {
 		log.Errorf("heartbeat sent to non leader master: %q", err)
 		c.String(http.StatusTemporaryRedirect, addr)
@@ -222,15 +702,94 @@ func (r *Router) HeartbeatHandler(c *Context) {
 	c.String(http.StatusOK, "Success")
 }
 
+// MasterCompression reports how well the objects of every node compress and
+// the ratio of all of them, as of the last heartbeats of the nodes
+func (r *Router) MasterCompression(c *Context) {
+	stats, err := raft.Raft.Compression()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var nodes []H
+	var total sdfs.CompressionStats
+	for addr, s := range stats {
+		nodes = append(nodes, H{
+			"addr":        addr,
+			"compression": s,
+			"ratio":       s.Ratio(),
+		})
+		total.Size += s.Size
+		total.Stored += s.Stored
+		total.Objects += s.Objects
+		total.Compressed += s.Compressed
+	}
+	c.JSON(http.StatusOK, H{
+		"nodes":  nodes,
+		"size":   total.Size,
+		"stored": total.Stored,
+		"ratio":  total.Ratio(),
+	})
+}
+
+// MasterGC removes objects that no file references from the nodes, with
+// dry_run=true it only reports them
+func (r *Router) MasterGC(c *Context) {
+	grace := settings.GCGracePeriod
+	if g := c.Query("grace"); g != "" {
+		var err error
+		if grace, err = time.ParseDuration(g); err != nil || grace < 0 {
+			c.BadRequest("invalid grace")
+			return
+		}
+	}
+	var dryRun bool
+	if d := c.Query("dry_run"); d != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(d); err != nil {
+			c.BadRequest("invalid dry_run")
+			return
+		}
+	}
+	reports, failed, err := raft.Raft.CollectGarbage(grace, dryRun)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var nodes []H
+	var objects int
+	var size int64
+	for addr, gc := range reports {
+		nodes = append(nodes, H{
+			"addr": addr,
+			"gc":   gc,
+		})
+		objects += len(gc.Objects)
+		size += gc.Size
+	}
+	c.JSON(http.StatusOK, H{
+		"dry_run": dryRun,
+		"grace":   grace.String(),
+		"nodes":   nodes,
+		"failed":  failed,
+		"objects": objects,
+		"size":    size,
+	})
+}
+
 // DebugPrintFS prints SDFS structure, it could be slow when there are
 // many file/dirs
 func (r *Router) DebugPrintFS(c *Context) {
-	dir, err := sdfs.Fs.GetDir("/")
+	dir, err := sdfs.Fs.GetDir(sdfs.JoinNamespace(c.Query("ns"), "/"))
+	if err != nil {
+		log.Errorf("error printing FS, sdfs error: %q", err)
+		c.Error(err)
+		return
+	}
+	s, err := sdfs.Fs.PrintDir(dir)
 	if err != nil {
 		log.Errorf("error printing FS, sdfs error: %q", err)
-		c.String(http.StatusInternalServerError, "ISE: error: %q", err)
+		c.Error(err)
 		return
 	}
-	s := dir.PrintDir()
 	c.String(http.StatusOK, s)
 }
@@ -7,8 +7,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/Lyianu/sdfs/log"
 	"github.com/Lyianu/sdfs/pkg/settings"
@@ -21,14 +24,22 @@ func NewRouter(master string, node string) *Router {
 		routes:     make(map[string]HandleFunc),
 		MasterAddr: master,
 		NodeAddr:   node,
-		uploads:    make(map[string]struct{}),
+		uploads:    make(map[string]upload),
 		downloads:  make(map[string]*download),
 	}
 	r.addRoute(http.MethodPost, settings.URLUpload, r.Upload)
 	r.addRoute(http.MethodGet, settings.URLDownload, r.Download)
 	r.addRoute(http.MethodGet, settings.URLSDFSDelete, r.Delete)
+	r.addRoute(http.MethodGet, settings.URLSDFSRef, r.Ref)
+	r.addRoute(http.MethodPost, settings.URLSDFSGC, r.CollectGarbage)
 	r.addRoute(http.MethodGet, settings.URLSDFSDownload, r.AddDownload)
+	r.addRoute(http.MethodPost, settings.URLSDFSDownload, r.AddChunkedDownload)
+	r.addRoute(http.MethodGet, settings.URLSDFSChunk, r.Chunk)
+	r.addRoute(http.MethodPost, settings.URLSDFSShard, r.Shard)
+	r.addRoute(http.MethodPost, settings.URLSDFSReconstruct, r.Reconstruct)
 	r.addRoute(http.MethodGet, settings.URLSDFSUpload, r.AddUpload)
+	r.addRoute(http.MethodPost, settings.URLSDFSReplicaRequest, r.CreateReplica)
+	r.addRoute(http.MethodGet, settings.URLSDFSScrub, r.ScrubStats)
 
 	r.addRoute(http.MethodGet, settings.URLDebugPrintHashstore, r.DebugPrintHS)
 	return r
@@ -36,6 +47,19 @@ func NewRouter(master string, node string) *Router {
 
 func (r *Router) AddUpload(c *Context) {
 	id := c.Query("id")
+	up := upload{Chunking: c.Query("chunking")}
+	if err := sdfs.CheckChunking(up.Chunking); err != nil {
+		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		return
+	}
+	var err error
+	if up.Erasure, err = sdfs.ParseErasure(c.Query("erasure")); err != nil {
+		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		return
+	}
+	if t := c.Query("targets"); t != "" {
+		up.Targets = strings.Split(t, ",")
+	}
 	r.mu.Lock()
 	_, ok := r.uploads[id]
 	if ok {
@@ -44,7 +68,7 @@ func (r *Router) AddUpload(c *Context) {
 		c.String(http.StatusInternalServerError, "failed to add upload, id already exists")
 		return
 	}
-	r.uploads[id] = struct{}{}
+	r.uploads[id] = up
 	r.mu.Unlock()
 	c.String(http.StatusOK, "Success")
 }
@@ -56,8 +80,38 @@ func (r *Router) Upload(c *Context) {
 		c.String(http.StatusBadRequest, "Bad Request: id not found")
 		return
 	}
-	hash, err := sdfs.Hs.Add(c.req.Body)
-	if err != nil {
+	r.mu.RLock()
+	up := r.uploads[id]
+	r.mu.RUnlock()
+	var hash string
+	var size int64
+	var chunks []sdfs.ChunkRef
+	var err error
+	// content that does not match the digest the client sent is rejected
+	if want := requestDigest(c); want != "" && up.Chunking == sdfs.ChunkNone && up.Erasure.IsZero() {
+		hash = want
+		size, err = sdfs.Hs.AddChecked(c.req.Body, hash)
+	} else {
+		if up.Erasure.IsZero() {
+			hash, size, chunks, err = sdfs.Hs.AddChunked(c.req.Body, up.Chunking)
+		} else {
+			hash, size, chunks, err = sdfs.Hs.AddErasure(c.req.Body, up.Erasure)
+		}
+		if err == nil && want != "" && hash != want {
+			for _, ref := range chunks {
+				sdfs.Hs.Remove(ref.Hash)
+			}
+			if chunks == nil {
+				sdfs.Hs.Remove(hash)
+			}
+			err = sdfs.NewError(sdfs.ErrChecksumMismatch, "content has hash %s, want %s", hash, want)
+		}
+	}
+	if errors.Is(err, sdfs.ErrChecksumMismatch) {
+		log.Errorf("file upload: sdfs error: %q", err)
+		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		return
+	} else if err != nil {
 		log.Errorf("file upload: sdfs error: %q", err)
 		c.String(http.StatusBadRequest, "Bad Request: failed to read body")
 		return
@@ -68,8 +122,12 @@ func (r *Router) Upload(c *Context) {
 		return
 	}
 
+	if !up.Erasure.IsZero() {
+		r.placeShards(chunks, up.Targets)
+	}
+
 	// report to master
-	err = HTTPUploadCallback(r.MasterAddr, id, hash, r.NodeAddr)
+	err = HTTPUploadCallback(r.MasterAddr, id, hash, r.NodeAddr, size, chunks)
 	if err != nil {
 		log.Errorf("error calling back master: %q", err)
 		c.String(http.StatusInternalServerError, "Internal Server Error")
@@ -85,8 +143,65 @@ func (r *Router) Delete(c *Context) {
 		c.String(http.StatusBadRequest, "Bad Request: hash not found")
 		return
 	}
-	err := sdfs.Hs.Remove(hash)
+	var err error
+	if c.Query("all") == "true" {
+		err = sdfs.Hs.RemoveAll(hash)
+	} else {
+		err = sdfs.Hs.Remove(hash)
+	}
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// CollectGarbage handles requests of the master to remove the objects it did
+// not list as live, objects touched within the grace period are kept
+func (r *Router) CollectGarbage(c *Context) {
+	var request struct {
+		Live   []string      `json:"live"`
+		Grace  time.Duration `json:"grace"`
+		DryRun bool          `json:"dry_run"`
+	}
+	b, err := io.ReadAll(c.req.Body)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		return
+	}
+	defer c.req.Body.Close()
+	if err := json.Unmarshal(b, &request); err != nil {
+		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		return
+	}
+	// a request without the list would remove every object
+	if request.Live == nil || request.Grace < 0 {
+		c.String(http.StatusBadRequest, "Bad Request: invalid live objects or grace")
+		return
+	}
+	report, err := sdfs.Hs.Collect(request.Live, request.Grace, request.DryRun)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
+		return
+	}
+	c.JSON(http.StatusOK, H{
+		"gc": report,
+	})
+}
+
+// Ref handles requests to add references to a file copied by the master
+func (r *Router) Ref(c *Context) {
+	hash := c.Query("hash")
+	if hash == "" {
+		c.String(http.StatusBadRequest, "Bad Request: hash not found")
+		return
+	}
+	n, err := strconv.ParseInt(c.Query("n"), 10, 32)
 	if err != nil {
+		c.String(http.StatusBadRequest, "Bad Request: invalid n")
+		return
+	}
+	if err := sdfs.Hs.Ref(hash, int32(n)); err != nil {
 		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
 		return
 	}
@@ -104,6 +219,7 @@ func (r *Router) Download(c *Context) {
 	r.mu.RLock()
 	download, ok := r.downloads[id]
 	if !ok {
+		r.mu.RUnlock()
 		c.String(http.StatusNotFound, "Not Found: id not exist")
 		return
 	}
@@ -116,45 +232,260 @@ func (r *Router) Download(c *Context) {
 
 	c.SetContentType("application/octet-stream")
 	c.SetHeader("Content-Disposition", fmt.Sprintf("filename=\"%s\"", download.FileName))
-	f, err := sdfs.Hs.Get(download.Hash)
+	if !download.Erasure.IsZero() {
+		r.serveErasure(c, download)
+		return
+	}
+	if download.Chunks != nil {
+		r.serveChunked(c, download)
+		return
+	}
+	r.serveObject(c, download.Hash)
+}
+
+// Chunk serves an object to nodes assembling a chunked download
+func (r *Router) Chunk(c *Context) {
+	hash := c.Query("hash")
+	if hash == "" {
+		c.String(http.StatusBadRequest, "Bad Request: hash not found")
+		return
+	}
+	c.SetContentType("application/octet-stream")
+	r.serveObject(c, hash)
+}
+
+// serveObject sends the object with the given hash from the hashstore, the
+// first range of the request is sent if it has any
+func (r *Router) serveObject(c *Context, hash string) {
+	f, err := sdfs.Hs.Get(hash)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
 		return
 	}
+	defer atomic.AddInt32(&f.OpenCount, -1)
 	size := f.Size
 	ranges, err := c.ParseRange(size)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
 		return
 	}
-	if len(ranges) == 0 {
-		defer atomic.AddInt32(&f.OpenCount, -1)
-		os_f, err := os.Open(settings.DataPathPrefix + f.Hash)
+	// compressed objects are sent as they are stored to clients that
+	// accept their encoding, without digest headers as the hash is that of
+	// the decoded content
+	if len(ranges) == 0 && f.Codec == sdfs.CodecGzip && acceptsEncoding(c.Header("Accept-Encoding"), "gzip") {
+		os_f, err := sdfs.Hs.OpenStored(f)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
 			return
 		}
+		defer os_f.Close()
+		c.SetHeader("Content-Encoding", "gzip")
+		c.SetHeader("Vary", "Accept-Encoding")
+		c.SetHeader("Content-Length", fmt.Sprintf("%d", f.Stored))
 		io.Copy(c.w, os_f)
 		return
 	}
-	c.SetHeader("Accept-Ranges", "bytes")
-	c.SetHeader("Content-Range", fmt.Sprintf("bytes %d-%d/%d", ranges[0].Start, ranges[0].End, size))
-	c.SetHeader("Content-Length", fmt.Sprintf("%d", ranges[0].End-ranges[0].Start+1))
-	c.StatusCode(http.StatusPartialContent)
-	defer atomic.AddInt32(&f.OpenCount, -1)
-	os_f, err := os.Open(settings.DataPathPrefix + f.Hash)
+	setDigest(c, f.Hash)
+	if len(ranges) == 0 {
+		os_f, err := sdfs.Hs.OpenVerified(f)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
+			return
+		}
+		defer os_f.Close()
+		c.SetHeader("Content-Length", fmt.Sprintf("%d", size))
+		if _, err := io.Copy(c.w, os_f); errors.Is(err, sdfs.ErrChecksumMismatch) {
+			r.reportCorrupt(hash, err)
+			// the content sent is wrong, the client should see the
+			// response fail instead of a complete body
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+	os_f, err := sdfs.Hs.Open(f)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
 		return
 	}
+	defer os_f.Close()
 	ra := ranges[0]
 	_, err = os_f.Seek(ra.Start, 0)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
 		return
 	}
+	c.SetHeader("Accept-Ranges", "bytes")
+	// End of ranges is exclusive
+	c.SetHeader("Content-Range", fmt.Sprintf("bytes %d-%d/%d", ra.Start, ra.End-1, size))
+	c.SetHeader("Content-Length", fmt.Sprintf("%d", ra.End-ra.Start))
+	c.w.WriteHeader(c.StatusCode(http.StatusPartialContent))
+	io.CopyN(c.w, os_f, ra.End-ra.Start)
+}
+
+// serveChunked sends a chunked download, chunks are read from the hashstore
+// of the node or fetched from the nodes that hold them. The first range of
+// the request is sent if it has any, only the chunks it covers are read
+func (r *Router) serveChunked(c *Context, d *download) {
+	ranges, err := c.ParseRange(d.Size)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
+		return
+	}
+	setDigest(c, d.Hash)
+	start, end := int64(0), d.Size
+	status := http.StatusOK
+	if len(ranges) != 0 {
+		start, end = ranges[0].Start, ranges[0].End
+		c.SetHeader("Accept-Ranges", "bytes")
+		c.SetHeader("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, d.Size))
+		status = http.StatusPartialContent
+	}
+	c.SetHeader("Content-Length", fmt.Sprintf("%d", end-start))
+	c.w.WriteHeader(c.StatusCode(status))
+	var off int64
+	for _, chunk := range d.Chunks {
+		if off >= end {
+			break
+		}
+		if off+chunk.Size > start {
+			from, to := start-off, end-off
+			if from < 0 {
+				from = 0
+			}
+			if to > chunk.Size {
+				to = chunk.Size
+			}
+			if err := r.copyChunk(c.w, chunk, from, to); err != nil {
+				log.Errorf("download of %s: chunk %s: %q", d.Hash, chunk.Hash, err)
+				// the headers are sent, the client should see the
+				// response fail instead of a short body
+				panic(http.ErrAbortHandler)
+			}
+		}
+		off += chunk.Size
+	}
+}
+
+// copyChunk writes the bytes from..to of chunk to w, from the hashstore of
+// the node if it holds the chunk or else from a node that does. Whole
+// chunks are verified against their hashes
+func (r *Router) copyChunk(w io.Writer, chunk chunkSource, from, to int64) error {
+	if f, err := sdfs.Hs.Get(chunk.Hash); err == nil {
+		defer atomic.AddInt32(&f.OpenCount, -1)
+		if from == 0 && to == f.Size {
+			rc, err := sdfs.Hs.OpenVerified(f)
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			if _, err := io.Copy(w, rc); errors.Is(err, sdfs.ErrChecksumMismatch) {
+				r.reportCorrupt(chunk.Hash, err)
+				return err
+			} else if err != nil {
+				return err
+			}
+			return nil
+		}
+		os_f, err := sdfs.Hs.Open(f)
+		if err != nil {
+			return err
+		}
+		defer os_f.Close()
+		if _, err := os_f.Seek(from, io.SeekStart); err != nil {
+			return err
+		}
+		_, err = io.CopyN(w, os_f, to-from)
+		return err
+	}
+	err := fmt.Errorf("no node holds chunk %s", chunk.Hash)
+	for _, host := range chunk.Hosts {
+		if host == r.NodeAddr {
+			continue
+		}
+		var n int64
+		n, err = fetchChunk(w, host, chunk, from, to)
+		if err == nil || n != 0 {
+			// bytes already written can not be taken back
+			return err
+		}
+		log.Errorf("failed to fetch chunk %s from %s: %q", chunk.Hash, host, err)
+	}
+	return err
+}
+
+// fetchChunk writes the bytes from..to of chunk to w from the node at host,
+// it returns the number of bytes written
+func fetchChunk(w io.Writer, host string, chunk chunkSource, from, to int64) (int64, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s%s%s?hash=%s", settings.URLSDFSScheme, host, settings.URLSDFSChunk, url.QueryEscape(chunk.Hash)), nil)
+	if err != nil {
+		return 0, err
+	}
+	want := http.StatusOK
+	// whole chunks are requested without a range, so that the node
+	// verifies them as it sends them
+	if from != 0 || to != chunk.Size {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to-1))
+		want = http.StatusPartialContent
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		return 0, fmt.Errorf("chunk statuscode mismatch, get: %d, expected: %d", resp.StatusCode, want)
+	}
+	return io.CopyN(w, resp.Body, to-from)
+}
+
+// reportCorrupt tells the master that the object with the given hash was
+// found corrupt and quarantined while it was sent
+func (r *Router) reportCorrupt(hash string, err error) {
+	log.Errorf("download of %s: %q", hash, err)
+	if err := ReportCorruptToMaster(r.MasterAddr, hash, r.NodeAddr); err != nil {
+		log.Errorf("failed to report corrupt object %s: %q", hash, err)
+	}
+}
+
+// setDigest sets the digest headers of content with the given hash, so that
+// clients can verify what they download
+func setDigest(c *Context, hash string) {
+	if d := sdfs.ReprDigest(hash); d != "" {
+		c.SetHeader(sdfs.HeaderReprDigest, d)
+		c.SetHeader(sdfs.HeaderDigest, sdfs.Digest(hash))
+	}
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header accept allows
+// the content coding
+func acceptsEncoding(accept, coding string) bool {
+	star := false
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		q := 1.0
+		if p := strings.TrimSpace(params); strings.HasPrefix(p, "q=") {
+			q, _ = strconv.ParseFloat(p[2:], 64)
+		}
+		if strings.EqualFold(name, coding) {
+			return q > 0
+		}
+		if name == "*" {
+			star = q > 0
+		}
+	}
+	return star
+}
 
-	io.CopyN(c.w, os_f, ra.End-ra.Start+1)
+// requestDigest returns the hash in the digest headers of the request, it
+// is empty if the client sent none
+func requestDigest(c *Context) string {
+	for _, h := range []string{sdfs.HeaderReprDigest, "Content-Digest", sdfs.HeaderDigest} {
+		if hash, ok := sdfs.ParseDigest(c.Header(h)); ok {
+			return hash
+		}
+	}
+	return ""
 }
 
 // AddDownload
@@ -170,6 +501,38 @@ func (r *Router) AddDownload(c *Context) {
 	c.String(http.StatusOK, "%s", file)
 }
 
+// AddChunkedDownload adds a download of a chunked or erasure-coded file, the
+// master sends the chunks or shards of the file and the nodes that hold them
+func (r *Router) AddChunkedDownload(c *Context) {
+	var request struct {
+		Hash    string        `json:"hash"`
+		Name    string        `json:"name"`
+		Size    int64         `json:"size"`
+		Chunks  []chunkSource `json:"chunks"`
+		Erasure sdfs.Erasure  `json:"erasure"`
+	}
+	b, err := io.ReadAll(c.req.Body)
+	if err != nil {
+		c.String(http.StatusBadRequest, "Bad Request: %q", err)
+		return
+	}
+	defer c.req.Body.Close()
+	if err := json.Unmarshal(b, &request); err != nil || len(request.Chunks) == 0 {
+		c.String(http.StatusBadRequest, "Bad Request: invalid manifest")
+		return
+	}
+	if !request.Erasure.IsZero() && len(request.Chunks) != request.Erasure.Shards() {
+		c.String(http.StatusBadRequest, "Bad Request: invalid manifest")
+		return
+	}
+	id, err := r.RequestChunkedDownload(request.Hash, request.Name, request.Size, request.Chunks, request.Erasure)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Internal Server Error: sdfs error: %q", err)
+		return
+	}
+	c.String(http.StatusOK, "%s", id)
+}
+
 // CreateReplica downloads file from other node to replicate file in
 // local hashstore this is now a synchronized operation, could be made
 // asynchronous in the future
@@ -192,43 +555,81 @@ func (r *Router) CreateReplica(c *Context) {
 		return
 	}
 	c.String(http.StatusOK, "replica task added")
-	hash := DownloadFileFromLink(request["link"].(string))
-	if hash != request["hash"].(string) {
-		ReportReplicationToMaster(r.MasterAddr, hash, "FAILED")
+	hash := request["hash"].(string)
+	if err := DownloadFileFromLink(request["link"].(string), hash); err != nil {
+		log.Errorf("failed to replicate %s: %q", hash, err)
+		ReportReplicationToMaster(r.MasterAddr, hash, "FAILED", r.NodeAddr)
 		return
 	}
-	ReportReplicationToMaster(r.MasterAddr, hash, "OK")
+	ReportReplicationToMaster(r.MasterAddr, hash, "OK", r.NodeAddr)
 }
 
 // TODO: handle error
-func ReportReplicationToMaster(masterAddr, hash, result string) {
+func ReportReplicationToMaster(masterAddr, hash, result, host string) {
 	addr := settings.URLSDFSScheme + masterAddr + settings.URLSDFSReplicaCallback
-	url := fmt.Sprintf("%s?hash=%s&result=%s", addr, hash, result)
+	url := fmt.Sprintf("%s?hash=%s&result=%s&host=%s", addr, url.QueryEscape(hash), result, host)
 	http.Get(url)
 }
 
-// DownloadFileFromLink downloads file from link and add it to the hashstore
-func DownloadFileFromLink(link string) string {
+// DownloadFileFromLink downloads the file with the given hash from link and
+// adds it to the hashstore, content that does not match hash is rejected
+// without being stored
+func DownloadFileFromLink(link, hash string) error {
 	resp, err := http.Get(link)
 	if err != nil {
-		log.Errorf("failed to get file, error: %q", err)
-		return ""
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get file from %s: %s", link, resp.Status)
+	}
+	if d, ok := sdfs.ParseDigest(resp.Header.Get(sdfs.HeaderReprDigest)); ok && d != hash {
+		return sdfs.NewError(sdfs.ErrChecksumMismatch, "source of %s has digest %s", hash, d)
+	}
+	_, err = sdfs.Hs.AddChecked(resp.Body, hash)
+	return err
+}
+
+// ScrubStats returns the progress and stats of the scrubber of the node
+func (r *Router) ScrubStats(c *Context) {
+	if r.Scrubber == nil {
+		c.String(http.StatusNotFound, "Not Found: scrubber not running")
+		return
+	}
+	c.JSON(http.StatusOK, H{
+		"scrub": r.Scrubber.Stats(),
+	})
+}
+
+// ReportCorruptToMaster tells the master that host quarantined its replica
+// of the file with the given hash
+func ReportCorruptToMaster(masterAddr, hash, host string) error {
+	addr := settings.URLSDFSScheme + masterAddr + settings.URLSDFSScrubReport
+	resp, err := http.Get(fmt.Sprintf("%s?hash=%s&host=%s", addr, url.QueryEscape(hash), url.QueryEscape(host)))
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
-	hash, err := sdfs.Hs.Add(resp.Body)
+	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Errorf("failed to add replica: %q", err)
-		return ""
+		return err
 	}
-	return hash
+	if resp.StatusCode == http.StatusTemporaryRedirect {
+		return ReportCorruptToMaster(string(b), hash, host)
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("scrub report statuscode mismatch, get: %d, expected: %d", resp.StatusCode, http.StatusOK)
+	}
+	return nil
 }
 
-func HTTPUploadCallback(masterAddr, id, hash, host string) error {
+func HTTPUploadCallback(masterAddr, id, hash, host string, size int64, chunks []sdfs.ChunkRef) error {
 	addr := settings.URLSDFSScheme + masterAddr + settings.URLUploadCallback
 	request := H{
-		"id":   id,
-		"hash": hash,
-		"host": host,
+		"id":     id,
+		"hash":   hash,
+		"host":   host,
+		"size":   size,
+		"chunks": chunks,
 	}
 	b, err := json.Marshal(request)
 	if err != nil {
@@ -249,7 +650,7 @@ func HTTPUploadCallback(masterAddr, id, hash, host string) error {
 	}
 	url := string(result)
 	if resp.StatusCode == http.StatusTemporaryRedirect {
-		return HTTPUploadCallback(url, id, hash, host)
+		return HTTPUploadCallback(url, id, hash, host, size, chunks)
 	} else if resp.StatusCode != http.StatusOK {
 		log.Errorf("upload callback statuscode mismatch, get: %d, expected: %d", resp.StatusCode, http.StatusOK)
 		return errors.New("upload callback statuscode mismatch")
@@ -0,0 +1,82 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/raft"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// MasterSetQuota sets the quota of the directory at path, limits that are not
+// given are unlimited
+func (r *Router) MasterSetQuota(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	var q sdfs.DirQuota
+	limits := []struct {
+		key string
+		v   *uint64
+	}{
+		{"bytes", &q.Bytes},
+		{"physical_bytes", &q.PhysicalBytes},
+		{"files", &q.Files},
+	}
+	for _, l := range limits {
+		s := c.Query(l.key)
+		if s == "" {
+			continue
+		}
+		if *l.v, err = strconv.ParseUint(s, 10, 64); err != nil {
+			c.BadRequest("invalid %s", l.key)
+			return
+		}
+	}
+	if q.IsZero() {
+		c.BadRequest("no limit given")
+		return
+	}
+	if err := raft.Raft.SetQuota(path, q); err != nil {
+		log.Errorf("failed to set quota of %s: %q", path, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterRemoveQuota removes the quota of the directory at path
+func (r *Router) MasterRemoveQuota(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if err := raft.Raft.SetQuota(path, sdfs.DirQuota{}); err != nil {
+		log.Errorf("failed to remove quota of %s: %q", path, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterUsage returns the logical and physical size, the file count and the
+// quota of the directory at path
+func (r *Router) MasterUsage(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	u, err := sdfs.Fs.GetUsage(path)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, H{
+		"usage": u,
+	})
+}
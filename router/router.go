@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"sync"
+
+	"github.com/Lyianu/sdfs/sdfs"
 )
 
 type HandleFunc func(*Context)
@@ -16,11 +18,23 @@ type Router struct {
 	downloads      map[string]*download
 	downloadsQueue []*download
 
-	uploads map[string]struct{}
+	uploads map[string]upload
 
 	mu         sync.RWMutex
 	MasterAddr string
 	NodeAddr   string
+
+	// Scrubber verifies the hashstore of a node, nil on masters
+	Scrubber *sdfs.Scrubber
+}
+
+// upload is an upload a master added to a node
+type upload struct {
+	Chunking string
+	Erasure  sdfs.Erasure
+	// Targets are the nodes the shards of erasure-coded uploads are
+	// placed on
+	Targets []string
 }
 
 // addRoute adds route to the router
@@ -0,0 +1,120 @@
+package router
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+const (
+	// default and maximum number of results of a search request
+	defaultSearchLimit = 1000
+	maxSearchLimit     = 100000
+	// results are flushed to the client every searchFlushEvery results
+	searchFlushEvery = 100
+)
+
+// searchOptions parses the query of a search request, the continuation token
+// is the base64 encoded key of the last result of the previous page
+func searchOptions(c *Context) (sdfs.SearchOptions, error) {
+	opts := sdfs.SearchOptions{
+		Prefix:    sdfs.JoinNamespace(c.Query("ns"), c.Query("prefix")),
+		Delimiter: c.Query("delimiter"),
+		Pattern:   c.Query("pattern"),
+		Checksum:  c.Query("checksum"),
+		Limit:     defaultSearchLimit,
+	}
+	var err error
+	if v := c.Query("min_size"); v != "" {
+		if opts.MinSize, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return opts, sdfs.NewError(sdfs.ErrInvalidArgument, "invalid min_size")
+		}
+	}
+	if v := c.Query("max_size"); v != "" {
+		if opts.MaxSize, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return opts, sdfs.NewError(sdfs.ErrInvalidArgument, "invalid max_size")
+		}
+	}
+	if v := c.Query("modified_after"); v != "" {
+		if opts.ModifiedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return opts, sdfs.NewError(sdfs.ErrInvalidArgument, "invalid modified_after, want RFC 3339 time")
+		}
+	}
+	if v := c.Query("modified_before"); v != "" {
+		if opts.ModifiedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return opts, sdfs.NewError(sdfs.ErrInvalidArgument, "invalid modified_before, want RFC 3339 time")
+		}
+	}
+	// xattr filters are given as xattr=key=value or xattr=key
+	for _, v := range c.req.URL.Query()["xattr"] {
+		if opts.Xattrs == nil {
+			opts.Xattrs = make(map[string]string)
+		}
+		k, val, _ := strings.Cut(v, "=")
+		opts.Xattrs[k] = val
+	}
+	if v := c.Query("limit"); v != "" {
+		if opts.Limit, err = strconv.Atoi(v); err != nil || opts.Limit <= 0 || opts.Limit > maxSearchLimit {
+			return opts, sdfs.NewError(sdfs.ErrInvalidArgument, "limit should be between 1 and %d", maxSearchLimit)
+		}
+	}
+	if v := c.Query("token"); v != "" {
+		b, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return opts, sdfs.NewError(sdfs.ErrInvalidArgument, "invalid token")
+		}
+		opts.StartAfter = string(b)
+	}
+	return opts, nil
+}
+
+// MasterSearch streams the files that match the query as newline delimited
+// JSON, the last line holds the token of the next page, which is empty when
+// there are no more results, errors met after the first result are sent as
+// the last line instead
+func (r *Router) MasterSearch(c *Context) {
+	opts, err := searchOptions(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	// check what can fail before the status code is sent
+	if err := opts.Validate(); err != nil {
+		c.Error(err)
+		return
+	}
+	if _, err := sdfs.Fs.NamespaceOf(opts.Prefix); err != nil {
+		c.Error(err)
+		return
+	}
+	c.SetContentType("application/x-ndjson")
+	c.w.WriteHeader(c.StatusCode(http.StatusOK))
+	flusher, _ := c.w.(http.Flusher)
+	enc := json.NewEncoder(c.w)
+	n := 0
+	next, err := sdfs.Fs.Search(opts, func(res sdfs.SearchResult) error {
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+		n++
+		if flusher != nil && n%searchFlushEvery == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		_, code := ErrorStatus(err)
+		enc.Encode(H{"error": H{"code": code, "message": err.Error()}})
+		return
+	}
+	token := ""
+	if next != "" {
+		token = base64.RawURLEncoding.EncodeToString([]byte(next))
+	}
+	enc.Encode(H{"next_token": token, "count": n})
+}
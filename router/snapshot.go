@@ -0,0 +1,110 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/raft"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// MasterListSnapshots lists the snapshots of the directory at path, or all
+// snapshots if path is not given
+func (r *Router) MasterListSnapshots(c *Context) {
+	path := ""
+	if c.Query("path") != "" {
+		var err error
+		if path, err = pathQuery(c, "path"); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+	l, err := sdfs.Fs.ListSnapshots(path)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	infos := make([]sdfs.SnapshotInfo, 0, len(l))
+	for _, s := range l {
+		infos = append(infos, s.Info())
+	}
+	c.JSON(http.StatusOK, H{
+		"snapshots": infos,
+	})
+}
+
+// MasterCreateSnapshot takes a snapshot named name of the directory at path
+func (r *Router) MasterCreateSnapshot(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	name := c.Query("name")
+	if name == "" {
+		c.BadRequest("name not found")
+		return
+	}
+	s, err := raft.Raft.CreateSnapshot(path, name)
+	if err != nil {
+		log.Errorf("failed to create snapshot %s@%s: %q", path, name, err)
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, H{
+		"snapshot": s.Info(),
+	})
+}
+
+// MasterDeleteSnapshot deletes a snapshot, content that was only kept for
+// the snapshot is deleted from nodes
+func (r *Router) MasterDeleteSnapshot(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	name := c.Query("name")
+	if name == "" {
+		c.BadRequest("name not found")
+		return
+	}
+	orphans, err := raft.Raft.DeleteSnapshot(path, name)
+	if err != nil {
+		log.Errorf("failed to delete snapshot %s@%s: %q", path, name, err)
+		c.Error(err)
+		return
+	}
+	if err := raft.Raft.DeleteOrphans(orphans); err != nil {
+		c.Error(fmt.Errorf("snapshot deleted, but %w", err))
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterDiffSnapshot compares snapshot from with snapshot to, or with the
+// live tree if to is not given
+func (r *Router) MasterDiffSnapshot(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	from := c.Query("from")
+	if from == "" {
+		c.BadRequest("from not found")
+		return
+	}
+	changes, err := sdfs.Fs.DiffSnapshot(path, from, c.Query("to"))
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, H{
+		"path":    path,
+		"from":    from,
+		"to":      c.Query("to"),
+		"changes": changes,
+	})
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/raft"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// trashNamespace returns the namespace named by the ns query, the default
+// namespace if it is not given
+func trashNamespace(c *Context) string {
+	if ns := c.Query("ns"); ns != "" {
+		return ns
+	}
+	return sdfs.DefaultNamespace
+}
+
+// MasterListTrash lists the trash entries of a namespace, or of all
+// namespaces if all is true
+func (r *Router) MasterListTrash(c *Context) {
+	ns := trashNamespace(c)
+	if c.Query("all") == "true" {
+		ns = ""
+	}
+	l := sdfs.Fs.ListTrash(ns)
+	infos := make([]sdfs.TrashInfo, 0, len(l))
+	for _, e := range l {
+		infos = append(infos, e.Info())
+	}
+	c.JSON(http.StatusOK, H{
+		"trash": infos,
+	})
+}
+
+// MasterRestoreTrash puts the file of a trash entry back at path, or where it
+// was deleted from if path is not given
+func (r *Router) MasterRestoreTrash(c *Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.BadRequest("id not found")
+		return
+	}
+	var path string
+	if c.Query("path") != "" {
+		var err error
+		if path, err = pathQuery(c, "path"); err != nil {
+			c.Error(err)
+			return
+		}
+	}
+	ns := trashNamespace(c)
+	if _, err := raft.Raft.RestoreTrash(ns, id, path); err != nil {
+		log.Errorf("failed to restore trash entry %s/%s: %q", ns, id, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterPurgeTrash removes a trash entry before it expires, the content of
+// the file is deleted from nodes if nothing else references it
+func (r *Router) MasterPurgeTrash(c *Context) {
+	id := c.Query("id")
+	if id == "" {
+		c.BadRequest("id not found")
+		return
+	}
+	ns := trashNamespace(c)
+	orphans, err := raft.Raft.PurgeTrash(ns, id)
+	if err != nil {
+		log.Errorf("failed to purge trash entry %s/%s: %q", ns, id, err)
+		c.Error(err)
+		return
+	}
+	if err := raft.Raft.DeleteOrphans(orphans); err != nil {
+		c.Error(fmt.Errorf("trash entry purged, but %w", err))
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+// MasterSetTrashRetention sets how long files deleted from a namespace are
+// kept in its trash, retention is a duration such as "72h", "0" restores the
+// default
+func (r *Router) MasterSetTrashRetention(c *Context) {
+	retention, err := time.ParseDuration(c.Query("retention"))
+	if err != nil || retention < 0 {
+		c.BadRequest("invalid retention")
+		return
+	}
+	ns := trashNamespace(c)
+	if err := raft.Raft.SetTrashRetention(ns, retention); err != nil {
+		log.Errorf("failed to set trash retention of %s: %q", ns, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
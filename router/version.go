@@ -0,0 +1,63 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/raft"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// MasterListVersions lists the versions of the file at path, newest first
+func (r *Router) MasterListVersions(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	l, err := sdfs.Fs.ListVersions(path)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, H{
+		"versions": l,
+	})
+}
+
+// MasterSetVersioning sets the number of prior versions kept for files
+// written in the directory at path, 0 falls back to the parent directory
+func (r *Router) MasterSetVersioning(c *Context) {
+	path, err := pathQuery(c, "path")
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	keep, err := strconv.Atoi(c.Query("keep"))
+	if err != nil || keep < 0 {
+		c.BadRequest("invalid keep")
+		return
+	}
+	if err := raft.Raft.SetVersioning(path, keep); err != nil {
+		log.Errorf("failed to set versioning of %s: %q", path, err)
+		c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
+
+func (r *Router) masterDeleteVersion(c *Context, path, id string) {
+	orphans, err := raft.Raft.DeleteVersion(path, id)
+	if err != nil {
+		log.Errorf("failed to delete version %s of %s: %q", id, path, err)
+		c.Error(err)
+		return
+	}
+	if err := raft.Raft.DeleteOrphans(orphans); err != nil {
+		c.Error(fmt.Errorf("version deleted, but %w", err))
+		return
+	}
+	c.String(http.StatusOK, "Success")
+}
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Lyianu/sdfs/raft"
+	"github.com/Lyianu/sdfs/sdfs"
+)
+
+// MasterWatch streams changes of the namespace under the prefix in query
+// "prefix" as Server-Sent Events. Clients resume with the Last-Event-ID header
+// or query "from", the raft index of the first change to send, without them
+// only new changes are sent. Without ns and prefix changes of all
+// namespaces are sent
+func (r *Router) MasterWatch(c *Context) {
+	prefix, ns := c.Query("prefix"), c.Query("ns")
+	if prefix != "" || ns != "" {
+		if prefix == "" {
+			prefix = "/"
+		}
+		p, err := sdfs.CleanPath(sdfs.JoinNamespace(ns, prefix))
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		prefix = p
+	}
+	var start uint64
+	if id := c.Header("Last-Event-ID"); id != "" {
+		n, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			c.BadRequest("invalid Last-Event-ID")
+			return
+		}
+		start = n + 1
+	} else if from := c.Query("from"); from != "" {
+		n, err := strconv.ParseUint(from, 10, 64)
+		if err != nil {
+			c.BadRequest("invalid from")
+			return
+		}
+		start = n
+	}
+	flusher, ok := c.w.(http.Flusher)
+	if !ok {
+		c.Error(fmt.Errorf("streaming not supported"))
+		return
+	}
+	c.SetContentType("text/event-stream")
+	c.SetHeader("Cache-Control", "no-cache")
+	c.w.WriteHeader(c.StatusCode(http.StatusOK))
+	flusher.Flush()
+	raft.Raft.WatchEvents(c.req.Context(), prefix, start, func(e *raft.WatchEvent) error {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(c.w, "id: %d\nevent: %s\ndata: %s\n\n", e.Index, e.Type, b); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+}
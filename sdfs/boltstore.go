@@ -0,0 +1,129 @@
+package sdfs
+
+import (
+	"bytes"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltStore is a MetaStore backed by a bbolt database file
+type boltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the bbolt database at path as a MetaStore, the file is
+// created if it does not exist
+func OpenBoltStore(path string) (MetaStore, error) {
+	return openBoltStore(path, metaBuckets)
+}
+
+// openBoltStore opens the bbolt database at path with the given buckets
+func openBoltStore(path string, buckets []string) (MetaStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range buckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(b)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (s *boltStore) Update(fn func(tx MetaTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) View(fn func(tx MetaTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func (t boltTx) bucket(name string) (*bolt.Bucket, error) {
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		return nil, NewError(ErrNotFound, "bucket %s not exist", name)
+	}
+	return b, nil
+}
+
+func (t boltTx) Get(bucket, key string) []byte {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return nil
+	}
+	return b.Get([]byte(key))
+}
+
+func (t boltTx) Put(bucket, key string, value []byte) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), value)
+}
+
+func (t boltTx) Delete(bucket, key string) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	return b.Delete([]byte(key))
+}
+
+func (t boltTx) ForEach(bucket, prefix string, fn func(key string, value []byte) error) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	p := []byte(prefix)
+	c := b.Cursor()
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		if err := fn(string(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t boltTx) ForEachChild(bucket, prefix string, fn func(key string, value []byte) error) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	p := []byte(prefix)
+	c := b.Cursor()
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); {
+		if i := bytes.IndexByte(k[len(p):], '/'); i >= 0 {
+			// skip the keys under the subdirectory, '0' follows '/'
+			n := len(p) + i
+			k, v = c.Seek(append(k[:n:n], '0'))
+			continue
+		}
+		if err := fn(string(k), v); err != nil {
+			return err
+		}
+		k, v = c.Next()
+	}
+	return nil
+}
@@ -0,0 +1,506 @@
+package sdfs
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// dirCache keeps the directories whose entries are in memory in the order
+// they were used, the least recently used ones are unloaded once they have
+// more than limit entries in all
+type dirCache struct {
+	mu      sync.Mutex
+	lru     *list.List // of *Directory, least recently used first
+	entries int
+	limit   int
+}
+
+func newDirCache(limit int) *dirCache {
+	return &dirCache{lru: list.New(), limit: limit}
+}
+
+// touch marks d as the most recently used directory, n is the number of its
+// entries. d.mu should be held by the caller
+func (c *dirCache) touch(d *Directory, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d.elem == nil {
+		d.elem = c.lru.PushBack(d)
+	} else {
+		c.lru.MoveToBack(d.elem)
+	}
+	c.entries += n - d.cached
+	d.cached = n
+}
+
+// remove forgets d, it is called for directories that are deleted
+func (c *dirCache) remove(d *Directory) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d.elem != nil {
+		c.lru.Remove(d.elem)
+		d.elem = nil
+		c.entries -= d.cached
+		d.cached = 0
+	}
+}
+
+// entries returns the number of entries of d, d.mu should be held by the
+// caller
+func (d *Directory) entries() int {
+	return len(d.Files) + len(d.Symlinks)
+}
+
+// rlock read-locks d with its entries loaded
+func (f *FS) rlock(d *Directory) error {
+	for {
+		d.mu.RLock()
+		if d.loaded {
+			f.cache.touch(d, d.entries())
+			return nil
+		}
+		d.mu.RUnlock()
+		if err := f.lock(d); err != nil {
+			return err
+		}
+		// d may be unloaded again before it is read-locked
+		d.mu.Unlock()
+	}
+}
+
+// lock write-locks d with its entries loaded, loading them may unload the
+// entries of directories used less recently. f.mu should not be held by the
+// caller
+func (f *FS) lock(d *Directory) error {
+	d.mu.Lock()
+	if d.loaded {
+		f.cache.touch(d, d.entries())
+		return nil
+	}
+	if err := f.load(d); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	f.cache.touch(d, d.entries())
+	f.evict(d)
+	return nil
+}
+
+// unlock unlocks d locked with lock, the entries it has now are counted in
+// the cache, which is brought back within its limit. f.mu should not be held
+// by the caller
+func (f *FS) unlock(d *Directory) {
+	f.cache.touch(d, d.entries())
+	d.mu.Unlock()
+	f.evict(nil)
+}
+
+// load reads the entries of d from the store, d.mu should be held for
+// writing by the caller
+func (f *FS) load(d *Directory) error {
+	d.Files = make(map[string]*File)
+	d.Symlinks = make(map[string]string)
+	d.Versions = make(map[string][]*Version)
+	d.Xattrs = make(map[string]map[string]string)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := len(d.FullPath)
+	err := f.view(func(tx MetaTx) error {
+		err := tx.ForEachChild(bucketFiles, d.FullPath, func(k string, v []byte) error {
+			file, err := f.readFile(tx, string(v))
+			if err != nil {
+				return err
+			}
+			d.Files[k[n:]] = f.hold(file)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEachChild(bucketSymlinks, d.FullPath, func(k string, v []byte) error {
+			d.Symlinks[k[n:]] = string(v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEachChild(bucketVersions, d.FullPath, func(k string, v []byte) error {
+			var r []versionRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			versions, err := f.readVersions(tx, r)
+			if err != nil {
+				return err
+			}
+			d.Versions[k[n:]] = versions
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.ForEachChild(bucketXattrs, d.FullPath, func(k string, v []byte) error {
+			var m map[string]string
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			d.Xattrs[k[n:]] = m
+			return nil
+		})
+	})
+	if err != nil {
+		f.unload(d)
+		return err
+	}
+	d.loaded = true
+	return nil
+}
+
+// unload drops the entries of d from memory and releases their files, d.mu
+// should be held for writing and f.mu by the caller
+func (f *FS) unload(d *Directory) {
+	for _, file := range d.Files {
+		f.release(file)
+	}
+	for _, versions := range d.Versions {
+		for _, v := range versions {
+			f.release(v.File)
+		}
+	}
+	d.Files, d.Symlinks, d.Versions, d.Xattrs = nil, nil, nil, nil
+	d.loaded = false
+}
+
+// evict unloads the least recently used directories other than keep until
+// the cache is within its limit, directories that are in use are skipped.
+// f.mu should not be held by the caller
+func (f *FS) evict(keep *Directory) {
+	c := f.cache
+	var victims []*Directory
+	c.mu.Lock()
+	for e := c.lru.Front(); e != nil && c.entries > c.limit; {
+		next := e.Next()
+		// locks are not taken in path order, so they are only tried
+		if d := e.Value.(*Directory); d != keep && d.mu.TryLock() {
+			c.lru.Remove(e)
+			d.elem = nil
+			c.entries -= d.cached
+			d.cached = 0
+			victims = append(victims, d)
+		}
+		e = next
+	}
+	c.mu.Unlock()
+	if len(victims) == 0 {
+		return
+	}
+	f.mu.Lock()
+	for _, d := range victims {
+		f.unload(d)
+	}
+	f.mu.Unlock()
+	for _, d := range victims {
+		d.mu.Unlock()
+	}
+}
+
+// readFile returns the file with the given checksum, the one in ChecksumDB
+// if it is cached, otherwise one read from tx that is cached once it is
+// held. f.mu should be held by the caller
+func (f *FS) readFile(tx MetaTx, hash string) (*File, error) {
+	if file, ok := f.ChecksumDB[hash]; ok {
+		return file, nil
+	}
+	r, err := getFileRecord(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+	file := &File{
+		Checksum:         hash,
+		Size:             r.Size,
+		ModTime:          time.Unix(0, r.ModTime),
+		Host:             r.Host,
+		SemaphoreReplica: r.Links,
+		Pins:             r.Pins,
+	}
+	if r.Erasure != nil {
+		file.Erasure = *r.Erasure
+	}
+	if len(r.Chunks) != 0 {
+		file.Chunks = make([]*Chunk, len(r.Chunks))
+		for i, c := range r.Chunks {
+			if file.Chunks[i], err = f.readChunk(tx, c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return file, nil
+}
+
+// readChunk returns the chunk with the given checksum like readFile
+func (f *FS) readChunk(tx MetaTx, hash string) (*Chunk, error) {
+	if c, ok := f.ChunkDB[hash]; ok {
+		return c, nil
+	}
+	r, err := getChunkRecord(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &Chunk{Hash: hash, Size: r.Size, Host: r.Host}, nil
+}
+
+// readVersions returns the versions of r, their files are held
+func (f *FS) readVersions(tx MetaTx, r []versionRecord) ([]*Version, error) {
+	versions := make([]*Version, 0, len(r))
+	for _, vr := range r {
+		file, err := f.readFile(tx, vr.Checksum)
+		if err != nil {
+			for _, v := range versions {
+				f.release(v.File)
+			}
+			return nil, err
+		}
+		versions = append(versions, &Version{ID: vr.ID, Created: time.Unix(0, vr.Created), File: f.hold(file)})
+	}
+	return versions, nil
+}
+
+// fileOf returns the file with the given checksum, it is read from the
+// store if it is not cached
+func (f *FS) fileOf(hash string) (*File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var file *File
+	err := f.view(func(tx MetaTx) error {
+		var err error
+		file, err = f.readFile(tx, hash)
+		return err
+	})
+	return file, err
+}
+
+// hold keeps file in ChecksumDB for as long as directory entries, versions
+// or trash entries in memory refer to it, every hold is undone by a release.
+// It returns the cached file, which replaces file if another one with the
+// same checksum is cached already. f.mu should be held by the caller
+func (f *FS) hold(file *File) *File {
+	if cached, ok := f.ChecksumDB[file.Checksum]; ok {
+		cached.holds++
+		return cached
+	}
+	for i, c := range file.Chunks {
+		if cached, ok := f.ChunkDB[c.Hash]; ok {
+			c = cached
+			file.Chunks[i] = c
+		} else {
+			f.ChunkDB[c.Hash] = c
+		}
+		c.holds++
+	}
+	file.holds = 1
+	f.ChecksumDB[file.Checksum] = file
+	return file
+}
+
+// release undoes a hold of file, the file and its chunks leave the cache
+// when nothing holds them any more. f.mu should be held by the caller
+func (f *FS) release(file *File) {
+	file.holds--
+	if file.holds != 0 {
+		return
+	}
+	delete(f.ChecksumDB, file.Checksum)
+	for _, c := range file.Chunks {
+		c.holds--
+		if c.holds == 0 {
+			delete(f.ChunkDB, c.Hash)
+		}
+	}
+}
+
+// refs collects the changes an operation makes to the number of paths and
+// pins of files by their checksums, putRefs writes them in the transaction
+// of the operation and applyRefs sets them on the cached files once it is
+// committed
+type refs map[string]*refChange
+
+type refChange struct {
+	links, pins int
+	// record is the record of the file after the change
+	record fileRecord
+}
+
+func (r refs) add(hash string, links, pins int) {
+	c, ok := r[hash]
+	if !ok {
+		c = &refChange{}
+		r[hash] = c
+	}
+	c.links += links
+	c.pins += pins
+}
+
+// putRefs writes the changes of r to the records of the files, files left
+// with neither a path nor a pin are deleted and returned so that their
+// content can be deleted from nodes. f.mu should be held by the caller
+func (f *FS) putRefs(tx MetaTx, r refs) ([]*File, error) {
+	hashes := make([]string, 0, len(r))
+	for hash := range r {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	var orphans []*File
+	for _, hash := range hashes {
+		c := r[hash]
+		rec, err := getFileRecord(tx, hash)
+		if err != nil {
+			return nil, err
+		}
+		links, pins := int(rec.Links)+c.links, int(rec.Pins)+c.pins
+		if links < 0 || pins < 0 {
+			return nil, NewError(ErrConflict, "references of %s out of range", hash)
+		}
+		rec.Links, rec.Pins = uint32(links), uint32(pins)
+		c.record = rec
+		if links != 0 || pins != 0 {
+			if err := putJSON(tx, bucketChecksums, hash, rec); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		file, err := f.readFile(tx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if err := deleteFileRecords(tx, hash, rec.Chunks); err != nil {
+			return nil, err
+		}
+		orphans = append(orphans, file)
+	}
+	return orphans, nil
+}
+
+// applyRefs sets the numbers of paths and pins written by putRefs on the
+// cached files, f.mu should be held by the caller
+func (f *FS) applyRefs(r refs) {
+	for hash, c := range r {
+		if file, ok := f.ChecksumDB[hash]; ok {
+			file.SemaphoreReplica, file.Pins = c.record.Links, c.record.Pins
+		}
+	}
+}
+
+// putNewFile writes the record of file, which is new to the store, and the
+// references to its chunks, chunks new to the store get records too. The
+// file has no paths or pins until they are added with putRefs
+func putNewFile(tx MetaTx, file *File) error {
+	r := fileRecord{Size: file.Size, ModTime: file.ModTime.UnixNano()}
+	if !file.Erasure.IsZero() {
+		e := file.Erasure
+		r.Erasure = &e
+	}
+	n := make(map[string]int)
+	for _, c := range file.Chunks {
+		r.Chunks = append(r.Chunks, c.Hash)
+		n[c.Hash]++
+		if n[c.Hash] > 1 || tx.Get(bucketChunks, c.Hash) != nil {
+			continue
+		}
+		if err := putJSON(tx, bucketChunks, c.Hash, chunkRecord{Size: c.Size}); err != nil {
+			return err
+		}
+	}
+	for c, k := range n {
+		if err := tx.Put(bucketChunkRefs, linkKey(c, file.Checksum), []byte(strconv.Itoa(k))); err != nil {
+			return err
+		}
+	}
+	return putJSON(tx, bucketChecksums, file.Checksum, r)
+}
+
+// deleteFileRecords removes the record of a file that is no longer
+// referenced and its references to chunks, the records of chunks no other
+// file contains are removed too
+func deleteFileRecords(tx MetaTx, hash string, chunks []string) error {
+	if err := tx.Delete(bucketChecksums, hash); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if err := tx.Delete(bucketChunkRefs, linkKey(c, hash)); err != nil {
+			return err
+		}
+		used, err := hasKeys(tx, bucketChunkRefs, linkKey(c, ""))
+		if err != nil {
+			return err
+		}
+		if !used {
+			if err := tx.Delete(bucketChunks, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// errFound stops an iteration at the first key
+var errFound = errors.New("found")
+
+// hasKeys reports whether bucket has a key with the given prefix
+func hasKeys(tx MetaTx, bucket, prefix string) (bool, error) {
+	err := tx.ForEach(bucket, prefix, func(k string, v []byte) error {
+		return errFound
+	})
+	if err == errFound {
+		return true, nil
+	}
+	return false, err
+}
+
+// putLink makes path a path of the file with the given checksum
+func putLink(tx MetaTx, path, hash string) error {
+	if err := tx.Put(bucketFiles, path, []byte(hash)); err != nil {
+		return err
+	}
+	return tx.Put(bucketLinks, linkKey(hash, path), []byte{})
+}
+
+// deleteLink removes path, a path of the file with the given checksum
+func deleteLink(tx MetaTx, path, hash string) error {
+	if err := tx.Delete(bucketFiles, path); err != nil {
+		return err
+	}
+	return tx.Delete(bucketLinks, linkKey(hash, path))
+}
+
+// linkDirs returns the directories of the paths of the file with the given
+// checksum, a directory is listed once for every path in it. f.mu should be
+// held by the caller
+func (f *FS) linkDirs(tx MetaTx, hash string) ([]*Directory, error) {
+	var dirs []*Directory
+	prefix := linkKey(hash, "")
+	err := tx.ForEach(bucketLinks, prefix, func(k string, v []byte) error {
+		if d, ok := f.dirs[dirOf(k[len(prefix):])]; ok {
+			dirs = append(dirs, d)
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// Paths returns the paths of the file with the given checksum in order
+func (f *FS) Paths(hash string) ([]string, error) {
+	var paths []string
+	prefix := linkKey(hash, "")
+	err := f.view(func(tx MetaTx) error {
+		return tx.ForEach(bucketLinks, prefix, func(k string, v []byte) error {
+			paths = append(paths, k[len(prefix):])
+			return nil
+		})
+	})
+	return paths, err
+}
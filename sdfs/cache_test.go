@@ -0,0 +1,63 @@
+package sdfs
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFsEvictsEntries(t *testing.T) {
+	fs := NewFS()
+	fs.cache.limit = 2
+	for i := 0; i < 5; i++ {
+		fs.AddSizedFile(fmt.Sprintf("/d%d/f", i), fmt.Sprintf("h%d", i), 10)
+	}
+	if _, err := fs.Link("/d0/f", "/d4/g"); err != nil {
+		t.Fatal(err)
+	}
+	loaded := 0
+	for _, d := range fs.Roots[0].SubDirs {
+		if d.loaded {
+			loaded++
+		}
+	}
+	if loaded > 2 || len(fs.ChecksumDB) > 2 {
+		t.Errorf("want at most 2 directories and files in memory, have: %d, %d", loaded, len(fs.ChecksumDB))
+	}
+	// evicted entries are read from the store again
+	for i := 0; i < 5; i++ {
+		if f, err := fs.GetFile(fmt.Sprintf("/d%d/f", i)); err != nil || f.Checksum != fmt.Sprintf("h%d", i) {
+			t.Errorf("want h%d at /d%d/f, have: %v, %v", i, i, f, err)
+		}
+	}
+	if f, _ := fs.GetFile("/d0/f"); f.SemaphoreReplica != 2 {
+		t.Errorf("want h0 referenced by 2 paths, have: %d", f.SemaphoreReplica)
+	}
+	if fs.Roots[0].TotalSize() != 60 {
+		t.Errorf("want 60 bytes used, have: %d", fs.Roots[0].TotalSize())
+	}
+	if err := fs.DeleteFile("/d4/g"); err != nil {
+		t.Fatal(err)
+	}
+	if f, _ := fs.GetFile("/d0/f"); f.SemaphoreReplica != 1 {
+		t.Errorf("want h0 referenced by 1 path, have: %d", f.SemaphoreReplica)
+	}
+	if p, _ := fs.Paths("h0"); len(p) != 1 || p[0] != "/d0/f" {
+		t.Errorf("want /d0/f the only path of h0, have: %v", p)
+	}
+}
+
+func TestFsHoldsSharedFiles(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/a/f", "h", 10)
+	fs.Link("/a/f", "/b/f")
+	a, _ := fs.GetFile("/a/f")
+	b, _ := fs.GetFile("/b/f")
+	if a != b || a.holds != 2 {
+		t.Errorf("want one file held by both paths, have: %p, %p, %d holds", a, b, a.holds)
+	}
+	fs.DeleteFile("/a/f")
+	fs.DeleteFile("/b/f")
+	if len(fs.ChecksumDB) != 0 || len(fs.ChunkDB) != 0 {
+		t.Errorf("want nothing cached, have: %d files, %d chunks", len(fs.ChecksumDB), len(fs.ChunkDB))
+	}
+}
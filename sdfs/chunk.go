@@ -0,0 +1,249 @@
+package sdfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/Lyianu/sdfs/pkg/chunker"
+	"github.com/Lyianu/sdfs/pkg/settings"
+)
+
+// Chunking modes of uploads, content uploaded with ChunkNone is stored as a
+// single object
+const (
+	ChunkNone    = ""
+	ChunkFixed   = "fixed"
+	ChunkFastCDC = "fastcdc"
+)
+
+// CheckChunking returns an error if mode is not a chunking mode
+func CheckChunking(mode string) error {
+	switch mode {
+	case ChunkNone, ChunkFixed, ChunkFastCDC:
+		return nil
+	}
+	return NewError(ErrInvalidArgument, "unknown chunking %q", mode)
+}
+
+// Chunk is a piece of the content of chunked files, it is stored on nodes as
+// an object of its own and placed and replicated on its own. Chunks are
+// shared by every file that contains them
+type Chunk struct {
+	Hash string
+	Size uint64
+	// Host contains hosts that have the chunk in their hashstores
+	Host []int32
+
+	// holds counts the occurrences of the chunk in the files in ChecksumDB,
+	// the chunk is in ChunkDB while there are any
+	holds int
+}
+
+// ChunkRef names a chunk in the manifests nodes and masters send each other
+type ChunkRef struct {
+	Hash string `json:"hash"`
+	Size uint64 `json:"size"`
+	// Host is the node a shard of an erasure-coded upload was placed on
+	Host string `json:"host,omitempty"`
+}
+
+// chunkRecord is how chunks are kept in the store, the record is deleted
+// with the last file that contains the chunk
+type chunkRecord struct {
+	Size uint64  `json:"size"`
+	Host []int32 `json:"host,omitempty"`
+}
+
+// getChunkRecord reads the record of the chunk with the given checksum
+func getChunkRecord(tx MetaTx, hash string) (chunkRecord, error) {
+	var r chunkRecord
+	v := tx.Get(bucketChunks, hash)
+	if v == nil {
+		return r, NewError(ErrNotFound, "chunk %s not exist", hash)
+	}
+	return r, json.Unmarshal(v, &r)
+}
+
+// physical returns the bytes the replicas of file take on nodes, f.mu
+// should be held by the caller
+func (file *File) physical() int64 {
+	if file.Chunks == nil {
+		return int64(file.Size) * int64(len(file.Host))
+	}
+	var n int64
+	for _, c := range file.Chunks {
+		n += int64(c.Size) * int64(len(c.Host))
+	}
+	return n
+}
+
+// addChunkHost records that host holds c, f.mu should be held by the caller
+func (f *FS) addChunkHost(c *Chunk, host int32) error {
+	for _, h := range c.Host {
+		if h == host {
+			return nil
+		}
+	}
+	hosts := append(c.Host[:len(c.Host):len(c.Host)], host)
+	dirs, err := f.putChunkHosts(c, hosts)
+	if err != nil {
+		return err
+	}
+	c.Host = hosts
+	for _, d := range dirs {
+		d.addUsage(0, int64(c.Size), 0)
+	}
+	return nil
+}
+
+// removeChunkHost records that host lost c, it returns the hosts left. f.mu
+// should be held by the caller
+func (f *FS) removeChunkHost(c *Chunk, host int32) ([]int32, error) {
+	hosts := make([]int32, 0, len(c.Host))
+	for _, h := range c.Host {
+		if h != host {
+			hosts = append(hosts, h)
+		}
+	}
+	if len(hosts) == len(c.Host) {
+		return hosts, nil
+	}
+	dirs, err := f.putChunkHosts(c, hosts)
+	if err != nil {
+		return nil, err
+	}
+	c.Host = hosts
+	for _, d := range dirs {
+		d.addUsage(0, -int64(c.Size), 0)
+	}
+	return hosts, nil
+}
+
+// putChunkHosts writes hosts as the hosts of c, it returns the directories
+// of the paths of the files that contain c, once for every occurrence of c
+// in every path. f.mu should be held by the caller
+func (f *FS) putChunkHosts(c *Chunk, hosts []int32) ([]*Directory, error) {
+	var dirs []*Directory
+	err := f.update(func(tx MetaTx) error {
+		if err := putJSON(tx, bucketChunks, c.Hash, chunkRecord{Size: c.Size, Host: hosts}); err != nil {
+			return err
+		}
+		prefix := linkKey(c.Hash, "")
+		return tx.ForEach(bucketChunkRefs, prefix, func(k string, v []byte) error {
+			n, err := strconv.Atoi(string(v))
+			if err != nil {
+				return err
+			}
+			l, err := f.linkDirs(tx, k[len(prefix):])
+			if err != nil {
+				return err
+			}
+			for i := 0; i < n; i++ {
+				dirs = append(dirs, l...)
+			}
+			return nil
+		})
+	})
+	return dirs, err
+}
+
+// Manifest returns copies of the chunks of file in order, it is nil if file
+// is stored whole. The hosts are the current ones even if file was read
+// before they changed
+func (f *FS) Manifest(file *File) []Chunk {
+	if file.Chunks == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	chunks := make([]Chunk, len(file.Chunks))
+	f.view(func(tx MetaTx) error {
+		for i, c := range file.Chunks {
+			// the chunk is gone if file was removed since
+			if cur, err := f.readChunk(tx, c.Hash); err == nil {
+				c = cur
+			}
+			chunks[i] = Chunk{Hash: c.Hash, Size: c.Size, Host: append([]int32(nil), c.Host...)}
+		}
+		return nil
+	})
+	return chunks
+}
+
+// InUse reports whether content with the given hash is still a file stored
+// whole or a chunk in f, objects in use should not be deleted from nodes
+func (f *FS) InUse(hash string) bool {
+	used := false
+	f.view(func(tx MetaTx) error {
+		if r, err := getFileRecord(tx, hash); err == nil && len(r.Chunks) == 0 {
+			used = true
+		} else {
+			used = tx.Get(bucketChunks, hash) != nil
+		}
+		return nil
+	})
+	return used
+}
+
+// newChunker returns the chunker of mode for r
+func newChunker(mode string, r io.Reader) (chunker.Chunker, error) {
+	if mode == ChunkFixed {
+		return chunker.NewFixed(r, settings.ChunkSize), nil
+	}
+	return chunker.NewFastCDC(r, settings.ChunkSize/4, settings.ChunkSize, settings.ChunkSize*4)
+}
+
+// AddChunked stores the content of r split into chunks with mode, every
+// chunk is an object of its own. It returns the hash and the size of the
+// whole content and its chunks in order, chunks is nil if the content is a
+// single chunk, which is then stored like Add does
+func (h *HashStore) AddChunked(r io.Reader, mode string) (hash string, size int64, chunks []ChunkRef, err error) {
+	if err := CheckChunking(mode); err != nil {
+		return "", 0, nil, err
+	}
+	if mode == ChunkNone {
+		hash, size, err = h.Add(r)
+		return hash, size, nil, err
+	}
+	sum := sha256.New()
+	c, err := newChunker(mode, io.TeeReader(r, sum))
+	if err != nil {
+		return "", 0, nil, err
+	}
+	// chunks added so far are released if the upload fails
+	defer func() {
+		if err != nil {
+			for _, ref := range chunks {
+				h.Remove(ref.Hash)
+			}
+			chunks = nil
+		}
+	}()
+	for {
+		b, err := c.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return "", 0, chunks, err
+		}
+		hash, n, err := h.Add(bytes.NewReader(b))
+		if err != nil {
+			return "", 0, chunks, err
+		}
+		chunks = append(chunks, ChunkRef{Hash: hash, Size: uint64(n)})
+		size += n
+	}
+	hash = encodeSum(sum.Sum(nil))
+	if len(chunks) == 0 {
+		// empty content has no chunks
+		hash, size, err = h.Add(bytes.NewReader(nil))
+		return hash, size, nil, err
+	}
+	if len(chunks) == 1 {
+		return hash, size, nil, nil
+	}
+	return hash, size, chunks, nil
+}
@@ -0,0 +1,151 @@
+package sdfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/Lyianu/sdfs/pkg/settings"
+)
+
+// smallChunks makes chunks small enough for tests
+func smallChunks(t *testing.T) {
+	size := settings.ChunkSize
+	settings.ChunkSize = 256
+	t.Cleanup(func() { settings.ChunkSize = size })
+}
+
+func TestHashStoreAddChunked(t *testing.T) {
+	smallChunks(t)
+	h, err := OpenHashStore([]string{t.TempDir()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(content)
+	whole, _, _ := h.Add(bytes.NewReader(content))
+
+	for _, mode := range []string{ChunkFixed, ChunkFastCDC} {
+		hash, size, chunks, err := h.AddChunked(bytes.NewReader(content), mode)
+		if err != nil || hash != whole || size != int64(len(content)) {
+			t.Fatalf("%s: want %s of %d bytes, have: %s, %d, %v", mode, whole, len(content), hash, size, err)
+		}
+		if len(chunks) < 2 {
+			t.Fatalf("%s: want content split, have %d chunks", mode, len(chunks))
+		}
+		var buf bytes.Buffer
+		for _, c := range chunks {
+			f, err := h.Get(c.Hash)
+			if err != nil {
+				t.Fatalf("%s: chunk %s not stored: %v", mode, c.Hash, err)
+			}
+			r, _ := h.Open(f)
+			n, _ := io.Copy(&buf, r)
+			r.Close()
+			if uint64(n) != c.Size {
+				t.Errorf("%s: want chunk of %d bytes, have %d", mode, c.Size, n)
+			}
+		}
+		if !bytes.Equal(buf.Bytes(), content) {
+			t.Errorf("%s: chunks do not reassemble the content", mode)
+		}
+	}
+
+	// content of a single chunk is stored whole
+	hash, _, chunks, err := h.AddChunked(bytes.NewReader(content[:100]), ChunkFixed)
+	if err != nil || chunks != nil {
+		t.Fatalf("want no chunks, have: %v, %v", chunks, err)
+	}
+	if _, err := h.Get(hash); err != nil {
+		t.Errorf("single chunk content not stored under its hash: %v", err)
+	}
+	if _, _, _, err := h.AddChunked(bytes.NewReader(nil), "rabin"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want unknown chunking rejected, have: %v", err)
+	}
+}
+
+func TestFsWriteChunked(t *testing.T) {
+	fs := NewFS()
+	now := time.Unix(1, 0)
+	a := []ChunkRef{{Hash: "c1", Size: 10}, {Hash: "c2", Size: 20}, {Hash: "c1", Size: 10}}
+	b := []ChunkRef{{Hash: "c2", Size: 20}, {Hash: "c3", Size: 30}}
+	fa, _, err := fs.WriteChunked("/a", "ha", 40, now, a, WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fs.WriteChunked("/b", "hb", 50, now, b, WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(fs.ChunkDB) != 3 || fs.ChunkDB["c2"] != fa.Chunks[1] {
+		t.Fatalf("want c2 shared by 3 chunks, have: %v", fs.ChunkDB)
+	}
+
+	// hosts are recorded per chunk and count once per occurrence
+	if err := fs.AddHost("ha", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.AddHost("c3", 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(fa.Host) != 0 || len(fs.ChunkDB["c2"].Host) != 1 {
+		t.Errorf("want hosts on chunks only, have: %v, %v", fa.Host, fs.ChunkDB["c2"].Host)
+	}
+	if _, physical, _ := fs.Roots[0].Usage(); physical != 40+20+30 {
+		t.Errorf("want 90 physical bytes, have: %d", physical)
+	}
+	if _, err := fs.RemoveHost("ha", 1); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want hosts of chunked files rejected, have: %v", err)
+	}
+	if hosts, err := fs.RemoveHost("c3", 2); err != nil || len(hosts) != 0 {
+		t.Errorf("want c3 without hosts, have: %v, %v", hosts, err)
+	}
+	if m := fs.Manifest(fa); len(m) != 3 || m[2].Hash != "c1" || len(m[2].Host) != 1 {
+		t.Errorf("unexpected manifest: %+v", m)
+	}
+	if info, _ := fs.Stat("/a"); info.Chunks != 3 {
+		t.Errorf("want 3 chunks in stat, have: %d", info.Chunks)
+	}
+
+	// chunks are released with the last file that contains them
+	if err := fs.DeleteFile("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if fs.InUse("ha") || fs.InUse("c1") || !fs.InUse("c2") {
+		t.Errorf("want c1 released and c2 kept, have: %v", fs.ChunkDB)
+	}
+}
+
+func TestOpenFSChunked(t *testing.T) {
+	store := NewMemStore()
+	fs, err := OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1, 0)
+	fs.WriteChunked("/a", "ha", 30, now, []ChunkRef{{Hash: "c1", Size: 10}, {Hash: "c2", Size: 20}}, WriteOptions{})
+	fs.WriteChunked("/b", "hb", 30, now, []ChunkRef{{Hash: "c3", Size: 10}, {Hash: "c2", Size: 20}}, WriteOptions{})
+	fs.AddHost("ha", 1)
+	fs.DeleteFile("/b")
+
+	fs, err = OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := fs.GetFile("/a")
+	if err != nil || len(a.Chunks) != 2 || a.Chunks[1].Hash != "c2" || len(a.Chunks[1].Host) != 1 {
+		t.Fatalf("chunked file not restored: %+v, %v", a, err)
+	}
+	if _, physical, _ := fs.Roots[0].Usage(); physical != 30 {
+		t.Errorf("want 30 physical bytes, have: %d", physical)
+	}
+	// the record of c3 is dropped as nothing contains it
+	store.View(func(tx MetaTx) error {
+		if tx.Get(bucketChunks, "c3") != nil {
+			t.Errorf("record of released chunk kept")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,355 @@
+package sdfs
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/pkg/util"
+)
+
+// codecs objects can be stored with, objects are keyed by the hash of their
+// uncompressed content whatever codec they are stored with
+const (
+	CodecNone = ""
+	CodecGzip = "gzip"
+	// CodecZstd is reserved, this build has no zstd implementation
+	CodecZstd = "zstd"
+)
+
+// codecSuffix ends the names of the files of objects stored with a codec,
+// names of objects stored as is have no suffix
+var codecSuffix = map[string]string{
+	CodecGzip: ".gz",
+}
+
+// CheckCodec returns an error matching ErrInvalidArgument if objects can not
+// be stored with codec
+func CheckCodec(codec string) error {
+	switch codec {
+	case CodecNone, CodecGzip:
+		return nil
+	case CodecZstd:
+		return NewError(ErrInvalidArgument, "compression %s is not supported by this build", codec)
+	}
+	return NewError(ErrInvalidArgument, "unknown compression %q", codec)
+}
+
+// splitCodec splits the name of an object file into the name of the object
+// and the codec it is stored with
+func splitCodec(name string) (string, string) {
+	for codec, suffix := range codecSuffix {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), codec
+		}
+	}
+	return name, CodecNone
+}
+
+// Object is the content of an object opened for reading
+type Object interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// A compressed object is a sequence of frames, every frame compresses
+// settings.CompressionFrame bytes of the content, the last one the rest, as
+// a gzip member of its own. Concatenated members are a valid gzip stream, so
+// the file can be sent as is to clients that accept gzip, while ranges are
+// read by decompressing only the frames they cover
+
+// compress writes the content of the file at path, which has size bytes,
+// compressed with codec to a new file in the same directory. It returns the
+// name of the new file, its size and the offsets of the frames in it
+func compress(path, codec string, size int64) (string, int64, []int64, error) {
+	if codec != CodecGzip {
+		return "", 0, nil, CheckCodec(codec)
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	defer src.Close()
+	name := filepath.Join(filepath.Dir(path), tmpPrefix+util.RandomString(16))
+	dst, err := os.Create(name)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	defer dst.Close()
+	w := &countingWriter{w: bufio.NewWriter(dst)}
+	zw := gzip.NewWriter(w)
+	var frames []int64
+	for off := int64(0); off < size; off += settings.CompressionFrame {
+		frames = append(frames, w.n)
+		zw.Reset(w)
+		if _, err = io.CopyN(zw, src, settings.CompressionFrame); err != nil && err != io.EOF {
+			break
+		}
+		if err = zw.Close(); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.w.(*bufio.Writer).Flush()
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", 0, nil, err
+	}
+	return name, w.n, frames, nil
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read from r, it is a byte reader so that
+// gzip reads no further than the end of a member
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n++
+	}
+	return b, err
+}
+
+// inspect computes the hash and the size of the content stored with codec
+// in r, and the frame size and the offsets of the frames of compressed
+// content. Content that can not be decompressed is reported with
+// ErrChecksumMismatch, path names it in errors
+func inspect(src io.Reader, path, codec string) (sum string, size, frame int64, frames []int64, err error) {
+	if codec == CodecNone {
+		hash := sha256.New()
+		size, err := io.Copy(hash, src)
+		if err != nil {
+			return "", 0, 0, nil, err
+		}
+		return encodeSum(hash.Sum(nil)), size, 0, nil, nil
+	}
+	if err := CheckCodec(codec); err != nil {
+		return "", 0, 0, nil, err
+	}
+	r := &countingReader{r: bufio.NewReader(src)}
+	hash := sha256.New()
+	// start is the offset of the member zr reads, the header is read when
+	// the reader is reset to it
+	var last, start int64
+	zr, err := gzip.NewReader(r)
+	for err == nil {
+		zr.Multistream(false)
+		var n int64
+		if n, err = io.Copy(hash, zr); err != nil {
+			break
+		}
+		// only the last frame may be short
+		if n == 0 || frame != 0 && (last != frame || n > frame) {
+			return "", 0, 0, nil, NewError(ErrChecksumMismatch, "%s has frames of different sizes", path)
+		}
+		if frame == 0 {
+			frame = n
+		}
+		last = n
+		frames = append(frames, start)
+		size += n
+		start = r.n
+		err = zr.Reset(r)
+	}
+	if err != io.EOF {
+		return "", 0, 0, nil, corrupt(path, err)
+	}
+	if len(frames) == 0 {
+		return "", 0, 0, nil, NewError(ErrChecksumMismatch, "%s has no frames", path)
+	}
+	return encodeSum(hash.Sum(nil)), size, frame, frames, nil
+}
+
+// corrupt reports errors of decompression with ErrChecksumMismatch, io.EOF
+// and errors reading the file are returned as they are
+func corrupt(path string, err error) error {
+	var perr *fs.PathError
+	if err == nil || err == io.EOF || errors.As(err, &perr) || errors.Is(err, ErrChecksumMismatch) {
+		return err
+	}
+	return NewError(ErrChecksumMismatch, "%s can not be decompressed: %v", path, err)
+}
+
+// frameReader reads the content of a compressed object, a frame is
+// decompressed from its start when a read reaches it, so that seeking skips
+// the frames before the offset. ReadAt seeks, it should not be used
+// concurrently with Read
+type frameReader struct {
+	src    Object
+	name   string
+	frame  int64
+	frames []int64
+	stored int64
+	size   int64
+
+	off int64
+	// zr reads the frame of off at off, it is nil when the frame has to be
+	// opened first
+	zr *gzip.Reader
+}
+
+func (r *frameReader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.zr == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.zr.Read(p)
+	r.off += int64(n)
+	if err == io.EOF {
+		r.zr = nil
+		if r.off%r.frame != 0 && r.off < r.size {
+			return n, corrupt(r.name, io.ErrUnexpectedEOF)
+		}
+		if r.off < r.size {
+			err = nil
+		}
+	}
+	return n, corrupt(r.name, err)
+}
+
+// open starts to decompress the frame of r.off and skips to r.off
+func (r *frameReader) open() error {
+	i := r.off / r.frame
+	if i >= int64(len(r.frames)) {
+		return corrupt(r.name, io.ErrUnexpectedEOF)
+	}
+	end := r.stored
+	if i+1 < int64(len(r.frames)) {
+		end = r.frames[i+1]
+	}
+	zr, err := gzip.NewReader(io.NewSectionReader(r.src, r.frames[i], end-r.frames[i]))
+	if err != nil {
+		return corrupt(r.name, err)
+	}
+	zr.Multistream(false)
+	if _, err := io.CopyN(io.Discard, zr, r.off-i*r.frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return corrupt(r.name, err)
+	}
+	r.zr = zr
+	return nil
+}
+
+func (r *frameReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, NewError(ErrInvalidArgument, "negative offset %d", offset)
+	}
+	if offset != r.off {
+		r.off, r.zr = offset, nil
+	}
+	return offset, nil
+}
+
+func (r *frameReader) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *frameReader) Close() error {
+	return r.src.Close()
+}
+
+// OpenStored opens the content of f as it is stored, compressed with
+// f.Codec if it is not CodecNone. Encrypted content is decrypted as it is
+// read
+func (h *HashStore) OpenStored(f *file) (Object, error) {
+	f.mu.Lock()
+	path, sealed := f.Path(), f.KeyID != ""
+	f.mu.Unlock()
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, h.failed(f.disk, err)
+	}
+	if !sealed {
+		return r, nil
+	}
+	sr, _, err := openSealed(r, h.Keys)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return sr, nil
+}
+
+// CompressionStats describes how well the objects of a hashstore compress
+type CompressionStats struct {
+	Codec string `json:"codec"`
+	// Size is the size of the content of the objects, Stored the size of
+	// their files
+	Size   int64 `json:"size"`
+	Stored int64 `json:"stored"`
+	// Compressed is the number of objects stored with a codec
+	Compressed int `json:"compressed"`
+	Objects    int `json:"objects"`
+}
+
+// Ratio returns the size of the content per byte stored, 1 if nothing is
+// stored
+func (s CompressionStats) Ratio() float64 {
+	if s.Stored == 0 {
+		return 1
+	}
+	return float64(s.Size) / float64(s.Stored)
+}
+
+// CompressionStats returns how well the objects on the healthy disks of h
+// compress
+func (h *HashStore) CompressionStats() CompressionStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	s := CompressionStats{Codec: h.Codec}
+	for _, d := range h.Disks {
+		s.Size += d.used
+		s.Stored += d.stored
+		s.Compressed += d.compressed
+		s.Objects += d.objects
+	}
+	return s
+}
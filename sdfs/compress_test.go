@@ -0,0 +1,171 @@
+package sdfs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Lyianu/sdfs/pkg/settings"
+)
+
+// smallFrames makes frames of compressed objects small enough for tests
+func smallFrames(t *testing.T) {
+	frame := settings.CompressionFrame
+	settings.CompressionFrame = 1000
+	t.Cleanup(func() { settings.CompressionFrame = frame })
+}
+
+// text returns n bytes of compressible content
+func text(n int) []byte {
+	return []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", n/44+1))[:n]
+}
+
+func TestCheckCodec(t *testing.T) {
+	if err := CheckCodec(CodecGzip); err != nil {
+		t.Errorf("want gzip supported, have: %v", err)
+	}
+	for _, codec := range []string{CodecZstd, "lz4"} {
+		if err := CheckCodec(codec); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("want %s rejected, have: %v", codec, err)
+		}
+	}
+}
+
+func TestHashStoreCompression(t *testing.T) {
+	smallFrames(t)
+	h, err := OpenHashStore([]string{t.TempDir()}, NewMemIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Codec = CodecGzip
+	content := text(10500)
+	hash, size, err := h.Add(bytes.NewReader(content))
+	if err != nil || size != int64(len(content)) {
+		t.Fatalf("want %d bytes added, have: %d, %v", len(content), size, err)
+	}
+	if hash != sumOf(string(content)) {
+		t.Errorf("want object keyed by the hash of its content, have: %s", hash)
+	}
+	f, err := h.Get(hash)
+	if err != nil || f.Codec != CodecGzip || f.Stored >= f.Size || len(f.frames) != 11 {
+		t.Fatalf("want content compressed in 11 frames, have: %+v, %v", f, err)
+	}
+	r, err := h.Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, err := io.ReadAll(r); err != nil || !bytes.Equal(b, content) {
+		t.Errorf("content not decompressed: %v", err)
+	}
+	for _, off := range []int64{0, 999, 1000, 4321, 10000, 10499} {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 1500)
+		n, err := io.ReadFull(r, b)
+		if want := content[off:]; len(want) > len(b) {
+			want = want[:len(b)]
+			if err != nil || !bytes.Equal(b, want) {
+				t.Errorf("read at %d: %v", off, err)
+			}
+		} else if n != len(want) || !bytes.Equal(b[:n], want) {
+			t.Errorf("read at %d: want %d bytes, have %d: %v", off, len(want), n, err)
+		}
+	}
+	b := make([]byte, 10)
+	if n, err := r.ReadAt(b, 10495); n != 5 || err != io.EOF || !bytes.Equal(b[:n], content[10495:]) {
+		t.Errorf("want the last 5 bytes, have: %d, %v", n, err)
+	}
+
+	// the file as it is stored is a valid gzip stream of the content
+	raw, err := h.OpenStored(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	zr, err := gzip.NewReader(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(zr); err != nil || !bytes.Equal(b, content) {
+		t.Errorf("stored file is not the gzip stream of the content: %v", err)
+	}
+
+	// content that does not get smaller is stored as it is
+	random := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(random)
+	plain, _, _ := h.Add(bytes.NewReader(random))
+	if f, _ := h.Get(plain); f.Codec != CodecNone || f.Stored != 5000 {
+		t.Errorf("want incompressible content stored as is, have: %+v", f)
+	}
+	s := h.CompressionStats()
+	if s.Objects != 2 || s.Compressed != 1 || s.Size != 15500 || s.Ratio() <= 1 {
+		t.Errorf("unexpected compression stats: %+v", s)
+	}
+}
+
+func TestHashStoreCompressionReload(t *testing.T) {
+	smallFrames(t)
+	dir := t.TempDir()
+	index := NewMemIndex()
+	h, err := OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Codec = CodecGzip
+	content := text(3500)
+	hash, _, _ := h.Add(bytes.NewReader(content))
+	f, _ := h.Get(hash)
+	stored := f.Stored
+
+	read := func(h *HashStore) ([]byte, error) {
+		f, err := h.Get(hash)
+		if err != nil {
+			return nil, err
+		}
+		r, err := h.OpenVerified(f)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	// the frames are restored from the index, or found in the file when
+	// the hashstore is rebuilt
+	for _, index := range []MetaStore{index, nil} {
+		h, err := OpenHashStore([]string{dir}, index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := h.Get(hash)
+		if err != nil || f.Codec != CodecGzip || f.Stored != stored || f.Size != 3500 || f.Frame != 1000 || len(f.frames) != 4 {
+			t.Fatalf("compressed object not restored, have: %+v, %v", f, err)
+		}
+		if b, err := read(h); err != nil || !bytes.Equal(b, content) {
+			t.Errorf("restored object not read: %v", err)
+		}
+	}
+
+	// an object that can not be decompressed is quarantined without failing
+	// its disk
+	h, _ = OpenHashStore([]string{dir}, index)
+	f, err = h.Get(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(f.Path())
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(f.Path(), data, 0644)
+	if _, err := read(h); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("want ErrChecksumMismatch, have: %v", err)
+	}
+	if _, err := h.Get(hash); err == nil || h.Disks[0].Err() != nil {
+		t.Errorf("want corrupt object quarantined and disk healthy, have: %v", h.Disks[0].Err())
+	}
+}
@@ -0,0 +1,167 @@
+package sdfs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// run with -race, the test checks that concurrent operations on overlapping
+// paths leave the FS consistent
+func TestFsConcurrent(t *testing.T) {
+	testConcurrent(t, NewFS())
+}
+
+// the same operations with entries of directories evicted all the time
+func TestFsConcurrentEviction(t *testing.T) {
+	fs := NewFS()
+	fs.cache.limit = 16
+	testConcurrent(t, fs)
+}
+
+func testConcurrent(t *testing.T, fs *FS) {
+	const workers = 8
+	const files = 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < files; i++ {
+				path := fmt.Sprintf("/d%d/sub%d/f%d", i%4, i%7, i)
+				// every worker adds the same files, only one add per path
+				// should create the entry
+				if _, err := fs.AddSizedFile(path, fmt.Sprintf("hash%d", i), 1); err != nil {
+					t.Errorf("AddSizedFile(%q): %v", path, err)
+				}
+				if _, err := fs.GetFile(path); err != nil {
+					t.Errorf("GetFile(%q): %v", path, err)
+				}
+				fs.List(fmt.Sprintf("/d%d", i%4))
+				fs.Stat(path)
+				fs.Symlink(path, fmt.Sprintf("/links/w%d/l%d", w, i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	root := fs.Roots[0]
+	if root.TotalSize() != files {
+		t.Errorf("root size want: %d, have: %d", files, root.TotalSize())
+	}
+	for i := 0; i < files; i++ {
+		f, err := fs.GetFile(fmt.Sprintf("/links/w0/l%d", i))
+		if err != nil {
+			t.Fatalf("GetFile through symlink: %v", err)
+		}
+		if f.SemaphoreReplica != 1 {
+			t.Errorf("file %d SemaphoreReplica want: 1, have: %d", i, f.SemaphoreReplica)
+		}
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < files; i++ {
+				fs.DeleteFile(fmt.Sprintf("/d%d/sub%d/f%d", i%4, i%7, i))
+			}
+		}()
+	}
+	wg.Wait()
+	if root.TotalSize() != 0 {
+		t.Errorf("root size want: 0, have: %d", root.TotalSize())
+	}
+	if len(fs.ChecksumDB) != 0 {
+		t.Errorf("ChecksumDB want empty, have: %d entries", len(fs.ChecksumDB))
+	}
+}
+
+// populate adds n files to fs spread over a tree of directories with at most
+// 100 entries each
+func populate(fs *FS, n int) []string {
+	paths := make([]string, n)
+	for i := 0; i < n; i++ {
+		paths[i] = fmt.Sprintf("/%d/%d/%d", i/10000, i/100%100, i)
+		fs.AddSizedFile(paths[i], fmt.Sprintf("%x", i), 1)
+	}
+	return paths
+}
+
+var (
+	benchOnce  sync.Once
+	benchFs    *FS
+	benchPaths []string
+)
+
+// benchTree returns a FS of a million files shared by the benchmarks, it is
+// only built once since building it takes a few seconds
+func benchTree(b *testing.B) (*FS, []string) {
+	n := 1 << 20
+	if testing.Short() {
+		n = 1 << 16
+	}
+	benchOnce.Do(func() {
+		benchFs = NewFS()
+		benchPaths = populate(benchFs, n)
+	})
+	b.ResetTimer()
+	return benchFs, benchPaths
+}
+
+func BenchmarkFsAddFile(b *testing.B) {
+	fs := NewFS()
+	b.ReportAllocs()
+	populate(fs, b.N)
+}
+
+func BenchmarkFsGetFile(b *testing.B) {
+	fs, paths := benchTree(b)
+	for i := 0; i < b.N; i++ {
+		if _, err := fs.GetFile(paths[i%len(paths)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFsGetFileParallel(b *testing.B) {
+	fs, paths := benchTree(b)
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := fs.GetFile(paths[i%len(paths)]); err != nil {
+				b.Fatal(err)
+			}
+			i += 7919
+		}
+	})
+}
+
+// readers run while one writer keeps adding and deleting files
+func BenchmarkFsMixedParallel(b *testing.B) {
+	fs, paths := benchTree(b)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			p := fmt.Sprintf("/w/%d/%d", i%100, i)
+			fs.AddSizedFile(p, "w"+p, 1)
+			fs.DeleteFile(p)
+		}
+	}()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			fs.GetFile(paths[i%len(paths)])
+			i += 7919
+		}
+	})
+	close(stop)
+	<-done
+}
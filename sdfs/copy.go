@@ -0,0 +1,198 @@
+package sdfs
+
+import (
+	"sort"
+	"strings"
+)
+
+// splitRel splits a relative path of a tree into the path of its directory
+// and its name
+func splitRel(rel string) (string, string) {
+	i := strings.LastIndex(rel, "/")
+	return rel[:i+1], rel[i+1:]
+}
+
+// Copy copies the file or directory tree at src to dst without moving any
+// content, copies share their Files with the originals. dst should not exist
+// and both should be in the same namespace, symlinks in the tree are copied
+// as they are and prior versions are not copied, extended attributes are. It
+// returns the copied files, one for each new path
+func (f *FS) Copy(src, dst string) ([]*File, error) {
+	sp, err := ParsePath(src)
+	if err != nil {
+		return nil, err
+	}
+	dp, err := ParsePath(dst)
+	if err != nil {
+		return nil, err
+	}
+	if dp.IsRoot() {
+		return nil, &PathError{dst, "no file name"}
+	}
+	if sp.Namespace != dp.Namespace {
+		return nil, NewError(ErrInvalidArgument, "can not copy across namespaces")
+	}
+	// the usage of the source is checked against the quota of dst first,
+	// the tree itself is read in the transaction that copies it
+	var size, physical, n uint64
+	var file *File
+	var xattrs map[string]string
+	sdir, err := f.getDir(sp)
+	if err == nil {
+		size, physical, n = sdir.Usage()
+	} else {
+		dir, name, err := f.lookupFile(src)
+		if err != nil {
+			return nil, NewError(ErrNotFound, "file not exist")
+		}
+		if err := f.rlock(dir); err != nil {
+			return nil, err
+		}
+		file = dir.Files[name]
+		xattrs = dir.Xattrs[name]
+		dir.mu.RUnlock()
+		if file == nil {
+			return nil, NewError(ErrNotFound, "file not exist")
+		}
+		f.mu.Lock()
+		size, physical, n = file.Size, uint64(file.physical()), 1
+		f.mu.Unlock()
+	}
+	if err := f.checkQuota(dp, size, physical, n); err != nil {
+		return nil, err
+	}
+	ddir, err := f.addDir(dp.Dir())
+	if err != nil {
+		return nil, err
+	}
+	if sdir != nil && strings.HasPrefix(ddir.FullPath, sdir.FullPath) {
+		return nil, NewError(ErrInvalidArgument, "can not copy a directory into itself")
+	}
+	name := dp.Base()
+	if err := f.lock(ddir); err != nil {
+		return nil, err
+	}
+	defer f.unlock(ddir)
+	if ddir.exists(name) {
+		return nil, NewError(ErrAlreadyExists, "file exists")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if sdir == nil {
+		return f.copyFile(ddir, name, file, xattrs)
+	}
+	return f.copyTree(sdir, ddir, name)
+}
+
+// copyFile copies file to name in ddir along with the extended attributes of
+// its path, ddir.mu and f.mu should be held by the caller
+func (f *FS) copyFile(ddir *Directory, name string, file *File, xattrs map[string]string) ([]*File, error) {
+	path := ddir.FullPath + name
+	r := refs{}
+	r.add(file.Checksum, 1, 0)
+	err := f.update(func(tx MetaTx) error {
+		// the source may have been deleted since, along with the file
+		var err error
+		if file, err = f.readFile(tx, file.Checksum); err != nil {
+			return err
+		}
+		if err := putLink(tx, path, file.Checksum); err != nil {
+			return err
+		}
+		if err := putXattrs(tx, path, xattrs); err != nil {
+			return err
+		}
+		_, err = f.putRefs(tx, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	file = f.linkFile(ddir, name, file)
+	ddir.setXattrs(name, xattrs)
+	f.applyRefs(r)
+	return []*File{file}, nil
+}
+
+// copyTree copies the tree of sdir to name in ddir, the entries are copied
+// in the store and the new directories load them when they are used.
+// ddir.mu and f.mu should be held by the caller
+func (f *FS) copyTree(sdir, ddir *Directory, name string) ([]*File, error) {
+	prefix, path := sdir.FullPath, ddir.FullPath+name+"/"
+	r := refs{}
+	var files []*File
+	var rels []string
+	err := f.update(func(tx MetaTx) error {
+		// the keys of each bucket are read before any is written, a bucket
+		// should not be changed while it is iterated
+		for _, bucket := range []string{bucketDirs, bucketFiles, bucketXattrs, bucketSymlinks} {
+			kv := make(map[string][]byte)
+			err := tx.ForEach(bucket, prefix, func(k string, v []byte) error {
+				kv[k[len(prefix):]] = append([]byte(nil), v...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if bucket == bucketDirs {
+				kv[""] = []byte{}
+			}
+			byHash := make(map[string]*File)
+			for rel, v := range kv {
+				if bucket != bucketFiles {
+					if err := tx.Put(bucket, path+rel, v); err != nil {
+						return err
+					}
+					if bucket == bucketDirs {
+						rels = append(rels, rel)
+					}
+					continue
+				}
+				hash := string(v)
+				if err := putLink(tx, path+rel, hash); err != nil {
+					return err
+				}
+				r.add(hash, 1, 0)
+				file, ok := byHash[hash]
+				if !ok {
+					if file, err = f.readFile(tx, hash); err != nil {
+						return err
+					}
+					byHash[hash] = file
+				}
+				files = append(files, file)
+			}
+		}
+		_, err := f.putRefs(tx, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.applyRefs(r)
+	// the new tree is built before it is attached to ddir, nobody else can
+	// reach its directories until then. Parents sort before their
+	// subdirectories, the usage of every directory is the one of its source
+	sort.Strings(rels)
+	dirs := make(map[string]*Directory, len(rels))
+	for _, rel := range rels {
+		parent, base := splitRel(strings.TrimSuffix(rel, "/"))
+		d := NewDirectory(base, path+rel)
+		if rel == "" {
+			d.Name, d.Parent = name, ddir
+		} else {
+			d.Parent = dirs[parent]
+			d.Parent.SubDirs[base] = d
+		}
+		if s, ok := f.dirs[prefix+rel]; ok {
+			d.Size, d.PhysicalSize, d.FileCount = s.Usage()
+		}
+		dirs[rel] = d
+		f.dirs[d.FullPath] = d
+	}
+	root := dirs[""]
+	size, physical, n := root.Usage()
+	ddir.addUsage(int64(size), int64(physical), int64(n))
+	ddir.SubDirs[name] = root
+	return files, nil
+}
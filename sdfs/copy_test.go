@@ -0,0 +1,73 @@
+package sdfs
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFsCopyFile(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/a/f", "h1", 10)
+	files, err := fs.Copy("/a/f", "/b/g")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("want 1 file copied, have: %v, %v", files, err)
+	}
+	f, _ := fs.GetFile("/a/f")
+	g, err := fs.GetFile("/b/g")
+	if err != nil || f != g || g.SemaphoreReplica != 2 {
+		t.Errorf("copy does not share file, have: %+v, %v", g, err)
+	}
+	if fs.Roots[0].TotalSize() != 20 {
+		t.Errorf("want 20 bytes used, have: %d", fs.Roots[0].TotalSize())
+	}
+	if _, err := fs.Copy("/a/f", "/b/g"); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("want error copying to existing path, have: %v", err)
+	}
+	if _, err := fs.Copy("/a/x", "/b/x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want error copying missing file, have: %v", err)
+	}
+}
+
+func TestFsCopyTree(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/src/f", "h1", 10)
+	fs.AddSizedFile("/src/sub/g", "h2", 20)
+	fs.AddSizedFile("/src/sub/h", "h1", 10)
+	fs.AddDir("/src/empty")
+	fs.Symlink("sub/g", "/src/link")
+	files, err := fs.Copy("/src", "/dst/copy")
+	if err != nil || len(files) != 3 {
+		t.Fatalf("want 3 files copied, have: %v, %v", files, err)
+	}
+	for _, p := range []string{"/dst/copy/f", "/dst/copy/sub/g", "/dst/copy/sub/h", "/dst/copy/link"} {
+		if _, err := fs.GetFile(p); err != nil {
+			t.Errorf("%s not copied: %v", p, err)
+		}
+	}
+	if _, err := fs.GetDir("/dst/copy/empty"); err != nil {
+		t.Errorf("empty directory not copied: %v", err)
+	}
+	if f, _ := fs.GetFile("/src/f"); f.SemaphoreReplica != 4 {
+		t.Errorf("want h1 referenced by 4 paths, have: %d", f.SemaphoreReplica)
+	}
+	dst, _ := fs.GetDir("/dst")
+	if size, _, n := dst.Usage(); size != 40 || n != 3 {
+		t.Errorf("want 40 bytes in 3 files under /dst, have: %d, %d", size, n)
+	}
+	if _, err := fs.Copy("/src", "/src/sub/copy"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want error copying a directory into itself, have: %v", err)
+	}
+}
+
+func TestFsCopyQuota(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/src/f", "h1", 10)
+	fs.AddSizedFile("/src/g", "h2", 10)
+	fs.SetQuota("/dst", DirQuota{Files: 1})
+	if _, err := fs.Copy("/src", "/dst/copy"); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("want quota error, have: %v", err)
+	}
+	if _, err := fs.Copy("/src/f", "/dst/f"); err != nil {
+		t.Errorf("want single file copied, have: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package sdfs
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// Headers that carry the sha256 sum of the content of a file, Repr-Digest
+// is defined by RFC 9530 and Digest by RFC 3230, which it obsoletes. Both
+// describe the whole file, responses to range requests carry them too
+const (
+	HeaderReprDigest = "Repr-Digest"
+	HeaderDigest     = "Digest"
+)
+
+// sumOfHash returns the standard base64 encoding of the sha256 sum that hash
+// encodes, ok is false if hash is not a sha256 sum
+func sumOfHash(hash string) (string, bool) {
+	b64 := strings.Replace(hash, "_", "/", -1)
+	b, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil || len(b) != 32 {
+		return "", false
+	}
+	return b64, true
+}
+
+// SumHash returns the hash of content with the given sha256 sum
+func SumHash(sum []byte) string {
+	return encodeSum(sum)
+}
+
+// ReprDigest returns the value of the Repr-Digest header of content with
+// the given hash, it is empty if hash is not a sha256 sum
+func ReprDigest(hash string) string {
+	b64, ok := sumOfHash(hash)
+	if !ok {
+		return ""
+	}
+	return "sha-256=:" + b64 + ":"
+}
+
+// Digest returns the value of the Digest header of content with the given
+// hash, it is empty if hash is not a sha256 sum
+func Digest(hash string) string {
+	b64, ok := sumOfHash(hash)
+	if !ok {
+		return ""
+	}
+	return "SHA-256=" + b64
+}
+
+// ParseDigest returns the hash of the sha256 sum in the value of a
+// Repr-Digest or Digest header, ok is false if there is none
+func ParseDigest(v string) (hash string, ok bool) {
+	for _, d := range strings.Split(v, ",") {
+		alg, value, found := strings.Cut(strings.TrimSpace(d), "=")
+		if !found || !strings.EqualFold(alg, "sha-256") {
+			continue
+		}
+		// Repr-Digest values are byte sequences of RFC 8941
+		if len(value) > 1 && value[0] == ':' && value[len(value)-1] == ':' {
+			value = value[1 : len(value)-1]
+		}
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(b) != 32 {
+			continue
+		}
+		return encodeSum(b), true
+	}
+	return "", false
+}
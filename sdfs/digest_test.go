@@ -0,0 +1,23 @@
+package sdfs
+
+import (
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	hash := sumOf("hello")
+	if got, ok := ParseDigest(ReprDigest(hash)); !ok || got != hash {
+		t.Errorf("want %s from Repr-Digest, have: %s, %v", hash, got, ok)
+	}
+	if got, ok := ParseDigest("md5=abc, " + Digest(hash)); !ok || got != hash {
+		t.Errorf("want %s from Digest, have: %s, %v", hash, got, ok)
+	}
+	if ReprDigest("not a sum") != "" || Digest("not a sum") != "" {
+		t.Errorf("want no digest of a hash that is not a sha256 sum")
+	}
+	for _, v := range []string{"", "sha-512=:AAAA:", "sha-256=:AAAA:", "sha-256"} {
+		if _, ok := ParseDigest(v); ok {
+			t.Errorf("want no sha256 digest in %q", v)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package sdfs
+
+import (
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/Lyianu/sdfs/log"
+	"github.com/shirou/gopsutil/disk"
+)
+
+// Disk is a data directory of a HashStore, every data directory should be
+// on a disk of its own. A disk that fails is taken out of the hashstore, its
+// objects are no longer served and nothing is written to it until the node
+// restarts
+type Disk struct {
+	Dir string
+
+	// used, stored, objects and compressed add up the objects on the disk,
+	// they are guarded by the lock of the hashstore
+	used       int64
+	stored     int64
+	objects    int
+	compressed int
+
+	mu  sync.RWMutex
+	err error // why the disk failed, nil while it is healthy
+}
+
+// DiskStat describes a disk of a node, nodes report them in heartbeats
+type DiskStat struct {
+	Dir     string `json:"dir"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+	Total   uint64 `json:"total"`
+	Free    uint64 `json:"free"`
+	// Used is the size of the objects on the disk
+	Used    int64 `json:"used"`
+	Objects int   `json:"objects"`
+}
+
+// diskUsage returns the total and the free space of the file system dir is
+// on, tests replace it
+var diskUsage = func(dir string) (total, free uint64, err error) {
+	u, err := disk.Usage(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	return u.Total, u.Free, nil
+}
+
+// Path returns the path of the object with the given hash on d, files of
+// objects stored with a codec add its suffix to it
+func (d *Disk) Path(hash string) string {
+	return objectPath(d.Dir, objectName(hash))
+}
+
+// file returns the path of the file of the object with the given hash on
+// d stored with codec, encrypted if sealed is true
+func (d *Disk) file(hash, codec string, sealed bool) string {
+	p := d.Path(hash) + codecSuffix[codec]
+	if sealed {
+		p += sealedSuffix
+	}
+	return p
+}
+
+// count adds n times f to the objects on d
+func (d *Disk) count(f *file, n int) {
+	d.used += int64(n) * f.Size
+	d.stored += int64(n) * f.Stored
+	d.objects += n
+	if f.Codec != CodecNone {
+		d.compressed += n
+	}
+}
+
+// Err returns why d failed, nil if it is healthy
+func (d *Disk) Err() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.err
+}
+
+// open makes sure d can hold objects of the current layout
+func (d *Disk) open() error {
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return err
+	}
+	return checkLayout(d.Dir)
+}
+
+// isFull reports whether err is caused by a full disk, which does not make
+// the disk fail
+func isFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+// fail takes d out of h because of err, the objects on d are dropped from
+// memory but kept in the index, so that they are found again if the disk is
+// back after a restart. h.mu should be held by the caller
+func (h *HashStore) fail(d *Disk, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return
+	}
+	d.err = err
+	log.Errorf("disk %s failed, %d objects are lost: %q", d.Dir, d.objects, err)
+	for hash, f := range h.s {
+		if f.disk == d {
+			h.Size -= f.Size
+			delete(h.s, hash)
+		}
+	}
+	d.used, d.stored, d.objects, d.compressed = 0, 0, 0, 0
+}
+
+// failed handles an I/O error on d, d is failed unless the error is caused
+// by a full disk or a missing file. It returns err
+func (h *HashStore) failed(d *Disk, err error) error {
+	if err == nil || isFull(err) || os.IsNotExist(err) {
+		return err
+	}
+	h.mu.Lock()
+	h.fail(d, err)
+	h.mu.Unlock()
+	return err
+}
+
+// pickDisk returns the healthy disk with the most free space
+func (h *HashStore) pickDisk() (*Disk, error) {
+	var best *Disk
+	var bestFree uint64
+	for _, d := range h.Disks {
+		if d.Err() != nil {
+			continue
+		}
+		_, free, err := diskUsage(d.Dir)
+		if err != nil {
+			h.failed(d, err)
+			continue
+		}
+		if best == nil || free > bestFree {
+			best, bestFree = d, free
+		}
+	}
+	if best == nil {
+		return nil, NewError(ErrNoCapacity, "no healthy disk")
+	}
+	return best, nil
+}
+
+// DiskStats returns the state of every disk of h, disks whose usage can not
+// be read are failed
+func (h *HashStore) DiskStats() []DiskStat {
+	stats := make([]DiskStat, 0, len(h.Disks))
+	for _, d := range h.Disks {
+		s := DiskStat{Dir: d.Dir}
+		if d.Err() == nil {
+			total, free, err := diskUsage(d.Dir)
+			if err != nil {
+				h.failed(d, err)
+			}
+			s.Total, s.Free = total, free
+		}
+		if err := d.Err(); err != nil {
+			s.Error = err.Error()
+		} else {
+			s.Healthy = true
+		}
+		h.mu.RLock()
+		s.Used, s.Objects = d.used, d.objects
+		h.mu.RUnlock()
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+// Open opens the content of f for reading, it is f from Get. Compressed
+// content is decompressed and encrypted content decrypted as it is read,
+// reads fail with an error matching ErrChecksumMismatch if it can not be
+func (h *HashStore) Open(f *file) (Object, error) {
+	r, err := h.OpenStored(f)
+	if err != nil {
+		return nil, err
+	}
+	if f.Codec == CodecNone {
+		return r, nil
+	}
+	f.mu.Lock()
+	name := f.Path()
+	f.mu.Unlock()
+	return &frameReader{src: r, name: name, frame: f.Frame, frames: f.frames, stored: f.Stored, size: f.Size}, nil
+}
+
+// Path returns the path of the file of f
+func (f *file) Path() string {
+	return f.disk.file(f.Hash, f.Codec, f.KeyID != "")
+}
+
+// verifyingReader hashes the content of an object as it is read, content
+// that turns out not to match its hash when the end is reached is verified
+// again and quarantined if it is corrupt
+type verifyingReader struct {
+	r   Object
+	h   *HashStore
+	f   *file
+	sum hash.Hash
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.sum.Write(p[:n])
+	if err != io.EOF && !errors.Is(err, ErrChecksumMismatch) || err == io.EOF && encodeSum(v.sum.Sum(nil)) == v.f.Hash {
+		return n, err
+	}
+	ok, verr := v.h.Verify(v.f.Hash, nil)
+	if verr != nil {
+		return n, verr
+	}
+	if ok {
+		return n, NewError(ErrConflict, "object %s changed while it was read", v.f.Hash)
+	}
+	return n, NewError(ErrChecksumMismatch, "object %s is corrupt, quarantined it", v.f.Hash)
+}
+
+func (v *verifyingReader) Close() error {
+	return v.r.Close()
+}
+
+// OpenVerified opens the content of f for reading like Open, reading to the
+// end fails with ErrChecksumMismatch if the content does not match the hash
+// of f, in which case the object is quarantined
+func (h *HashStore) OpenVerified(f *file) (io.ReadCloser, error) {
+	r, err := h.Open(f)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := sumOfHash(f.Hash); !ok {
+		return r, nil
+	}
+	return &verifyingReader{r: r, h: h, f: f, sum: sha256.New()}, nil
+}
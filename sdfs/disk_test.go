@@ -0,0 +1,125 @@
+package sdfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeUsage replaces diskUsage with free space and failures set by tests
+type fakeUsage struct {
+	mu     sync.Mutex
+	free   map[string]uint64
+	broken map[string]bool
+}
+
+func useFakeUsage(t *testing.T) *fakeUsage {
+	u := &fakeUsage{free: make(map[string]uint64), broken: make(map[string]bool)}
+	old := diskUsage
+	diskUsage = func(dir string) (uint64, uint64, error) {
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if u.broken[dir] {
+			return 0, 0, errors.New("input/output error")
+		}
+		return 1 << 30, u.free[dir], nil
+	}
+	t.Cleanup(func() { diskUsage = old })
+	return u
+}
+
+func (u *fakeUsage) set(dir string, free uint64, broken bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.free[dir] = free
+	u.broken[dir] = broken
+}
+
+func TestHashStoreDiskPlacement(t *testing.T) {
+	u := useFakeUsage(t)
+	d1, d2 := t.TempDir(), t.TempDir()
+	u.set(d1, 100, false)
+	u.set(d2, 200, false)
+	h, err := OpenHashStore([]string{d1, d2}, NewMemIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _, _ := h.Add(strings.NewReader("aaa"))
+	u.set(d2, 50, false)
+	b, _, _ := h.Add(strings.NewReader("bbb"))
+	if _, err := os.Stat(h.Disks[1].Path(a)); err != nil {
+		t.Errorf("want %s on the disk with most free space: %v", a, err)
+	}
+	if _, err := os.Stat(h.Disks[0].Path(b)); err != nil {
+		t.Errorf("want %s on the disk with most free space: %v", b, err)
+	}
+	f, _ := h.Get(a)
+	r, err := h.Open(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	stats := h.DiskStats()
+	if len(stats) != 2 || stats[0].Objects != 1 || stats[1].Used != 3 || !stats[0].Healthy || stats[1].Free != 50 {
+		t.Errorf("unexpected disk stats: %+v", stats)
+	}
+}
+
+func TestHashStoreDiskFailure(t *testing.T) {
+	u := useFakeUsage(t)
+	d1, d2 := t.TempDir(), t.TempDir()
+	u.set(d1, 100, false)
+	u.set(d2, 200, false)
+	index := NewMemIndex()
+	h, err := OpenHashStore([]string{d1, d2}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _, _ := h.Add(strings.NewReader("aaa"))
+	h.Ref(a, 1)
+	u.set(d2, 200, true)
+	b, _, err := h.Add(strings.NewReader("bbb"))
+	if err != nil {
+		t.Fatalf("want writes to go on after a disk failed, have: %v", err)
+	}
+	if _, err := os.Stat(h.Disks[0].Path(b)); err != nil {
+		t.Errorf("want %s on the healthy disk: %v", b, err)
+	}
+	if _, err := h.Get(a); err == nil {
+		t.Errorf("object of failed disk still served")
+	}
+	stats := h.DiskStats()
+	if stats[1].Healthy || stats[1].Error == "" || stats[1].Objects != 0 || h.GetSize() != 3 {
+		t.Errorf("failed disk not reported, have: %+v, size %d", stats, h.GetSize())
+	}
+
+	// the node restarts without the failed disk, its objects stay indexed
+	os.Rename(d2, d2+".gone")
+	os.WriteFile(d2, nil, 0644)
+	h, err = OpenHashStore([]string{d1, d2}, index)
+	if err != nil {
+		t.Fatalf("want hashstore opened with one disk, have: %v", err)
+	}
+	if h.Disks[1].Err() == nil {
+		t.Errorf("want second disk failed")
+	}
+	// and the disk is back
+	os.Remove(d2)
+	os.Rename(d2+".gone", d2)
+	u.set(d2, 200, false)
+	h, err = OpenHashStore([]string{d1, d2}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := h.Get(a); err != nil || f.ReplicaCount != 2 {
+		t.Errorf("want object of recovered disk with its references, have: %+v, %v", f, err)
+	}
+	blocked := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(blocked, nil, 0644)
+	if _, err := OpenHashStore([]string{filepath.Join(blocked, "data")}, nil); err == nil {
+		t.Errorf("want error when no disk is healthy")
+	}
+}
@@ -0,0 +1,442 @@
+package sdfs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/Lyianu/sdfs/pkg/kms"
+	"github.com/Lyianu/sdfs/pkg/util"
+)
+
+// An encrypted object is a header followed by its stored content, which is
+// compressed if the object has a codec, sealed in chunks with AES-GCM under
+// a data key of its own. The header holds the data key wrapped by a master
+// key of the KMS of the hashstore. Chunk i is sealed with i as its nonce,
+// which is unique as data keys are, and with i and whether it is the last
+// chunk as additional data, so that chunks can be neither reordered nor cut
+// off. Every chunk is opened on its own, which keeps range reads possible.
+// Objects are still named by the hash of their plaintext, so that equal
+// content is stored once
+
+// sealChunk is the size of the plaintext of every chunk of an encrypted
+// object but the last one
+const sealChunk = 64 << 10
+
+// sealMagic starts the files of encrypted objects
+const sealMagic = "SDFSENC1"
+
+// sealedSuffix ends the names of the files of encrypted objects, after the
+// suffix of their codec
+const sealedSuffix = ".enc"
+
+// sealHeader is the header of the file of an encrypted object
+type sealHeader struct {
+	KeyID   string
+	Wrapped []byte
+	Chunk   int64
+}
+
+func (hd sealHeader) size() int64 {
+	return int64(len(sealMagic) + 2 + len(hd.KeyID) + 2 + len(hd.Wrapped) + 4)
+}
+
+func (hd sealHeader) write(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	buf.WriteString(sealMagic)
+	binary.Write(buf, binary.LittleEndian, uint16(len(hd.KeyID)))
+	buf.WriteString(hd.KeyID)
+	binary.Write(buf, binary.LittleEndian, uint16(len(hd.Wrapped)))
+	buf.Write(hd.Wrapped)
+	binary.Write(buf, binary.LittleEndian, uint32(hd.Chunk))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readSealHeader reads the header of the encrypted object in the file at
+// path from r
+func readSealHeader(r io.Reader, path string) (sealHeader, error) {
+	var hd sealHeader
+	magic := make([]byte, len(sealMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return hd, corrupt(path, err)
+	}
+	if string(magic) != sealMagic {
+		return hd, NewError(ErrChecksumMismatch, "%s is not an encrypted object", path)
+	}
+	var n uint16
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return hd, corrupt(path, err)
+	}
+	id := make([]byte, n)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return hd, corrupt(path, err)
+	}
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return hd, corrupt(path, err)
+	}
+	hd.KeyID, hd.Wrapped = string(id), make([]byte, n)
+	if _, err := io.ReadFull(r, hd.Wrapped); err != nil {
+		return hd, corrupt(path, err)
+	}
+	var chunk uint32
+	if err := binary.Read(r, binary.LittleEndian, &chunk); err != nil {
+		return hd, corrupt(path, err)
+	}
+	if chunk == 0 {
+		return hd, NewError(ErrChecksumMismatch, "%s has empty chunks", path)
+	}
+	hd.Chunk = int64(chunk)
+	return hd, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, i int64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(i))
+	return nonce
+}
+
+func chunkAD(i int64, last bool) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, uint64(i))
+	if last {
+		ad[8] = 1
+	}
+	return ad
+}
+
+// seal writes the size bytes of r sealed under a new data key wrapped by
+// keys to a new file in dir. It returns the name of the file, the id of the
+// master key and the size of the file
+func seal(r io.Reader, size int64, dir string, keys kms.KMS) (string, string, int64, error) {
+	key, err := kms.NewKey()
+	if err != nil {
+		return "", "", 0, err
+	}
+	id, wrapped, err := keys.Wrap(key)
+	if err != nil {
+		return "", "", 0, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", "", 0, err
+	}
+	name := filepath.Join(dir, tmpPrefix+util.RandomString(16))
+	f, err := os.Create(name)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer f.Close()
+	w := &countingWriter{w: bufio.NewWriter(f)}
+	err = sealHeader{KeyID: id, Wrapped: wrapped, Chunk: sealChunk}.write(w)
+	// empty content is a single empty chunk, so that it is authenticated
+	chunks := (size + sealChunk - 1) / sealChunk
+	if chunks == 0 {
+		chunks = 1
+	}
+	buf := make([]byte, sealChunk)
+	var out []byte
+	for i := int64(0); i < chunks && err == nil; i++ {
+		n := size - i*sealChunk
+		if n > sealChunk {
+			n = sealChunk
+		}
+		if _, err = io.ReadFull(r, buf[:n]); err != nil {
+			break
+		}
+		out = aead.Seal(out[:0], chunkNonce(aead, i), buf[:n], chunkAD(i, i == chunks-1))
+		_, err = w.Write(out)
+	}
+	if err == nil {
+		err = w.w.(*bufio.Writer).Flush()
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", "", 0, err
+	}
+	return name, id, w.n, nil
+}
+
+// sealFile seals the size bytes of the file at path like seal, the sealed
+// file is written next to it
+func sealFile(path string, size int64, keys kms.KMS) (string, string, int64, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer r.Close()
+	return seal(r, size, filepath.Dir(path), keys)
+}
+
+// sealedReader reads the plaintext of an encrypted object, chunks are opened
+// as reads reach them. It should not be used concurrently
+type sealedReader struct {
+	f      *os.File
+	aead   cipher.AEAD
+	start  int64 // offset of the first chunk in f
+	chunk  int64
+	chunks int64
+	size   int64
+
+	off int64
+	// buf is the plaintext of chunk cur, cur is -1 if buf holds none
+	cur int64
+	buf []byte
+	ct  []byte
+}
+
+// openSealed opens the encrypted object in f, its data key is unwrapped by
+// keys. Keys that can not be unwrapped are no sign of corruption, they are
+// reported with ErrNotFound and ErrInvalidArgument
+func openSealed(f *os.File, keys kms.KMS) (*sealedReader, sealHeader, error) {
+	if keys == nil {
+		return nil, sealHeader{}, NewError(ErrInvalidArgument, "%s is encrypted and there are no keys", f.Name())
+	}
+	hd, err := readSealHeader(io.NewSectionReader(f, 0, 1<<16+1<<16+64), f.Name())
+	if err != nil {
+		return nil, hd, err
+	}
+	key, err := keys.Unwrap(hd.KeyID, hd.Wrapped)
+	if errors.Is(err, kms.ErrUnknownKey) {
+		return nil, hd, NewError(ErrNotFound, "master key %s of %s not found", hd.KeyID, f.Name())
+	} else if err != nil {
+		return nil, hd, NewError(ErrInvalidArgument, "data key of %s does not unwrap: %v", f.Name(), err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, hd, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, hd, err
+	}
+	overhead := int64(aead.Overhead())
+	body := fi.Size() - hd.size()
+	chunks := (body + hd.Chunk + overhead - 1) / (hd.Chunk + overhead)
+	if body < overhead {
+		return nil, hd, NewError(ErrChecksumMismatch, "%s is truncated", f.Name())
+	}
+	return &sealedReader{
+		f:      f,
+		aead:   aead,
+		start:  hd.size(),
+		chunk:  hd.Chunk,
+		chunks: chunks,
+		size:   body - chunks*overhead,
+		cur:    -1,
+	}, hd, nil
+}
+
+// open opens chunk i into r.buf
+func (r *sealedReader) open(i int64) error {
+	r.cur = -1
+	stride := r.chunk + int64(r.aead.Overhead())
+	off := r.start + i*stride
+	n := stride
+	if i == r.chunks-1 {
+		n = r.start + r.size + r.chunks*int64(r.aead.Overhead()) - off
+	}
+	if int64(cap(r.ct)) < n {
+		r.ct = make([]byte, stride)
+	}
+	r.ct = r.ct[:n]
+	if m, err := r.f.ReadAt(r.ct, off); int64(m) < n {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return corrupt(r.f.Name(), err)
+	}
+	buf, err := r.aead.Open(r.buf[:0], chunkNonce(r.aead, i), r.ct, chunkAD(i, i == r.chunks-1))
+	if err != nil {
+		return NewError(ErrChecksumMismatch, "chunk %d of %s does not authenticate", i, r.f.Name())
+	}
+	r.buf, r.cur = buf, i
+	return nil
+}
+
+func (r *sealedReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) && off < r.size {
+		i := off / r.chunk
+		if i != r.cur {
+			if err := r.open(i); err != nil {
+				return n, err
+			}
+		}
+		m := copy(p[n:], r.buf[off-i*r.chunk:])
+		n += m
+		off += int64(m)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *sealedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadAt(p, r.off)
+	r.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *sealedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, NewError(ErrInvalidArgument, "negative offset %d", offset)
+	}
+	r.off = offset
+	return offset, nil
+}
+
+func (r *sealedReader) Close() error {
+	return r.f.Close()
+}
+
+// Rekey brings the encryption of the objects of h up to date with its keys:
+// unencrypted objects are encrypted, the data keys of objects wrapped by a
+// master key other than the current one are wrapped again, and with
+// reencrypt every object is encrypted again under a new data key. It
+// returns the number of objects changed, the node should not be running
+func (h *HashStore) Rekey(reencrypt bool) (int, error) {
+	if h.Keys == nil {
+		return 0, NewError(ErrInvalidArgument, "no keys to encrypt objects with")
+	}
+	h.mu.RLock()
+	files := make([]*file, 0, len(h.s))
+	for _, f := range h.s {
+		files = append(files, f)
+	}
+	h.mu.RUnlock()
+	current, n := h.Keys.Current(), 0
+	for _, f := range files {
+		f.mu.Lock()
+		keyID := f.KeyID
+		f.mu.Unlock()
+		var err error
+		switch {
+		case keyID == "" || reencrypt:
+			err = h.reseal(f)
+		case keyID != current:
+			err = h.rewrap(f)
+		default:
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// reseal encrypts the stored content of f under a new data key
+func (h *HashStore) reseal(f *file) error {
+	atomic.AddInt32(&f.OpenCount, 1)
+	defer atomic.AddInt32(&f.OpenCount, -1)
+	r, err := h.OpenStored(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	name, id, sealed, err := seal(r, f.Stored, f.disk.Dir, h.Keys)
+	if err != nil {
+		return err
+	}
+	return h.replace(f, name, id, sealed)
+}
+
+// rewrap wraps the data key of the encrypted object f by the current master
+// key, only the header of its file changes
+func (h *HashStore) rewrap(f *file) error {
+	src, err := os.Open(f.Path())
+	if err != nil {
+		return h.failed(f.disk, err)
+	}
+	defer src.Close()
+	sr, hd, err := openSealed(src, h.Keys)
+	if err != nil {
+		return err
+	}
+	key, err := h.Keys.Unwrap(hd.KeyID, hd.Wrapped)
+	if err != nil {
+		return err
+	}
+	id, wrapped, err := h.Keys.Wrap(key)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(f.disk.Dir, tmpPrefix+util.RandomString(16))
+	dst, err := os.Create(name)
+	if err != nil {
+		return h.failed(f.disk, err)
+	}
+	defer dst.Close()
+	w := &countingWriter{w: dst}
+	err = sealHeader{KeyID: id, Wrapped: wrapped, Chunk: hd.Chunk}.write(w)
+	if err == nil {
+		_, err = io.Copy(w, io.NewSectionReader(src, sr.start, sr.size+sr.chunks*int64(sr.aead.Overhead())))
+	}
+	if err != nil {
+		os.Remove(name)
+		return err
+	}
+	return h.replace(f, name, id, w.n)
+}
+
+// replace makes the file name the encrypted file of f, its data key is
+// wrapped by the master key id and it has sealed bytes
+func (h *HashStore) replace(f *file, name, id string, sealed int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.s[f.Hash] != f {
+		// removed in the meantime
+		os.Remove(name)
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	old, path := f.Path(), f.disk.file(f.Hash, f.Codec, true)
+	if err := os.Rename(name, path); err != nil {
+		os.Remove(name)
+		if !isFull(err) {
+			h.fail(f.disk, err)
+		}
+		return err
+	}
+	r := f.record()
+	r.Key, r.Sealed = id, sealed
+	if err := h.update(func(tx MetaTx) error { return putJSON(tx, bucketObjects, f.Hash, r) }); err != nil {
+		if old != path {
+			os.Remove(path)
+		}
+		return err
+	}
+	f.KeyID, f.Sealed = id, sealed
+	if old != path {
+		os.Remove(old)
+	}
+	return nil
+}
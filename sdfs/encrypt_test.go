@@ -0,0 +1,207 @@
+package sdfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Lyianu/sdfs/pkg/kms"
+)
+
+// openKeys opens a new keyfile for tests
+func openKeys(t *testing.T) *kms.File {
+	t.Helper()
+	keys, err := kms.OpenFile(filepath.Join(t.TempDir(), "keys"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// readObject reads the content of the object with the given hash
+func readObject(h *HashStore, hash string) ([]byte, error) {
+	f, err := h.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	r, err := h.OpenVerified(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestHashStoreEncryption(t *testing.T) {
+	keys := openKeys(t)
+	h, err := OpenEncryptedHashStore([]string{t.TempDir()}, NewMemIndex(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := text(3*sealChunk + 100)
+	hash, _, err := h.Add(bytes.NewReader(content))
+	if err != nil || hash != sumOf(string(content)) {
+		t.Fatalf("want object keyed by the hash of its plaintext, have: %s, %v", hash, err)
+	}
+	// equal content is stored once
+	if again, _, _ := h.Add(bytes.NewReader(content)); again != hash {
+		t.Errorf("want equal content deduplicated, have: %s", again)
+	}
+	f, _ := h.Get(hash)
+	if f.KeyID != keys.Current() || f.ReplicaCount != 2 || f.Stored != f.Size {
+		t.Fatalf("want object encrypted under the current master key, have: %+v", f)
+	}
+	data, _ := os.ReadFile(f.Path())
+	if int64(len(data)) != f.Sealed || bytes.Contains(data, content[:100]) {
+		t.Errorf("want the file of %d bytes without plaintext, have %d bytes", f.Sealed, len(data))
+	}
+	if b, err := readObject(h, hash); err != nil || !bytes.Equal(b, content) {
+		t.Errorf("content not decrypted: %v", err)
+	}
+	r, _ := h.Open(f)
+	defer r.Close()
+	for _, off := range []int64{0, sealChunk - 1, sealChunk, 2*sealChunk + 7} {
+		b := make([]byte, sealChunk+10)
+		if n, err := r.ReadAt(b, off); err != nil || !bytes.Equal(b[:n], content[off:off+int64(n)]) {
+			t.Errorf("read at %d: %v", off, err)
+		}
+	}
+	if _, err := r.Seek(int64(len(content))-10, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := io.ReadAll(r); err != nil || !bytes.Equal(b, content[len(content)-10:]) {
+		t.Errorf("want the last 10 bytes, have %q: %v", b, err)
+	}
+
+	// empty content and compressed content are encrypted too
+	empty, _, _ := h.Add(bytes.NewReader(nil))
+	if b, err := readObject(h, empty); err != nil || len(b) != 0 {
+		t.Errorf("empty object not read: %q, %v", b, err)
+	}
+	smallFrames(t)
+	h.Codec = CodecGzip
+	small := text(2500)
+	compressed, _, _ := h.Add(bytes.NewReader(small))
+	if f, _ := h.Get(compressed); f.Codec != CodecGzip || f.KeyID == "" || f.Stored >= f.Size {
+		t.Errorf("want object compressed and encrypted, have: %+v", f)
+	}
+	if b, err := readObject(h, compressed); err != nil || !bytes.Equal(b, small) {
+		t.Errorf("compressed object not read: %v", err)
+	}
+
+	// a chunk that does not authenticate is corruption
+	data[len(data)-20] ^= 1
+	os.WriteFile(f.Path(), data, 0644)
+	if _, err := readObject(h, hash); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("want ErrChecksumMismatch, have: %v", err)
+	}
+	if _, err := h.Get(hash); err == nil || h.Disks[0].Err() != nil {
+		t.Errorf("want tampered object quarantined and disk healthy, have: %v", h.Disks[0].Err())
+	}
+}
+
+func TestHashStoreEncryptionReload(t *testing.T) {
+	keys := openKeys(t)
+	dir := t.TempDir()
+	index := NewMemIndex()
+	h, err := OpenEncryptedHashStore([]string{dir}, index, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := text(sealChunk + 1)
+	hash, _, _ := h.Add(bytes.NewReader(content))
+
+	for _, index := range []MetaStore{index, nil} {
+		h, err := OpenEncryptedHashStore([]string{dir}, index, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f, err := h.Get(hash); err != nil || f.KeyID != keys.Current() || f.Size != int64(len(content)) {
+			t.Fatalf("encrypted object not restored: %+v, %v", f, err)
+		}
+		if b, err := readObject(h, hash); err != nil || !bytes.Equal(b, content) {
+			t.Errorf("restored object not read: %v", err)
+		}
+	}
+	// without the keys the object can not be read, but it is not corrupt
+	h, err = OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readObject(h, hash); err == nil || errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("want object unreadable without keys, have: %v", err)
+	}
+	if _, err := h.Get(hash); err != nil {
+		t.Errorf("object dropped without keys: %v", err)
+	}
+}
+
+func TestHashStoreRekey(t *testing.T) {
+	dir := t.TempDir()
+	index := NewMemIndex()
+	h, err := OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _, _ := h.Add(bytes.NewReader(text(1000)))
+	plain, _ := h.Get(a)
+	plainPath := plain.Path()
+
+	// unencrypted objects are encrypted
+	keys := openKeys(t)
+	h, err = OpenEncryptedHashStore([]string{dir}, index, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _, _ := h.Add(bytes.NewReader(text(2000)))
+	if n, err := h.Rekey(false); err != nil || n != 1 {
+		t.Fatalf("want 1 object encrypted, have: %d, %v", n, err)
+	}
+	if _, err := os.Stat(plainPath); !os.IsNotExist(err) {
+		t.Errorf("unencrypted file kept: %v", err)
+	}
+
+	// data keys are wrapped by the new master key, their content stays
+	old := keys.Current()
+	fb, _ := h.Get(b)
+	before, _ := os.ReadFile(fb.Path())
+	if _, err := keys.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := h.Rekey(false); err != nil || n != 2 {
+		t.Fatalf("want 2 objects rewrapped, have: %d, %v", n, err)
+	}
+	after, _ := os.ReadFile(fb.Path())
+	if fb.KeyID != keys.Current() || !bytes.Equal(before[len(before)-100:], after[len(after)-100:]) {
+		t.Errorf("want data key of %s rewrapped, have key %s", b, fb.KeyID)
+	}
+	if n, _ := h.Rekey(false); n != 0 {
+		t.Errorf("want nothing to rekey, have %d", n)
+	}
+
+	// objects are encrypted again under new data keys
+	if n, err := h.Rekey(true); err != nil || n != 2 {
+		t.Fatalf("want 2 objects encrypted again, have: %d, %v", n, err)
+	}
+	if again, _ := os.ReadFile(fb.Path()); bytes.Equal(after[len(after)-100:], again[len(again)-100:]) {
+		t.Errorf("content of %s not encrypted again", b)
+	}
+
+	// no object is left under the rotated master key after a restart
+	h, err = OpenEncryptedHashStore([]string{dir}, index, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{a, b} {
+		f, err := h.Get(hash)
+		if err != nil || f.KeyID == old || f.KeyID == "" {
+			t.Fatalf("rekeyed object not restored: %+v, %v", f, err)
+		}
+		if _, err := readObject(h, hash); err != nil {
+			t.Errorf("rekeyed object not read: %v", err)
+		}
+	}
+}
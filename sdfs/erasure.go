@@ -0,0 +1,274 @@
+package sdfs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/Lyianu/sdfs/pkg/erasure"
+	"github.com/Lyianu/sdfs/pkg/util"
+)
+
+// erasureBlock is the number of bytes of each shard encoded at a time
+const erasureBlock = 64 << 10
+
+// Erasure is an erasure-coded storage class, files of it are stored as Data
+// data shards and Parity parity shards placed on distinct nodes instead of
+// being replicated. The zero value is full replication
+type Erasure struct {
+	Data   int `json:"data"`
+	Parity int `json:"parity"`
+}
+
+// ParseErasure parses a storage class of the form <data>+<parity>, e.g. 6+3,
+// an empty string is full replication
+func ParseErasure(s string) (Erasure, error) {
+	if s == "" {
+		return Erasure{}, nil
+	}
+	d, p, ok := strings.Cut(s, "+")
+	data, err := strconv.Atoi(d)
+	if !ok || err != nil {
+		return Erasure{}, NewError(ErrInvalidArgument, "invalid erasure coding %q", s)
+	}
+	parity, err := strconv.Atoi(p)
+	if err != nil {
+		return Erasure{}, NewError(ErrInvalidArgument, "invalid erasure coding %q", s)
+	}
+	e := Erasure{Data: data, Parity: parity}
+	if _, err := e.Code(); err != nil {
+		return Erasure{}, NewError(ErrInvalidArgument, "invalid erasure coding %q", s)
+	}
+	return e, nil
+}
+
+// IsZero reports whether e is full replication
+func (e Erasure) IsZero() bool {
+	return e.Data == 0
+}
+
+func (e Erasure) String() string {
+	if e.IsZero() {
+		return ""
+	}
+	return strconv.Itoa(e.Data) + "+" + strconv.Itoa(e.Parity)
+}
+
+// Shards returns the number of shards of files of e
+func (e Erasure) Shards() int {
+	return e.Data + e.Parity
+}
+
+// Code returns the Reed-Solomon code of e
+func (e Erasure) Code() (*erasure.Code, error) {
+	return erasure.New(e.Data, e.Parity)
+}
+
+// SetErasure stores files uploaded to the tree of the directory at path
+// with the storage class e, the directory is created if it does not exist.
+// The zero value falls back to the setting of the parent directory
+func (f *FS) SetErasure(path string, e Erasure) error {
+	if !e.IsZero() {
+		if _, err := e.Code(); err != nil {
+			return NewError(ErrInvalidArgument, "invalid erasure coding %d+%d", e.Data, e.Parity)
+		}
+	}
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	dir, err := f.addDir(p)
+	if err != nil {
+		return err
+	}
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	err = f.update(func(tx MetaTx) error {
+		if e.IsZero() {
+			return tx.Delete(bucketErasure, dir.FullPath)
+		}
+		return tx.Put(bucketErasure, dir.FullPath, []byte(e.String()))
+	})
+	if err != nil {
+		return err
+	}
+	dir.Erasure = e
+	return nil
+}
+
+// ErasureOf returns the storage class of files uploaded to path, the path
+// need not exist
+func (f *FS) ErasureOf(path string) (Erasure, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return Erasure{}, err
+	}
+	dir, err := f.rootDir(p)
+	if err != nil {
+		return Erasure{}, err
+	}
+	for _, part := range p.Dir().Parts {
+		dir.mu.RLock()
+		sub, ok := dir.SubDirs[part]
+		dir.mu.RUnlock()
+		if !ok {
+			break
+		}
+		dir = sub
+	}
+	for d := dir; d != nil; d = d.Parent {
+		d.mu.RLock()
+		e := d.Erasure
+		d.mu.RUnlock()
+		if !e.IsZero() {
+			return e, nil
+		}
+	}
+	return Erasure{}, nil
+}
+
+// Shard returns the erasure-coded file that the chunk with the given hash is
+// a shard of and its index in the file, ok is false if it is none
+func (f *FS) Shard(hash string) (file *File, index int, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := linkKey(hash, "")
+	f.view(func(tx MetaTx) error {
+		return tx.ForEach(bucketChunkRefs, prefix, func(k string, v []byte) error {
+			cur, err := f.readFile(tx, k[len(prefix):])
+			if err != nil || cur.Erasure.IsZero() {
+				return err
+			}
+			for i, s := range cur.Chunks {
+				if s.Hash == hash {
+					file, index, ok = cur, i, true
+					return errFound
+				}
+			}
+			return nil
+		})
+	})
+	return file, index, ok
+}
+
+// zeroReader reads zeros, shards are padded with them
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// AddErasure stores the content of r as the shards of e, every shard is an
+// object of its own. Data shards are consecutive parts of the content, the
+// last ones padded with zeros. It returns the hash and the size of the
+// whole content and the shards in order, the whole content is not kept
+func (h *HashStore) AddErasure(r io.Reader, e Erasure) (hash string, size int64, shards []ChunkRef, err error) {
+	code, err := e.Code()
+	if err != nil {
+		return "", 0, nil, NewError(ErrInvalidArgument, "invalid erasure coding %d+%d", e.Data, e.Parity)
+	}
+	// the content is read from the hashstore as parity shards need all of
+	// it, it is released once the shards are stored. It is not compressed
+	// as it is read out of order
+	hash, size, err = h.add(r, "", CodecNone)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	defer h.Remove(hash)
+	f, err := h.Get(hash)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	defer atomic.AddInt32(&f.OpenCount, -1)
+	src, err := h.Open(f)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	defer src.Close()
+	shardSize := (size + int64(e.Data) - 1) / int64(e.Data)
+	if shardSize == 0 {
+		shardSize = 1
+	}
+	defer func() {
+		if err != nil {
+			for _, s := range shards {
+				h.Remove(s.Hash)
+			}
+			shards = nil
+		}
+	}()
+
+	// parity shards are written to temporary files in a single pass over
+	// the content
+	parity := make([]*os.File, e.Parity)
+	for i := range parity {
+		p, err := os.Create(filepath.Join(f.disk.Dir, tmpPrefix+util.RandomString(16)))
+		if err != nil {
+			return "", 0, nil, h.failed(f.disk, err)
+		}
+		defer os.Remove(p.Name())
+		defer p.Close()
+		parity[i] = p
+	}
+	blocks := make([][]byte, e.Shards())
+	buf := make([][]byte, e.Shards())
+	for i := range buf {
+		buf[i] = make([]byte, erasureBlock)
+	}
+	for off := int64(0); off < shardSize; off += erasureBlock {
+		n := shardSize - off
+		if n > erasureBlock {
+			n = erasureBlock
+		}
+		for i := range blocks {
+			blocks[i] = buf[i][:n]
+		}
+		for j := 0; j < e.Data; j++ {
+			m, err := src.ReadAt(blocks[j], int64(j)*shardSize+off)
+			if err != nil && err != io.EOF {
+				return "", 0, nil, err
+			}
+			zeroReader{}.Read(blocks[j][m:])
+		}
+		if err := code.Encode(blocks); err != nil {
+			return "", 0, nil, err
+		}
+		for i, p := range parity {
+			if _, err := p.Write(blocks[e.Data+i]); err != nil {
+				return "", 0, nil, h.failed(f.disk, err)
+			}
+		}
+	}
+
+	for j := 0; j < e.Data; j++ {
+		n := size - int64(j)*shardSize
+		if n < 0 {
+			n = 0
+		} else if n > shardSize {
+			n = shardSize
+		}
+		data := io.MultiReader(io.NewSectionReader(src, int64(j)*shardSize, n), io.LimitReader(zeroReader{}, shardSize-n))
+		s, m, err := h.Add(data)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		shards = append(shards, ChunkRef{Hash: s, Size: uint64(m)})
+	}
+	for _, p := range parity {
+		if _, err := p.Seek(0, io.SeekStart); err != nil {
+			return "", 0, nil, err
+		}
+		s, m, err := h.Add(p)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		shards = append(shards, ChunkRef{Hash: s, Size: uint64(m)})
+	}
+	return hash, size, shards, nil
+}
@@ -0,0 +1,127 @@
+package sdfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestParseErasure(t *testing.T) {
+	if e, err := ParseErasure("6+3"); err != nil || e != (Erasure{Data: 6, Parity: 3}) || e.String() != "6+3" {
+		t.Errorf("want 6+3, have: %+v, %v", e, err)
+	}
+	if e, err := ParseErasure(""); err != nil || !e.IsZero() {
+		t.Errorf("want replication, have: %+v, %v", e, err)
+	}
+	for _, s := range []string{"6", "6+", "+3", "0+3", "6+0", "a+b", "200+100"} {
+		if _, err := ParseErasure(s); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("want %q rejected, have: %v", s, err)
+		}
+	}
+}
+
+func TestHashStoreAddErasure(t *testing.T) {
+	h, err := OpenHashStore([]string{t.TempDir()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 3*erasureBlock+100)
+	rand.New(rand.NewSource(1)).Read(content)
+	e := Erasure{Data: 4, Parity: 2}
+	hash, size, shards, err := h.AddErasure(bytes.NewReader(content), e)
+	if err != nil || size != int64(len(content)) || len(shards) != 6 {
+		t.Fatalf("want 6 shards, have: %v, %d, %v", shards, size, err)
+	}
+	if _, err := h.Get(hash); err == nil {
+		t.Errorf("whole content kept")
+	}
+	read := func(hash string) []byte {
+		f, err := h.Get(hash)
+		if err != nil {
+			t.Fatalf("shard %s not stored: %v", hash, err)
+		}
+		r, _ := h.Open(f)
+		defer r.Close()
+		b, _ := io.ReadAll(r)
+		return b
+	}
+	blocks := make([][]byte, len(shards))
+	var joined []byte
+	for i, s := range shards {
+		blocks[i] = read(s.Hash)
+		if uint64(len(blocks[i])) != s.Size || s.Size != shards[0].Size {
+			t.Errorf("shard %d has %d bytes, want %d", i, len(blocks[i]), shards[0].Size)
+		}
+		if i < e.Data {
+			joined = append(joined, blocks[i]...)
+		}
+	}
+	if !bytes.Equal(joined[:len(content)], content) {
+		t.Errorf("data shards do not join to the content")
+	}
+
+	// the content survives the loss of as many shards as there are parity
+	// shards
+	code, _ := e.Code()
+	lost := append([][]byte(nil), blocks...)
+	lost[0], lost[3] = nil, nil
+	if err := code.Reconstruct(lost); err != nil || !bytes.Equal(lost[0], blocks[0]) || !bytes.Equal(lost[3], blocks[3]) {
+		t.Errorf("shards not rebuilt from parity: %v", err)
+	}
+}
+
+func TestFsErasure(t *testing.T) {
+	store := NewMemStore()
+	fs, err := OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Erasure{Data: 2, Parity: 1}
+	if err := fs.SetErasure("/cold", e); err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := fs.ErasureOf("/cold/new/f"); have != e {
+		t.Errorf("want storage class inherited, have: %+v", have)
+	}
+	if have, _ := fs.ErasureOf("/f"); !have.IsZero() {
+		t.Errorf("want replication outside the tree, have: %+v", have)
+	}
+	if err := fs.SetErasure("/x", Erasure{Data: 1}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want invalid storage class rejected, have: %v", err)
+	}
+
+	shards := []ChunkRef{{Hash: "s0", Size: 5}, {Hash: "s1", Size: 5}, {Hash: "s2", Size: 5}}
+	if _, _, err := fs.WriteErasure("/cold/f", "h", 10, time.Unix(1, 0), shards[:2], e, WriteOptions{}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want missing shards rejected, have: %v", err)
+	}
+	if _, _, err := fs.WriteErasure("/cold/f", "h", 10, time.Unix(1, 0), shards, e, WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range shards {
+		fs.AddHost(s.Hash, int32(i))
+	}
+	if file, i, ok := fs.Shard("s2"); !ok || i != 2 || file.Checksum != "h" {
+		t.Errorf("want s2 the third shard of h, have: %v, %d, %v", file, i, ok)
+	}
+	if _, _, ok := fs.Shard("h"); ok {
+		t.Errorf("want h not a shard")
+	}
+
+	fs, err = OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := fs.ErasureOf("/cold/f"); have != e {
+		t.Errorf("storage class of directory not restored: %+v", have)
+	}
+	info, err := fs.Stat("/cold/f")
+	if err != nil || info.Erasure != "2+1" || info.Chunks != 0 {
+		t.Errorf("erasure-coded file not restored: %+v, %v", info, err)
+	}
+	if _, physical, _ := fs.Roots[0].Usage(); physical != 15 {
+		t.Errorf("want 15 physical bytes, have: %d", physical)
+	}
+}
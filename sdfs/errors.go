@@ -0,0 +1,44 @@
+package sdfs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error kinds of SDFS, errors returned by the FS and the hashstore match one
+// of them with errors.Is, so callers(e.g. the router) can tell why an
+// operation failed without parsing messages
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrAlreadyExists   = errors.New("already exists")
+	ErrConflict        = errors.New("conflict")
+	ErrBusy            = errors.New("busy")
+	ErrNoCapacity      = errors.New("no capacity")
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrPreconditionFailed is returned by conditional writes whose condition
+	// does not hold
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrChecksumMismatch is returned when content does not match the hash
+	// it is expected to have
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// Error is an error of a specific kind with a message for humans
+type Error struct {
+	Kind error
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// NewError returns an error of the given kind, format and a are used to
+// build its message
+func NewError(kind error, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, a...)}
+}
@@ -2,49 +2,52 @@ package sdfs
 
 import (
 	"sync"
+	"time"
 )
 
 // File represents a file in the local SDFS namespace
 // Note that multiple objects in the SDFS namespace could point to single
 // File object if they have the same checksum, in this case semaphores
-// are needed
+// are needed. The paths of a file are kept in the store, see FS.Paths
 type File struct {
-	Checksum string
-	// FSPath contains logical position of the file
-	FSPath           []Location
+	Checksum         string
 	SemaphoreOpen    uint32
 	SemaphoreReplica uint32
 	Size             uint64
+	// Pins counts the snapshots, prior versions and trash entries that
+	// reference the file, a pinned file is kept in the FS after its last path
+	// is deleted
+	Pins uint32
+	// ModTime is the time the content was first added to the FS
+	ModTime time.Time
 
 	// Host contains hosts that has this file in their hashstores
 	Host []int32
+	// Chunks is the manifest of a chunked file, its content is the content
+	// of the chunks in order and Host is empty as chunks have hosts of
+	// their own. It is nil for files stored whole and never changes
+	Chunks []*Chunk
+	// Erasure is the storage class of an erasure-coded file, its Chunks
+	// are then its shards
+	Erasure Erasure
 
-	mu sync.Mutex
+	// holds counts what refers to the file in memory, it is in ChecksumDB
+	// while there is anything
+	holds int
+	mu    sync.Mutex
 }
 
-type Location struct {
-	Parent   *Directory
-	FileName string
-}
-
-// Paths returns a slice that contains every path the file corresponds
-func (f *File) Paths() (paths []string) {
-	for _, fsp := range f.FSPath {
-		paths = append(paths, fsp.Parent.FullPath+fsp.FileName)
-	}
-	return
-}
+const (
+	MaxXattrKeyLength   = 255
+	MaxXattrValueLength = 4096
+	MaxXattrCount       = 64
+)
 
-// NewFile creates a new file with given parameters
-func NewFile(name, checksum string, fileSize uint64, parent *Directory) *File {
+// NewFile creates a new file with given parameters, it has no paths until
+// it is added to a directory
+func NewFile(checksum string, fileSize uint64) *File {
 	f := new(File)
 	f.Checksum = checksum
-	f.FSPath = append(f.FSPath, Location{
-		Parent:   parent,
-		FileName: name,
-	})
-	f.SemaphoreOpen = 0
-	f.SemaphoreReplica = 1
 	f.Size = fileSize
 	return f
 }
@@ -56,3 +59,43 @@ func (f *File) Lock() {
 func (f *File) Unlock() {
 	f.mu.Unlock()
 }
+
+// ValidateXattr checks if key and value can be used as an extended attribute,
+// keys are limited to letters, digits, '.', '-' and '_'
+func ValidateXattr(key, value string) error {
+	if key == "" || len(key) > MaxXattrKeyLength {
+		return NewError(ErrInvalidArgument, "invalid xattr key length")
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return NewError(ErrInvalidArgument, "invalid character in xattr key")
+		}
+	}
+	if len(value) > MaxXattrValueLength {
+		return NewError(ErrInvalidArgument, "xattr value too long")
+	}
+	return nil
+}
+
+// addXattrs validates attrs and sets them in m
+func addXattrs(m, attrs map[string]string) error {
+	for k, v := range attrs {
+		if err := ValidateXattr(k, v); err != nil {
+			return err
+		}
+		if _, ok := m[k]; !ok && len(m) >= MaxXattrCount {
+			return NewError(ErrInvalidArgument, "too many xattrs")
+		}
+		m[k] = v
+	}
+	return nil
+}
+
+// copyXattrs returns a copy of m that can be changed
+func copyXattrs(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
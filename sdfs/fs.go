@@ -2,31 +2,106 @@ package sdfs
 
 import (
 	"bytes"
+	"container/list"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lyianu/sdfs/pkg/settings"
+	"github.com/Lyianu/sdfs/pkg/util"
 )
 
 // FS represents local part of SDFS, it could contain multiple namespaces
-// defined by Roots, but for now, we use only one(/)
+// defined by Roots, Roots[0] is the root of the default namespace
+//
+// The store is the source of truth of the FS, only the tree of directories
+// is kept in memory as a whole. The entries of a directory are read from the
+// store when it is used and dropped again when too many entries of other
+// directories were used since, ChecksumDB and ChunkDB cache the files and
+// chunks that entries in memory refer to
+//
+// Every directory is guarded by its own RW lock, lookups hold at most one
+// directory lock at a time and take them in path order, so readers of
+// different paths never wait on each other. mu guards ChecksumDB, ChunkDB,
+// the counts and hosts of files and the index of directories, writes of them
+// to the store are made with it held. It is always taken after the lock of a
+// directory, never before
 type FS struct {
 	Roots      []*Directory
+	Namespaces map[string]*Namespace
 	ChecksumDB map[string]*File // Checksum => File
+	// ChunkDB holds the chunks of the files in ChecksumDB, it is guarded by
+	// mu too
+	ChunkDB map[string]*Chunk // Checksum => Chunk
+
+	// Snapshots maps "<dir>@<name>" to snapshots of dir
+	Snapshots map[string]*Snapshot
+	// Trash maps "<namespace>/<id>" to deleted files kept in the trash
+	Trash map[string]*TrashEntry
+
+	// store keeps the metadata, every change is written to it before it is
+	// made in memory
+	store MetaStore
+	// cache orders the directories whose entries are in memory
+	cache *dirCache
+	// dirs maps the paths of all directories to them
+	dirs map[string]*Directory
+	// nodes maps the ids of nodes to their addresses
+	nodes map[int32]string
 
-	mu sync.Mutex
+	mu     sync.Mutex
+	nsMu   sync.RWMutex
+	snapMu sync.RWMutex
+	// trashMu guards Trash, it is taken before any other lock
+	trashMu sync.RWMutex
 }
 
 type Directory struct {
-	Name     string
-	SubDirs  map[string]*Directory
-	Files    map[string]*File
+	Name    string
+	SubDirs map[string]*Directory
+	// Files, Symlinks, Versions and Xattrs are the entries of the directory,
+	// they are only in memory while loaded is set, which FS.lock and
+	// FS.rlock make sure of
+	Files map[string]*File
+	// Symlinks maps link names to their targets
+	Symlinks map[string]string
 	FullPath string
 	Parent   *Directory
-	Size     uint64
+	// Size, PhysicalSize and FileCount add up the files in the tree of the
+	// directory, they are updated atomically and should be read with
+	// TotalSize and Usage. PhysicalSize counts every replica on nodes
+	Size         uint64
+	PhysicalSize uint64
+	FileCount    uint64
+	// Quota limits the usage of the tree of the directory
+	Quota DirQuota
+	// Versioning is the number of prior versions kept for files written in
+	// the tree of the directory, 0 inherits the setting of the parent
+	Versioning int
+	// Erasure is the storage class of files uploaded to the tree of the
+	// directory, the zero value inherits the setting of the parent
+	Erasure Erasure
+	// Versions maps names of files written with versioning to their
+	// versions, oldest first, the last one is the current file
+	Versions map[string][]*Version
+	// Xattrs maps names of files to their extended attributes, attributes
+	// belong to the path, not to the content it shares with other paths.
+	// The maps are replaced, never changed, once they are set
+	Xattrs map[string]map[string]string
 
-	mu sync.Mutex
+	loaded bool
+	// elem and cached are the place of the directory in the cache of the FS
+	// and the entries it counts for there, they are guarded by the cache
+	elem   *list.Element
+	cached int
+
+	mu sync.RWMutex
 }
 
 var Fs *FS
@@ -35,265 +110,906 @@ func init() {
 	Fs = NewFS()
 }
 
+// NewDirectory returns a directory whose entries are loaded when it is used
 func NewDirectory(name, fullPath string) *Directory {
 	d := new(Directory)
-	d.Files = make(map[string]*File)
 	d.FullPath = fullPath
 	d.Name = name
 	d.SubDirs = make(map[string]*Directory)
 	return d
 }
 
+// NewFS returns an empty FS that keeps its metadata in memory
 func NewFS() *FS {
+	return newFS(NewMemStore())
+}
+
+func newFS(store MetaStore) *FS {
+	root := NewDirectory("/", "/")
 	f := &FS{
-		Roots:      []*Directory{NewDirectory("/", "/")},
+		Roots: []*Directory{root},
+		Namespaces: map[string]*Namespace{
+			DefaultNamespace: {
+				Name:         DefaultNamespace,
+				Root:         root,
+				ReplicaCount: settings.DefaultReplicaCount,
+			},
+		},
 		ChecksumDB: make(map[string]*File),
+		ChunkDB:    make(map[string]*Chunk),
+		Snapshots:  make(map[string]*Snapshot),
+		Trash:      make(map[string]*TrashEntry),
+		store:      store,
+		cache:      newDirCache(settings.MetaCacheEntries),
+		dirs:       map[string]*Directory{root.FullPath: root},
+		nodes:      make(map[int32]string),
 	}
 	return f
 }
 
+// addUsage adds the deltas to the usage of d and all its parents
+func (d *Directory) addUsage(size, physical, files int64) {
+	for ; d != nil; d = d.Parent {
+		atomic.AddUint64(&d.Size, uint64(size))
+		atomic.AddUint64(&d.PhysicalSize, uint64(physical))
+		atomic.AddUint64(&d.FileCount, uint64(files))
+	}
+}
+
+// addFile accounts a path of file to d, f.mu should be held by the caller
+func (d *Directory) addFile(file *File) {
+	d.addUsage(int64(file.Size), file.physical(), 1)
+}
+
+// removeFile removes a path of file from the usage of d, f.mu should be held
+// by the caller
+func (d *Directory) removeFile(file *File) {
+	d.addUsage(-int64(file.Size), -file.physical(), -1)
+}
+
+// TotalSize returns the size of all files in the tree of d
+func (d *Directory) TotalSize() uint64 {
+	return atomic.LoadUint64(&d.Size)
+}
+
+// GetFileParent returns the directory that contains the file at filePath
 func (f *FS) GetFileParent(filePath string) (*Directory, error) {
-	return f.GetDir(filePath[:strings.LastIndex(filePath, "/")])
+	p, err := ParsePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return f.getDir(p.Dir())
 }
 
+// ParseFileName returns the name of the file at filePath
 func ParseFileName(filePath string) string {
-	return filePath[strings.LastIndex(filePath, "/")+1:]
+	p, err := ParsePath(filePath)
+	if err != nil {
+		return filePath[strings.LastIndex(filePath, "/")+1:]
+	}
+	return p.Base()
 }
 
 // AddFile adds file to the FS, it first stores the data in the local disk
 // after that it adds an entry to the FS's db
 func (f *FS) AddFile(path string, hash string) (*File, error) {
-	f.AddDir(path[:strings.LastIndex(path, "/")])
-	dir, _ := f.GetFileParent(path)
-	fname := ParseFileName(path)
+	return f.AddSizedFile(path, hash, 0)
+}
+
+// AddSizedFile works like AddFile, size is used when the checksum is new to
+// the FS and is accounted to every directory on the path
+func (f *FS) AddSizedFile(path string, hash string, size uint64) (*File, error) {
+	return f.AddFileAt(path, hash, size, time.Now())
+}
+
+// AddFileAt works like AddSizedFile, mtime is used as the modification time
+// when the checksum is new to the FS
+func (f *FS) AddFileAt(path string, hash string, size uint64, mtime time.Time) (*File, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if p.IsRoot() {
+		return nil, &PathError{path, "no file name"}
+	}
+	path = p.String()
+	dir, err := f.addDir(p.Dir())
+	if err != nil {
+		return nil, err
+	}
+	fname := p.Base()
 
+	// the directory stays locked until the file is in place, so concurrent
+	// adds of the same path see each other
+	if err := f.lock(dir); err != nil {
+		return nil, err
+	}
+	defer f.unlock(dir)
 	// first check if there is a different file at the given path
-	if file, err := f.GetFile(path); err == nil {
+	if file, ok := dir.Files[fname]; ok {
 		if file.Checksum != hash {
-			return nil, fmt.Errorf("a file with different checksum exists at %s", path)
+			return nil, NewError(ErrConflict, "a file with different checksum exists at %s", path)
 		}
+		return file, nil
+	}
+	_, isDir := dir.SubDirs[fname]
+	_, isLink := dir.Symlinks[fname]
+	if isDir || isLink {
+		return nil, NewError(ErrConflict, "a directory or symlink exists at %s", path)
 	}
 	f.mu.Lock()
+	defer f.mu.Unlock()
 	// if there is not, check if there is a same file in the SDFS namespace,
-	// but with a different path
-	if file, ok := f.ChecksumDB[hash]; ok {
-		// same file has been added to the SDFS namespace, but the path might
-		// be different
-		fp := file.Paths()
-		for _, p := range fp {
-			// if already exists
-			if p == path {
-				f.mu.Unlock()
-				return file, nil
-			}
+	// but with a different path, if so create a replica at the given path
+	var file *File
+	r := refs{}
+	r.add(hash, 1, 0)
+	err = f.update(func(tx MetaTx) error {
+		var err error
+		file, err = f.readFile(tx, hash)
+		if errors.Is(err, ErrNotFound) {
+			// no file with the same checksum exists, create a new one
+			file, err = f.newFile(tx, hash, size, mtime, nil, Erasure{})
 		}
-		// if file exists at another path, create a replica at the given path
-		file.FSPath = append(file.FSPath, Location{
-			Parent:   dir,
-			FileName: fname,
-		})
-		dir.Files[fname] = file
-		file.SemaphoreReplica++
-		//file.mu.Unlock()
-		f.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if err := putLink(tx, path, hash); err != nil {
+			return err
+		}
+		_, err = f.putRefs(tx, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	file = f.linkFile(dir, fname, file)
+	f.applyRefs(r)
+	return file, nil
+}
+
+func (f *FS) MustAddFile(path string, data []byte) {
+	if _, err := f.AddSizedFile(path, util.RandomString(16), uint64(len(data))); err != nil {
+		panic(err)
+	}
+}
 
+// GetFile gets file from the local disk which has the given path in SDFS
+// namespace, it returns a file only when error is nil, symlinks in the path
+// are followed
+func (f *FS) GetFile(path string) (*File, error) {
+	dir, name, err := f.lookupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.rlock(dir); err != nil {
+		return nil, err
+	}
+	defer dir.mu.RUnlock()
+	if file, ok := dir.Files[name]; !ok {
+		return nil, NewError(ErrNotFound, "file not exist")
+	} else {
 		return file, nil
 	}
+}
+
+// AddDir creates the directory at the given path and all its parents
+func (f *FS) AddDir(path string) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	_, err = f.addDir(p)
+	return err
+}
+
+// addDir creates the directory at p and all its parents and returns it
+func (f *FS) addDir(p Path) (*Directory, error) {
+	dir, err := f.rootDir(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range p.Parts {
+		// most directories on the path exist already, only take the write
+		// lock when one has to be created
+		dir.mu.RLock()
+		sub, ok := dir.SubDirs[part]
+		dir.mu.RUnlock()
+		if !ok {
+			if sub, err = f.subDir(dir, part); err != nil {
+				return nil, err
+			}
+		}
+		dir = sub
+	}
+	return dir, nil
+}
+
+// subDir returns the subdirectory of d with the given name, creating it if
+// it does not exist
+func (f *FS) subDir(d *Directory, name string) (*Directory, error) {
+	if err := f.lock(d); err != nil {
+		return nil, err
+	}
+	defer f.unlock(d)
+	if sub, ok := d.SubDirs[name]; ok {
+		return sub, nil
+	}
+	if _, ok := d.Symlinks[name]; ok {
+		return nil, NewError(ErrConflict, "path contains a symlink")
+	}
+	if _, ok := d.Files[name]; ok {
+		return nil, NewError(ErrConflict, "path contains a file")
+	}
+	sub := NewDirectory(name, d.FullPath+name+"/")
+	err := f.update(func(tx MetaTx) error {
+		return tx.Put(bucketDirs, sub.FullPath, []byte{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sub.Parent = d
+	d.SubDirs[name] = sub
+	f.mu.Lock()
+	f.dirs[sub.FullPath] = sub
 	f.mu.Unlock()
-	// no file with the same checksum exists, create a new one
-	file := NewFile(fname, hash, 0, dir)
-	dir.Files[fname] = file
-	f.ChecksumDB[hash] = file
+	return sub, nil
+}
 
-	for dir != f.Roots[0] {
-		dir.Size += file.Size
-		dir = dir.Parent
+// GetDir gets the directory at the given path, symlinks in the path are
+// followed
+func (f *FS) GetDir(path string) (*Directory, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
 	}
-	dir.Size += file.Size
+	return f.getDir(p)
+}
 
-	return file, nil
+func (f *FS) getDir(p Path) (*Directory, error) {
+	root, err := f.rootDir(p)
+	if err != nil {
+		return nil, err
+	}
+	hops := 0
+	return f.walk(root, p.Parts, &hops)
 }
 
-func (f *FS) MustAddFile(path string, data []byte) {
-	parts := strings.Split(path, "/")
-	blankCount := 0
-	dir := f.Roots[0]
-	currentPath := "/"
-	for i, part := range parts {
-		if part == "" {
-			blankCount++
-			continue
+// DeleteFile deletes file of a given path, it remove the file logically
+// in SDFS namespace but might not delete the actual physical file stored
+// on the disk, if the path is a symlink, only the link is removed
+func (f *FS) DeleteFile(path string) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	dir, fn, err := f.lookup(p, false)
+	if err != nil {
+		return NewError(ErrNotFound, "file not exist")
+	}
+	if err := f.lock(dir); err != nil {
+		return err
+	}
+	defer f.unlock(dir)
+	if _, ok := dir.Symlinks[fn]; ok {
+		err := f.update(func(tx MetaTx) error {
+			return tx.Delete(bucketSymlinks, dir.FullPath+fn)
+		})
+		if err != nil {
+			return err
+		}
+		delete(dir.Symlinks, fn)
+		return nil
+	}
+	file, ok := dir.Files[fn]
+	if !ok {
+		return NewError(ErrNotFound, "file not exist")
+	}
+	if len(dir.Versions[fn]) > 1 {
+		return NewError(ErrConflict, "file has prior versions")
+	}
+	// check if any other subroutine is using the file
+	s := file.mu.TryLock()
+	if s {
+		defer file.mu.Unlock()
+	} else {
+		return NewError(ErrBusy, "File not available")
+	}
+	// if no other path or snapshot references the file, its record is
+	// deleted with the path
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := refs{}
+	r.add(file.Checksum, -1, 0)
+	err = f.update(func(tx MetaTx) error {
+		// TODO: notify node to delete the file if it is orphaned
+		if _, err := f.putRefs(tx, r); err != nil {
+			return err
 		}
-		dir.mu.Lock()
-		dir.Size += uint64(len(data))
-		currentPath += part
-		if i == len(parts)-1 {
-			break
+		if err := tx.Delete(bucketVersions, dir.FullPath+fn); err != nil {
+			return err
 		}
-		currentPath += "/"
-		var ok bool
-		_, ok = dir.SubDirs[part]
-		if !ok {
-			dir.SubDirs[part] = NewDirectory(part, currentPath)
-			dir.SubDirs[part].Parent = dir
+		if err := tx.Delete(bucketXattrs, dir.FullPath+fn); err != nil {
+			return err
+		}
+		return deleteLink(tx, dir.FullPath+fn, file.Checksum)
+	})
+	if err != nil {
+		return err
+	}
+	f.applyRefs(r)
+	f.setVersions(dir, fn, nil)
+	delete(dir.Xattrs, fn)
+	f.unlink(dir, fn, file)
+	return nil
+}
+
+// linkFile makes file the file at fn in dir once the path is written to the
+// store, it returns the cached file with the checksum of file. dir.mu and
+// f.mu should be held by the caller
+func (f *FS) linkFile(dir *Directory, fn string, file *File) *File {
+	file = f.hold(file)
+	dir.Files[fn] = file
+	dir.addFile(file)
+	return file
+}
+
+// unlink removes the path fn in dir of file once it is deleted from the
+// store, dir.mu and f.mu should be held by the caller
+func (f *FS) unlink(dir *Directory, fn string, file *File) {
+	delete(dir.Files, fn)
+	dir.removeFile(file)
+	f.release(file)
+}
+
+// setVersions makes versions the versions of the file name in d, their files
+// are held instead of the ones of the versions they replace. d.mu and f.mu
+// should be held by the caller
+func (f *FS) setVersions(d *Directory, name string, versions []*Version) {
+	for _, v := range versions {
+		v.File = f.hold(v.File)
+	}
+	for _, v := range d.Versions[name] {
+		f.release(v.File)
+	}
+	if len(versions) == 0 {
+		delete(d.Versions, name)
+		return
+	}
+	d.Versions[name] = versions
+}
+
+// lockPair write-locks the loaded directories a and b in path order, it
+// returns the function that unlocks them
+func (f *FS) lockPair(a, b *Directory) (func(), error) {
+	if a == b {
+		if err := f.lock(a); err != nil {
+			return nil, err
 		}
-		dir.mu.Unlock()
-		dir = dir.SubDirs[part]
+		return func() { f.unlock(a) }, nil
+	}
+	if b.FullPath < a.FullPath {
+		a, b = b, a
+	}
+	if err := f.lock(a); err != nil {
+		return nil, err
 	}
+	if err := f.lock(b); err != nil {
+		f.unlock(a)
+		return nil, err
+	}
+	return func() {
+		f.unlock(b)
+		f.unlock(a)
+	}, nil
 }
 
-// GetFile gets file from the local disk which has the given path in SDFS
-// namespace, it returns a file only when error is nil
-func (f *FS) GetFile(path string) (*File, error) {
+// Rename moves the file or symlink at src to dst, dst should not exist and
+// both should be in the same namespace, directories can not be renamed
+func (f *FS) Rename(src, dst string) error {
+	sp, err := ParsePath(src)
+	if err != nil {
+		return err
+	}
+	dp, err := ParsePath(dst)
+	if err != nil {
+		return err
+	}
+	if dp.IsRoot() {
+		return &PathError{dst, "no file name"}
+	}
+	if sp.Namespace != dp.Namespace {
+		return NewError(ErrInvalidArgument, "can not rename across namespaces")
+	}
+	sdir, sname, err := f.lookup(sp, false)
+	if err != nil {
+		return NewError(ErrNotFound, "file not exist")
+	}
+	ddir, err := f.addDir(dp.Dir())
+	if err != nil {
+		return err
+	}
+	dname := dp.Base()
+	// a file moved to another directory is checked against the quotas it
+	// moves into first, symlinks are not counted
+	if sdir != ddir {
+		if err := f.rlock(sdir); err != nil {
+			return err
+		}
+		file := sdir.Files[sname]
+		sdir.mu.RUnlock()
+		if file != nil {
+			f.mu.Lock()
+			physical := uint64(file.physical())
+			f.mu.Unlock()
+			if err := f.checkMoveQuota(dp, sdir, file.Size, physical, 1); err != nil {
+				return err
+			}
+		}
+	}
+	// with two directories involved, locks are taken in path order
+	unlock, err := f.lockPair(sdir, ddir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	file, isFile := sdir.Files[sname]
+	target, isLink := sdir.Symlinks[sname]
+	if !isFile && !isLink {
+		if _, ok := sdir.SubDirs[sname]; ok {
+			return NewError(ErrInvalidArgument, "directories can not be renamed")
+		}
+		return NewError(ErrNotFound, "file not exist")
+	}
+	if ddir.exists(dname) {
+		return NewError(ErrAlreadyExists, "file exists")
+	}
+	spath, dpath := sdir.FullPath+sname, ddir.FullPath+dname
+	// the versions and extended attributes of a file move with it
+	versions := sdir.Versions[sname]
+	xattrs := sdir.Xattrs[sname]
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	parts := strings.Split(path, "/")
-	blankCount := 0
-	dir := f.Roots[0]
-	for i, part := range parts {
-		if part == "" {
-			blankCount++
-			continue
+	err = f.update(func(tx MetaTx) error {
+		if len(xattrs) != 0 {
+			if err := tx.Delete(bucketXattrs, spath); err != nil {
+				return err
+			}
+			if err := putXattrs(tx, dpath, xattrs); err != nil {
+				return err
+			}
 		}
-		if i == len(parts)-1 {
-			break
+		if len(versions) != 0 {
+			if err := tx.Delete(bucketVersions, spath); err != nil {
+				return err
+			}
+			if err := putVersions(tx, dpath, versions); err != nil {
+				return err
+			}
 		}
-		dir.mu.Lock()
-		var ok bool
-		_, ok = dir.SubDirs[part]
-		if !ok {
-			dir.mu.Unlock()
-			return nil, errors.New("file not exist")
+		if isLink {
+			if err := tx.Delete(bucketSymlinks, spath); err != nil {
+				return err
+			}
+			return tx.Put(bucketSymlinks, dpath, []byte(target))
 		}
-		dir.mu.Unlock()
-		dir = dir.SubDirs[part]
+		if err := deleteLink(tx, spath, file.Checksum); err != nil {
+			return err
+		}
+		return putLink(tx, dpath, file.Checksum)
+	})
+	if err != nil {
+		return err
 	}
-	if file, ok := dir.Files[ParseFileName(path)]; !ok {
-		return nil, errors.New("file not exist")
-	} else {
-		return file, nil
+	// the entries change directories, what they hold stays the same
+	if len(versions) != 0 {
+		delete(sdir.Versions, sname)
+		ddir.Versions[dname] = versions
+	}
+	if len(xattrs) != 0 {
+		delete(sdir.Xattrs, sname)
+		ddir.Xattrs[dname] = xattrs
+	}
+	if isLink {
+		delete(sdir.Symlinks, sname)
+		ddir.Symlinks[dname] = target
+		return nil
 	}
+	delete(sdir.Files, sname)
+	ddir.Files[dname] = file
+	sdir.removeFile(file)
+	ddir.addFile(file)
+	return nil
 }
 
-func (f *FS) AddDir(path string) error {
+// IsShared reports whether deleting a path of file would leave the content
+// referenced by other paths or snapshots
+func (f *FS) IsShared(file *File) bool {
+	var r fileRecord
+	err := f.view(func(tx MetaTx) error {
+		var err error
+		r, err = getFileRecord(tx, file.Checksum)
+		return err
+	})
+	return err == nil && (r.Links > 1 || r.Pins > 0)
+}
 
-	parts := strings.Split(path, "/")
-	blankCount := 0
-	dir := f.Roots[0]
-	currentPath := "/"
-	for i, part := range parts {
-		if part == "" {
-			blankCount++
-			continue
+// readObject reads the file and the chunk with the given checksum, either
+// is nil if there is none. f.mu should be held by the caller
+func (f *FS) readObject(hash string) (file *File, c *Chunk, err error) {
+	err = f.view(func(tx MetaTx) error {
+		var err error
+		if file, err = f.readFile(tx, hash); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
 		}
-		dir.mu.Lock()
-		currentPath += part
-		if i == len(parts) {
-			break
+		if c, err = f.readChunk(tx, hash); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
 		}
-		currentPath += "/"
-		var ok bool
-		_, ok = dir.SubDirs[part]
-		if !ok {
-			dir.SubDirs[part] = NewDirectory(part, currentPath)
-			dir.SubDirs[part].Parent = dir
+		return nil
+	})
+	if err == nil && file == nil && c == nil {
+		err = NewError(ErrNotFound, "file not exist")
+	}
+	return file, c, err
+}
+
+// putFileHosts writes hosts as the hosts of file, which is stored whole, it
+// returns the directories of its paths. f.mu should be held by the caller
+func (f *FS) putFileHosts(file *File, hosts []int32) ([]*Directory, error) {
+	var dirs []*Directory
+	err := f.update(func(tx MetaTx) error {
+		r, err := getFileRecord(tx, file.Checksum)
+		if err != nil {
+			return err
+		}
+		r.Host = hosts
+		if err := putJSON(tx, bucketChecksums, file.Checksum, r); err != nil {
+			return err
+		}
+		dirs, err = f.linkDirs(tx, file.Checksum)
+		return err
+	})
+	return dirs, err
+}
+
+// AddHost records that the node host holds the file or chunk with the given
+// checksum, a host of a chunked file holds all its chunks
+func (f *FS) AddHost(hash string, host int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, c, err := f.readObject(hash)
+	if err != nil {
+		return err
+	}
+	// content stored whole may also be a chunk of other files, both share
+	// the object on nodes
+	if c != nil {
+		if err := f.addChunkHost(c, host); err != nil {
+			return err
+		}
+	}
+	if file == nil {
+		return nil
+	}
+	if file.Chunks != nil {
+		for _, c := range file.Chunks {
+			if err := f.addChunkHost(c, host); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, h := range file.Host {
+		if h == host {
+			return nil
 		}
-		dir.mu.Unlock()
-		dir = dir.SubDirs[part]
+	}
+	hosts := append(file.Host[:len(file.Host):len(file.Host)], host)
+	dirs, err := f.putFileHosts(file, hosts)
+	if err != nil {
+		return err
+	}
+	file.Host = hosts
+	for _, d := range dirs {
+		d.addUsage(0, int64(file.Size), 0)
 	}
 	return nil
 }
 
-func (f *FS) GetDir(path string) (*Directory, error) {
+// RemoveHost records that the node host no longer holds the file or chunk
+// with the given checksum, it returns the nodes that still hold it
+func (f *FS) RemoveHost(hash string, host int32) ([]int32, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	parts := strings.Split(path, "/")
-	blankCount := 0
-	dir := f.Roots[0]
-	for _, part := range parts {
-		if part == "" {
-			blankCount++
-			continue
+	file, c, err := f.readObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if file != nil && file.Chunks != nil {
+		return nil, NewError(ErrInvalidArgument, "%s is chunked, its chunks have hosts", hash)
+	}
+	var hosts []int32
+	if c != nil {
+		if hosts, err = f.removeChunkHost(c, host); err != nil {
+			return nil, err
 		}
-		dir.mu.Lock()
-		var ok bool
-		_, ok = dir.SubDirs[part]
-		if !ok {
-			return nil, errors.New("directory not exist")
+	}
+	if file == nil {
+		return hosts, nil
+	}
+	hosts = make([]int32, 0, len(file.Host))
+	for _, h := range file.Host {
+		if h != host {
+			hosts = append(hosts, h)
 		}
-		dir.mu.Unlock()
-		dir = dir.SubDirs[part]
 	}
-	return dir, nil
+	if len(hosts) == len(file.Host) {
+		return hosts, nil
+	}
+	dirs, err := f.putFileHosts(file, hosts)
+	if err != nil {
+		return nil, err
+	}
+	file.Host = hosts
+	for _, d := range dirs {
+		d.addUsage(0, -int64(file.Size), 0)
+	}
+	return hosts, nil
 }
 
-// DeleteFile deletes file of a given path, it remove the file logically
-// in SDFS namespace but might not delete the actual physical file stored
-// on the disk
-func (f *FS) DeleteFile(path string) error {
-	file, err := f.GetFile(path)
+// AddNode records that the node with the given id is at addr, ids of nodes
+// are kept so that the hosts of files still name the same nodes after the
+// master restarts
+func (f *FS) AddNode(id int32, addr string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.nodes[id] == addr {
+		return nil
+	}
+	err := f.update(func(tx MetaTx) error {
+		return tx.Put(bucketNodes, strconv.Itoa(int(id)), []byte(addr))
+	})
 	if err != nil {
 		return err
 	}
-	// check if any other subroutine is using the file
-	s := file.mu.TryLock()
-	if s {
-		defer file.mu.Unlock()
-	} else {
-		return fmt.Errorf("File not available")
+	f.nodes[id] = addr
+	return nil
+}
+
+// NodeID returns the id recorded for the node at addr
+func (f *FS) NodeID(addr string) (int32, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for id, a := range f.nodes {
+		if a == addr {
+			return id, true
+		}
 	}
-	// if there is no other replicas, delete the file locally & logically
-	fn := ParseFileName(path)
+	return 0, false
+}
 
-	dir, _ := f.GetDir(path[:len(path)-len(fn)-1])
+// Nodes returns the ids of the nodes recorded with AddNode and their
+// addresses
+func (f *FS) Nodes() map[int32]string {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	if file.SemaphoreReplica == 1 {
-		// TODO: notify node to delete the file
-		// err := os.Remove(settings.DataPathPrefix + file.LocalPath)
-		delete(dir.Files, fn)
-		delete(f.ChecksumDB, file.Checksum)
-		return err
+	nodes := make(map[int32]string, len(f.nodes))
+	for id, addr := range f.nodes {
+		nodes[id] = addr
 	}
-	// if there is a replica, delete the file logically and reduce SemaphoreReplica
-	delete(dir.Files, fn)
-	for k, p := range file.Paths() {
-		if p == path {
-			file.FSPath = append(file.FSPath[:k], file.FSPath[k+1:]...)
-			break
+	return nodes
+}
+
+// FileInfo describes an entry in the SDFS namespace, it is what stat and
+// listing requests return
+type FileInfo struct {
+	Name     string            `json:"name"`
+	Path     string            `json:"path"`
+	IsDir    bool              `json:"is_dir"`
+	Checksum string            `json:"checksum,omitempty"`
+	Size     uint64            `json:"size"`
+	ModTime  time.Time         `json:"mtime"`
+	Xattrs   map[string]string `json:"xattrs,omitempty"`
+	// Chunks is the number of chunks of a chunked file
+	Chunks int `json:"chunks,omitempty"`
+	// Erasure is the storage class of an erasure-coded file, e.g. 6+3
+	Erasure string `json:"erasure,omitempty"`
+	// Target is set only when the entry is a symlink
+	Target string `json:"target,omitempty"`
+}
+
+// fileInfo returns the info of file at path, xattrs are the extended
+// attributes of the path
+func fileInfo(name, path string, file *File, xattrs map[string]string) FileInfo {
+	info := FileInfo{
+		Name:     name,
+		Path:     path,
+		Checksum: file.Checksum,
+		Size:     file.Size,
+		ModTime:  file.ModTime,
+	}
+	if len(xattrs) != 0 {
+		info.Xattrs = copyXattrs(xattrs)
+	}
+	if file.Erasure.IsZero() {
+		info.Chunks = len(file.Chunks)
+	} else {
+		info.Erasure = file.Erasure.String()
+	}
+	return info
+}
+
+func dirInfo(dir *Directory) FileInfo {
+	return FileInfo{
+		Name:  dir.Name,
+		Path:  dir.FullPath,
+		IsDir: true,
+		Size:  dir.TotalSize(),
+	}
+}
+
+// Stat returns the info of the file or directory at the given path
+func (f *FS) Stat(path string) (FileInfo, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if dir, name, err := f.lookupFile(path); err == nil {
+		if err := f.rlock(dir); err != nil {
+			return FileInfo{}, err
+		}
+		file, ok := dir.Files[name]
+		xattrs := dir.Xattrs[name]
+		dir.mu.RUnlock()
+		if ok {
+			return fileInfo(p.Base(), p.String(), file, xattrs), nil
+		}
+	}
+	dir, err := f.GetDir(path)
+	if err != nil {
+		return FileInfo{}, NewError(ErrNotFound, "file not exist")
+	}
+	return dirInfo(dir), nil
+}
+
+// List returns the infos of the entries directly under the given directory
+func (f *FS) List(path string) ([]FileInfo, error) {
+	dir, err := f.GetDir(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.rlock(dir); err != nil {
+		return nil, err
+	}
+	defer dir.mu.RUnlock()
+	infos := make([]FileInfo, 0, len(dir.SubDirs)+len(dir.Files)+len(dir.Symlinks))
+	for _, d := range dir.SubDirs {
+		infos = append(infos, dirInfo(d))
+	}
+	for name, file := range dir.Files {
+		infos = append(infos, fileInfo(name, dir.FullPath+name, file, dir.Xattrs[name]))
+	}
+	for name, target := range dir.Symlinks {
+		infos = append(infos, FileInfo{Name: name, Path: dir.FullPath + name, Target: target})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos, nil
+}
+
+// SetXattr sets an extended attribute on the file at the given path
+func (f *FS) SetXattr(path, key, value string) error {
+	return f.updateXattrs(path, func(m map[string]string) error {
+		return addXattrs(m, map[string]string{key: value})
+	})
+}
+
+// RemoveXattr removes an extended attribute from the file at the given path
+func (f *FS) RemoveXattr(path, key string) error {
+	return f.updateXattrs(path, func(m map[string]string) error {
+		if _, ok := m[key]; !ok {
+			return NewError(ErrNotFound, "xattr not exist")
 		}
+		delete(m, key)
+		return nil
+	})
+}
+
+// updateXattrs applies fn to a copy of the extended attributes of the file
+// at path, the copy replaces them once it is written to the store
+func (f *FS) updateXattrs(path string, fn func(m map[string]string) error) error {
+	dir, name, err := f.lookupFile(path)
+	if err != nil {
+		return err
 	}
-	for dir != f.Roots[0] {
-		dir.Size -= file.Size
-		dir = dir.Parent
+	if err := f.lock(dir); err != nil {
+		return err
+	}
+	defer f.unlock(dir)
+	if _, ok := dir.Files[name]; !ok {
+		return NewError(ErrNotFound, "file not exist")
 	}
-	file.SemaphoreReplica--
+	m := copyXattrs(dir.Xattrs[name])
+	if err := fn(m); err != nil {
+		return err
+	}
+	err = f.update(func(tx MetaTx) error {
+		return putXattrs(tx, dir.FullPath+name, m)
+	})
+	if err != nil {
+		return err
+	}
+	dir.setXattrs(name, m)
 	return nil
 }
 
+// putXattrs writes the extended attributes of the file at path
+func putXattrs(tx MetaTx, path string, m map[string]string) error {
+	if len(m) == 0 {
+		return tx.Delete(bucketXattrs, path)
+	}
+	return putJSON(tx, bucketXattrs, path, m)
+}
+
+// setXattrs makes m the extended attributes of the file name in d, d.mu
+// should be held by the caller
+func (d *Directory) setXattrs(name string, m map[string]string) {
+	if len(m) == 0 {
+		delete(d.Xattrs, name)
+		return
+	}
+	d.Xattrs[name] = m
+}
+
+// Xattrs returns the extended attributes of the file at the given path
+func (f *FS) Xattrs(path string) (map[string]string, error) {
+	dir, name, err := f.lookupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.rlock(dir); err != nil {
+		return nil, err
+	}
+	defer dir.mu.RUnlock()
+	if _, ok := dir.Files[name]; !ok {
+		return nil, NewError(ErrNotFound, "file not exist")
+	}
+	return copyXattrs(dir.Xattrs[name]), nil
+}
+
 // PrintDir prints directory recursively, it could take a long time to finish
-func (d *Directory) PrintDir() string {
+func (f *FS) PrintDir(d *Directory) (string, error) {
 	b := new(bytes.Buffer)
-	fmt.Fprintf(b, "[dir]%s\n", d.Name)
-	for k := range d.Files {
-		fmt.Fprintf(b, "%s\n", k)
+	if err := f.printDir(d, 0, b); err != nil {
+		return "", err
 	}
-	for _, v := range d.SubDirs {
-		v.printSubDirs(1, b)
-	}
-	return b.String()
+	return b.String(), nil
 }
 
-func (d *Directory) printSubDirs(depth int, w io.Writer) {
-	tab := ""
-	for i := 0; i < depth; i++ {
-		tab += "\t"
+func (f *FS) printDir(d *Directory, depth int, w io.Writer) error {
+	if err := f.rlock(d); err != nil {
+		return err
 	}
+	tab := strings.Repeat("\t", depth)
 	fmt.Fprintf(w, "%s[dir]%s\n", tab, d.Name)
 	for k := range d.Files {
 		fmt.Fprintf(w, "%s%s\n", tab, k)
 	}
+	for k, v := range d.Symlinks {
+		fmt.Fprintf(w, "%s%s -> %s\n", tab, k, v)
+	}
+	subs := make([]*Directory, 0, len(d.SubDirs))
 	for _, v := range d.SubDirs {
-		v.printSubDirs(depth+1, w)
+		subs = append(subs, v)
+	}
+	// the entries of subdirectories may evict d, so it is not held while
+	// they are printed
+	d.mu.RUnlock()
+	for _, v := range subs {
+		if err := f.printDir(v, depth+1, w); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -1,7 +1,9 @@
 package sdfs
 
 import (
+	"errors"
 	"testing"
+	"time"
 )
 
 func TestFsAddDir(t *testing.T) {
@@ -55,8 +57,8 @@ func TestFsAddFile(t *testing.T) {
 		t.Fatalf("Parent directory not exist")
 	}
 	if bar, ok := foo.Files["bar"]; ok {
-		if len(bar.FSPath) == 1 {
-			if bar.FSPath[0].FileName == "bar" && bar.FSPath[0].Parent == foo && bar.Size == uint64(len([]byte("foobar"))) {
+		if p, _ := fs.Paths(bar.Checksum); len(p) == 1 {
+			if p[0] == "/foo/bar" && bar.Size == uint64(len([]byte("foobar"))) {
 				return
 			}
 		} else {
@@ -78,8 +80,8 @@ func TestFsGetFile(t *testing.T) {
 		t.Fatalf("Parent directory not exist")
 	}
 	if bar, err := fs.GetFile("/foo/bar.go"); err == nil {
-		if len(bar.FSPath) == 1 {
-			if bar.FSPath[0].FileName == "bar.go" && bar.FSPath[0].Parent == foo && bar.Checksum == "foobar" {
+		if p, _ := fs.Paths(bar.Checksum); len(p) == 1 {
+			if p[0] == "/foo/bar.go" && bar.Checksum == "foobar" {
 				return
 			}
 		} else {
@@ -106,3 +108,126 @@ func TestFsDeleteFile(t *testing.T) {
 	}
 	t.Errorf("want file not exist error, have nil")
 }
+
+func TestFsXattr(t *testing.T) {
+	fs := NewFS()
+	fs.AddFile("/foo/bar.go", "foobar")
+	if err := fs.SetXattr("/foo/bar.go", "source", "ingest-7"); err != nil {
+		t.Fatalf("Got error when setting xattr: %q", err)
+	}
+	if err := fs.SetXattr("/foo/bar.go", "bad key", "v"); err == nil {
+		t.Errorf("want error on invalid xattr key, have nil")
+	}
+	info, err := fs.Stat("/foo/bar.go")
+	if err != nil {
+		t.Fatalf("Got error on stat: %q", err)
+	}
+	if info.Xattrs["source"] != "ingest-7" {
+		t.Errorf("xattr not correct, want: %q, have: %q", "ingest-7", info.Xattrs["source"])
+	}
+	infos, err := fs.List("/foo")
+	if err != nil || len(infos) != 1 || infos[0].Xattrs["source"] != "ingest-7" {
+		t.Errorf("listing not correct, have: %+v, error: %v", infos, err)
+	}
+	if err := fs.RemoveXattr("/foo/bar.go", "source"); err != nil {
+		t.Fatalf("Got error when removing xattr: %q", err)
+	}
+	if x, _ := fs.Xattrs("/foo/bar.go"); len(x) != 0 {
+		t.Errorf("want no xattrs, have: %v", x)
+	}
+}
+
+func TestFsXattrPerPath(t *testing.T) {
+	fs := NewFS()
+	fs.AddFile("/a", "h")
+	fs.AddFile("/b", "h")
+	fs.Link("/a", "/c")
+	if err := fs.SetXattr("/a", "team", "infra"); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/b", "/c"} {
+		if x, _ := fs.Xattrs(p); len(x) != 0 {
+			t.Errorf("want no xattrs on %s sharing the content of /a, have: %v", p, x)
+		}
+	}
+	if err := fs.Rename("/a", "/d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Copy("/d", "/e"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.DeleteFile("/d"); err != nil {
+		t.Fatal(err)
+	}
+	fs.AddFile("/d", "h")
+	if x, _ := fs.Xattrs("/d"); len(x) != 0 {
+		t.Errorf("want xattrs deleted with their path, have: %v", x)
+	}
+	if x, _ := fs.Xattrs("/e"); x["team"] != "infra" {
+		t.Errorf("want xattrs moved by rename and copied, have: %v", x)
+	}
+
+	e, err := fs.TrashFile("/e", time.Unix(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.RestoreTrash("", e.ID, "/f"); err != nil {
+		t.Fatal(err)
+	}
+	if x, _ := fs.Xattrs("/f"); x["team"] != "infra" {
+		t.Errorf("want xattrs restored from the trash, have: %v", x)
+	}
+
+	// attributes of a write are added to the ones of the path
+	opts := WriteOptions{Overwrite: true, Xattrs: map[string]string{"stage": "raw"}}
+	if _, _, err := fs.WriteFile("/f", "h2", 10, time.Unix(2, 0), opts); err != nil {
+		t.Fatal(err)
+	}
+	if x, _ := fs.Xattrs("/f"); len(x) != 2 || x["team"] != "infra" || x["stage"] != "raw" {
+		t.Errorf("want xattrs of the write added, have: %v", x)
+	}
+	opts.Xattrs = map[string]string{"bad key": ""}
+	if _, _, err := fs.WriteFile("/g", "h3", 10, time.Unix(3, 0), opts); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want invalid xattr refused, have: %v", err)
+	}
+	if _, err := fs.GetFile("/g"); err == nil {
+		t.Errorf("file written with invalid xattrs")
+	}
+}
+
+func TestFsRename(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/a/b", "hash", 10)
+	fs.Symlink("/a/b", "/a/l")
+	if err := fs.Rename("/a/b", "/c/d"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := fs.GetFile("/a/b"); err == nil {
+		t.Errorf("file still at old path")
+	}
+	f, err := fs.GetFile("/c/d")
+	if err != nil {
+		t.Fatalf("file not at new path: %v", err)
+	}
+	if p, _ := fs.Paths(f.Checksum); len(p) != 1 || p[0] != "/c/d" {
+		t.Errorf("paths not updated, have: %v", p)
+	}
+	a, _ := fs.GetDir("/a")
+	c, _ := fs.GetDir("/c")
+	if a.TotalSize() != 0 || c.TotalSize() != 10 {
+		t.Errorf("sizes not moved, have: /a %d, /c %d", a.TotalSize(), c.TotalSize())
+	}
+	if err := fs.Rename("/a/l", "/c/l"); err != nil {
+		t.Fatalf("Rename symlink: %v", err)
+	}
+	if target, err := fs.Readlink("/c/l"); err != nil || target != "/a/b" {
+		t.Errorf("symlink not moved, have: %q, %v", target, err)
+	}
+	fs.AddFile("/c/e", "other")
+	if err := fs.Rename("/c/d", "/c/e"); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("want ErrAlreadyExists, have: %v", err)
+	}
+	if err := fs.Rename("/c", "/f"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want ErrInvalidArgument for directories, have: %v", err)
+	}
+}
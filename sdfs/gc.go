@@ -0,0 +1,210 @@
+package sdfs
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Lyianu/sdfs/log"
+)
+
+// LiveObjects returns the hashes of the objects each node should keep by the
+// addresses of the nodes, they are the files stored whole and the chunks that
+// list the node as a host. Files kept by snapshots, prior versions and the
+// trash still have records, so their objects are live too. Hosts are mapped
+// to addresses with the ids recorded by AddNode, a node that got a new id
+// keeps the objects of its old ones. unknown are the hosts without a recorded
+// address, the nodes holding their objects can not be told
+func (f *FS) LiveObjects() (live map[string][]string, unknown []int32, err error) {
+	hosts := make(map[int32][]string)
+	// keys are read in order, so the lists of hashes are sorted once both
+	// buckets are merged
+	err = f.view(func(tx MetaTx) error {
+		err := tx.ForEach(bucketChecksums, "", func(k string, v []byte) error {
+			var r fileRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if len(r.Chunks) != 0 {
+				return nil
+			}
+			for _, h := range r.Host {
+				hosts[h] = append(hosts[h], k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.ForEach(bucketChunks, "", func(k string, v []byte) error {
+			var r chunkRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			for _, h := range r.Host {
+				hosts[h] = append(hosts[h], k)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	live = make(map[string][]string)
+	f.mu.Lock()
+	for h, hashes := range hosts {
+		addr, ok := f.nodes[h]
+		if !ok {
+			unknown = append(unknown, h)
+			continue
+		}
+		live[addr] = append(live[addr], hashes...)
+	}
+	f.mu.Unlock()
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i] < unknown[j] })
+	for addr, hashes := range live {
+		// content stored whole may also be a chunk of other files, or listed
+		// under more than one id of the node
+		sort.Strings(hashes)
+		n := 0
+		for i, hash := range hashes {
+			if i == 0 || hash != hashes[i-1] {
+				hashes[n] = hash
+				n++
+			}
+		}
+		live[addr] = hashes[:n]
+	}
+	return live, unknown, nil
+}
+
+// GCReport describes what a garbage collection of a hashstore removed, or
+// would remove if it is a dry run
+type GCReport struct {
+	DryRun bool `json:"dry_run"`
+	// Objects are the orphaned objects, Size adds up their content
+	Objects []string `json:"objects"`
+	Size    int64    `json:"size"`
+	// Temp are the files of unfinished writes, TempSize adds them up
+	Temp     []string `json:"temp"`
+	TempSize int64    `json:"temp_size"`
+	// Recent counts the orphaned objects kept because they were referenced
+	// within the grace period, the master may not know of them yet
+	Recent int `json:"recent"`
+	// Busy are the orphaned objects kept because they are being read
+	Busy []string `json:"busy,omitempty"`
+}
+
+// Collect removes the objects of h that are not in live and the files of
+// unfinished writes, both only if they were not touched within grace. An
+// object is touched when it is stored and whenever a reference is added to
+// it, so uploads and replicas the master has not recorded yet are kept.
+// Nothing is removed if dryRun is true, the report then tells what would be
+func (h *HashStore) Collect(live []string, grace time.Duration, dryRun bool) (GCReport, error) {
+	report := GCReport{DryRun: dryRun, Objects: []string{}, Temp: []string{}}
+	cutoff := time.Now().Add(-grace)
+	keep := make(map[string]bool, len(live))
+	for _, hash := range live {
+		keep[hash] = true
+	}
+
+	var errResult error
+	for _, hash := range h.orphans(keep) {
+		size, ok, err := h.sweep(hash, cutoff, dryRun)
+		if errors.Is(err, ErrBusy) {
+			report.Busy = append(report.Busy, hash)
+			continue
+		} else if errors.Is(err, ErrNotFound) {
+			// removed since it was found orphaned
+			continue
+		} else if err != nil {
+			log.Errorf("failed to collect %s: %q", hash, err)
+			errResult = err
+			continue
+		}
+		if !ok {
+			report.Recent++
+			continue
+		}
+		report.Objects = append(report.Objects, hash)
+		report.Size += size
+	}
+
+	for _, d := range h.Disks {
+		if d.Err() != nil {
+			continue
+		}
+		entries, err := os.ReadDir(d.Dir)
+		if err != nil {
+			log.Errorf("failed to read %s: %q", d.Dir, err)
+			errResult = err
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasPrefix(e.Name(), tmpPrefix) {
+				continue
+			}
+			i, err := e.Info()
+			if err != nil || !i.ModTime().Before(cutoff) {
+				continue
+			}
+			path := filepath.Join(d.Dir, e.Name())
+			if !dryRun {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					log.Errorf("failed to remove unfinished write %s: %q", path, err)
+					errResult = err
+					continue
+				}
+			}
+			report.Temp = append(report.Temp, path)
+			report.TempSize += i.Size()
+		}
+	}
+	if !dryRun && (len(report.Objects) != 0 || len(report.Temp) != 0) {
+		log.Infof("garbage collection removed %d objects of %d bytes and %d unfinished writes", len(report.Objects), report.Size, len(report.Temp))
+	}
+	return report, errResult
+}
+
+// orphans returns the hashes of the objects of h that are not in keep
+func (h *HashStore) orphans(keep map[string]bool) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var hashes []string
+	for hash := range h.s {
+		if !keep[hash] {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// sweep removes the object with the given hash if it was not touched after
+// cutoff, it returns the size of the object and whether it was removed, or
+// would be if dryRun is true
+func (h *HashStore) sweep(hash string, cutoff time.Time, dryRun bool) (int64, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, ok := h.s[hash]
+	if !ok {
+		return 0, false, NewError(ErrNotFound, "file not found")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Created.After(cutoff) || f.Referenced.After(cutoff) {
+		return 0, false, nil
+	}
+	if atomic.LoadInt32(&f.OpenCount) != 0 {
+		return 0, false, NewError(ErrBusy, "file is being accessed by other goroutine")
+	}
+	if dryRun {
+		return f.Size, true, nil
+	}
+	return f.Size, true, h.drop(f)
+}
@@ -0,0 +1,156 @@
+package sdfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFsLiveObjects(t *testing.T) {
+	fs := NewFS()
+	now := time.Unix(1, 0)
+	if _, err := fs.AddSizedFile("/a", "ha", 10); err != nil {
+		t.Fatal(err)
+	}
+	chunks := []ChunkRef{{Hash: "c1", Size: 10}, {Hash: "c2", Size: 20}}
+	if _, _, err := fs.WriteChunked("/b", "hb", 30, now, chunks, WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.AddSizedFile("/c", "hc", 10); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []struct {
+		hash string
+		host int32
+	}{{"ha", 1}, {"ha", 2}, {"hb", 1}, {"c2", 2}, {"hc", 2}} {
+		if err := fs.AddHost(h.hash, h.host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// content in the trash is still live
+	if _, err := fs.TrashFile("/c", now); err != nil {
+		t.Fatal(err)
+	}
+	fs.AddNode(1, "n1")
+	live, unknown, err := fs.LiveObjects()
+	if err != nil || !reflect.DeepEqual(live, map[string][]string{"n1": {"c1", "c2", "ha"}}) {
+		t.Errorf("want live objects of n1, have: %v, %v", live, err)
+	}
+	if !reflect.DeepEqual(unknown, []int32{2}) {
+		t.Errorf("want host 2 unknown, have: %v", unknown)
+	}
+	fs.AddNode(2, "n2")
+	want := map[string][]string{
+		"n1": {"c1", "c2", "ha"},
+		"n2": {"c2", "ha", "hc"},
+	}
+	if live, unknown, _ := fs.LiveObjects(); !reflect.DeepEqual(live, want) || len(unknown) != 0 {
+		t.Errorf("want live objects %v, have: %v, unknown %v", want, live, unknown)
+	}
+
+	if err := fs.DeleteFile("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if live, _, _ := fs.LiveObjects(); !reflect.DeepEqual(live["n1"], []string{"c1", "c2"}) {
+		t.Errorf("want ha dead after its last path is deleted, have: %v", live["n1"])
+	}
+}
+
+func TestOpenFSLiveObjectsNewNodeIDs(t *testing.T) {
+	store := NewMemStore()
+	fs, err := OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.AddNode(1, "n1")
+	fs.AddNode(2, "n2")
+	fs.AddSizedFile("/a", "ha", 10)
+	fs.AddSizedFile("/b", "hb", 10)
+	fs.AddHost("ha", 1)
+	fs.AddHost("hb", 2)
+
+	// after a restart of the master n1 registers again with a new id and
+	// stores new objects under it
+	if fs, err = OpenFS(store); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := fs.NodeID("n2"); !ok || id != 2 {
+		t.Errorf("want the id of n2 reloaded, have: %d, %t", id, ok)
+	}
+	fs.AddNode(3, "n1")
+	fs.AddSizedFile("/c", "hc", 10)
+	fs.AddHost("hc", 3)
+	want := map[string][]string{
+		"n1": {"ha", "hc"},
+		"n2": {"hb"},
+	}
+	if live, unknown, err := fs.LiveObjects(); err != nil || !reflect.DeepEqual(live, want) || len(unknown) != 0 {
+		t.Errorf("want live objects %v, have: %v, unknown %v, %v", want, live, unknown, err)
+	}
+}
+
+func TestHashStoreCollect(t *testing.T) {
+	dir := t.TempDir()
+	index := NewMemIndex()
+	h, err := OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, _, _ := h.Add(bytes.NewReader([]byte("live")))
+	orphan, _, _ := h.Add(bytes.NewReader([]byte("orphan")))
+	open, _, _ := h.Add(bytes.NewReader([]byte("open")))
+	tmp := filepath.Join(dir, tmpPrefix+"leaked")
+	os.WriteFile(tmp, []byte("partial"), 0644)
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(tmp, old, old)
+
+	// objects and writes younger than the grace period are kept
+	r, err := h.Collect([]string{live}, time.Hour, false)
+	if err != nil || len(r.Objects) != 0 || r.Recent != 2 || !reflect.DeepEqual(r.Temp, []string{tmp}) {
+		t.Fatalf("want only the leaked write collected, have: %+v, %v", r, err)
+	}
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Errorf("leaked write kept: %v", err)
+	}
+
+	f, _ := h.Get(open)
+	r, err = h.Collect([]string{live}, 0, true)
+	if err != nil || !r.DryRun || !reflect.DeepEqual(r.Objects, []string{orphan}) || r.Size != 6 || !reflect.DeepEqual(r.Busy, []string{open}) {
+		t.Fatalf("want %s reported and %s busy, have: %+v, %v", orphan, open, r, err)
+	}
+	if _, ok := h.s[orphan]; !ok {
+		t.Errorf("object removed by a dry run")
+	}
+
+	// a reference added again restarts the grace period
+	for _, f := range h.s {
+		f.Created, f.Referenced = old, old
+	}
+	h.Ref(orphan, 1)
+	f.OpenCount--
+	if r, _ := h.Collect([]string{live}, time.Hour, true); !reflect.DeepEqual(r.Objects, []string{open}) || r.Recent != 1 {
+		t.Errorf("want %s kept after it was referenced, have: %+v", orphan, r)
+	}
+	r, err = h.Collect([]string{live}, 0, false)
+	if err != nil || len(r.Objects) != 2 || r.Size != 10 || len(r.Busy) != 0 {
+		t.Fatalf("want 2 orphaned objects collected, have: %+v, %v", r, err)
+	}
+	if _, err := h.Get(live); err != nil {
+		t.Errorf("live object collected: %v", err)
+	}
+
+	// collected objects are gone from the disk and the index
+	h, err = OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Get(orphan); err == nil {
+		t.Errorf("collected object restored")
+	}
+	if s := h.DiskStats(); s[0].Objects != 1 {
+		t.Errorf("want 1 object left, have: %d", s[0].Objects)
+	}
+}
@@ -3,14 +3,19 @@ package sdfs
 import (
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/Lyianu/sdfs/log"
+	"github.com/Lyianu/sdfs/pkg/kms"
 	"github.com/Lyianu/sdfs/pkg/settings"
 	"github.com/Lyianu/sdfs/pkg/util"
 )
@@ -21,12 +26,45 @@ func init() {
 	Hs = NewHashStore()
 }
 
+// buckets used by the index of a HashStore
+const bucketObjects = "objects" // hash => objectRecord
+
+var indexBuckets = []string{bucketObjects}
+
+// OpenHashIndex opens the bbolt database at path as the index of a
+// HashStore, the file is created if it does not exist
+func OpenHashIndex(path string) (MetaStore, error) {
+	return openBoltStore(path, indexBuckets)
+}
+
+// NewMemIndex returns an empty in-memory index for a HashStore
+func NewMemIndex() MetaStore {
+	return newMemStore(indexBuckets)
+}
+
+// tmpPrefix starts the names of files that are still being written to the
+// hashstore, checksums never contain a '.'
+const tmpPrefix = ".tmp-"
+
 type HashStore struct {
 	// string is the hash of the file, int32 correspond to the opened time
 	s map[string]*file
 
-	Size int64
-	mu   sync.RWMutex
+	// Disks are the data directories objects are stored in
+	Disks []*Disk
+	Size  int64
+	mu    sync.RWMutex
+
+	// Codec is the codec new objects are compressed with, objects already
+	// stored keep the codec they were stored with
+	Codec string
+	// Keys wraps the data keys new objects are encrypted with, objects are
+	// stored unencrypted if it is nil
+	Keys kms.KMS
+
+	// index keeps the objects on disk so that their references survive
+	// restarts, it is nil for hashstores that are rebuilt from Dir
+	index MetaStore
 }
 
 type file struct {
@@ -34,8 +72,63 @@ type file struct {
 	OpenCount    int32
 	ReplicaCount int32
 	Size         int64
+	// Created is the time the object was first stored
+	Created time.Time
+	// Verified is the last time the content was found to match the hash
+	Verified time.Time
+	// Referenced is the last time a reference was added to the object, it
+	// is not kept in the index
+	Referenced time.Time
+	// Codec is the codec the content is compressed with, Stored the size
+	// of its file. Frame is the size of the content of each frame of a
+	// compressed object and frames are their offsets in the file
+	Codec  string
+	Stored int64
+	Frame  int64
+	frames []int64
+	// KeyID is the id of the master key that wraps the data key of an
+	// encrypted object, Sealed the size of its file
+	KeyID  string
+	Sealed int64
 
-	mu sync.Mutex
+	disk *Disk
+	mu   sync.Mutex
+}
+
+// objectRecord is how objects are kept in the index of a HashStore
+type objectRecord struct {
+	Size int64 `json:"size"`
+	Refs int32 `json:"refs"`
+	// Checksum is the hash computed from the content when it was verified,
+	// it should be the key of the record
+	Checksum string `json:"checksum"`
+	Created  int64  `json:"created"`  // unix nanoseconds
+	Verified int64  `json:"verified"` // unix nanoseconds
+	// Codec, Stored, Frame and Frames are those of compressed objects
+	Codec  string  `json:"codec,omitempty"`
+	Stored int64   `json:"stored,omitempty"`
+	Frame  int64   `json:"frame,omitempty"`
+	Frames []int64 `json:"frames,omitempty"`
+	// Key and Sealed are those of encrypted objects
+	Key    string `json:"key,omitempty"`
+	Sealed int64  `json:"sealed,omitempty"`
+}
+
+func (f *file) record() objectRecord {
+	r := objectRecord{
+		Size:     f.Size,
+		Checksum: f.Hash,
+		Refs:     f.ReplicaCount,
+		Created:  f.Created.UnixNano(),
+		Verified: f.Verified.UnixNano(),
+	}
+	if f.Codec != CodecNone {
+		r.Codec, r.Stored, r.Frame, r.Frames = f.Codec, f.Stored, f.Frame, f.frames
+	}
+	if f.KeyID != "" {
+		r.Key, r.Sealed, r.Stored = f.KeyID, f.Sealed, f.Stored
+	}
+	return r
 }
 
 func (h *HashStore) GetSize() int64 {
@@ -46,12 +139,292 @@ func (h *HashStore) GetSize() int64 {
 
 func NewHashStore() *HashStore {
 	h := &HashStore{
-		s:    make(map[string]*file),
-		Size: 0,
+		s:     make(map[string]*file),
+		Disks: []*Disk{{Dir: settings.DataPathPrefix}},
+		Size:  0,
+		Codec: settings.Compression,
 	}
 	return h
 }
 
+// OpenHashStore opens the hashstore in the data directories dirs, objects
+// are loaded from index and reconciled with the files in dirs: files of
+// unfinished writes are removed, objects whose file is missing are dropped
+// and files that are not indexed are verified and added. Directories that
+// can not be read are failed, the hashstore opens as long as one of them is
+// healthy. Changes made to the returned hashstore are written to index, a
+// nil index rebuilds the hashstore from the files with a single reference
+// each
+func OpenHashStore(dirs []string, index MetaStore) (*HashStore, error) {
+	return OpenEncryptedHashStore(dirs, index, nil)
+}
+
+// OpenEncryptedHashStore opens the hashstore in the data directories dirs
+// like OpenHashStore, new objects are encrypted with data keys wrapped by
+// keys, which also unwraps the keys of the objects already encrypted.
+// Objects stored unencrypted stay so until they are rekeyed
+func OpenEncryptedHashStore(dirs []string, index MetaStore, keys kms.KMS) (*HashStore, error) {
+	if len(dirs) == 0 {
+		return nil, NewError(ErrInvalidArgument, "no data directory")
+	}
+	h := NewHashStore()
+	h.Keys = keys
+	h.Disks = nil
+	for _, dir := range dirs {
+		h.Disks = append(h.Disks, &Disk{Dir: dir})
+	}
+	if index == nil {
+		return h, h.reconcile()
+	}
+	err := index.View(func(tx MetaTx) error {
+		return tx.ForEach(bucketObjects, "", func(k string, v []byte) error {
+			var r objectRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if r.Codec == CodecNone && r.Key == "" {
+				r.Stored = r.Size
+			}
+			if r.Checksum != k {
+				// a size that matches no file makes reconcile verify the
+				// content again
+				log.Errorf("object %s was indexed with checksum %s", k, r.Checksum)
+				r.Size, r.Stored, r.Sealed = -1, -1, -1
+			}
+			h.s[k] = &file{
+				Hash:         k,
+				ReplicaCount: r.Refs,
+				Size:         r.Size,
+				Created:      time.Unix(0, r.Created),
+				Verified:     time.Unix(0, r.Verified),
+				Codec:        r.Codec,
+				Stored:       r.Stored,
+				Frame:        r.Frame,
+				frames:       r.Frames,
+				KeyID:        r.Key,
+				Sealed:       r.Sealed,
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.index = index
+	if err := h.reconcile(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// object is a file found in a data directory
+type object struct {
+	disk   *Disk
+	size   int64
+	codec  string
+	sealed bool
+}
+
+// scan returns the objects on d by their hashes, files of unfinished writes
+// are removed
+func (d *Disk) scan() (map[string]object, error) {
+	present := make(map[string]object)
+	err := filepath.WalkDir(d.Dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			if path == filepath.Join(d.Dir, quarantineDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		name := e.Name()
+		if strings.HasPrefix(name, tmpPrefix) {
+			log.Infof("removing unfinished write %s", path)
+			return os.Remove(path)
+		}
+		if path == filepath.Join(d.Dir, layoutFile) {
+			return nil
+		}
+		sealed := strings.HasSuffix(name, sealedSuffix)
+		name, codec := splitCodec(strings.TrimSuffix(name, sealedSuffix))
+		hash, err := hashOfName(name)
+		if err != nil || path != d.file(hash, codec, sealed) {
+			log.Errorf("%s is not an object, leaving it out", path)
+			return nil
+		}
+		if o, ok := present[hash]; ok {
+			log.Errorf("object %s is stored more than once on %s, using %s", hash, d.Dir, o.disk.file(hash, o.codec, o.sealed))
+			return nil
+		}
+		i, err := e.Info()
+		if err != nil {
+			return err
+		}
+		present[hash] = object{disk: d, size: i.Size(), codec: codec, sealed: sealed}
+		return nil
+	})
+	return present, err
+}
+
+// reconcile makes the objects of h match the files on its disks
+func (h *HashStore) reconcile() error {
+	present := make(map[string]object)
+	healthy := 0
+	for _, d := range h.Disks {
+		if err := d.open(); errors.Is(err, ErrConflict) {
+			return err
+		} else if err != nil {
+			h.fail(d, err)
+			continue
+		}
+		objects, err := d.scan()
+		if err != nil {
+			h.fail(d, err)
+			continue
+		}
+		healthy++
+		for hash, obj := range objects {
+			if o, ok := present[hash]; ok {
+				log.Errorf("object %s is on both %s and %s, using the first", hash, o.disk.Dir, d.Dir)
+				continue
+			}
+			present[hash] = obj
+		}
+	}
+	if healthy == 0 {
+		return NewError(ErrNoCapacity, "no healthy data directory")
+	}
+	var drop []string
+	for hash, f := range h.s {
+		if o, ok := present[hash]; !ok || !o.matches(f) {
+			log.Errorf("object %s is missing or has wrong size, dropping it", hash)
+			drop = append(drop, hash)
+		}
+	}
+	var add []*file
+	for hash, o := range present {
+		f, ok := h.s[hash]
+		if ok && o.matches(f) {
+			f.disk = o.disk
+			continue
+		}
+		nf, err := h.inspect(hash, o)
+		if errors.Is(err, ErrChecksumMismatch) {
+			log.Errorf("content of %s does not match its hash, leaving it out", hash)
+			continue
+		} else if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidArgument) {
+			log.Errorf("%s can not be decrypted, leaving it out: %q", hash, err)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if ok {
+			// the index was wrong about the size, the references still hold
+			nf.ReplicaCount, nf.Created = f.ReplicaCount, f.Created
+		}
+		add = append(add, nf)
+	}
+	err := h.update(func(tx MetaTx) error {
+		// objects of a failed disk may be back after a restart
+		if healthy == len(h.Disks) {
+			for _, hash := range drop {
+				if err := tx.Delete(bucketObjects, hash); err != nil {
+					return err
+				}
+			}
+		}
+		for _, f := range add {
+			if err := putJSON(tx, bucketObjects, f.Hash, f.record()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, hash := range drop {
+		delete(h.s, hash)
+	}
+	for _, f := range add {
+		h.s[f.Hash] = f
+	}
+	h.Size = 0
+	for _, f := range h.s {
+		h.Size += f.Size
+		f.disk.count(f, 1)
+	}
+	log.Infof("hashstore loaded %d objects on %d of %d disks, %d dropped, %d added", len(h.s), healthy, len(h.Disks), len(drop), len(add))
+	return nil
+}
+
+// matches reports whether o is the file of f
+func (o object) matches(f *file) bool {
+	if o.codec != f.Codec || o.sealed != (f.KeyID != "") {
+		return false
+	}
+	if o.sealed {
+		return o.size == f.Sealed
+	}
+	return o.size == f.Stored
+}
+
+// inspect reads the object with the given hash in its file o and returns it
+// with a single reference, content that does not match the hash is
+// reported with ErrChecksumMismatch
+func (h *HashStore) inspect(hash string, o object) (*file, error) {
+	path := o.disk.file(hash, o.codec, o.sealed)
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	now := time.Now()
+	f := &file{Hash: hash, ReplicaCount: 1, Created: now, Verified: now, Codec: o.codec, Stored: o.size, disk: o.disk}
+	var src io.Reader = r
+	if o.sealed {
+		sr, hd, err := openSealed(r, h.Keys)
+		if err != nil {
+			return nil, err
+		}
+		src, f.Stored, f.KeyID, f.Sealed = sr, sr.size, hd.KeyID, o.size
+	}
+	sum, size, frame, frames, err := inspect(src, path, o.codec)
+	if err != nil {
+		return nil, err
+	}
+	if sum != hash {
+		return nil, NewError(ErrChecksumMismatch, "content of %s hashes to %s", hash, sum)
+	}
+	f.Size, f.Frame, f.frames = size, frame, frames
+	return f, nil
+}
+
+// update runs fn in a transaction of the index of h, it does nothing when h
+// has no index
+func (h *HashStore) update(fn func(tx MetaTx) error) error {
+	if h.index == nil {
+		return nil
+	}
+	return h.index.Update(fn)
+}
+
+// Close closes the index of h
+func (h *HashStore) Close() error {
+	if h.index == nil {
+		return nil
+	}
+	return h.index.Close()
+}
+
+// encodeSum encodes a sha256 sum as the hash of an object, hashes are used as
+// file names so '/' is replaced
+func encodeSum(b []byte) string {
+	return strings.Replace(base64.StdEncoding.EncodeToString(b), "/", "_", -1)
+}
+
 func (h *HashStore) Get(hash string) (*file, error) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
@@ -60,80 +433,255 @@ func (h *HashStore) Get(hash string) (*file, error) {
 
 		return f, nil
 	}
-	return nil, errors.New("file with specific hash not found")
+	return nil, NewError(ErrNotFound, "file with specific hash not found")
 }
 
-func (h *HashStore) Add(r io.Reader) (string, error) {
-	tmpName := settings.DataPathPrefix + util.RandomString(16)
+// diskWriter remembers the error of writes to a disk, so that it can be
+// told from errors of the reader
+type diskWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *diskWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Add stores the content of r in the hashstore on the disk with the most
+// free space, it returns the hash and the size of the content
+func (h *HashStore) Add(r io.Reader) (string, int64, error) {
+	return h.add(r, "", h.Codec)
+}
+
+// AddChecked stores the content of r like Add if it has the given hash,
+// content that does not match is rejected with ErrChecksumMismatch without
+// being stored
+func (h *HashStore) AddChecked(r io.Reader, hash string) (int64, error) {
+	_, size, err := h.add(r, hash, h.Codec)
+	return size, err
+}
+
+// add stores the content of r compressed with codec, want is the hash it
+// should have, empty if any content is accepted
+func (h *HashStore) add(r io.Reader, want, codec string) (string, int64, error) {
+	d, err := h.pickDisk()
+	if err != nil {
+		return "", 0, err
+	}
+	tmpName := filepath.Join(d.Dir, tmpPrefix+util.RandomString(16))
 	f, err := os.Create(tmpName)
 	if err != nil {
-		return "", err
+		return "", 0, h.failed(d, err)
 	}
 	defer f.Close()
-	tReader := io.TeeReader(r, f)
+	w := &diskWriter{w: f}
+	tReader := io.TeeReader(r, w)
 	hash := sha256.New()
 	size, err := io.Copy(hash, tReader)
-	sum := base64.StdEncoding.EncodeToString(hash.Sum(nil))
 	if err != nil {
 		os.Remove(tmpName)
-		return "", err
+		if w.err != nil {
+			h.failed(d, w.err)
+		}
+		return "", 0, err
 	}
-	log.Debugf("original hash: %s", sum)
-	sum = strings.Replace(sum, "/", "_", -1)
+	sum := encodeSum(hash.Sum(nil))
 	log.Debugf("proccessed hash: %s", sum)
+	if want != "" && sum != want {
+		os.Remove(tmpName)
+		return "", 0, NewError(ErrChecksumMismatch, "content hashes to %s, expected %s", sum, want)
+	}
+	stored, frames := size, []int64(nil)
+	h.mu.RLock()
+	_, ok := h.s[sum]
+	h.mu.RUnlock()
+	if codec != CodecNone && size > 0 && !ok {
+		name, n, fr, err := compress(tmpName, codec, size)
+		if err != nil {
+			os.Remove(tmpName)
+			return "", 0, h.failed(d, err)
+		}
+		if n < size {
+			os.Remove(tmpName)
+			tmpName, stored, frames = name, n, fr
+		} else {
+			// content that does not compress is stored as it is
+			os.Remove(name)
+			codec = CodecNone
+		}
+	} else {
+		codec = CodecNone
+	}
+	var keyID string
+	var sealed int64
+	if h.Keys != nil && !ok {
+		name, id, n, err := sealFile(tmpName, stored, h.Keys)
+		if err != nil {
+			os.Remove(tmpName)
+			// errors of the KMS are no fault of the disk
+			if errors.As(err, new(*fs.PathError)) {
+				h.failed(d, err)
+			}
+			return "", 0, err
+		}
+		os.Remove(tmpName)
+		tmpName, keyID, sealed = name, id, n
+	}
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	if f, ok := h.s[sum]; ok {
 		os.Remove(tmpName)
 		f.mu.Lock()
+		defer f.mu.Unlock()
+		r := f.record()
+		r.Refs++
+		if err := h.update(func(tx MetaTx) error { return putJSON(tx, bucketObjects, sum, r) }); err != nil {
+			return "", 0, err
+		}
 		f.ReplicaCount++
-		f.mu.Unlock()
-		return sum, nil
+		f.Referenced = time.Now()
+		return sum, size, nil
 	}
-
-	n := settings.DataPathPrefix + sum
-	if err = os.Rename(tmpName, n); err != nil {
-		return "", err
+	if err := d.Err(); err != nil {
+		// the disk failed while the content was written
+		os.Remove(tmpName)
+		return "", 0, err
 	}
-	h.s[sum] = &file{
+
+	now := time.Now()
+	nf := &file{
 		Hash:         sum,
 		OpenCount:    0,
 		ReplicaCount: 1,
 		Size:         size,
+		Created:      now,
+		Verified:     now,
+		Referenced:   now,
+		Codec:        codec,
+		Stored:       stored,
+		frames:       frames,
+		KeyID:        keyID,
+		Sealed:       sealed,
+		disk:         d,
+	}
+	if codec != CodecNone {
+		nf.Frame = settings.CompressionFrame
+	}
+	if err := h.update(func(tx MetaTx) error { return putJSON(tx, bucketObjects, sum, nf.record()) }); err != nil {
+		os.Remove(tmpName)
+		return "", 0, err
+	}
+	n := nf.Path()
+	err = os.MkdirAll(filepath.Dir(n), 0755)
+	if err == nil {
+		err = os.Rename(tmpName, n)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		h.update(func(tx MetaTx) error { return tx.Delete(bucketObjects, sum) })
+		if !isFull(err) {
+			h.fail(d, err)
+		}
+		return "", 0, err
 	}
+	h.s[sum] = nf
+	d.count(nf, 1)
 
 	atomic.AddInt64(&h.Size, size)
 
-	return sum, nil
+	return sum, size, nil
 }
 
 func (h *HashStore) AddLocal(checksum string, size int64) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
-	h.s[checksum] = &file{
+	d := h.Disks[0]
+	f := &file{
 		Hash:         checksum,
 		OpenCount:    0,
 		ReplicaCount: 1,
 		Size:         size,
+		Stored:       size,
+		disk:         d,
 	}
+	h.s[checksum] = f
+	d.count(f, 1)
 }
 
+// Ref adds n references to the file with the given hash, it is called when
+// the master copies a file without moving its content
+func (h *HashStore) Ref(hash string, n int32) error {
+	if n <= 0 {
+		return NewError(ErrInvalidArgument, "invalid number of references")
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	f, ok := h.s[hash]
+	if !ok {
+		return NewError(ErrNotFound, "file not found")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.record()
+	r.Refs += n
+	if err := h.update(func(tx MetaTx) error { return putJSON(tx, bucketObjects, hash, r) }); err != nil {
+		return err
+	}
+	f.ReplicaCount += n
+	f.Referenced = time.Now()
+	return nil
+}
+
+// Remove drops a reference to the file with the given hash, the file is
+// removed with its last reference
 func (h *HashStore) Remove(hash string) error {
+	return h.remove(hash, false)
+}
+
+// RemoveAll removes the file with the given hash regardless of its
+// references, the master calls it once no path references the file
+func (h *HashStore) RemoveAll(hash string) error {
+	return h.remove(hash, true)
+}
+
+func (h *HashStore) remove(hash string, all bool) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 	if f, ok := h.s[hash]; ok {
 		f.mu.Lock()
 		defer f.mu.Unlock()
-		if f.ReplicaCount > 1 {
+		if f.ReplicaCount > 1 && !all {
+			r := f.record()
+			r.Refs--
+			if err := h.update(func(tx MetaTx) error { return putJSON(tx, bucketObjects, hash, r) }); err != nil {
+				return err
+			}
 			f.ReplicaCount--
 			return nil
 		}
 		if f.OpenCount != 0 {
-			return errors.New("file is being accessed by other goroutine")
+			return NewError(ErrBusy, "file is being accessed by other goroutine")
 		}
-		h.Size -= f.Size
-		delete(h.s, hash)
-		return nil
+		return h.drop(f)
+	}
+	return NewError(ErrNotFound, "file not found")
+}
+
+// drop removes f from h and its file from its disk, h.mu and f.mu should be
+// held by the caller
+func (h *HashStore) drop(f *file) error {
+	if err := h.update(func(tx MetaTx) error { return tx.Delete(bucketObjects, f.Hash) }); err != nil {
+		return err
+	}
+	if err := os.Remove(f.Path()); err != nil && !os.IsNotExist(err) {
+		log.Errorf("failed to remove file of %s: %q", f.Hash, err)
 	}
-	return errors.New("file not found")
+	h.Size -= f.Size
+	f.disk.count(f, -1)
+	delete(h.s, f.Hash)
+	return nil
 }
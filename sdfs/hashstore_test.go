@@ -0,0 +1,208 @@
+package sdfs
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func sumOf(data string) string {
+	s := sha256.Sum256([]byte(data))
+	return encodeSum(s[:])
+}
+
+// putObject writes data as the object with the given hash behind the back
+// of h
+func putObject(t *testing.T, h *HashStore, hash, data string) {
+	t.Helper()
+	p := h.Disks[0].Path(hash)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashStoreIndex(t *testing.T) {
+	dir := t.TempDir()
+	index := NewMemIndex()
+	h, err := OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _, err := h.Add(strings.NewReader("aaa"))
+	if err != nil || a != sumOf("aaa") {
+		t.Fatalf("want %s added, have: %s, %v", sumOf("aaa"), a, err)
+	}
+	h.Add(strings.NewReader("aaa"))
+	if err := h.Ref(a, 2); err != nil {
+		t.Fatal(err)
+	}
+	b, _, _ := h.Add(strings.NewReader("bbbb"))
+	c, _, _ := h.Add(strings.NewReader("cc"))
+	if err := h.RemoveAll(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(h.Disks[0].Path(c)); !os.IsNotExist(err) {
+		t.Errorf("removed object still on disk: %v", err)
+	}
+
+	// an unfinished write, a lost object, an object the index does not know
+	// and a corrupt one
+	os.WriteFile(filepath.Join(dir, tmpPrefix+"x"), []byte("partial"), 0644)
+	os.Remove(h.Disks[0].Path(b))
+	putObject(t, h, sumOf("dd"), "dd")
+	putObject(t, h, sumOf("ee"), "not ee")
+
+	h, err = OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := h.Get(a); err != nil || f.ReplicaCount != 4 || f.Size != 3 {
+		t.Errorf("want 4 references of %s restored, have: %+v, %v", a, f, err)
+	}
+	if _, err := h.Get(b); err == nil {
+		t.Errorf("lost object %s still indexed", b)
+	}
+	if f, err := h.Get(sumOf("dd")); err != nil || f.ReplicaCount != 1 {
+		t.Errorf("unindexed object not added, have: %+v, %v", f, err)
+	}
+	if _, err := h.Get(sumOf("ee")); err == nil {
+		t.Errorf("corrupt object added")
+	}
+	if _, err := os.Stat(filepath.Join(dir, tmpPrefix+"x")); !os.IsNotExist(err) {
+		t.Errorf("unfinished write not removed: %v", err)
+	}
+	if h.GetSize() != 5 {
+		t.Errorf("want size 5, have: %d", h.GetSize())
+	}
+	h.Remove(a)
+	h, _ = OpenHashStore([]string{dir}, index)
+	if f, _ := h.Get(a); f.ReplicaCount != 3 {
+		t.Errorf("want 3 references after remove, have: %d", f.ReplicaCount)
+	}
+}
+
+func TestHashStoreBoltIndex(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(t.TempDir(), "index.db")
+	index, err := OpenHashIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _, _ := h.Add(strings.NewReader("aaa"))
+	h.Ref(a, 1)
+	h.Close()
+	if index, err = OpenHashIndex(path); err != nil {
+		t.Fatal(err)
+	}
+	h, err = OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if f, err := h.Get(a); err != nil || f.ReplicaCount != 2 || f.Created.IsZero() {
+		t.Errorf("object not restored, have: %+v, %v", f, err)
+	}
+}
+
+func TestHashStoreLayout(t *testing.T) {
+	dir := t.TempDir()
+	// version 1 kept objects in dir named by their hash
+	for _, data := range []string{"aaa", "bbb"} {
+		os.WriteFile(filepath.Join(dir, sumOf(data)), []byte(data), 0644)
+	}
+	if _, err := OpenHashStore([]string{dir}, nil); !errors.Is(err, ErrConflict) {
+		t.Fatalf("want error opening old layout, have: %v", err)
+	}
+	n, err := MigrateLayout(dir)
+	if err != nil || n != 2 {
+		t.Fatalf("want 2 objects migrated, have: %d, %v", n, err)
+	}
+	if n, err := MigrateLayout(dir); err != nil || n != 0 {
+		t.Errorf("want nothing to migrate again, have: %d, %v", n, err)
+	}
+	h, err := OpenHashStore([]string{dir}, NewMemIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"aaa", "bbb"} {
+		if _, err := h.Get(sumOf(data)); err != nil {
+			t.Errorf("migrated object %s not found: %v", data, err)
+		}
+	}
+	a, _, _ := h.Add(strings.NewReader("ccc"))
+	name := objectName(a)
+	if p := h.Disks[0].Path(a); p != filepath.Join(dir, name[:2], name[2:4], name) || len(name) != 64 {
+		t.Errorf("unexpected object path %s", p)
+	}
+	if _, err := os.Stat(h.Disks[0].Path(a)); err != nil {
+		t.Errorf("object not stored at its path: %v", err)
+	}
+	if v, _ := readLayout(dir); v != LayoutVersion {
+		t.Errorf("want layout version %d, have: %d", LayoutVersion, v)
+	}
+}
+
+func TestHashStoreAddChecked(t *testing.T) {
+	h, err := OpenHashStore([]string{t.TempDir()}, NewMemIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := h.AddChecked(strings.NewReader("aaa"), sumOf("aaa")); err != nil || size != 3 {
+		t.Errorf("want content with matching hash stored, have: %d, %v", size, err)
+	}
+	if _, err := h.AddChecked(strings.NewReader("aab"), sumOf("bbb")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("want ErrChecksumMismatch, have: %v", err)
+	}
+	if _, err := h.Get(sumOf("aab")); err == nil {
+		t.Errorf("mismatching content stored")
+	}
+	entries, _ := os.ReadDir(h.Disks[0].Dir)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), tmpPrefix) {
+			t.Errorf("mismatching content left in %s", e.Name())
+		}
+	}
+}
+
+func TestHashStoreOpenVerified(t *testing.T) {
+	h, err := OpenHashStore([]string{t.TempDir()}, NewMemIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _, _ := h.Add(strings.NewReader("aaa"))
+	b, _, _ := h.Add(strings.NewReader("bbb"))
+	os.WriteFile(h.Disks[0].Path(b), []byte("bbc"), 0644)
+	read := func(hash string) (string, error) {
+		f, err := h.Get(hash)
+		if err != nil {
+			return "", err
+		}
+		r, err := h.OpenVerified(f)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		return string(data), err
+	}
+	if data, err := read(a); err != nil || data != "aaa" {
+		t.Errorf("want aaa read, have: %q, %v", data, err)
+	}
+	if _, err := read(b); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("want ErrChecksumMismatch, have: %v", err)
+	}
+	if _, err := h.Get(b); err == nil {
+		t.Errorf("corrupt object still served")
+	}
+}
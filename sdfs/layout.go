@@ -0,0 +1,136 @@
+package sdfs
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Lyianu/sdfs/log"
+)
+
+// LayoutVersion is the version of the layout of hashstore directories
+// written by this version of SDFS. Version 1 kept every object in the
+// directory itself named by its hash, version 2 names objects by the hex
+// encoding of their sha256 sum and fans them out as ab/cd/<hex>
+const LayoutVersion = 2
+
+// layoutFile is the file in a hashstore directory that holds the version of
+// its layout, directories of version 1 do not have it
+const layoutFile = "LAYOUT"
+
+// objectName returns the name of the file of the object with the given
+// hash, hashes that are not sha256 sums, which Add never returns, are named
+// by the hex encoding of their bytes
+func objectName(hash string) string {
+	b, err := base64.StdEncoding.DecodeString(strings.Replace(hash, "_", "/", -1))
+	if err != nil || len(b) != 32 {
+		return hex.EncodeToString([]byte(hash))
+	}
+	return hex.EncodeToString(b)
+}
+
+// hashOfName returns the hash of the object stored in the file name
+func hashOfName(name string) (string, error) {
+	b, err := hex.DecodeString(name)
+	if err != nil || len(b) != 32 {
+		return "", NewError(ErrInvalidArgument, "%s is not an object name", name)
+	}
+	return encodeSum(b), nil
+}
+
+// objectPath returns the path of the file name in the hashstore directory
+// dir
+func objectPath(dir, name string) string {
+	return filepath.Join(dir, name[:2], name[2:4], name)
+}
+
+// readLayout returns the layout version of the hashstore directory dir, 0 if
+// dir has neither a layout file nor objects of version 1
+func readLayout(dir string) (int, error) {
+	b, err := os.ReadFile(filepath.Join(dir, layoutFile))
+	if err == nil {
+		return strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	if !os.IsNotExist(err) {
+		return 0, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), tmpPrefix) {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// writeLayout records version as the layout version of dir
+func writeLayout(dir string, version int) error {
+	tmp := filepath.Join(dir, tmpPrefix+layoutFile)
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(version)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, layoutFile))
+}
+
+// checkLayout makes sure dir is of the current layout version, new
+// directories are marked with it
+func checkLayout(dir string) error {
+	v, err := readLayout(dir)
+	if err != nil {
+		return err
+	}
+	switch v {
+	case LayoutVersion:
+		return nil
+	case 0:
+		return writeLayout(dir, LayoutVersion)
+	}
+	return NewError(ErrConflict, "hashstore %s has layout version %d, migrate it to version %d first", dir, v, LayoutVersion)
+}
+
+// MigrateLayout moves the objects of the hashstore directory dir to the
+// current layout in place, it returns the number of objects moved. Files
+// that are not objects are left where they are. An interrupted migration is
+// finished by running it again
+func MigrateLayout(dir string) (int, error) {
+	v, err := readLayout(dir)
+	if err != nil {
+		return 0, err
+	}
+	if v == LayoutVersion {
+		return 0, nil
+	}
+	if v > LayoutVersion {
+		return 0, NewError(ErrConflict, "hashstore %s has unknown layout version %d", dir, v)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for _, e := range entries {
+		hash := e.Name()
+		if e.IsDir() || strings.HasPrefix(hash, tmpPrefix) {
+			continue
+		}
+		if _, err := base64.StdEncoding.DecodeString(strings.Replace(hash, "_", "/", -1)); err != nil {
+			log.Errorf("%s is not an object, leaving it", hash)
+			continue
+		}
+		p := objectPath(dir, objectName(hash))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return moved, err
+		}
+		if err := os.Rename(filepath.Join(dir, hash), p); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, writeLayout(dir, LayoutVersion)
+}
@@ -0,0 +1,259 @@
+package sdfs
+
+import (
+	"strings"
+)
+
+// MaxSymlinkHops limits how many symlinks a single lookup follows, lookups
+// exceeding it are considered to be in a loop
+const MaxSymlinkHops = 40
+
+var errTooManyLinks = NewError(ErrInvalidPath, "too many levels of symbolic links")
+
+func splitPath(path string) []string {
+	var parts []string
+	for _, p := range strings.Split(path, "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+// rootOf returns the root directory of the namespace dir belongs to
+func rootOf(dir *Directory) *Directory {
+	for dir.Parent != nil {
+		dir = dir.Parent
+	}
+	return dir
+}
+
+// linkStart returns the directory the target of a symlink in dir is resolved
+// from, and the target without its namespace
+func (f *FS) linkStart(dir *Directory, target string) (*Directory, string, error) {
+	if ns, _ := SplitNamespace(target); ns != "" {
+		return f.root(target)
+	}
+	if strings.HasPrefix(target, "/") {
+		return rootOf(dir), target, nil
+	}
+	return dir, target, nil
+}
+
+// walk follows parts from dir and returns the directory they lead to,
+// symlinks met on the way are followed, hops counts the symlinks followed
+// during the whole lookup
+func (f *FS) walk(dir *Directory, parts []string, hops *int) (*Directory, error) {
+	for i := 0; i < len(parts); i++ {
+		part := parts[i]
+		switch part {
+		case ".":
+			continue
+		case "..":
+			if dir.Parent != nil {
+				dir = dir.Parent
+			}
+			continue
+		}
+		dir.mu.RLock()
+		sub, ok := dir.SubDirs[part]
+		dir.mu.RUnlock()
+		if ok {
+			dir = sub
+			continue
+		}
+		// only a symlink needs the entries of dir
+		if err := f.rlock(dir); err != nil {
+			return nil, err
+		}
+		target, isLink := dir.Symlinks[part]
+		dir.mu.RUnlock()
+		if !isLink {
+			return nil, NewError(ErrNotFound, "directory not exist")
+		}
+		*hops++
+		if *hops > MaxSymlinkHops {
+			return nil, errTooManyLinks
+		}
+		start, rest, err := f.linkStart(dir, target)
+		if err != nil {
+			return nil, err
+		}
+		dir = start
+		parts = append(splitPath(rest), parts[i+1:]...)
+		i = -1
+	}
+	return dir, nil
+}
+
+// lookup resolves path to the directory that contains its last element and
+// the name of the element, a symlink at the last element is followed only
+// when follow is true
+func (f *FS) lookup(p Path, follow bool) (*Directory, string, error) {
+	hops := 0
+	dir, err := f.rootDir(p)
+	if err != nil {
+		return nil, "", err
+	}
+	parts := p.Parts
+	for {
+		if len(parts) == 0 {
+			return nil, "", NewError(ErrNotFound, "file not exist")
+		}
+		dir, err = f.walk(dir, parts[:len(parts)-1], &hops)
+		if err != nil {
+			return nil, "", err
+		}
+		name := parts[len(parts)-1]
+		if !follow {
+			return dir, name, nil
+		}
+		if err := f.rlock(dir); err != nil {
+			return nil, "", err
+		}
+		target, ok := dir.Symlinks[name]
+		dir.mu.RUnlock()
+		if !ok {
+			return dir, name, nil
+		}
+		hops++
+		if hops > MaxSymlinkHops {
+			return nil, "", errTooManyLinks
+		}
+		start, rest, err := f.linkStart(dir, target)
+		if err != nil {
+			return nil, "", err
+		}
+		dir = start
+		parts = splitPath(rest)
+	}
+}
+
+// exists reports whether dir has an entry of any kind with the given name,
+// dir.mu should be held by the caller
+func (d *Directory) exists(name string) bool {
+	if _, ok := d.Files[name]; ok {
+		return true
+	}
+	if _, ok := d.SubDirs[name]; ok {
+		return true
+	}
+	_, ok := d.Symlinks[name]
+	return ok
+}
+
+// Link creates a hard link at dst to the file at src, both paths will share
+// the same File
+func (f *FS) Link(src, dst string) (*File, error) {
+	file, err := f.GetFile(src)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParsePath(dst)
+	if err != nil {
+		return nil, err
+	}
+	if p.IsRoot() {
+		return nil, &PathError{dst, "no file name"}
+	}
+	f.mu.Lock()
+	physical := uint64(file.physical())
+	f.mu.Unlock()
+	if err := f.checkQuota(p, file.Size, physical, 1); err != nil {
+		return nil, err
+	}
+	if _, err := f.addDir(p.Dir()); err != nil {
+		return nil, err
+	}
+	dir, name, err := f.lookup(p, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.lock(dir); err != nil {
+		return nil, err
+	}
+	defer f.unlock(dir)
+	if dir.exists(name) {
+		return nil, NewError(ErrAlreadyExists, "file exists")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := refs{}
+	r.add(file.Checksum, 1, 0)
+	err = f.update(func(tx MetaTx) error {
+		// src may have been deleted since, along with the file
+		if file, err = f.readFile(tx, file.Checksum); err != nil {
+			return err
+		}
+		if err := putLink(tx, dir.FullPath+name, file.Checksum); err != nil {
+			return err
+		}
+		_, err := f.putRefs(tx, r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	file = f.linkFile(dir, name, file)
+	f.applyRefs(r)
+	return file, nil
+}
+
+// Symlink creates a symbolic link at linkPath pointing to target, target is
+// not required to exist, relative targets are resolved from the directory
+// of the link
+func (f *FS) Symlink(target, linkPath string) error {
+	if err := validateTarget(target); err != nil {
+		return err
+	}
+	p, err := ParsePath(linkPath)
+	if err != nil {
+		return err
+	}
+	if p.IsRoot() {
+		return &PathError{linkPath, "no file name"}
+	}
+	if _, err := f.addDir(p.Dir()); err != nil {
+		return err
+	}
+	dir, name, err := f.lookup(p, false)
+	if err != nil {
+		return err
+	}
+	if err := f.lock(dir); err != nil {
+		return err
+	}
+	defer f.unlock(dir)
+	if dir.exists(name) {
+		return NewError(ErrAlreadyExists, "file exists")
+	}
+	err = f.update(func(tx MetaTx) error {
+		return tx.Put(bucketSymlinks, dir.FullPath+name, []byte(target))
+	})
+	if err != nil {
+		return err
+	}
+	dir.Symlinks[name] = target
+	return nil
+}
+
+// Readlink returns the target of the symbolic link at the given path
+func (f *FS) Readlink(path string) (string, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return "", err
+	}
+	dir, name, err := f.lookup(p, false)
+	if err != nil {
+		return "", err
+	}
+	if err := f.rlock(dir); err != nil {
+		return "", err
+	}
+	defer dir.mu.RUnlock()
+	target, ok := dir.Symlinks[name]
+	if !ok {
+		return "", NewError(ErrInvalidArgument, "not a symlink")
+	}
+	return target, nil
+}
@@ -0,0 +1,67 @@
+package sdfs
+
+import (
+	"testing"
+)
+
+func TestFsLink(t *testing.T) {
+	fs := NewFS()
+	fs.AddFile("/foo/bar.go", "foobar")
+	if _, err := fs.Link("/foo/bar.go", "/baz/qux.go"); err != nil {
+		t.Fatalf("Got error when linking: %q", err)
+	}
+	bar, _ := fs.GetFile("/foo/bar.go")
+	qux, err := fs.GetFile("/baz/qux.go")
+	if err != nil {
+		t.Fatalf("link not found, got error: %q", err)
+	}
+	if bar != qux || qux.SemaphoreReplica != 2 {
+		t.Errorf("link does not share file, have: %+v", qux)
+	}
+	if _, err := fs.Link("/foo/bar.go", "/baz/qux.go"); err == nil {
+		t.Errorf("want error when linking to existing path, have nil")
+	}
+	fs.DeleteFile("/foo/bar.go")
+	if _, err := fs.GetFile("/baz/qux.go"); err != nil {
+		t.Errorf("link removed with original path, got error: %q", err)
+	}
+}
+
+func TestFsSymlink(t *testing.T) {
+	fs := NewFS()
+	fs.AddFile("/foo/bar/baz.go", "foobar")
+	if err := fs.Symlink("/foo/bar", "/link"); err != nil {
+		t.Fatalf("Got error when creating symlink: %q", err)
+	}
+	if err := fs.Symlink("../foo/bar/baz.go", "/qux/rel.go"); err != nil {
+		t.Fatalf("Got error when creating symlink: %q", err)
+	}
+	for _, p := range []string{"/link/baz.go", "/qux/rel.go"} {
+		file, err := fs.GetFile(p)
+		if err != nil || file.Checksum != "foobar" {
+			t.Errorf("symlink %s not resolved, have: %+v, error: %v", p, file, err)
+		}
+	}
+	if dir, err := fs.GetDir("/link"); err != nil || dir.FullPath != "/foo/bar/" {
+		t.Errorf("symlinked dir not resolved, have: %+v, error: %v", dir, err)
+	}
+	if target, err := fs.Readlink("/link"); err != nil || target != "/foo/bar" {
+		t.Errorf("Readlink want: %q, have: %q, error: %v", "/foo/bar", target, err)
+	}
+	fs.DeleteFile("/link")
+	if _, err := fs.GetFile("/foo/bar/baz.go"); err != nil {
+		t.Errorf("deleting symlink removed its target, got error: %q", err)
+	}
+}
+
+func TestFsSymlinkLoop(t *testing.T) {
+	fs := NewFS()
+	fs.Symlink("/b", "/a")
+	fs.Symlink("/a", "/b")
+	if _, err := fs.GetFile("/a/foo"); err != errTooManyLinks {
+		t.Errorf("want %q, have %v", errTooManyLinks, err)
+	}
+	if _, err := fs.GetFile("/a"); err != errTooManyLinks {
+		t.Errorf("want %q, have %v", errTooManyLinks, err)
+	}
+}
@@ -0,0 +1,253 @@
+package sdfs
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultNamespace is the namespace of paths without a namespace prefix, its
+// root is Roots[0]
+const DefaultNamespace = "default"
+
+// Namespace is a named tree in the FS(a bucket), paths in a namespace other
+// than the default one are written as "name:/path"
+type Namespace struct {
+	Name string
+	Root *Directory
+	// Quota limits the logical bytes stored in the namespace, 0 for unlimited
+	Quota uint64
+	// ReplicaCount is the replication factor of files uploaded to the
+	// namespace
+	ReplicaCount int
+	// TrashRetention is how long deleted files are kept in the trash, 0 for
+	// DefaultTrashRetention, it is guarded by the namespace lock of the FS
+	TrashRetention time.Duration
+}
+
+// CheckQuota checks if size more bytes can be stored in the namespace
+func (ns *Namespace) CheckQuota(size uint64) error {
+	if ns.Quota != 0 && ns.Root.TotalSize()+size > ns.Quota {
+		return NewError(ErrNoCapacity, "namespace quota exceeded")
+	}
+	return nil
+}
+
+// ValidateNamespaceName checks if name can be used as a namespace name, names
+// are 3-63 characters of lowercase letters, digits and '-'
+func ValidateNamespaceName(name string) error {
+	if len(name) < 3 || len(name) > 63 {
+		return NewError(ErrInvalidArgument, "invalid namespace name length")
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return NewError(ErrInvalidArgument, "invalid character in namespace name")
+		}
+	}
+	return nil
+}
+
+// JoinNamespace returns path qualified by namespace ns
+func JoinNamespace(ns, path string) string {
+	if ns == "" || ns == DefaultNamespace {
+		return path
+	}
+	return ns + ":" + path
+}
+
+// SplitNamespace splits a qualified path into its namespace and the path in
+// the namespace, ns is empty for paths in the default namespace
+func SplitNamespace(path string) (ns, p string) {
+	if strings.HasPrefix(path, "/") {
+		return "", path
+	}
+	i := strings.Index(path, ":")
+	if i <= 0 || strings.Contains(path[:i], "/") {
+		return "", path
+	}
+	p = path[i+1:]
+	if p == "" {
+		p = "/"
+	}
+	return path[:i], p
+}
+
+// root returns the root directory of the namespace path belongs to, and the
+// path without its namespace
+func (f *FS) root(path string) (*Directory, string, error) {
+	name, p := SplitNamespace(path)
+	ns, err := f.GetNamespace(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return ns.Root, p, nil
+}
+
+// rootDir returns the root directory of the namespace of p
+func (f *FS) rootDir(p Path) (*Directory, error) {
+	ns, err := f.GetNamespace(p.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	return ns.Root, nil
+}
+
+// GetNamespace returns the namespace with the given name, an empty name means
+// the default namespace
+func (f *FS) GetNamespace(name string) (*Namespace, error) {
+	if name == "" {
+		name = DefaultNamespace
+	}
+	f.nsMu.RLock()
+	defer f.nsMu.RUnlock()
+	ns, ok := f.Namespaces[name]
+	if !ok {
+		return nil, NewError(ErrNotFound, "namespace not exist")
+	}
+	return ns, nil
+}
+
+// NamespaceOf returns the namespace a qualified path belongs to
+func (f *FS) NamespaceOf(path string) (*Namespace, error) {
+	name, _ := SplitNamespace(path)
+	return f.GetNamespace(name)
+}
+
+// CreateNamespace creates an empty namespace, replicaCount falls back to the
+// replication factor of the default namespace when it is not positive
+func (f *FS) CreateNamespace(name string, quota uint64, replicaCount int) (*Namespace, error) {
+	if err := ValidateNamespaceName(name); err != nil {
+		return nil, err
+	}
+	f.nsMu.Lock()
+	defer f.nsMu.Unlock()
+	if _, ok := f.Namespaces[name]; ok {
+		return nil, NewError(ErrAlreadyExists, "namespace exists")
+	}
+	if replicaCount <= 0 {
+		replicaCount = f.Namespaces[DefaultNamespace].ReplicaCount
+	}
+	err := f.update(func(tx MetaTx) error {
+		return putJSON(tx, bucketNamespaces, name, nsRecord{Quota: quota, ReplicaCount: replicaCount})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f.addNamespace(name, quota, replicaCount), nil
+}
+
+// addNamespace adds an empty namespace in memory, f.nsMu should be held by
+// the caller
+func (f *FS) addNamespace(name string, quota uint64, replicaCount int) *Namespace {
+	ns := &Namespace{
+		Name:         name,
+		Root:         NewDirectory("/", JoinNamespace(name, "/")),
+		Quota:        quota,
+		ReplicaCount: replicaCount,
+	}
+	f.Namespaces[name] = ns
+	f.Roots = append(f.Roots, ns.Root)
+	f.mu.Lock()
+	f.dirs[ns.Root.FullPath] = ns.Root
+	f.mu.Unlock()
+	return ns
+}
+
+// DeleteNamespace deletes an empty namespace, the default namespace can not
+// be deleted
+func (f *FS) DeleteNamespace(name string) error {
+	if name == DefaultNamespace {
+		return NewError(ErrInvalidArgument, "default namespace can not be deleted")
+	}
+	f.trashMu.RLock()
+	defer f.trashMu.RUnlock()
+	f.snapMu.RLock()
+	defer f.snapMu.RUnlock()
+	f.nsMu.Lock()
+	defer f.nsMu.Unlock()
+	ns, ok := f.Namespaces[name]
+	if !ok {
+		return NewError(ErrNotFound, "namespace not exist")
+	}
+	empty, err := f.empty(ns.Root)
+	if err != nil {
+		return err
+	} else if !empty {
+		return NewError(ErrConflict, "namespace not empty")
+	}
+	for _, e := range f.Trash {
+		if e.Namespace == name {
+			return NewError(ErrConflict, "trash of namespace not empty")
+		}
+	}
+	// snapshots pin their files and would come back with a namespace of the
+	// same name
+	for _, snap := range f.Snapshots {
+		if ns, _ := SplitNamespace(snap.Path); ns == name {
+			return NewError(ErrConflict, "namespace has snapshots")
+		}
+	}
+	err = f.update(func(tx MetaTx) error {
+		var dirs []string
+		err := tx.ForEach(bucketDirs, ns.Root.FullPath, func(k string, v []byte) error {
+			dirs = append(dirs, k)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, d := range dirs {
+			if err := tx.Delete(bucketDirs, d); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(bucketNamespaces, name)
+	})
+	if err != nil {
+		return err
+	}
+	delete(f.Namespaces, name)
+	for i, r := range f.Roots {
+		if r == ns.Root {
+			f.Roots = append(f.Roots[:i], f.Roots[i+1:]...)
+			break
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for path, d := range f.dirs {
+		if strings.HasPrefix(path, ns.Root.FullPath) {
+			delete(f.dirs, path)
+			f.cache.remove(d)
+		}
+	}
+	return nil
+}
+
+// empty reports whether there is no file or symlink in the tree of d
+func (f *FS) empty(d *Directory) (bool, error) {
+	var used bool
+	err := f.view(func(tx MetaTx) error {
+		var err error
+		if used, err = hasKeys(tx, bucketFiles, d.FullPath); err != nil || used {
+			return err
+		}
+		used, err = hasKeys(tx, bucketSymlinks, d.FullPath)
+		return err
+	})
+	return !used, err
+}
+
+// ListNamespaces returns all namespaces sorted by name
+func (f *FS) ListNamespaces() []*Namespace {
+	f.nsMu.RLock()
+	defer f.nsMu.RUnlock()
+	l := make([]*Namespace, 0, len(f.Namespaces))
+	for _, ns := range f.Namespaces {
+		l = append(l, ns)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Name < l[j].Name
+	})
+	return l
+}
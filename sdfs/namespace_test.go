@@ -0,0 +1,79 @@
+package sdfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSplitNamespace(t *testing.T) {
+	tests := []struct {
+		path, ns, p string
+	}{
+		{"/foo/bar", "", "/foo/bar"},
+		{"logs:/foo/bar", "logs", "/foo/bar"},
+		{"logs:", "logs", "/"},
+		{"/foo:/bar", "", "/foo:/bar"},
+	}
+	for _, test := range tests {
+		ns, p := SplitNamespace(test.path)
+		if ns != test.ns || p != test.p {
+			t.Errorf("SplitNamespace(%q) want: (%q, %q), have: (%q, %q)", test.path, test.ns, test.p, ns, p)
+		}
+	}
+}
+
+func TestFsNamespace(t *testing.T) {
+	fs := NewFS()
+	if _, err := fs.CreateNamespace("logs", 1024, 2); err != nil {
+		t.Fatalf("Got error when creating namespace: %q", err)
+	}
+	if _, err := fs.CreateNamespace("logs", 0, 0); err == nil {
+		t.Errorf("want error when creating existing namespace, have nil")
+	}
+	fs.AddSizedFile("logs:/foo/bar.go", "foobar", 6)
+	if _, err := fs.GetFile("/foo/bar.go"); err == nil {
+		t.Errorf("file in namespace logs found in default namespace")
+	}
+	file, err := fs.GetFile("logs:/foo/bar.go")
+	if err != nil {
+		t.Fatalf("file not found in namespace, got error: %q", err)
+	}
+	if p, _ := fs.Paths(file.Checksum); len(p) != 1 || p[0] != "logs:/foo/bar.go" {
+		t.Errorf("file paths not correct, have: %v", p)
+	}
+	ns, _ := fs.GetNamespace("logs")
+	if ns.Root.Size != 6 || ns.ReplicaCount != 2 {
+		t.Errorf("namespace not correct, have: %+v", ns)
+	}
+	if err := fs.DeleteNamespace("logs"); err == nil {
+		t.Errorf("want error when deleting non-empty namespace, have nil")
+	}
+	fs.DeleteFile("logs:/foo/bar.go")
+	if err := fs.DeleteNamespace("logs"); err != nil {
+		t.Errorf("Got error when deleting namespace: %q", err)
+	}
+	if len(fs.ListNamespaces()) != 1 || len(fs.Roots) != 1 {
+		t.Errorf("namespace not deleted, have: %v", fs.ListNamespaces())
+	}
+}
+
+func TestFsDeleteNamespaceSnapshots(t *testing.T) {
+	fs := NewFS()
+	fs.CreateNamespace("logs", 0, 0)
+	fs.AddSizedFile("logs:/foo/bar.go", "foobar", 6)
+	if _, err := fs.CreateSnapshot("logs:/foo", "s1", time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	fs.DeleteFile("logs:/foo/bar.go")
+	if err := fs.DeleteNamespace("logs"); !errors.Is(err, ErrConflict) {
+		t.Errorf("want namespace with snapshots kept, have: %v", err)
+	}
+	orphans, err := fs.DeleteSnapshot("logs:/foo", "s1")
+	if err != nil || len(orphans) != 1 {
+		t.Fatalf("want the snapshotted file released, have: %v, %v", orphans, err)
+	}
+	if err := fs.DeleteNamespace("logs"); err != nil {
+		t.Errorf("Got error when deleting namespace: %q", err)
+	}
+}
@@ -0,0 +1,171 @@
+package sdfs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	MaxPathLength      = 4096
+	MaxComponentLength = 255
+)
+
+// ErrInvalidPath is matched by every error caused by a malformed path
+var ErrInvalidPath = errors.New("invalid path")
+
+// reservedNames can not be used as a file or directory name, they are kept
+// for SDFS internal entries
+var reservedNames = map[string]struct{}{
+	".sdfs": {},
+}
+
+// PathError records a path that failed validation and the reason
+type PathError struct {
+	Path   string
+	Reason string
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("invalid path %q: %s", e.Path, e.Reason)
+}
+
+func (e *PathError) Unwrap() error {
+	return ErrInvalidPath
+}
+
+// Path is a cleaned and validated path in the SDFS namespace, it should be
+// created with ParsePath
+type Path struct {
+	// Namespace is empty for the default namespace
+	Namespace string
+	// Parts contains the components of the path, it is empty for the root
+	Parts []string
+}
+
+// ParsePath cleans and validates a path, "." and empty components are
+// dropped and ".." removes the previous component, relative paths are
+// considered relative to the root of their namespace
+func ParsePath(s string) (Path, error) {
+	if s == "" {
+		return Path{}, &PathError{s, "empty path"}
+	}
+	if len(s) > MaxPathLength {
+		return Path{}, &PathError{s, "path too long"}
+	}
+	ns, rest := SplitNamespace(s)
+	if ns == DefaultNamespace {
+		ns = ""
+	}
+	if ns != "" {
+		if err := ValidateNamespaceName(ns); err != nil {
+			return Path{}, &PathError{s, err.Error()}
+		}
+	}
+	p := Path{Namespace: ns}
+	for _, part := range strings.Split(rest, "/") {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(p.Parts) == 0 {
+				return Path{}, &PathError{s, "path escapes the root"}
+			}
+			p.Parts = p.Parts[:len(p.Parts)-1]
+			continue
+		}
+		if err := validateName(part); err != nil {
+			return Path{}, &PathError{s, err.Error()}
+		}
+		p.Parts = append(p.Parts, part)
+	}
+	return p, nil
+}
+
+// validateName checks if name can be used as a file or directory name
+func validateName(name string) error {
+	if len(name) > MaxComponentLength {
+		return errors.New("name too long")
+	}
+	if _, ok := reservedNames[name]; ok {
+		return fmt.Errorf("name %q is reserved", name)
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f {
+			return errors.New("control character in name")
+		}
+	}
+	return nil
+}
+
+// validateTarget checks a symlink target, targets are kept as they are so
+// only characters and length are checked
+func validateTarget(target string) error {
+	if target == "" {
+		return &PathError{target, "empty symlink target"}
+	}
+	if len(target) > MaxPathLength {
+		return &PathError{target, "path too long"}
+	}
+	for _, c := range target {
+		if c < 0x20 || c == 0x7f {
+			return &PathError{target, "control character in name"}
+		}
+	}
+	return nil
+}
+
+// String returns the canonical form of the path
+func (p Path) String() string {
+	return JoinNamespace(p.Namespace, "/"+strings.Join(p.Parts, "/"))
+}
+
+// IsRoot reports whether p is the root of its namespace
+func (p Path) IsRoot() bool {
+	return len(p.Parts) == 0
+}
+
+// Dir returns the parent of p, the parent of a root is itself
+func (p Path) Dir() Path {
+	if p.IsRoot() {
+		return p
+	}
+	return Path{Namespace: p.Namespace, Parts: p.Parts[:len(p.Parts)-1]}
+}
+
+// Base returns the last component of p, it is empty for a root
+func (p Path) Base() string {
+	if p.IsRoot() {
+		return ""
+	}
+	return p.Parts[len(p.Parts)-1]
+}
+
+// Join returns the path of name under p
+func (p Path) Join(name string) Path {
+	parts := make([]string, len(p.Parts), len(p.Parts)+1)
+	copy(parts, p.Parts)
+	return Path{Namespace: p.Namespace, Parts: append(parts, name)}
+}
+
+// HasPrefix reports whether p is prefix or under it
+func (p Path) HasPrefix(prefix Path) bool {
+	if p.Namespace != prefix.Namespace || len(p.Parts) < len(prefix.Parts) {
+		return false
+	}
+	for i := range prefix.Parts {
+		if p.Parts[i] != prefix.Parts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CleanPath returns the canonical form of a path string
+func CleanPath(s string) (string, error) {
+	p, err := ParsePath(s)
+	if err != nil {
+		return "", err
+	}
+	return p.String(), nil
+}
@@ -0,0 +1,63 @@
+package sdfs
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"/", "/"},
+		{"foo", "/foo"},
+		{"//a//b/", "/a/b"},
+		{"/a/./b/../c", "/a/c"},
+		{"logs:/a/", "logs:/a"},
+		{"default:/a", "/a"},
+		{"/a:b", "/a:b"},
+	}
+	for _, test := range tests {
+		p, err := ParsePath(test.path)
+		if err != nil {
+			t.Errorf("ParsePath(%q) got error: %q", test.path, err)
+			continue
+		}
+		if p.String() != test.want {
+			t.Errorf("ParsePath(%q) want: %q, have: %q", test.path, test.want, p.String())
+		}
+	}
+}
+
+func TestParsePathInvalid(t *testing.T) {
+	for _, path := range []string{"", "/..", "/a/../..", "/a\x00b", "/.sdfs/x", "BAD:/a", "/" + strings.Repeat("a", MaxComponentLength+1)} {
+		if _, err := ParsePath(path); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("ParsePath(%q) want ErrInvalidPath, have: %v", path, err)
+		}
+	}
+}
+
+func TestPathDirBase(t *testing.T) {
+	p, _ := ParsePath("logs:/a/b")
+	if p.Dir().String() != "logs:/a" || p.Base() != "b" {
+		t.Errorf("want dir: %q, base: %q, have dir: %q, base: %q", "logs:/a", "b", p.Dir().String(), p.Base())
+	}
+	root, _ := ParsePath("/")
+	if !root.IsRoot() || root.Dir().String() != "/" || root.Base() != "" {
+		t.Errorf("root not correct, have: %+v", root)
+	}
+}
+
+func TestFsGetDirNotExist(t *testing.T) {
+	fs := NewFS()
+	fs.AddDir("/foo")
+	if _, err := fs.GetDir("/foo/bar"); err == nil {
+		t.Errorf("want error getting non-existent directory, have nil")
+	}
+	// the failed lookup above must not keep /foo locked
+	fs.AddDir("/foo/bar")
+	if _, err := fs.GetDir("/foo/bar/"); err != nil {
+		t.Errorf("Got error when getting dir %q: %q", "/foo/bar/", err)
+	}
+}
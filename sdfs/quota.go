@@ -0,0 +1,141 @@
+package sdfs
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// DirQuota limits the usage of a directory tree, zero fields are unlimited
+type DirQuota struct {
+	// Bytes limits the logical size, every path of a file counts once
+	Bytes uint64 `json:"bytes,omitempty"`
+	// PhysicalBytes limits the size of all replicas on nodes
+	PhysicalBytes uint64 `json:"physical_bytes,omitempty"`
+	// Files limits the number of file paths
+	Files uint64 `json:"files,omitempty"`
+}
+
+// IsZero reports whether q limits nothing
+func (q DirQuota) IsZero() bool {
+	return q == DirQuota{}
+}
+
+// DirUsage is the usage of a directory tree and its quota
+type DirUsage struct {
+	Path          string   `json:"path"`
+	Bytes         uint64   `json:"bytes"`
+	PhysicalBytes uint64   `json:"physical_bytes"`
+	Files         uint64   `json:"files"`
+	Quota         DirQuota `json:"quota"`
+}
+
+// Usage returns the logical size, the physical size and the number of files
+// of the tree of d
+func (d *Directory) Usage() (size, physical, files uint64) {
+	return atomic.LoadUint64(&d.Size), atomic.LoadUint64(&d.PhysicalSize), atomic.LoadUint64(&d.FileCount)
+}
+
+func (d *Directory) quota() DirQuota {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.Quota
+}
+
+// SetQuota sets the quota of the directory at path, the directory is created
+// if it does not exist, a zero quota removes the limits
+func (f *FS) SetQuota(path string, q DirQuota) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	dir, err := f.addDir(p)
+	if err != nil {
+		return err
+	}
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	err = f.update(func(tx MetaTx) error {
+		if q.IsZero() {
+			return tx.Delete(bucketQuotas, dir.FullPath)
+		}
+		return putJSON(tx, bucketQuotas, dir.FullPath, q)
+	})
+	if err != nil {
+		return err
+	}
+	dir.Quota = q
+	return nil
+}
+
+// GetUsage returns the usage and the quota of the directory at path
+func (f *FS) GetUsage(path string) (DirUsage, error) {
+	dir, err := f.GetDir(path)
+	if err != nil {
+		return DirUsage{}, err
+	}
+	size, physical, files := dir.Usage()
+	return DirUsage{
+		Path:          dir.FullPath,
+		Bytes:         size,
+		PhysicalBytes: physical,
+		Files:         files,
+		Quota:         dir.quota(),
+	}, nil
+}
+
+// CheckQuota checks if a new file of the given size stored with the given
+// number of replicas can be added at path without exceeding the quota of any
+// directory above it
+func (f *FS) CheckQuota(path string, size uint64, replicas int) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	return f.checkQuota(p, size, size*uint64(replicas), 1)
+}
+
+// checkQuota checks if files adding up to size and physical bytes can be
+// added at p
+func (f *FS) checkQuota(p Path, size, physical, n uint64) error {
+	return f.checkMoveQuota(p, nil, size, physical, n)
+}
+
+// checkMoveQuota is checkQuota for files moved to p from the directory src,
+// the directories above both keep their usage and are not checked
+func (f *FS) checkMoveQuota(p Path, src *Directory, size, physical, n uint64) error {
+	dir, err := f.rootDir(p)
+	if err != nil {
+		return err
+	}
+	// find the deepest existing directory on the path, the rest of the path
+	// is created with the file and holds no quota yet
+	for _, part := range p.Dir().Parts {
+		dir.mu.RLock()
+		sub, ok := dir.SubDirs[part]
+		dir.mu.RUnlock()
+		if !ok {
+			break
+		}
+		dir = sub
+	}
+	for d := dir; d != nil; d = d.Parent {
+		if src != nil && strings.HasPrefix(src.FullPath, d.FullPath) {
+			break
+		}
+		q := d.quota()
+		if q.IsZero() {
+			continue
+		}
+		used, usedPhysical, files := d.Usage()
+		if q.Bytes != 0 && used+size > q.Bytes {
+			return NewError(ErrNoCapacity, "quota of %s exceeded: %d of %d bytes used", d.FullPath, used, q.Bytes)
+		}
+		if q.PhysicalBytes != 0 && usedPhysical+physical > q.PhysicalBytes {
+			return NewError(ErrNoCapacity, "quota of %s exceeded: %d of %d physical bytes used", d.FullPath, usedPhysical, q.PhysicalBytes)
+		}
+		if q.Files != 0 && files+n > q.Files {
+			return NewError(ErrNoCapacity, "quota of %s exceeded: %d of %d files used", d.FullPath, files, q.Files)
+		}
+	}
+	return nil
+}
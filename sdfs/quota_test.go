@@ -0,0 +1,108 @@
+package sdfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFsUsage(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/team/a", "h1", 10)
+	fs.AddHost("h1", 1)
+	fs.AddHost("h1", 2)
+	fs.Link("/team/a", "/team/sub/b")
+	fs.AddSizedFile("/team/c", "h2", 5)
+
+	u, err := fs.GetUsage("/team")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Bytes != 25 || u.PhysicalBytes != 40 || u.Files != 3 {
+		t.Errorf("want 25 bytes, 40 physical bytes and 3 files, have: %+v", u)
+	}
+	fs.Rename("/team/sub/b", "/other/b")
+	fs.DeleteFile("/team/c")
+	u, _ = fs.GetUsage("/team")
+	if u.Bytes != 10 || u.PhysicalBytes != 20 || u.Files != 1 {
+		t.Errorf("want 10 bytes, 20 physical bytes and 1 file, have: %+v", u)
+	}
+	if root, _ := fs.GetUsage("/"); root.Files != 2 {
+		t.Errorf("want 2 files under /, have: %d", root.Files)
+	}
+	if hosts, err := fs.RemoveHost("h1", 1); err != nil || len(hosts) != 1 || hosts[0] != 2 {
+		t.Errorf("want host 2 left, have: %v, %v", hosts, err)
+	}
+	if u, _ = fs.GetUsage("/team"); u.PhysicalBytes != 10 {
+		t.Errorf("want 10 physical bytes after a replica is lost, have: %+v", u)
+	}
+}
+
+func TestFsCheckQuota(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/team/a", "h1", 10)
+	if err := fs.SetQuota("/team", DirQuota{Bytes: 100, PhysicalBytes: 150, Files: 2}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		size     uint64
+		replicas int
+		ok       bool
+	}{
+		{"/team/new/b", 90, 1, true},
+		{"/team/b", 91, 1, false},
+		{"/team/b", 60, 3, false},
+		{"/other/b", 1000, 3, true},
+	}
+	for _, tt := range tests {
+		err := fs.CheckQuota(tt.path, tt.size, tt.replicas)
+		if tt.ok && err != nil || !tt.ok && !errors.Is(err, ErrNoCapacity) {
+			t.Errorf("CheckQuota(%q, %d, %d) = %v", tt.path, tt.size, tt.replicas, err)
+		}
+	}
+	fs.AddSizedFile("/team/b", "h2", 1)
+	if err := fs.CheckQuota("/team/c", 1, 1); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("want file count exceeded, have: %v", err)
+	}
+	fs.SetQuota("/team", DirQuota{})
+	if err := fs.CheckQuota("/team/c", 1000, 3); err != nil {
+		t.Errorf("want quota removed, have: %v", err)
+	}
+}
+
+func TestFsQuotaMoves(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/team/a", "h1", 10)
+	fs.AddSizedFile("/other/b", "h2", 50)
+	fs.AddSizedFile("/other/c", "h3", 50)
+	if err := fs.SetQuota("/team", DirQuota{Bytes: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/other/b", "/team/b"); err != nil {
+		t.Errorf("want rename within quota, have: %v", err)
+	}
+	if err := fs.Rename("/other/c", "/team/c"); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("want rename over quota refused, have: %v", err)
+	}
+	if _, err := fs.Link("/other/c", "/team/d"); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("want link over quota refused, have: %v", err)
+	}
+	// moving within the quota counts nothing new
+	if err := fs.Rename("/team/b", "/team/sub/b"); err != nil {
+		t.Errorf("want rename under the same quota, have: %v", err)
+	}
+	e, err := fs.TrashFile("/other/c", time.Unix(1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.RestoreTrash("", e.ID, "/team/c"); !errors.Is(err, ErrNoCapacity) {
+		t.Errorf("want restore over quota refused, have: %v", err)
+	}
+	if _, err := fs.RestoreTrash("", e.ID, ""); err != nil {
+		t.Errorf("want restore to the old path, have: %v", err)
+	}
+	if u, _ := fs.GetUsage("/team"); u.Bytes != 60 || u.Files != 2 {
+		t.Errorf("want 60 bytes and 2 files, have: %+v", u)
+	}
+}
@@ -0,0 +1,283 @@
+package sdfs
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Lyianu/sdfs/log"
+)
+
+// quarantineDir is the directory in a data directory that corrupt objects
+// are moved to, they are kept for inspection and never served again
+const quarantineDir = "quarantine"
+
+// scrubWait is how long the scrubber waits between passes
+const scrubWait = time.Minute
+
+// errScrubStopped is returned by reads of the scrubber after it is stopped
+var errScrubStopped = errors.New("scrubber stopped")
+
+// due returns the hashes of the objects that were last verified before
+// cutoff, the ones verified longest ago first
+func (h *HashStore) due(cutoff time.Time) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var files []*file
+	for _, f := range h.s {
+		f.mu.Lock()
+		if f.Verified.Before(cutoff) {
+			files = append(files, f)
+		}
+		f.mu.Unlock()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Verified.Before(files[j].Verified)
+	})
+	hashes := make([]string, len(files))
+	for i, f := range files {
+		hashes[i] = f.Hash
+	}
+	return hashes
+}
+
+// Verify hashes the content of the object with the given hash again, the
+// content is read through wrap if it is not nil. It reports whether the
+// content matches the hash, objects that do not match are quarantined: they
+// are moved out of the hashstore and dropped from the index
+func (h *HashStore) Verify(hash string, wrap func(io.Reader) io.Reader) (bool, error) {
+	h.mu.RLock()
+	f, ok := h.s[hash]
+	h.mu.RUnlock()
+	if !ok {
+		return false, NewError(ErrNotFound, "file with specific hash not found")
+	}
+	r, err := h.Open(f)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	var src io.Reader = r
+	if wrap != nil {
+		src = wrap(r)
+	}
+	sum := sha256.New()
+	// content that can not be decompressed does not match
+	if _, err := io.Copy(sum, src); errors.Is(err, errScrubStopped) {
+		return false, err
+	} else if err != nil && !errors.Is(err, ErrChecksumMismatch) {
+		return false, h.failed(f.disk, err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.s[hash] != f {
+		// removed while it was read
+		return true, nil
+	}
+	if encodeSum(sum.Sum(nil)) == hash {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		rec := f.record()
+		now := time.Now()
+		rec.Verified = now.UnixNano()
+		if err := h.update(func(tx MetaTx) error { return putJSON(tx, bucketObjects, hash, rec) }); err != nil {
+			return true, err
+		}
+		f.Verified = now
+		return true, nil
+	}
+	return false, h.quarantine(f)
+}
+
+// quarantine moves the corrupt object f out of h, h.mu should be held by the
+// caller
+func (h *HashStore) quarantine(f *file) error {
+	hash, d, path := f.Hash, f.disk, f.Path()
+	if err := h.update(func(tx MetaTx) error { return tx.Delete(bucketObjects, hash) }); err != nil {
+		return err
+	}
+	q := filepath.Join(d.Dir, quarantineDir, objectName(hash)+codecSuffix[f.Codec])
+	if err := os.MkdirAll(filepath.Dir(q), 0755); err != nil {
+		log.Errorf("failed to create quarantine of %s: %q", d.Dir, err)
+	}
+	if err := os.Rename(path, q); err != nil {
+		log.Errorf("failed to quarantine %s, removing it: %q", hash, err)
+		os.Remove(path)
+	}
+	log.Errorf("object %s on %s is corrupt, quarantined it", hash, d.Dir)
+	delete(h.s, hash)
+	h.Size -= f.Size
+	d.count(f, -1)
+	return nil
+}
+
+// ScrubStats describes the progress of a Scrubber
+type ScrubStats struct {
+	Running bool `json:"running"`
+	// Passes is the number of finished passes
+	Passes int `json:"passes"`
+	// Objects is the number of objects due in the current or last pass,
+	// Checked the ones of them that are checked
+	Objects int `json:"objects"`
+	Checked int `json:"checked"`
+	// Bytes, Corrupt and Errors add up over all passes
+	Bytes   int64 `json:"bytes"`
+	Corrupt int   `json:"corrupt"`
+	Errors  int   `json:"errors"`
+	// Unreported is the number of corrupt objects the master has not been
+	// told about yet
+	Unreported int       `json:"unreported"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+}
+
+// Scrubber verifies the objects of a hashstore in the background, so that
+// corrupt content is found before it is served. Corrupt objects are
+// quarantined and reported, the master replicates them again from a
+// healthy copy
+type Scrubber struct {
+	hs *HashStore
+	// Rate limits the bytes read per second, 0 for no limit
+	Rate int64
+	// Interval is how long an object is left alone after it is verified
+	Interval time.Duration
+	// Report tells the master about a corrupt object, objects that fail to
+	// be reported are retried after every pass
+	Report func(hash string) error
+
+	mu         sync.Mutex
+	stats      ScrubStats
+	unreported []string
+}
+
+// NewScrubber returns a scrubber of hs
+func NewScrubber(hs *HashStore, rate int64, interval time.Duration, report func(hash string) error) *Scrubber {
+	return &Scrubber{
+		hs:       hs,
+		Rate:     rate,
+		Interval: interval,
+		Report:   report,
+	}
+}
+
+// Stats returns the progress of s
+func (s *Scrubber) Stats() ScrubStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Unreported = len(s.unreported)
+	return stats
+}
+
+// Run runs passes until stop is closed
+func (s *Scrubber) Run(stop <-chan struct{}) {
+	for {
+		s.Pass(stop)
+		select {
+		case <-stop:
+			return
+		case <-time.After(scrubWait):
+		}
+	}
+}
+
+// Pass verifies the objects that are due once, it returns early if stop is
+// closed
+func (s *Scrubber) Pass(stop <-chan struct{}) {
+	hashes := s.hs.due(time.Now().Add(-s.Interval))
+	s.mu.Lock()
+	s.stats.Running = true
+	s.stats.Objects, s.stats.Checked = len(hashes), 0
+	s.stats.Started = time.Now()
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.stats.Running = false
+		s.mu.Unlock()
+	}()
+
+	l := &limitedReader{rate: s.Rate, start: time.Now(), stop: stop}
+	wrap := func(r io.Reader) io.Reader {
+		l.r = r
+		return l
+	}
+	for _, hash := range hashes {
+		ok, err := s.hs.Verify(hash, wrap)
+		if errors.Is(err, errScrubStopped) {
+			return
+		}
+		s.mu.Lock()
+		s.stats.Checked++
+		s.stats.Bytes = l.total
+		if err != nil && !errors.Is(err, ErrNotFound) && !os.IsNotExist(err) {
+			log.Errorf("failed to verify %s: %q", hash, err)
+			s.stats.Errors++
+		} else if err == nil && !ok {
+			s.stats.Corrupt++
+			s.unreported = append(s.unreported, hash)
+		}
+		s.mu.Unlock()
+	}
+	s.report()
+	s.mu.Lock()
+	s.stats.Passes++
+	s.stats.Finished = time.Now()
+	s.mu.Unlock()
+}
+
+// report reports the corrupt objects found so far, the ones that fail are
+// kept for the next pass
+func (s *Scrubber) report() {
+	if s.Report == nil {
+		return
+	}
+	s.mu.Lock()
+	hashes := s.unreported
+	s.unreported = nil
+	s.mu.Unlock()
+	var failed []string
+	for _, hash := range hashes {
+		if err := s.Report(hash); err != nil {
+			log.Errorf("failed to report corrupt object %s: %q", hash, err)
+			failed = append(failed, hash)
+		}
+	}
+	s.mu.Lock()
+	s.unreported = append(failed, s.unreported...)
+	s.mu.Unlock()
+}
+
+// limitedReader reads from r at no more than rate bytes per second since
+// start, counting the bytes of every reader it wraps
+type limitedReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	total int64
+	stop  <-chan struct{}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.total += int64(n)
+	if l.rate <= 0 {
+		return n, err
+	}
+	wait := time.Duration(float64(l.total)/float64(l.rate)*float64(time.Second)) - time.Since(l.start)
+	if wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-l.stop:
+			return n, errScrubStopped
+		case <-t.C:
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,100 @@
+package sdfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScrubber(t *testing.T) {
+	dir := t.TempDir()
+	index := NewMemIndex()
+	h, err := OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _, _ := h.Add(strings.NewReader("aaa"))
+	b, _, _ := h.Add(strings.NewReader("bbbb"))
+	fa, _ := h.Get(a)
+	verified := fa.Verified
+	// bit rot
+	os.WriteFile(h.Disks[0].Path(b), []byte("bbbc"), 0644)
+
+	var reported []string
+	fail := true
+	s := NewScrubber(h, 0, 0, func(hash string) error {
+		if fail {
+			return errors.New("master unreachable")
+		}
+		reported = append(reported, hash)
+		return nil
+	})
+	s.Pass(nil)
+	stats := s.Stats()
+	if stats.Passes != 1 || stats.Checked != 2 || stats.Corrupt != 1 || stats.Bytes != 7 || stats.Unreported != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if _, err := h.Get(b); err == nil {
+		t.Errorf("corrupt object still served")
+	}
+	if _, err := os.Stat(filepath.Join(dir, quarantineDir, objectName(b))); err != nil {
+		t.Errorf("corrupt object not quarantined: %v", err)
+	}
+	if !fa.Verified.After(verified) || h.GetSize() != 3 {
+		t.Errorf("want %s verified and size 3, have: %v, %d", a, fa.Verified, h.GetSize())
+	}
+
+	// the report is retried after the next pass, objects verified recently
+	// are left alone
+	fail = false
+	s.Interval = time.Hour
+	s.Pass(nil)
+	stats = s.Stats()
+	if stats.Passes != 2 || stats.Objects != 0 || stats.Unreported != 0 || len(reported) != 1 || reported[0] != b {
+		t.Errorf("unexpected stats after report: %+v, reported %v", stats, reported)
+	}
+
+	// the quarantine survives a restart and the corrupt object is not
+	// indexed again
+	h, err = OpenHashStore([]string{dir}, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Get(b); err == nil {
+		t.Errorf("quarantined object loaded again")
+	}
+	if f, err := h.Get(a); err != nil || !f.Verified.After(verified) {
+		t.Errorf("want verification time kept, have: %+v, %v", f, err)
+	}
+	// a healthy copy can be stored again
+	if hash, _, err := h.Add(strings.NewReader("bbbb")); err != nil || hash != b {
+		t.Errorf("want %s stored again, have: %s, %v", b, hash, err)
+	}
+}
+
+func TestScrubberRate(t *testing.T) {
+	h, err := OpenHashStore([]string{t.TempDir()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Add(strings.NewReader(strings.Repeat("a", 100)))
+	h.Add(strings.NewReader(strings.Repeat("b", 100)))
+	s := NewScrubber(h, 1000, 0, nil)
+	start := time.Now()
+	s.Pass(nil)
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("want 200 bytes read at 1000 bytes per second, took %v", d)
+	}
+
+	// a stopped pass returns early
+	stop := make(chan struct{})
+	close(stop)
+	s.Rate = 1
+	s.Pass(stop)
+	if stats := s.Stats(); stats.Passes != 1 || stats.Running {
+		t.Errorf("want stopped pass unfinished, have: %+v", stats)
+	}
+}
@@ -0,0 +1,242 @@
+package sdfs
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SearchOptions selects the files returned by Search, zero values disable
+// the corresponding filter
+type SearchOptions struct {
+	// Prefix is the qualified path prefix of the results, it does not have
+	// to end at a path separator, e.g. "/logs/2026-10" matches
+	// "/logs/2026-10-01/a.parquet"
+	Prefix string
+	// Delimiter groups the results whose path contains Delimiter after
+	// Prefix into a single entry, the common prefix that ends at the first
+	// Delimiter, like the CommonPrefixes of S3 ListObjects
+	Delimiter string
+	// Pattern is a glob as in path.Match, it is matched against the file
+	// name if it contains no '/', otherwise against the path without its
+	// namespace
+	Pattern string
+	// MinSize and MaxSize bound the size of files, MaxSize 0 means no bound
+	MinSize, MaxSize uint64
+	Checksum         string
+	// ModifiedAfter and ModifiedBefore bound the modification time of files
+	ModifiedAfter, ModifiedBefore time.Time
+	// Xattrs must all be set on a file, an empty value matches any value
+	Xattrs map[string]string
+	// StartAfter skips every result whose key is not greater than it, it is
+	// the continuation token returned by a previous search
+	StartAfter string
+	// Limit is the maximum number of results, 0 means no limit
+	Limit int
+}
+
+// Validate checks if the pattern of o is well formed and its bounds are
+// ordered
+func (o *SearchOptions) Validate() error {
+	if o.Pattern != "" {
+		if _, err := path.Match(o.Pattern, ""); err != nil {
+			return NewError(ErrInvalidArgument, "invalid pattern: %s", err)
+		}
+	}
+	if o.MaxSize != 0 && o.MaxSize < o.MinSize {
+		return NewError(ErrInvalidArgument, "max size is less than min size")
+	}
+	if o.Limit < 0 {
+		return NewError(ErrInvalidArgument, "negative limit")
+	}
+	return nil
+}
+
+// SearchResult is a file or, with a delimiter, a common prefix found by
+// Search
+type SearchResult struct {
+	FileInfo
+	// CommonPrefix is set instead of the file info for grouped results
+	CommonPrefix string `json:"common_prefix,omitempty"`
+}
+
+// Key returns the key that orders results, continuation tokens are keys
+func (r SearchResult) Key() string {
+	if r.CommonPrefix != "" {
+		return r.CommonPrefix
+	}
+	return r.Path
+}
+
+// errSearchDone stops a walk once the limit is reached
+var errSearchDone = errors.New("search done")
+
+// Search calls fn with the files that match opts in path order, symlinks are
+// neither followed nor returned. If the results are cut by opts.Limit, the
+// key of the last result is returned as the token to continue from
+func (f *FS) Search(opts SearchOptions, fn func(r SearchResult) error) (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+	ns, p := SplitNamespace(opts.Prefix)
+	if ns == DefaultNamespace {
+		ns = ""
+	}
+	root, err := f.rootDir(Path{Namespace: ns})
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	s := &search{
+		f:      f,
+		opts:   opts,
+		prefix: root.FullPath + p[1:],
+		fn:     fn,
+	}
+	err = s.walk(root)
+	if err == errSearchDone {
+		return s.last, nil
+	}
+	return "", err
+}
+
+type search struct {
+	f      *FS
+	opts   SearchOptions
+	prefix string
+	fn     func(r SearchResult) error
+
+	count int
+	// last is the key of the last result
+	last string
+	// group is the common prefix being skipped after it is returned
+	group string
+}
+
+// entry is a child of a directory, key is its name with a trailing '/' for
+// directories so that sorting keys sorts the paths under them
+type entry struct {
+	key    string
+	file   *File
+	xattrs map[string]string
+	dir    *Directory
+}
+
+func (s *search) walk(d *Directory) error {
+	if err := s.f.rlock(d); err != nil {
+		return err
+	}
+	entries := make([]entry, 0, len(d.Files)+len(d.SubDirs))
+	for name, file := range d.Files {
+		entries = append(entries, entry{key: name, file: file, xattrs: d.Xattrs[name]})
+	}
+	for name, sub := range d.SubDirs {
+		entries = append(entries, entry{key: name + "/", dir: sub})
+	}
+	d.mu.RUnlock()
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	for _, e := range entries {
+		full := d.FullPath + e.key
+		if e.dir != nil {
+			if !s.visitDir(full) {
+				continue
+			}
+			if err := s.walk(e.dir); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.visitFile(full, e.key, e.file, e.xattrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visitDir reports whether the tree under the directory full may contain
+// results
+func (s *search) visitDir(full string) bool {
+	if !strings.HasPrefix(full, s.prefix) && !strings.HasPrefix(s.prefix, full) {
+		return false
+	}
+	if s.group != "" && strings.HasPrefix(full, s.group) {
+		return false
+	}
+	// every path under full is smaller than StartAfter
+	after := s.opts.StartAfter
+	if after != "" && after > full && !strings.HasPrefix(after, full) {
+		return false
+	}
+	return true
+}
+
+func (s *search) visitFile(full, name string, file *File, xattrs map[string]string) error {
+	if !strings.HasPrefix(full, s.prefix) || !s.match(full, name, file, xattrs) {
+		return nil
+	}
+	r := SearchResult{}
+	if s.opts.Delimiter != "" {
+		rest := full[len(s.prefix):]
+		if i := strings.Index(rest, s.opts.Delimiter); i >= 0 {
+			r.CommonPrefix = s.prefix + rest[:i+len(s.opts.Delimiter)]
+		}
+	}
+	key := full
+	if r.CommonPrefix != "" {
+		key = r.CommonPrefix
+	}
+	if key <= s.opts.StartAfter || key == s.last {
+		return nil
+	}
+	if s.opts.Limit > 0 && s.count >= s.opts.Limit {
+		return errSearchDone
+	}
+	if r.CommonPrefix != "" {
+		s.group = r.CommonPrefix
+	} else {
+		r.FileInfo = fileInfo(name, full, file, xattrs)
+	}
+	s.count++
+	s.last = key
+	return s.fn(r)
+}
+
+func (s *search) match(full, name string, file *File, xattrs map[string]string) bool {
+	o := &s.opts
+	if o.Pattern != "" {
+		subject := name
+		if strings.Contains(o.Pattern, "/") {
+			_, subject = SplitNamespace(full)
+		}
+		if ok, _ := path.Match(o.Pattern, subject); !ok {
+			return false
+		}
+	}
+	if file.Size < o.MinSize || o.MaxSize != 0 && file.Size > o.MaxSize {
+		return false
+	}
+	if o.Checksum != "" && file.Checksum != o.Checksum {
+		return false
+	}
+	if !o.ModifiedAfter.IsZero() && !file.ModTime.After(o.ModifiedAfter) {
+		return false
+	}
+	if !o.ModifiedBefore.IsZero() && !file.ModTime.Before(o.ModifiedBefore) {
+		return false
+	}
+	if len(o.Xattrs) != 0 {
+		for k, v := range o.Xattrs {
+			if a, ok := xattrs[k]; !ok || v != "" && a != v {
+				return false
+			}
+		}
+	}
+	return true
+}
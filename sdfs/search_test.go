@@ -0,0 +1,104 @@
+package sdfs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func searchKeys(t *testing.T, fs *FS, opts SearchOptions) ([]string, string) {
+	t.Helper()
+	var keys []string
+	next, err := fs.Search(opts, func(r SearchResult) error {
+		keys = append(keys, r.Key())
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Search(%+v): %v", opts, err)
+	}
+	return keys, next
+}
+
+func TestFsSearch(t *testing.T) {
+	fs := NewFS()
+	t0 := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	files := []struct {
+		path string
+		size uint64
+	}{
+		{"/logs/2026-10-01/a.parquet", 10},
+		{"/logs/2026-10-01/b.csv", 20},
+		{"/logs/2026-10-02/c.parquet", 30},
+		{"/logs/2026-11-01/d.parquet", 40},
+		{"/logs/2026-10.parquet", 50},
+		{"/other/e.parquet", 60},
+	}
+	for i, f := range files {
+		if _, err := fs.AddFileAt(f.path, f.path, f.size, t0.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs.SetXattr("/logs/2026-10-02/c.parquet", "team", "infra")
+	fs.Symlink("/logs", "/logs/2026-10-link")
+
+	tests := []struct {
+		name string
+		opts SearchOptions
+		want []string
+	}{
+		{"glob under prefix", SearchOptions{Prefix: "/logs/2026-10", Pattern: "*.parquet"},
+			[]string{"/logs/2026-10-01/a.parquet", "/logs/2026-10-02/c.parquet", "/logs/2026-10.parquet"}},
+		{"delimiter", SearchOptions{Prefix: "/logs/", Delimiter: "/"},
+			[]string{"/logs/2026-10-01/", "/logs/2026-10-02/", "/logs/2026-10.parquet", "/logs/2026-11-01/"}},
+		{"path glob", SearchOptions{Pattern: "/logs/*/*.parquet"},
+			[]string{"/logs/2026-10-01/a.parquet", "/logs/2026-10-02/c.parquet", "/logs/2026-11-01/d.parquet"}},
+		{"size", SearchOptions{MinSize: 20, MaxSize: 40},
+			[]string{"/logs/2026-10-01/b.csv", "/logs/2026-10-02/c.parquet", "/logs/2026-11-01/d.parquet"}},
+		{"time", SearchOptions{ModifiedAfter: t0.Add(3 * time.Hour)},
+			[]string{"/logs/2026-10.parquet", "/other/e.parquet"}},
+		{"xattr", SearchOptions{Xattrs: map[string]string{"team": ""}},
+			[]string{"/logs/2026-10-02/c.parquet"}},
+		{"checksum", SearchOptions{Checksum: "/other/e.parquet"},
+			[]string{"/other/e.parquet"}},
+	}
+	for _, tt := range tests {
+		keys, next := searchKeys(t, fs, tt.opts)
+		if !reflect.DeepEqual(keys, tt.want) || next != "" {
+			t.Errorf("%s: want: %v, have: %v, next: %q", tt.name, tt.want, keys, next)
+		}
+	}
+}
+
+func TestFsSearchContinuation(t *testing.T) {
+	fs := NewFS()
+	for _, p := range []string{"/a/1", "/a/2", "/b", "/c/1", "/c/2", "/d"} {
+		fs.AddFile(p, p)
+	}
+	for _, delim := range []string{"", "/"} {
+		all, _ := searchKeys(t, fs, SearchOptions{Delimiter: delim})
+		var pages []string
+		opts := SearchOptions{Delimiter: delim, Limit: 2}
+		for {
+			keys, next := searchKeys(t, fs, opts)
+			pages = append(pages, keys...)
+			if next == "" {
+				break
+			}
+			opts.StartAfter = next
+		}
+		if !reflect.DeepEqual(pages, all) {
+			t.Errorf("delimiter %q: pages want: %v, have: %v", delim, all, pages)
+		}
+	}
+}
+
+func TestFsSearchNamespace(t *testing.T) {
+	fs := NewFS()
+	fs.CreateNamespace("media", 0, 0)
+	fs.AddFile("media:/x/y", "h1")
+	fs.AddFile("/x/y", "h2")
+	keys, _ := searchKeys(t, fs, SearchOptions{Prefix: "media:/x"})
+	if want := []string{"media:/x/y"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("want: %v, have: %v", want, keys)
+	}
+}
@@ -0,0 +1,385 @@
+package sdfs
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxSnapshotNameLength limits the length of snapshot names
+const MaxSnapshotNameLength = 255
+
+// Snapshot is a read-only view of the files under a directory at the time it
+// was taken, it pins the files so that their content is not deleted while the
+// snapshot exists. A file in a snapshot is read with the path
+// "<dir>@<name>/<path in dir>", the files are kept in the store only
+type Snapshot struct {
+	Name    string
+	Path    string // qualified path of the directory
+	Created time.Time
+	// Count is the number of paths in the snapshot
+	Count int
+	Size  uint64
+}
+
+// SnapshotInfo describes a snapshot in API responses
+type SnapshotInfo struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Created time.Time `json:"created"`
+	Files   int       `json:"files"`
+	Size    uint64    `json:"size"`
+}
+
+// Info returns the info of s
+func (s *Snapshot) Info() SnapshotInfo {
+	return SnapshotInfo{
+		Name:    s.Name,
+		Path:    s.Path,
+		Created: s.Created,
+		Files:   s.Count,
+		Size:    s.Size,
+	}
+}
+
+// snapshotRecord is how snapshots are kept in a MetaStore, the checksums of
+// the files are kept in bucketSnapFiles by snapFileKey
+type snapshotRecord struct {
+	Created int64  `json:"created"` // unix nanoseconds
+	Count   int    `json:"count"`
+	Size    uint64 `json:"size"`
+	// Files maps relative paths to checksums in stores written before the
+	// files had keys of their own
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// snapFileKey returns the key of the file at rel in the snapshot with the
+// given key, the files of a snapshot share the prefix snapFileKey(key, "")
+func snapFileKey(key, rel string) string {
+	return key + "\x00" + rel
+}
+
+// types of SnapshotChange
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// SnapshotChange is a difference between a snapshot and another snapshot or
+// the live tree
+type SnapshotChange struct {
+	Path        string `json:"path"` // relative to the snapshot directory
+	Type        string `json:"type"`
+	OldChecksum string `json:"old_checksum,omitempty"`
+	NewChecksum string `json:"new_checksum,omitempty"`
+}
+
+// ValidateSnapshotName checks if name can be used as a snapshot name, names
+// are limited to letters, digits, '.', '-' and '_'
+func ValidateSnapshotName(name string) error {
+	if name == "" || len(name) > MaxSnapshotNameLength {
+		return NewError(ErrInvalidArgument, "invalid snapshot name length")
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return NewError(ErrInvalidArgument, "invalid character in snapshot name")
+		}
+	}
+	return nil
+}
+
+// snapshotKey returns the canonical directory path of the snapshot and its
+// key in FS.Snapshots
+func snapshotKey(path, name string) (string, string, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return "", "", err
+	}
+	if err := ValidateSnapshotName(name); err != nil {
+		return "", "", err
+	}
+	dir := p.String()
+	return dir, dir + "@" + name, nil
+}
+
+// SplitSnapshot splits a path of the form "<dir>@<name>/<rel>" into the
+// directory, the snapshot name and the path relative to the directory, ok is
+// false if no component of path contains a '@'
+func SplitSnapshot(path string) (dir, name, rel string, ok bool) {
+	ns, p := SplitNamespace(path)
+	parts := splitPath(p)
+	for i, part := range parts {
+		j := strings.LastIndex(part, "@")
+		if j < 0 {
+			continue
+		}
+		d := append(parts[:i:i], part[:j])
+		return JoinNamespace(ns, "/"+strings.Join(d, "/")), part[j+1:], strings.Join(parts[i+1:], "/"), true
+	}
+	return "", "", "", false
+}
+
+// CreateSnapshot takes a snapshot named name of the directory at path, created
+// is recorded as its creation time
+func (f *FS) CreateSnapshot(path, name string, created time.Time) (*Snapshot, error) {
+	dirPath, key, err := snapshotKey(path, name)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := f.GetDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	f.snapMu.Lock()
+	defer f.snapMu.Unlock()
+	if _, ok := f.Snapshots[key]; ok {
+		return nil, NewError(ErrAlreadyExists, "snapshot exists")
+	}
+	s := &Snapshot{
+		Name:    name,
+		Path:    dirPath,
+		Created: created,
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// the paths are read and pinned in the same transaction, so no write
+	// to the tree comes in between
+	r := refs{}
+	err = f.update(func(tx MetaTx) error {
+		prefix := dir.FullPath
+		err := tx.ForEach(bucketFiles, prefix, func(k string, v []byte) error {
+			hash := string(v)
+			r.add(hash, 0, 1)
+			s.Count++
+			return tx.Put(bucketSnapFiles, snapFileKey(key, k[len(prefix):]), []byte(hash))
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := f.putRefs(tx, r); err != nil {
+			return err
+		}
+		for _, c := range r {
+			s.Size += uint64(c.pins) * c.record.Size
+		}
+		return putJSON(tx, bucketSnapshots, key, snapshotRecord{Created: created.UnixNano(), Count: s.Count, Size: s.Size})
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.applyRefs(r)
+	f.Snapshots[key] = s
+	return s, nil
+}
+
+// GetSnapshot returns the snapshot named name of the directory at path
+func (f *FS) GetSnapshot(path, name string) (*Snapshot, error) {
+	_, key, err := snapshotKey(path, name)
+	if err != nil {
+		return nil, err
+	}
+	f.snapMu.RLock()
+	defer f.snapMu.RUnlock()
+	s, ok := f.Snapshots[key]
+	if !ok {
+		return nil, NewError(ErrNotFound, "snapshot not exist")
+	}
+	return s, nil
+}
+
+// ListSnapshots returns the snapshots of the directory at path sorted by
+// creation time, all snapshots are returned if path is empty
+func (f *FS) ListSnapshots(path string) ([]*Snapshot, error) {
+	if path != "" {
+		p, err := ParsePath(path)
+		if err != nil {
+			return nil, err
+		}
+		path = p.String()
+	}
+	f.snapMu.RLock()
+	defer f.snapMu.RUnlock()
+	var l []*Snapshot
+	for _, s := range f.Snapshots {
+		if path == "" || s.Path == path {
+			l = append(l, s)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if !l[i].Created.Equal(l[j].Created) {
+			return l[i].Created.Before(l[j].Created)
+		}
+		return l[i].Path+"@"+l[i].Name < l[j].Path+"@"+l[j].Name
+	})
+	return l, nil
+}
+
+// DeleteSnapshot deletes a snapshot and unpins its files, files that are
+// referenced by nothing else are removed from the FS and returned so that
+// their content can be deleted from nodes
+func (f *FS) DeleteSnapshot(path, name string) ([]*File, error) {
+	_, key, err := snapshotKey(path, name)
+	if err != nil {
+		return nil, err
+	}
+	f.snapMu.Lock()
+	defer f.snapMu.Unlock()
+	if _, ok := f.Snapshots[key]; !ok {
+		return nil, NewError(ErrNotFound, "snapshot not exist")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// a file may be in a snapshot under several paths, it is pinned once for
+	// each of them
+	r := refs{}
+	var orphans []*File
+	err = f.update(func(tx MetaTx) error {
+		var keys []string
+		err := tx.ForEach(bucketSnapFiles, snapFileKey(key, ""), func(k string, v []byte) error {
+			r.add(string(v), 0, -1)
+			keys = append(keys, k)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if err := tx.Delete(bucketSnapFiles, k); err != nil {
+				return err
+			}
+		}
+		if orphans, err = f.putRefs(tx, r); err != nil {
+			return err
+		}
+		return tx.Delete(bucketSnapshots, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.applyRefs(r)
+	delete(f.Snapshots, key)
+	return orphans, nil
+}
+
+// GetSnapshotFile returns the file at a path of the form
+// "<dir>@<name>/<path in dir>"
+func (f *FS) GetSnapshotFile(path string) (*File, error) {
+	dir, name, rel, ok := SplitSnapshot(path)
+	if !ok {
+		return nil, NewError(ErrNotFound, "file not exist")
+	}
+	s, err := f.GetSnapshot(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	var hash string
+	err = f.view(func(tx MetaTx) error {
+		hash = string(tx.Get(bucketSnapFiles, snapFileKey(s.Path+"@"+s.Name, rel)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	} else if hash == "" {
+		return nil, NewError(ErrNotFound, "file not exist")
+	}
+	return f.fileOf(hash)
+}
+
+// Resolve returns the file at path like GetFile, paths that do not exist in
+// the live tree are looked up in snapshots
+func (f *FS) Resolve(path string) (*File, error) {
+	file, err := f.GetFile(path)
+	if err == nil || !strings.Contains(path, "@") {
+		return file, err
+	}
+	if sf, serr := f.GetSnapshotFile(path); serr == nil {
+		return sf, nil
+	}
+	return nil, err
+}
+
+// DiffSnapshot returns the changes from snapshot from to snapshot to of the
+// directory at path, an empty to compares with the live tree
+func (f *FS) DiffSnapshot(path, from, to string) ([]SnapshotChange, error) {
+	s, err := f.GetSnapshot(path, from)
+	if err != nil {
+		return nil, err
+	}
+	// the files of from are read first, the other side is compared with
+	// them as it is read
+	bucket, prefix := bucketFiles, ""
+	if to != "" {
+		t, err := f.GetSnapshot(path, to)
+		if err != nil {
+			return nil, err
+		}
+		bucket, prefix = bucketSnapFiles, snapFileKey(t.Path+"@"+t.Name, "")
+	} else {
+		dir, err := f.GetDir(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		prefix = dir.FullPath
+	}
+	var changes []SnapshotChange
+	err = f.view(func(tx MetaTx) error {
+		old := make(map[string]string, s.Count)
+		fromPrefix := snapFileKey(s.Path+"@"+s.Name, "")
+		err := tx.ForEach(bucketSnapFiles, fromPrefix, func(k string, v []byte) error {
+			old[k[len(fromPrefix):]] = string(v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(bucket, prefix, func(k string, v []byte) error {
+			rel, cur := k[len(prefix):], string(v)
+			hash, ok := old[rel]
+			if !ok {
+				changes = append(changes, SnapshotChange{Path: rel, Type: ChangeAdded, NewChecksum: cur})
+				return nil
+			}
+			delete(old, rel)
+			if hash != cur {
+				changes = append(changes, SnapshotChange{Path: rel, Type: ChangeModified, OldChecksum: hash, NewChecksum: cur})
+			}
+			return nil
+		})
+		for rel, hash := range old {
+			changes = append(changes, SnapshotChange{Path: rel, Type: ChangeRemoved, OldChecksum: hash})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// loadSnapshots restores the snapshots kept in tx, only their headers are
+// read
+func (f *FS) loadSnapshots(tx MetaTx) error {
+	return tx.ForEach(bucketSnapshots, "", func(k string, v []byte) error {
+		var r snapshotRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		i := strings.LastIndex(k, "@")
+		if i < 0 {
+			return NewError(ErrInvalidArgument, "invalid snapshot key %s", k)
+		}
+		f.Snapshots[k] = &Snapshot{
+			Name:    k[i+1:],
+			Path:    k[:i],
+			Created: time.Unix(0, r.Created),
+			Count:   r.Count,
+			Size:    r.Size,
+		}
+		return nil
+	})
+}
@@ -0,0 +1,99 @@
+package sdfs
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitSnapshot(t *testing.T) {
+	tests := []struct {
+		path, dir, name, rel string
+		ok                   bool
+	}{
+		{"/datasets@2026-10-01/a/b", "/datasets", "2026-10-01", "a/b", true},
+		{"/@daily/a", "/", "daily", "a", true},
+		{"media:/x/y@s1", "media:/x/y", "s1", "", true},
+		{"/a/b", "", "", "", false},
+	}
+	for _, tt := range tests {
+		dir, name, rel, ok := SplitSnapshot(tt.path)
+		if dir != tt.dir || name != tt.name || rel != tt.rel || ok != tt.ok {
+			t.Errorf("SplitSnapshot(%q) = %q, %q, %q, %t", tt.path, dir, name, rel, ok)
+		}
+	}
+}
+
+func TestFsSnapshot(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/datasets/a", "h1", 1)
+	fs.AddSizedFile("/datasets/sub/b", "h2", 2)
+	fs.AddSizedFile("/other/c", "h3", 3)
+	s, err := fs.CreateSnapshot("/datasets", "2026-10-01", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Count != 2 || s.Size != 3 {
+		t.Errorf("want 2 files of size 3, have: %d, %d", s.Count, s.Size)
+	}
+	if _, err := fs.CreateSnapshot("/datasets", "2026-10-01", time.Now()); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("want ErrAlreadyExists, have: %v", err)
+	}
+
+	// changes to the live tree do not affect the snapshot
+	fs.DeleteFile("/datasets/a")
+	fs.AddSizedFile("/datasets/c", "h4", 4)
+	f, err := fs.Resolve("/datasets@2026-10-01/a")
+	if err != nil || f.Checksum != "h1" {
+		t.Fatalf("want h1 from snapshot, have: %v, %v", f, err)
+	}
+	if !fs.IsShared(f) {
+		t.Errorf("file in snapshot should be pinned")
+	}
+	changes, err := fs.DiffSnapshot("/datasets", "2026-10-01", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SnapshotChange{
+		{Path: "a", Type: ChangeRemoved, OldChecksum: "h1"},
+		{Path: "c", Type: ChangeAdded, NewChecksum: "h4"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("diff want: %v, have: %v", want, changes)
+	}
+
+	orphans, err := fs.DeleteSnapshot("/datasets", "2026-10-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 || orphans[0].Checksum != "h1" {
+		t.Errorf("want h1 orphaned, have: %v", orphans)
+	}
+	if _, ok := fs.ChecksumDB["h1"]; ok {
+		t.Errorf("orphan still in ChecksumDB")
+	}
+	if f, _ := fs.GetFile("/datasets/sub/b"); f == nil || f.Pins != 0 {
+		t.Errorf("file not unpinned: %v", f)
+	}
+}
+
+func TestOpenFSSnapshot(t *testing.T) {
+	store := NewMemStore()
+	fs, _ := OpenFS(store)
+	fs.AddSizedFile("/d/a", "h1", 1)
+	fs.CreateSnapshot("/d", "s1", time.Now())
+	fs.DeleteFile("/d/a")
+
+	fs, err := OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Resolve("/d@s1/a")
+	if err != nil || f.Pins != 1 || f.SemaphoreReplica != 0 {
+		t.Fatalf("snapshot file not restored, have: %+v, %v", f, err)
+	}
+	if orphans, err := fs.DeleteSnapshot("/d", "s1"); err != nil || len(orphans) != 1 {
+		t.Errorf("want 1 orphan, have: %v, %v", orphans, err)
+	}
+}
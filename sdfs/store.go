@@ -0,0 +1,681 @@
+package sdfs
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetaStore is a key/value store that keeps the metadata of the FS, keys are
+// grouped into buckets, all changes made by a single FS operation are
+// committed in one transaction
+type MetaStore interface {
+	// Update runs fn in a read-write transaction, the changes are discarded
+	// if fn returns an error
+	Update(fn func(tx MetaTx) error) error
+	// View runs fn in a read-only transaction
+	View(fn func(tx MetaTx) error) error
+	Close() error
+}
+
+// MetaTx is a transaction of a MetaStore, values returned by Get and ForEach
+// are only valid during the transaction
+type MetaTx interface {
+	Get(bucket, key string) []byte
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	// ForEach calls fn for every key that has the given prefix in key order,
+	// iteration stops at the first error returned by fn
+	ForEach(bucket, prefix string, fn func(key string, value []byte) error) error
+	// ForEachChild works like ForEach but skips the keys that have a '/'
+	// after prefix, so only the entries directly in a directory are visited
+	// and the trees of its subdirectories are not read
+	ForEachChild(bucket, prefix string, fn func(key string, value []byte) error) error
+}
+
+// buckets used by the FS, paths are qualified paths as in Directory.FullPath,
+// directory keys end with a '/'
+const (
+	bucketNamespaces = "namespaces" // name => nsRecord
+	bucketDirs       = "dirs"       // path => empty
+	bucketFiles      = "files"      // path => checksum
+	bucketSymlinks   = "symlinks"   // path => target
+	bucketChecksums  = "checksums"  // checksum => fileRecord
+	bucketXattrs     = "xattrs"     // path => map of xattrs
+	bucketSnapshots  = "snapshots"  // <dir>@<name> => snapshotRecord
+	bucketQuotas     = "quotas"     // directory path => DirQuota
+	bucketTrash      = "trash"      // <namespace>/<id> => trashRecord
+	bucketVersioning = "versioning" // directory path => number of prior versions
+	bucketErasure    = "erasure"    // directory path => storage class
+	bucketVersions   = "versions"   // path => []versionRecord
+	bucketChunks     = "chunks"     // checksum => chunkRecord
+	bucketLinks      = "links"      // checksum + "\x00" + path => empty
+	bucketChunkRefs  = "chunkrefs"  // chunk checksum + "\x00" + file checksum => occurrences
+	bucketSnapFiles  = "snapfiles"  // <dir>@<name> + "\x00" + relative path => checksum
+	bucketNodes      = "nodes"      // node id => address
+	bucketMeta       = "meta"       // "layout" => storeLayout
+)
+
+var metaBuckets = []string{bucketNamespaces, bucketDirs, bucketFiles, bucketSymlinks, bucketChecksums, bucketXattrs, bucketSnapshots, bucketQuotas, bucketTrash, bucketVersioning, bucketVersions, bucketChunks, bucketErasure, bucketLinks, bucketChunkRefs, bucketSnapFiles, bucketNodes, bucketMeta}
+
+// storeLayout is the version of the layout of the buckets, stores written
+// before the paths and chunks of files were indexed have none
+const storeLayout = "2"
+
+type nsRecord struct {
+	Quota          uint64 `json:"quota"`
+	ReplicaCount   int    `json:"replica_count"`
+	TrashRetention int64  `json:"trash_retention,omitempty"` // nanoseconds
+}
+
+// fileRecord is the part of File shared by every path with the same checksum
+type fileRecord struct {
+	Size    uint64  `json:"size"`
+	ModTime int64   `json:"mtime"` // unix nanoseconds
+	Host    []int32 `json:"host,omitempty"`
+	// Chunks are the hashes of the chunks of a chunked file or the shards
+	// of an erasure-coded file in order
+	Chunks []string `json:"chunks,omitempty"`
+	// Erasure is the storage class of an erasure-coded file
+	Erasure *Erasure `json:"erasure,omitempty"`
+	// Links and Pins are the SemaphoreReplica and the Pins of the file, the
+	// record is deleted when both drop to 0
+	Links uint32 `json:"links,omitempty"`
+	Pins  uint32 `json:"pins,omitempty"`
+}
+
+// getFileRecord reads the record of the file with the given checksum
+func getFileRecord(tx MetaTx, hash string) (fileRecord, error) {
+	var r fileRecord
+	v := tx.Get(bucketChecksums, hash)
+	if v == nil {
+		return r, NewError(ErrNotFound, "file %s not exist", hash)
+	}
+	return r, json.Unmarshal(v, &r)
+}
+
+// linkKey returns the key of path in bucketLinks, the keys of the paths of
+// a file share the prefix linkKey(hash, "")
+func linkKey(hash, path string) string {
+	return hash + "\x00" + path
+}
+
+// dirOf returns the path of the directory that holds the entry at path
+func dirOf(path string) string {
+	return path[:strings.LastIndex(path, "/")+1]
+}
+
+func putJSON(tx MetaTx, bucket, key string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Put(bucket, key, b)
+}
+
+// memStore is a MetaStore that keeps everything in memory, it is used when
+// the metadata does not have to survive restarts
+type memStore struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+
+	// keys caches the sorted keys of buckets, the keys of a bucket are
+	// sorted again on the first iteration after keys are added to it or
+	// deleted from it. Readers share mu, so keysMu guards keys among them
+	keysMu sync.Mutex
+	keys   map[string][]string
+}
+
+// NewMemStore returns an empty in-memory MetaStore
+func NewMemStore() MetaStore {
+	return newMemStore(metaBuckets)
+}
+
+func newMemStore(buckets []string) MetaStore {
+	s := &memStore{
+		buckets: make(map[string]map[string][]byte),
+		keys:    make(map[string][]string),
+	}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string][]byte)
+	}
+	return s
+}
+
+// memTx buffers writes until the transaction is committed, a nil value in
+// writes marks a deleted key
+type memTx struct {
+	s        *memStore
+	writable bool
+	writes   map[string]map[string][]byte
+}
+
+func (s *memStore) Update(fn func(tx MetaTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := &memTx{s: s, writable: true, writes: make(map[string]map[string][]byte)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for bucket, w := range tx.writes {
+		b := s.buckets[bucket]
+		n := len(b)
+		changed := false
+		for k, v := range w {
+			if v == nil {
+				if _, ok := b[k]; ok {
+					delete(b, k)
+					changed = true
+				}
+			} else {
+				b[k] = v
+			}
+		}
+		if changed || len(b) != n {
+			s.keysMu.Lock()
+			delete(s.keys, bucket)
+			s.keysMu.Unlock()
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of bucket in order, the slice is shared and
+// should not be changed
+func (s *memStore) sortedKeys(bucket string) []string {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+	if keys, ok := s.keys[bucket]; ok {
+		return keys
+	}
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for k := range s.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s.keys[bucket] = keys
+	return keys
+}
+
+func (s *memStore) View(fn func(tx MetaTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(&memTx{s: s})
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
+
+func (tx *memTx) bucket(name string) (map[string][]byte, error) {
+	b, ok := tx.s.buckets[name]
+	if !ok {
+		return nil, NewError(ErrNotFound, "bucket %s not exist", name)
+	}
+	return b, nil
+}
+
+func (tx *memTx) Get(bucket, key string) []byte {
+	if v, ok := tx.writes[bucket][key]; ok {
+		return v
+	}
+	b, err := tx.bucket(bucket)
+	if err != nil {
+		return nil
+	}
+	return b[key]
+}
+
+func (tx *memTx) put(bucket, key string, value []byte) error {
+	if !tx.writable {
+		return NewError(ErrInvalidArgument, "transaction is read-only")
+	}
+	if _, err := tx.bucket(bucket); err != nil {
+		return err
+	}
+	if tx.writes[bucket] == nil {
+		tx.writes[bucket] = make(map[string][]byte)
+	}
+	tx.writes[bucket][key] = value
+	return nil
+}
+
+func (tx *memTx) Put(bucket, key string, value []byte) error {
+	v := make([]byte, len(value))
+	copy(v, value)
+	return tx.put(bucket, key, v)
+}
+
+func (tx *memTx) Delete(bucket, key string) error {
+	return tx.put(bucket, key, nil)
+}
+
+func (tx *memTx) ForEach(bucket, prefix string, fn func(key string, value []byte) error) error {
+	return tx.forEach(bucket, prefix, false, fn)
+}
+
+func (tx *memTx) ForEachChild(bucket, prefix string, fn func(key string, value []byte) error) error {
+	return tx.forEach(bucket, prefix, true, fn)
+}
+
+// forEach iterates the keys of bucket that have the given prefix, keys with
+// a '/' after prefix are skipped if children is true
+func (tx *memTx) forEach(bucket, prefix string, children bool, fn func(key string, value []byte) error) error {
+	if _, err := tx.bucket(bucket); err != nil {
+		return err
+	}
+	keys := tx.s.sortedKeys(bucket)
+	if w := tx.writes[bucket]; len(w) != 0 {
+		keys = withWrites(keys, prefix, w)
+	}
+	for i := sort.SearchStrings(keys, prefix); i < len(keys) && strings.HasPrefix(keys[i], prefix); {
+		k := keys[i]
+		if children {
+			if j := strings.IndexByte(k[len(prefix):], '/'); j >= 0 {
+				// skip the keys under the subdirectory, '0' follows '/'
+				i = sort.SearchStrings(keys, k[:len(prefix)+j]+"0")
+				continue
+			}
+		}
+		i++
+		// keys deleted by the transaction are still in keys
+		v := tx.Get(bucket, k)
+		if v == nil {
+			continue
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withWrites returns the keys with prefix of the sorted keys and of w in
+// order
+func withWrites(keys []string, prefix string, w map[string][]byte) []string {
+	var l []string
+	for i := sort.SearchStrings(keys, prefix); i < len(keys) && strings.HasPrefix(keys[i], prefix); i++ {
+		l = append(l, keys[i])
+	}
+	for k, v := range w {
+		if v != nil && strings.HasPrefix(k, prefix) {
+			l = append(l, k)
+		}
+	}
+	sort.Strings(l)
+	n := 0
+	for i, k := range l {
+		if i == 0 || k != l[i-1] {
+			l[n] = k
+			n++
+		}
+	}
+	return l[:n]
+}
+
+// OpenFS loads the FS kept in store, changes made to the returned FS are
+// written to store. Only the directory tree, its usage, the snapshots and
+// the trash are loaded, the entries of directories are read from the store
+// when they are used and at most settings.MetaCacheEntries of them are kept
+// in memory
+func OpenFS(store MetaStore) (*FS, error) {
+	if err := upgrade(store); err != nil {
+		return nil, err
+	}
+	// f is not shared until it is returned, so it is loaded without taking
+	// its locks
+	f := newFS(store)
+	err := store.View(func(tx MetaTx) error {
+		err := tx.ForEach(bucketNamespaces, "", func(k string, v []byte) error {
+			var r nsRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			// the default namespace is only kept when its retention is set
+			ns, ok := f.Namespaces[k]
+			if !ok {
+				ns = f.addNamespace(k, r.Quota, r.ReplicaCount)
+			}
+			ns.TrashRetention = time.Duration(r.TrashRetention)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(bucketDirs, "", func(k string, v []byte) error {
+			_, err := f.mkdir(k)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(bucketQuotas, "", func(k string, v []byte) error {
+			d, err := f.mkdir(k)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(v, &d.Quota)
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(bucketVersioning, "", func(k string, v []byte) error {
+			d, err := f.mkdir(k)
+			if err != nil {
+				return err
+			}
+			d.Versioning, err = strconv.Atoi(string(v))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(bucketErasure, "", func(k string, v []byte) error {
+			d, err := f.mkdir(k)
+			if err != nil {
+				return err
+			}
+			d.Erasure, err = ParseErasure(string(v))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = tx.ForEach(bucketNodes, "", func(k string, v []byte) error {
+			id, err := strconv.ParseInt(k, 10, 32)
+			if err != nil {
+				return err
+			}
+			f.nodes[int32(id)] = string(v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := f.loadUsage(tx); err != nil {
+			return err
+		}
+		if err := f.loadSnapshots(tx); err != nil {
+			return err
+		}
+		return f.loadTrash(tx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// mkdir returns the directory at the qualified path key, it and its parents
+// are created in memory if they do not exist. It is used while f is loaded
+func (f *FS) mkdir(key string) (*Directory, error) {
+	p, err := ParsePath(key)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := f.rootDir(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range p.Parts {
+		sub, ok := dir.SubDirs[part]
+		if !ok {
+			sub = NewDirectory(part, dir.FullPath+part+"/")
+			sub.Parent = dir
+			dir.SubDirs[part] = sub
+			f.dirs[sub.FullPath] = sub
+		}
+		dir = sub
+	}
+	return dir, nil
+}
+
+// loadUsage adds every path of the files in tx to the usage of the
+// directories above it, the records are read one at a time
+func (f *FS) loadUsage(tx MetaTx) error {
+	return tx.ForEach(bucketChecksums, "", func(hash string, v []byte) error {
+		var r fileRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		physical := int64(r.Size) * int64(len(r.Host))
+		if len(r.Chunks) != 0 {
+			physical = 0
+			for _, c := range r.Chunks {
+				cr, err := getChunkRecord(tx, c)
+				if err != nil {
+					return err
+				}
+				physical += int64(cr.Size) * int64(len(cr.Host))
+			}
+		}
+		prefix := linkKey(hash, "")
+		return tx.ForEach(bucketLinks, prefix, func(k string, _ []byte) error {
+			d, err := f.mkdir(dirOf(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			d.addUsage(int64(r.Size), physical, 1)
+			return nil
+		})
+	})
+}
+
+// upgrade brings the layout of the buckets of store up to date
+func upgrade(store MetaStore) error {
+	var layout string
+	err := store.View(func(tx MetaTx) error {
+		layout = string(tx.Get(bucketMeta, "layout"))
+		return nil
+	})
+	if err != nil || layout == storeLayout {
+		return err
+	}
+	return store.Update(func(tx MetaTx) error {
+		if err := reindex(tx); err != nil {
+			return err
+		}
+		return tx.Put(bucketMeta, "layout", []byte(storeLayout))
+	})
+}
+
+// reindex builds the indexes of paths and chunks and counts the references
+// of files from the paths, versions, snapshots and trash entries in tx. The
+// records that older layouts kept differently are moved, xattrs kept by
+// checksum are given to every path of the file and the files of snapshots
+// get keys of their own. Every file record is held in memory while it runs,
+// which is only once for a store
+func reindex(tx MetaTx) error {
+	records := make(map[string]*fileRecord)
+	err := tx.ForEach(bucketChecksums, "", func(k string, v []byte) error {
+		var r fileRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		r.Links, r.Pins = 0, 0
+		records[k] = &r
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	pin := func(hash string) error {
+		r, ok := records[hash]
+		if !ok {
+			return NewError(ErrNotFound, "file %s not exist", hash)
+		}
+		r.Pins++
+		return nil
+	}
+	paths := make(map[string][]string)
+	err = tx.ForEach(bucketFiles, "", func(k string, v []byte) error {
+		hash := string(v)
+		r, ok := records[hash]
+		if !ok {
+			return NewError(ErrNotFound, "file %s of %s not exist", hash, k)
+		}
+		r.Links++
+		paths[hash] = append(paths[hash], k)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// versions other than the current one pin their files
+	pinVersions := func(versions []versionRecord) error {
+		for i := 0; i < len(versions)-1; i++ {
+			if err := pin(versions[i].Checksum); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = tx.ForEach(bucketVersions, "", func(k string, v []byte) error {
+		var r []versionRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		return pinVersions(r)
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.ForEach(bucketTrash, "", func(k string, v []byte) error {
+		var r trashRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		if err := pin(r.Checksum); err != nil {
+			return err
+		}
+		return pinVersions(r.Versions)
+	})
+	if err != nil {
+		return err
+	}
+	snapshots := make(map[string]snapshotRecord)
+	err = tx.ForEach(bucketSnapshots, "", func(k string, v []byte) error {
+		var r snapshotRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		snapshots[k] = r
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for key, r := range snapshots {
+		if r.Files == nil {
+			// the files have keys of their own already
+			err := tx.ForEach(bucketSnapFiles, snapFileKey(key, ""), func(k string, v []byte) error {
+				return pin(string(v))
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		r.Count, r.Size = 0, 0
+		for rel, hash := range r.Files {
+			if err := pin(hash); err != nil {
+				return err
+			}
+			if err := tx.Put(bucketSnapFiles, snapFileKey(key, rel), []byte(hash)); err != nil {
+				return err
+			}
+			r.Count++
+			r.Size += records[hash].Size
+		}
+		r.Files = nil
+		if err := putJSON(tx, bucketSnapshots, key, r); err != nil {
+			return err
+		}
+	}
+	var oldXattrs []string
+	err = tx.ForEach(bucketXattrs, "", func(k string, v []byte) error {
+		if !strings.Contains(k, "/") {
+			oldXattrs = append(oldXattrs, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, hash := range oldXattrs {
+		// xattrs used to be kept by checksum, every path of the file is
+		// given them
+		v := append([]byte(nil), tx.Get(bucketXattrs, hash)...)
+		for _, path := range paths[hash] {
+			if err := tx.Put(bucketXattrs, path, v); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(bucketXattrs, hash); err != nil {
+			return err
+		}
+	}
+	chunks := make(map[string]bool)
+	for hash, r := range records {
+		if r.Links == 0 && r.Pins == 0 {
+			if err := tx.Delete(bucketChecksums, hash); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, path := range paths[hash] {
+			if err := tx.Put(bucketLinks, linkKey(hash, path), []byte{}); err != nil {
+				return err
+			}
+		}
+		n := make(map[string]int)
+		for _, c := range r.Chunks {
+			n[c]++
+		}
+		for c, k := range n {
+			chunks[c] = true
+			if err := tx.Put(bucketChunkRefs, linkKey(c, hash), []byte(strconv.Itoa(k))); err != nil {
+				return err
+			}
+		}
+		if err := putJSON(tx, bucketChecksums, hash, r); err != nil {
+			return err
+		}
+	}
+	// records of chunks that no file contains were left behind when the
+	// last file containing them was dropped
+	var stale []string
+	err = tx.ForEach(bucketChunks, "", func(k string, v []byte) error {
+		if !chunks[k] {
+			stale = append(stale, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range stale {
+		if err := tx.Delete(bucketChunks, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update runs fn in a read-write transaction of the store of f
+func (f *FS) update(fn func(tx MetaTx) error) error {
+	return f.store.Update(fn)
+}
+
+// view runs fn in a read-only transaction of the store of f
+func (f *FS) view(fn func(tx MetaTx) error) error {
+	return f.store.View(fn)
+}
+
+// Close closes the store of f
+func (f *FS) Close() error {
+	return f.store.Close()
+}
@@ -0,0 +1,243 @@
+package sdfs
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// populateStore makes changes of every kind that is kept in a MetaStore
+func populateStore(t *testing.T, fs *FS) {
+	t.Helper()
+	if _, err := fs.CreateNamespace("media", 1024, 2); err != nil {
+		t.Fatal(err)
+	}
+	steps := []func() error{
+		func() error { return fs.AddDir("/empty/dir") },
+		func() error { _, err := fs.AddSizedFile("/a/b", "hash1", 10); return err },
+		func() error { _, err := fs.AddSizedFile("/a/c", "hash2", 20); return err },
+		func() error { _, err := fs.AddSizedFile("media:/x", "hash3", 30); return err },
+		func() error { _, err := fs.Link("/a/b", "/a/b2"); return err },
+		func() error { return fs.Symlink("/a", "/l") },
+		func() error { _, err := fs.Copy("media:/x", "media:/copy/x"); return err },
+		func() error { return fs.SetXattr("/a/b", "owner", "alice") },
+		func() error { return fs.AddHost("hash1", 3) },
+		func() error { return fs.DeleteFile("/a/c") },
+		func() error { return fs.SetQuota("/a", DirQuota{Bytes: 100, Files: 5}) },
+		func() error { return fs.SetTrashRetention(DefaultNamespace, time.Hour) },
+		func() error { _, err := fs.AddSizedFile("/t", "hash4", 40); return err },
+		func() error { return fs.SetXattr("/t", "owner", "bob") },
+		func() error { _, err := fs.TrashFile("/t", time.Unix(1, 0)); return err },
+		func() error { return fs.SetVersioning("/v", 3) },
+		func() error {
+			_, _, err := fs.WriteFile("/v/f", "hash5", 5, time.Unix(1, 0), WriteOptions{})
+			return err
+		},
+		func() error {
+			_, _, err := fs.WriteFile("/v/f", "hash6", 6, time.Unix(2, 0), WriteOptions{})
+			return err
+		},
+		func() error { _, err := fs.CreateSnapshot("/a", "s1", time.Unix(1, 0)); return err },
+		func() error { return fs.AddNode(3, "10.0.0.3:8000") },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+}
+
+func checkReopened(t *testing.T, fs *FS) {
+	t.Helper()
+	if _, err := fs.GetDir("/empty/dir"); err != nil {
+		t.Errorf("empty dir not restored: %v", err)
+	}
+	b, err := fs.GetFile("/l/b")
+	if err != nil {
+		t.Fatalf("file not restored through symlink: %v", err)
+	}
+	b2, err := fs.GetFile("/a/b2")
+	if err != nil || b2 != b {
+		t.Errorf("hard link not restored, have: %v, %v", b2, err)
+	}
+	if b.SemaphoreReplica != 2 || b.Size != 10 {
+		t.Errorf("want SemaphoreReplica 2 and Size 10, have: %d, %d", b.SemaphoreReplica, b.Size)
+	}
+	if len(b.Host) != 1 || b.Host[0] != 3 {
+		t.Errorf("hosts not restored, have: %v", b.Host)
+	}
+	if x, _ := fs.Xattrs("/a/b"); x["owner"] != "alice" {
+		t.Errorf("xattrs not restored, have: %v", x)
+	}
+	if x, _ := fs.Xattrs("/a/b2"); len(x) != 0 {
+		t.Errorf("want xattrs of a path not shared by its hard link, have: %v", x)
+	}
+	if _, err := fs.GetFile("/a/c"); err == nil {
+		t.Errorf("deleted file restored")
+	}
+	ns, err := fs.GetNamespace("media")
+	if err != nil || ns.Quota != 1024 || ns.ReplicaCount != 2 {
+		t.Fatalf("namespace not restored, have: %v, %v", ns, err)
+	}
+	if y, err := fs.GetFile("media:/copy/x"); err != nil || y.SemaphoreReplica != 2 {
+		t.Errorf("copy not restored, have: %v, %v", y, err)
+	}
+	if ns.Root.TotalSize() != 60 || fs.Roots[0].TotalSize() != 26 {
+		t.Errorf("sizes not restored, have: %d, %d", ns.Root.TotalSize(), fs.Roots[0].TotalSize())
+	}
+	u, err := fs.GetUsage("/a")
+	if err != nil || u.Quota != (DirQuota{Bytes: 100, Files: 5}) || u.PhysicalBytes != 20 || u.Files != 2 {
+		t.Errorf("quota or usage not restored, have: %+v, %v", u, err)
+	}
+	if r, _ := fs.TrashRetention(""); r != time.Hour {
+		t.Errorf("trash retention not restored, have: %v", r)
+	}
+	e, err := fs.GetTrash("", TrashID(time.Unix(1, 0)))
+	if err != nil || e.Path != "/t" || e.File.Pins != 1 || !e.Expires.Equal(time.Unix(3601, 0)) || e.Xattrs["owner"] != "bob" {
+		t.Errorf("trash not restored, have: %+v, %v", e, err)
+	}
+	l, err := fs.ListVersions("/v/f")
+	if err != nil || len(l) != 2 || l[1].Checksum != "hash5" || fs.ChecksumDB["hash5"].Pins != 1 {
+		t.Errorf("versions not restored, have: %+v, %v", l, err)
+	}
+	if d, _ := fs.GetDir("/v"); d.versioning() != 3 {
+		t.Errorf("versioning not restored, have: %d", d.versioning())
+	}
+	s, err := fs.GetSnapshot("/a", "s1")
+	if err != nil || s.Count != 2 || s.Size != 20 {
+		t.Errorf("snapshot not restored, have: %+v, %v", s, err)
+	}
+	if f, err := fs.GetSnapshotFile("/a@s1/b2"); err != nil || f.Checksum != "hash1" || f.Pins != 2 {
+		t.Errorf("file of snapshot not restored, have: %+v, %v", f, err)
+	}
+	if id, ok := fs.NodeID("10.0.0.3:8000"); !ok || id != 3 {
+		t.Errorf("node id not restored, have: %d, %v", id, ok)
+	}
+}
+
+func TestOpenFSMemStore(t *testing.T) {
+	store := NewMemStore()
+	fs, err := OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	populateStore(t, fs)
+	fs, err = OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReopened(t, fs)
+}
+
+func TestOpenFSBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meta.db")
+	store, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	populateStore(t, fs)
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	fs, err = OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkReopened(t, fs)
+}
+
+func TestOpenFSMigratesXattrs(t *testing.T) {
+	store := NewMemStore()
+	store.Update(func(tx MetaTx) error {
+		tx.Put(bucketFiles, "/a", []byte("hash"))
+		tx.Put(bucketFiles, "/b", []byte("hash"))
+		putJSON(tx, bucketChecksums, "hash", fileRecord{Size: 1})
+		// xattrs kept by checksum before they belonged to paths
+		putJSON(tx, bucketXattrs, "hash", map[string]string{"owner": "alice"})
+		return nil
+	})
+	fs, err := OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/a", "/b"} {
+		if x, _ := fs.Xattrs(p); x["owner"] != "alice" {
+			t.Errorf("xattrs of %s not migrated, have: %v", p, x)
+		}
+	}
+	store.View(func(tx MetaTx) error {
+		if tx.Get(bucketXattrs, "hash") != nil || tx.Get(bucketXattrs, "/a") == nil {
+			t.Errorf("xattrs record not moved to the paths")
+		}
+		return nil
+	})
+}
+
+func TestOpenFSUpgradesLayout(t *testing.T) {
+	store := NewMemStore()
+	store.Update(func(tx MetaTx) error {
+		tx.Put(bucketFiles, "/a/f", []byte("h1"))
+		tx.Put(bucketFiles, "/b/f", []byte("h1"))
+		putJSON(tx, bucketChecksums, "h1", fileRecord{Size: 1, Chunks: []string{"c1", "c1"}})
+		putJSON(tx, bucketChecksums, "h2", fileRecord{Size: 2})
+		putJSON(tx, bucketChecksums, "h3", fileRecord{Size: 3})
+		putJSON(tx, bucketChunks, "c1", chunkRecord{Size: 1})
+		putJSON(tx, bucketChunks, "c2", chunkRecord{Size: 1})
+		// snapshots kept their files in the record, h3 is referenced by
+		// nothing
+		putJSON(tx, bucketSnapshots, "/a@s", snapshotRecord{Files: map[string]string{"f": "h1", "g": "h2"}})
+		return nil
+	})
+	fs, err := OpenFS(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := fs.GetFile("/a/f"); err != nil || f.SemaphoreReplica != 2 || f.Pins != 1 {
+		t.Errorf("want h1 with 2 paths and a pin, have: %+v, %v", f, err)
+	}
+	if p, _ := fs.Paths("h1"); len(p) != 2 {
+		t.Errorf("want paths of h1 indexed, have: %v", p)
+	}
+	if f, err := fs.GetSnapshotFile("/a@s/g"); err != nil || f.Pins != 1 {
+		t.Errorf("want h2 kept by the snapshot, have: %+v, %v", f, err)
+	}
+	if s, _ := fs.GetSnapshot("/a", "s"); s.Count != 2 || s.Size != 3 {
+		t.Errorf("want snapshot of 2 files of size 3, have: %+v", s)
+	}
+	store.View(func(tx MetaTx) error {
+		if tx.Get(bucketChecksums, "h3") != nil || tx.Get(bucketChunks, "c2") != nil {
+			t.Errorf("records referenced by nothing not removed")
+		}
+		if v := tx.Get(bucketChunkRefs, linkKey("c1", "h1")); string(v) != "2" {
+			t.Errorf("want c1 twice in h1, have: %q", v)
+		}
+		return nil
+	})
+}
+
+func TestMemStoreRollback(t *testing.T) {
+	store := NewMemStore()
+	err := store.Update(func(tx MetaTx) error {
+		tx.Put(bucketFiles, "/a", []byte("hash"))
+		return ErrConflict
+	})
+	if err != ErrConflict {
+		t.Fatalf("want ErrConflict, have: %v", err)
+	}
+	store.View(func(tx MetaTx) error {
+		if v := tx.Get(bucketFiles, "/a"); v != nil {
+			t.Errorf("write of failed transaction kept: %q", v)
+		}
+		return nil
+	})
+}
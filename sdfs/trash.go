@@ -0,0 +1,447 @@
+package sdfs
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTrashRetention is how long deleted files are kept in the trash of
+// namespaces that do not set a retention of their own
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// TrashEntry is a deleted file kept in the trash of its namespace, the file is
+// pinned until the entry is restored or purged. Entries stay in memory and
+// hold their files and the files of their versions
+type TrashEntry struct {
+	ID        string
+	Namespace string
+	// Path is the qualified path the file was deleted from
+	Path    string
+	Deleted time.Time
+	// Expires is the time after which the entry is purged
+	Expires time.Time
+	File    *File
+	// Versions are the versions of the file if it was written with
+	// versioning, they are restored with it
+	Versions []*Version
+	// Xattrs are the extended attributes of the path, they are restored
+	// with the file
+	Xattrs map[string]string
+}
+
+// TrashInfo describes a TrashEntry to clients
+type TrashInfo struct {
+	ID        string    `json:"id"`
+	Namespace string    `json:"namespace"`
+	Path      string    `json:"path"`
+	Checksum  string    `json:"checksum"`
+	Size      uint64    `json:"size"`
+	Deleted   time.Time `json:"deleted"`
+	Expires   time.Time `json:"expires"`
+	Versions  int       `json:"versions,omitempty"`
+}
+
+func (e *TrashEntry) Info() TrashInfo {
+	return TrashInfo{
+		ID:        e.ID,
+		Namespace: e.Namespace,
+		Path:      e.Path,
+		Checksum:  e.File.Checksum,
+		Size:      e.File.Size,
+		Deleted:   e.Deleted,
+		Expires:   e.Expires,
+		Versions:  len(e.Versions),
+	}
+}
+
+type trashRecord struct {
+	Path     string `json:"path"`
+	Checksum string `json:"checksum"`
+	Deleted  int64  `json:"deleted"` // unix nanoseconds
+	Expires  int64  `json:"expires"`
+
+	Versions []versionRecord   `json:"versions,omitempty"`
+	Xattrs   map[string]string `json:"xattrs,omitempty"`
+}
+
+// TrashID returns the id of the entry of a file deleted at the given time,
+// ids are unique within a namespace
+func TrashID(deleted time.Time) string {
+	return strconv.FormatInt(deleted.UnixNano(), 16)
+}
+
+func trashKey(ns, id string) string {
+	if ns == "" {
+		ns = DefaultNamespace
+	}
+	return ns + "/" + id
+}
+
+// TrashRetention returns how long files deleted from namespace name are kept
+// in its trash
+func (f *FS) TrashRetention(name string) (time.Duration, error) {
+	if name == "" {
+		name = DefaultNamespace
+	}
+	f.nsMu.RLock()
+	defer f.nsMu.RUnlock()
+	ns, ok := f.Namespaces[name]
+	if !ok {
+		return 0, NewError(ErrNotFound, "namespace not exist")
+	}
+	if ns.TrashRetention <= 0 {
+		return DefaultTrashRetention, nil
+	}
+	return ns.TrashRetention, nil
+}
+
+// SetTrashRetention sets how long files deleted from namespace name are kept
+// in its trash, a retention of 0 restores the default, entries already in
+// the trash keep their expiry time
+func (f *FS) SetTrashRetention(name string, retention time.Duration) error {
+	if name == "" {
+		name = DefaultNamespace
+	}
+	if retention < 0 {
+		return NewError(ErrInvalidArgument, "negative retention")
+	}
+	f.nsMu.Lock()
+	defer f.nsMu.Unlock()
+	ns, ok := f.Namespaces[name]
+	if !ok {
+		return NewError(ErrNotFound, "namespace not exist")
+	}
+	err := f.update(func(tx MetaTx) error {
+		return putJSON(tx, bucketNamespaces, name, nsRecord{
+			Quota:          ns.Quota,
+			ReplicaCount:   ns.ReplicaCount,
+			TrashRetention: int64(retention),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	ns.TrashRetention = retention
+	return nil
+}
+
+// TrashFile moves the file at path to the trash of its namespace, deleted is
+// recorded as the deletion time and identifies the entry, symlinks are not
+// kept in the trash and should be deleted with DeleteFile
+func (f *FS) TrashFile(path string, deleted time.Time) (*TrashEntry, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	retention, err := f.TrashRetention(p.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	dir, fn, err := f.lookup(p, false)
+	if err != nil {
+		return nil, NewError(ErrNotFound, "file not exist")
+	}
+	ns := p.Namespace
+	if ns == "" {
+		ns = DefaultNamespace
+	}
+	id := TrashID(deleted)
+	key := trashKey(ns, id)
+	f.trashMu.Lock()
+	defer f.trashMu.Unlock()
+	if _, ok := f.Trash[key]; ok {
+		return nil, NewError(ErrAlreadyExists, "trash entry exists")
+	}
+	if err := f.lock(dir); err != nil {
+		return nil, err
+	}
+	defer f.unlock(dir)
+	file, ok := dir.Files[fn]
+	if !ok {
+		return nil, NewError(ErrNotFound, "file not exist")
+	}
+	if !file.mu.TryLock() {
+		return nil, NewError(ErrBusy, "File not available")
+	}
+	defer file.mu.Unlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e := &TrashEntry{
+		ID:        id,
+		Namespace: ns,
+		Path:      dir.FullPath + fn,
+		Deleted:   deleted,
+		Expires:   deleted.Add(retention),
+		File:      file,
+		Versions:  dir.Versions[fn],
+		Xattrs:    dir.Xattrs[fn],
+	}
+	r := trashRecord{
+		Path:     e.Path,
+		Checksum: file.Checksum,
+		Deleted:  deleted.UnixNano(),
+		Expires:  e.Expires.UnixNano(),
+		Versions: versionRecords(e.Versions),
+		Xattrs:   e.Xattrs,
+	}
+	// the path of the file becomes a pin of the entry, prior versions are
+	// pinned already
+	changes := refs{}
+	changes.add(file.Checksum, -1, 1)
+	err = f.update(func(tx MetaTx) error {
+		if _, err := f.putRefs(tx, changes); err != nil {
+			return err
+		}
+		if err := deleteLink(tx, e.Path, file.Checksum); err != nil {
+			return err
+		}
+		if err := tx.Delete(bucketVersions, e.Path); err != nil {
+			return err
+		}
+		if err := tx.Delete(bucketXattrs, e.Path); err != nil {
+			return err
+		}
+		return putJSON(tx, bucketTrash, key, r)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the versions move to the entry with what they hold
+	delete(dir.Versions, fn)
+	delete(dir.Xattrs, fn)
+	e.File = f.hold(file)
+	f.unlink(dir, fn, file)
+	f.applyRefs(changes)
+	f.Trash[key] = e
+	return e, nil
+}
+
+// GetTrash returns the trash entry with the given id in namespace ns
+func (f *FS) GetTrash(ns, id string) (*TrashEntry, error) {
+	f.trashMu.RLock()
+	defer f.trashMu.RUnlock()
+	e, ok := f.Trash[trashKey(ns, id)]
+	if !ok {
+		return nil, NewError(ErrNotFound, "trash entry not exist")
+	}
+	return e, nil
+}
+
+// ListTrash returns the trash entries of namespace ns sorted by deletion time,
+// entries of all namespaces are returned if ns is empty
+func (f *FS) ListTrash(ns string) []*TrashEntry {
+	f.trashMu.RLock()
+	defer f.trashMu.RUnlock()
+	var l []*TrashEntry
+	for _, e := range f.Trash {
+		if ns == "" || e.Namespace == ns {
+			l = append(l, e)
+		}
+	}
+	sortTrash(l)
+	return l
+}
+
+// ExpiredTrash returns the trash entries that expire before now
+func (f *FS) ExpiredTrash(now time.Time) []*TrashEntry {
+	f.trashMu.RLock()
+	defer f.trashMu.RUnlock()
+	var l []*TrashEntry
+	for _, e := range f.Trash {
+		if e.Expires.Before(now) {
+			l = append(l, e)
+		}
+	}
+	sortTrash(l)
+	return l
+}
+
+func sortTrash(l []*TrashEntry) {
+	sort.Slice(l, func(i, j int) bool {
+		if !l[i].Deleted.Equal(l[j].Deleted) {
+			return l[i].Deleted.Before(l[j].Deleted)
+		}
+		return l[i].Namespace < l[j].Namespace
+	})
+}
+
+// RestoreTrash puts the file of a trash entry back at path, or at the path it
+// was deleted from if path is empty, and removes the entry
+func (f *FS) RestoreTrash(ns, id, path string) (*File, error) {
+	key := trashKey(ns, id)
+	f.trashMu.Lock()
+	defer f.trashMu.Unlock()
+	e, ok := f.Trash[key]
+	if !ok {
+		return nil, NewError(ErrNotFound, "trash entry not exist")
+	}
+	if path == "" {
+		path = e.Path
+	}
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	if trashKey(p.Namespace, id) != key {
+		return nil, NewError(ErrInvalidArgument, "can not restore across namespaces")
+	}
+	if p.IsRoot() {
+		return nil, &PathError{p.String(), "no file name"}
+	}
+	dir, err := f.addDir(p.Dir())
+	if err != nil {
+		return nil, err
+	}
+	name := p.Base()
+	// the file is checked against the quota of path first unless the path
+	// holds it already
+	if err := f.rlock(dir); err != nil {
+		return nil, err
+	}
+	held := dir.Files[name] == e.File
+	dir.mu.RUnlock()
+	if !held {
+		f.mu.Lock()
+		physical := uint64(e.File.physical())
+		f.mu.Unlock()
+		if err := f.checkQuota(p, e.File.Size, physical, 1); err != nil {
+			return nil, err
+		}
+	}
+	if err := f.lock(dir); err != nil {
+		return nil, err
+	}
+	defer f.unlock(dir)
+	path = dir.FullPath + name
+	// a path that holds the file already is left as it is, versions are
+	// only restored to a free path so that they do not mix with the ones
+	// of another file
+	cur, ok := dir.Files[name]
+	if ok && (cur != e.File || len(e.Versions) != 0) {
+		if len(e.Versions) != 0 {
+			return nil, NewError(ErrAlreadyExists, "file exists")
+		}
+		return nil, NewError(ErrConflict, "a file with different checksum exists at %s", path)
+	}
+	if !ok && dir.exists(name) {
+		return nil, NewError(ErrConflict, "a directory or symlink exists at %s", path)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// the pin of the entry becomes a path of the file unless the path holds
+	// it already
+	r := refs{}
+	if ok {
+		r.add(e.File.Checksum, 0, -1)
+	} else {
+		r.add(e.File.Checksum, 1, -1)
+	}
+	err = f.update(func(tx MetaTx) error {
+		if _, err := f.putRefs(tx, r); err != nil {
+			return err
+		}
+		if !ok {
+			if err := putLink(tx, path, e.File.Checksum); err != nil {
+				return err
+			}
+			if err := putVersions(tx, path, e.Versions); err != nil {
+				return err
+			}
+			if err := putXattrs(tx, path, e.Xattrs); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(bucketTrash, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		f.linkFile(dir, name, e.File)
+		// the versions move to the directory with what they hold
+		if len(e.Versions) != 0 {
+			dir.Versions[name] = e.Versions
+		}
+		dir.setXattrs(name, e.Xattrs)
+	}
+	f.release(e.File)
+	f.applyRefs(r)
+	delete(f.Trash, key)
+	return e.File, nil
+}
+
+// PurgeTrash removes a trash entry, the files of the entry and its versions
+// that nothing else references are removed from the FS and returned so that
+// their content can be deleted from nodes
+func (f *FS) PurgeTrash(ns, id string) ([]*File, error) {
+	key := trashKey(ns, id)
+	f.trashMu.Lock()
+	defer f.trashMu.Unlock()
+	e, ok := f.Trash[key]
+	if !ok {
+		return nil, NewError(ErrNotFound, "trash entry not exist")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// the entry pins its file and the files of prior versions, the last
+	// version is the file itself
+	r := refs{}
+	r.add(e.File.Checksum, 0, -1)
+	for i := 0; i < len(e.Versions)-1; i++ {
+		r.add(e.Versions[i].File.Checksum, 0, -1)
+	}
+	var orphans []*File
+	err := f.update(func(tx MetaTx) error {
+		var err error
+		if orphans, err = f.putRefs(tx, r); err != nil {
+			return err
+		}
+		return tx.Delete(bucketTrash, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.release(e.File)
+	for _, v := range e.Versions {
+		f.release(v.File)
+	}
+	f.applyRefs(r)
+	delete(f.Trash, key)
+	return orphans, nil
+}
+
+// loadTrash restores the trash entries kept in tx and holds their files
+func (f *FS) loadTrash(tx MetaTx) error {
+	return tx.ForEach(bucketTrash, "", func(k string, v []byte) error {
+		var r trashRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		i := strings.Index(k, "/")
+		if i < 0 {
+			return NewError(ErrInvalidArgument, "invalid trash key %s", k)
+		}
+		file, err := f.readFile(tx, r.Checksum)
+		if err != nil {
+			return err
+		}
+		versions, err := f.readVersions(tx, r.Versions)
+		if err != nil {
+			return err
+		}
+		f.Trash[k] = &TrashEntry{
+			ID:        k[i+1:],
+			Namespace: k[:i],
+			Path:      r.Path,
+			Deleted:   time.Unix(0, r.Deleted),
+			Expires:   time.Unix(0, r.Expires),
+			File:      f.hold(file),
+			Versions:  versions,
+			Xattrs:    r.Xattrs,
+		}
+		return nil
+	})
+}
@@ -0,0 +1,93 @@
+package sdfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFsTrash(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/a/b", "h1", 10)
+	fs.AddSizedFile("/a/c", "h2", 20)
+	now := time.Unix(1000, 0)
+
+	e, err := fs.TrashFile("/a/b", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Namespace != DefaultNamespace || e.Path != "/a/b" || !e.Expires.Equal(now.Add(DefaultTrashRetention)) {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if _, err := fs.GetFile("/a/b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want trashed file removed from its path, have: %v", err)
+	}
+	if _, ok := fs.ChecksumDB["h1"]; !ok {
+		t.Errorf("trashed file removed from ChecksumDB")
+	}
+	if fs.Roots[0].TotalSize() != 20 {
+		t.Errorf("want trashed file not counted, have size: %d", fs.Roots[0].TotalSize())
+	}
+
+	if _, err := fs.RestoreTrash(DefaultNamespace, e.ID, "/restored/b"); err != nil {
+		t.Fatal(err)
+	}
+	if file, err := fs.GetFile("/restored/b"); err != nil || file.Pins != 0 || file.SemaphoreReplica != 1 {
+		t.Errorf("file not restored, have: %+v, %v", file, err)
+	}
+	if len(fs.ListTrash("")) != 0 {
+		t.Errorf("want restored entry removed from the trash")
+	}
+
+	e, _ = fs.TrashFile("/a/c", now)
+	fs.AddSizedFile("/a/c", "h3", 5)
+	if _, err := fs.RestoreTrash("", e.ID, ""); !errors.Is(err, ErrConflict) {
+		t.Errorf("want conflict restoring over another file, have: %v", err)
+	}
+	if l := fs.ExpiredTrash(now.Add(time.Hour)); len(l) != 0 {
+		t.Errorf("want no expired entries, have: %d", len(l))
+	}
+	if l := fs.ExpiredTrash(e.Expires.Add(time.Second)); len(l) != 1 || l[0] != e {
+		t.Errorf("want entry expired, have: %v", l)
+	}
+	orphans, err := fs.PurgeTrash("", e.ID)
+	if err != nil || len(orphans) != 1 || orphans[0].Checksum != "h2" {
+		t.Errorf("want orphan h2, have: %v, %v", orphans, err)
+	}
+	if _, ok := fs.ChecksumDB["h2"]; ok {
+		t.Errorf("purged file still in ChecksumDB")
+	}
+}
+
+func TestFsTrashShared(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/a", "h1", 10)
+	fs.Link("/a", "/b")
+	if _, err := fs.CreateNamespace("media", 0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetTrashRetention("media", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	fs.AddSizedFile("media:/x", "h1", 10)
+	now := time.Unix(1000, 0)
+	e, err := fs.TrashFile("media:/x", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Namespace != "media" || !e.Expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected entry: %+v", e)
+	}
+	if _, err := fs.RestoreTrash("media", e.ID, "/x"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want restore across namespaces refused, have: %v", err)
+	}
+	if err := fs.DeleteNamespace("media"); !errors.Is(err, ErrConflict) {
+		t.Errorf("want namespace with trash not deleted, have: %v", err)
+	}
+	if orphans, err := fs.PurgeTrash("media", e.ID); err != nil || len(orphans) != 0 {
+		t.Errorf("want no orphan for a file with other paths, have: %v, %v", orphans, err)
+	}
+	if err := fs.DeleteNamespace("media"); err != nil {
+		t.Error(err)
+	}
+}
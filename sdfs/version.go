@@ -0,0 +1,248 @@
+package sdfs
+
+import (
+	"strconv"
+	"time"
+)
+
+// Version is a version of the file at a path, versions other than the
+// current one pin their files
+type Version struct {
+	ID      string
+	Created time.Time
+	File    *File
+}
+
+// VersionInfo describes a Version to clients
+type VersionInfo struct {
+	ID       string    `json:"id"`
+	Checksum string    `json:"checksum"`
+	Size     uint64    `json:"size"`
+	Created  time.Time `json:"created"`
+	Current  bool      `json:"current"`
+}
+
+type versionRecord struct {
+	ID       string `json:"id"`
+	Checksum string `json:"checksum"`
+	Created  int64  `json:"created"` // unix nanoseconds
+}
+
+// VersionID returns the id of a version written at the given time, ids are
+// unique within a path
+func VersionID(created time.Time) string {
+	return strconv.FormatInt(created.UnixNano(), 16)
+}
+
+func versionRecords(versions []*Version) []versionRecord {
+	var r []versionRecord
+	for _, v := range versions {
+		r = append(r, versionRecord{ID: v.ID, Checksum: v.File.Checksum, Created: v.Created.UnixNano()})
+	}
+	return r
+}
+
+func putVersions(tx MetaTx, path string, versions []*Version) error {
+	if len(versions) == 0 {
+		return tx.Delete(bucketVersions, path)
+	}
+	return putJSON(tx, bucketVersions, path, versionRecords(versions))
+}
+
+// SetVersioning keeps keep prior versions of files written in the tree of the
+// directory at path, the directory is created if it does not exist, 0 falls
+// back to the setting of the parent directory
+func (f *FS) SetVersioning(path string, keep int) error {
+	if keep < 0 {
+		return NewError(ErrInvalidArgument, "negative number of versions")
+	}
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	dir, err := f.addDir(p)
+	if err != nil {
+		return err
+	}
+	dir.mu.Lock()
+	defer dir.mu.Unlock()
+	err = f.update(func(tx MetaTx) error {
+		if keep == 0 {
+			return tx.Delete(bucketVersioning, dir.FullPath)
+		}
+		return tx.Put(bucketVersioning, dir.FullPath, []byte(strconv.Itoa(keep)))
+	})
+	if err != nil {
+		return err
+	}
+	dir.Versioning = keep
+	return nil
+}
+
+// versioning returns the number of prior versions kept for files in d, 0 if
+// the tree of d is not versioned
+func (d *Directory) versioning() int {
+	for ; d != nil; d = d.Parent {
+		d.mu.RLock()
+		keep := d.Versioning
+		d.mu.RUnlock()
+		if keep != 0 {
+			return keep
+		}
+	}
+	return 0
+}
+
+// versions returns the versions of the file name in d, files written without
+// versioning have a single version, d.mu should be held by the caller
+func (d *Directory) versions(name string) ([]*Version, error) {
+	file, ok := d.Files[name]
+	if !ok {
+		return nil, NewError(ErrNotFound, "file not exist")
+	}
+	if v := d.Versions[name]; len(v) != 0 {
+		return v, nil
+	}
+	return []*Version{{ID: VersionID(file.ModTime), Created: file.ModTime, File: file}}, nil
+}
+
+// lookupFile resolves the path of a file like GetFile
+func (f *FS) lookupFile(path string) (*Directory, string, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, "", err
+	}
+	dir, name, err := f.lookup(p, true)
+	if err == errTooManyLinks {
+		return nil, "", err
+	} else if err != nil {
+		return nil, "", NewError(ErrNotFound, "file not exist")
+	}
+	return dir, name, nil
+}
+
+// ListVersions returns the versions of the file at path, newest first
+func (f *FS) ListVersions(path string) ([]VersionInfo, error) {
+	dir, name, err := f.lookupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.rlock(dir); err != nil {
+		return nil, err
+	}
+	defer dir.mu.RUnlock()
+	versions, err := dir.versions(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]VersionInfo, 0, len(versions))
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		infos = append(infos, VersionInfo{
+			ID:       v.ID,
+			Checksum: v.File.Checksum,
+			Size:     v.File.Size,
+			Created:  v.Created,
+			Current:  i == len(versions)-1,
+		})
+	}
+	return infos, nil
+}
+
+// GetVersion returns the file of the version id of the file at path
+func (f *FS) GetVersion(path, id string) (*File, error) {
+	dir, name, err := f.lookupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.rlock(dir); err != nil {
+		return nil, err
+	}
+	defer dir.mu.RUnlock()
+	versions, err := dir.versions(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if v.ID == id {
+			return v.File, nil
+		}
+	}
+	return nil, NewError(ErrNotFound, "version not exist")
+}
+
+// DeleteVersion deletes the version id of the file at path, deleting the
+// current version makes the previous one current, the only version of a file
+// can not be deleted. The file of the version is removed and returned if
+// nothing else references it
+func (f *FS) DeleteVersion(path, id string) ([]*File, error) {
+	dir, name, err := f.lookupFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.lock(dir); err != nil {
+		return nil, err
+	}
+	defer f.unlock(dir)
+	versions, err := dir.versions(name)
+	if err != nil {
+		return nil, err
+	}
+	i := -1
+	for k, v := range versions {
+		if v.ID == id {
+			i = k
+			break
+		}
+	}
+	if i < 0 {
+		return nil, NewError(ErrNotFound, "version not exist")
+	}
+	last := len(versions) - 1
+	if last == 0 {
+		return nil, NewError(ErrConflict, "only version of the file, delete the file instead")
+	}
+	path = dir.FullPath + name
+	kept := make([]*Version, 0, last)
+	kept = append(kept, versions[:i]...)
+	kept = append(kept, versions[i+1:]...)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	vf := versions[i].File
+	prev := versions[last-1].File
+	// the current version is a path of its file, prior versions pin theirs
+	r := refs{}
+	if i == last {
+		r.add(vf.Checksum, -1, 0)
+		r.add(prev.Checksum, 1, -1)
+	} else {
+		r.add(vf.Checksum, 0, -1)
+	}
+	var orphans []*File
+	err = f.update(func(tx MetaTx) error {
+		var err error
+		if orphans, err = f.putRefs(tx, r); err != nil {
+			return err
+		}
+		if i == last {
+			if err := deleteLink(tx, path, vf.Checksum); err != nil {
+				return err
+			}
+			if err := putLink(tx, path, prev.Checksum); err != nil {
+				return err
+			}
+		}
+		return putVersions(tx, path, kept)
+	})
+	if err != nil {
+		return nil, err
+	}
+	f.setVersions(dir, name, kept)
+	if i == last {
+		f.unlink(dir, name, vf)
+		f.linkFile(dir, name, prev)
+	}
+	f.applyRefs(r)
+	return orphans, nil
+}
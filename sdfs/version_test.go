@@ -0,0 +1,129 @@
+package sdfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFsWriteFileVersions(t *testing.T) {
+	fs := NewFS()
+	if _, _, err := fs.WriteFile("/a/f", "h1", 10, time.Unix(1, 0), WriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fs.WriteFile("/a/f", "h2", 20, time.Unix(2, 0), WriteOptions{}); !errors.Is(err, ErrConflict) {
+		t.Errorf("want conflict without versioning, have: %v", err)
+	}
+	if err := fs.SetVersioning("/a", 2); err != nil {
+		t.Fatal(err)
+	}
+	for i, h := range []string{"h2", "h3", "h4"} {
+		_, orphans, err := fs.WriteFile("/a/f", h, 20, time.Unix(int64(i+2), 0), WriteOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// h1 falls out of the two kept prior versions with the last write
+		if i == 2 && (len(orphans) != 1 || orphans[0].Checksum != "h1") {
+			t.Errorf("want h1 dropped, have: %v", orphans)
+		}
+	}
+	l, err := fs.ListVersions("/a/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 3 || l[0].Checksum != "h4" || !l[0].Current || l[2].Checksum != "h2" {
+		t.Errorf("unexpected versions: %+v", l)
+	}
+	if _, ok := fs.ChecksumDB["h1"]; ok {
+		t.Errorf("dropped version still in ChecksumDB")
+	}
+	if file, err := fs.GetVersion("/a/f", VersionID(time.Unix(3, 0))); err != nil || file.Checksum != "h3" || file.Pins != 1 {
+		t.Errorf("want pinned h3, have: %+v, %v", file, err)
+	}
+	if fs.Roots[0].TotalSize() != 20 {
+		t.Errorf("want prior versions not counted, have size: %d", fs.Roots[0].TotalSize())
+	}
+	if err := fs.DeleteFile("/a/f"); !errors.Is(err, ErrConflict) {
+		t.Errorf("want file with versions not deleted, have: %v", err)
+	}
+}
+
+func TestFsDeleteVersion(t *testing.T) {
+	fs := NewFS()
+	fs.SetVersioning("/", 5)
+	for i, h := range []string{"h1", "h2", "h3"} {
+		fs.WriteFile("/f", h, 10, time.Unix(int64(i+1), 0), WriteOptions{})
+	}
+	orphans, err := fs.DeleteVersion("/f", VersionID(time.Unix(1, 0)))
+	if err != nil || len(orphans) != 1 || orphans[0].Checksum != "h1" {
+		t.Errorf("want h1 removed, have: %v, %v", orphans, err)
+	}
+	// deleting the current version makes the previous one current
+	orphans, err = fs.DeleteVersion("/f", VersionID(time.Unix(3, 0)))
+	if err != nil || len(orphans) != 1 || orphans[0].Checksum != "h3" {
+		t.Errorf("want h3 removed, have: %v, %v", orphans, err)
+	}
+	file, err := fs.GetFile("/f")
+	if err != nil || file.Checksum != "h2" || file.Pins != 0 || file.SemaphoreReplica != 1 {
+		t.Errorf("want h2 current, have: %+v, %v", file, err)
+	}
+	if _, err := fs.DeleteVersion("/f", VersionID(time.Unix(2, 0))); !errors.Is(err, ErrConflict) {
+		t.Errorf("want the only version kept, have: %v", err)
+	}
+	if _, err := fs.DeleteVersion("/f", "ff"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want version not found, have: %v", err)
+	}
+}
+
+func TestFsTrashVersions(t *testing.T) {
+	fs := NewFS()
+	fs.SetVersioning("/", 5)
+	fs.WriteFile("/f", "h1", 10, time.Unix(1, 0), WriteOptions{})
+	fs.WriteFile("/f", "h2", 10, time.Unix(2, 0), WriteOptions{})
+	e, err := fs.TrashFile("/f", time.Unix(3, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.RestoreTrash("", e.ID, "/g"); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := fs.ListVersions("/g"); err != nil || len(l) != 2 {
+		t.Errorf("want versions restored, have: %v, %v", l, err)
+	}
+	e, _ = fs.TrashFile("/g", time.Unix(4, 0))
+	orphans, err := fs.PurgeTrash("", e.ID)
+	if err != nil || len(orphans) != 2 {
+		t.Errorf("want both versions removed, have: %v, %v", orphans, err)
+	}
+	if len(fs.ChecksumDB) != 0 {
+		t.Errorf("want no files left, have: %v", fs.ChecksumDB)
+	}
+}
+
+func TestFsRenameVersions(t *testing.T) {
+	fs := NewFS()
+	fs.SetVersioning("/a", 5)
+	fs.WriteFile("/a/f", "h1", 10, time.Unix(1, 0), WriteOptions{})
+	fs.WriteFile("/a/f", "h2", 20, time.Unix(2, 0), WriteOptions{})
+	if err := fs.Rename("/a/f", "/b/g"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ListVersions("/a/f"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("want versions gone from the old path, have: %v", err)
+	}
+	l, err := fs.ListVersions("/b/g")
+	if err != nil || len(l) != 2 || l[0].Checksum != "h2" || !l[0].Current || l[1].Checksum != "h1" {
+		t.Errorf("want versions moved, have: %+v, %v", l, err)
+	}
+	if file, err := fs.GetVersion("/b/g", VersionID(time.Unix(1, 0))); err != nil || file.Checksum != "h1" || file.Pins != 1 {
+		t.Errorf("want pinned h1 at the new path, have: %+v, %v", file, err)
+	}
+	a, _ := fs.GetDir("/a")
+	b, _ := fs.GetDir("/b")
+	if a.TotalSize() != 0 || b.TotalSize() != 20 {
+		t.Errorf("sizes not moved, have: /a %d, /b %d", a.TotalSize(), b.TotalSize())
+	}
+	if p, _ := fs.Paths("h2"); len(p) != 1 || p[0] != "/b/g" {
+		t.Errorf("paths not updated, have: %v", p)
+	}
+}
@@ -0,0 +1,239 @@
+package sdfs
+
+import (
+	"errors"
+	"time"
+)
+
+// WriteOptions controls how WriteFile treats a file that already exists at
+// the path and the attributes it sets on the path
+type WriteOptions struct {
+	// Overwrite replaces a file with a different checksum instead of failing,
+	// files in versioned directories are always replaced
+	Overwrite bool
+	// IfMatch writes only if the current file at the path has this
+	// checksum, it implies Overwrite
+	IfMatch string
+	// IfNoneMatch writes only if there is no file at the path
+	IfNoneMatch bool
+	// Xattrs are extended attributes set on the path with the write, other
+	// attributes of the path are kept
+	Xattrs map[string]string
+}
+
+// check checks the preconditions of o against old, the file currently at
+// path, old is nil if there is none
+func (o WriteOptions) check(path string, old *File) error {
+	if o.IfNoneMatch && old != nil {
+		return NewError(ErrPreconditionFailed, "a file exists at %s", path)
+	}
+	if o.IfMatch != "" && (old == nil || old.Checksum != o.IfMatch) {
+		return NewError(ErrPreconditionFailed, "checksum of %s does not match", path)
+	}
+	return nil
+}
+
+func (o WriteOptions) overwrite() bool {
+	return o.Overwrite || o.IfMatch != ""
+}
+
+// CheckWrite checks the preconditions of opts against the file at path
+// without writing anything, so that uploads that are going to fail are
+// refused early, WriteFile checks them again
+func (f *FS) CheckWrite(path string, opts WriteOptions) error {
+	p, err := ParsePath(path)
+	if err != nil {
+		return err
+	}
+	dir, name, err := f.lookup(p, false)
+	if err != nil {
+		// the directory does not exist yet, neither does the file
+		return opts.check(path, nil)
+	}
+	if err := f.rlock(dir); err != nil {
+		return err
+	}
+	defer dir.mu.RUnlock()
+	return opts.check(path, dir.Files[name])
+}
+
+// WriteFile adds a file at path like AddFileAt, mtime is the time of the
+// write. A different file at path is replaced if opts allows it or the
+// directory is versioned, in which case the replaced file is kept as a prior
+// version. The swap is atomic, readers see either the old or the new file.
+// Files that are left unreferenced, the replaced file or prior versions
+// beyond the limit, are removed and returned so that their content can be
+// deleted from nodes
+func (f *FS) WriteFile(path, hash string, size uint64, mtime time.Time, opts WriteOptions) (*File, []*File, error) {
+	return f.WriteChunked(path, hash, size, mtime, nil, opts)
+}
+
+// WriteChunked works like WriteFile, chunks is the manifest of the content
+// if it was uploaded in chunks. It is used only when the content is new to
+// the FS, content already in the FS keeps how it is stored
+func (f *FS) WriteChunked(path, hash string, size uint64, mtime time.Time, chunks []ChunkRef, opts WriteOptions) (*File, []*File, error) {
+	return f.writeFile(path, hash, size, mtime, chunks, Erasure{}, opts)
+}
+
+// WriteErasure works like WriteChunked for content stored as the shards of
+// the erasure-coded storage class e
+func (f *FS) WriteErasure(path, hash string, size uint64, mtime time.Time, shards []ChunkRef, e Erasure, opts WriteOptions) (*File, []*File, error) {
+	if len(shards) != e.Shards() {
+		return nil, nil, NewError(ErrInvalidArgument, "want %d shards, have %d", e.Shards(), len(shards))
+	}
+	return f.writeFile(path, hash, size, mtime, shards, e, opts)
+}
+
+func (f *FS) writeFile(path, hash string, size uint64, mtime time.Time, chunks []ChunkRef, e Erasure, opts WriteOptions) (*File, []*File, error) {
+	p, err := ParsePath(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.IsRoot() {
+		return nil, nil, &PathError{path, "no file name"}
+	}
+	dir, err := f.addDir(p.Dir())
+	if err != nil {
+		return nil, nil, err
+	}
+	keep := dir.versioning()
+	fname := p.Base()
+	path = dir.FullPath + fname
+
+	if err := f.lock(dir); err != nil {
+		return nil, nil, err
+	}
+	defer f.unlock(dir)
+	old := dir.Files[fname]
+	if err := opts.check(path, old); err != nil {
+		return nil, nil, err
+	}
+	xattrs := dir.Xattrs[fname]
+	if len(opts.Xattrs) != 0 {
+		xattrs = copyXattrs(xattrs)
+		if err := addXattrs(xattrs, opts.Xattrs); err != nil {
+			return nil, nil, err
+		}
+	}
+	if old != nil && old.Checksum == hash {
+		if len(opts.Xattrs) != 0 {
+			err := f.update(func(tx MetaTx) error {
+				return putXattrs(tx, path, xattrs)
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			dir.setXattrs(fname, xattrs)
+		}
+		return old, nil, nil
+	}
+	_, isDir := dir.SubDirs[fname]
+	_, isLink := dir.Symlinks[fname]
+	if isDir || isLink {
+		return nil, nil, NewError(ErrConflict, "a directory or symlink exists at %s", path)
+	}
+	if old != nil && keep == 0 && !opts.overwrite() {
+		return nil, nil, NewError(ErrConflict, "a file with different checksum exists at %s", path)
+	}
+	history := dir.Versions[fname]
+	if keep > 0 && old != nil && len(history) == 0 {
+		// the file was written before versioning was enabled
+		history = []*Version{{ID: VersionID(old.ModTime), Created: old.ModTime, File: old}}
+	}
+	if n := len(history); keep > 0 && n != 0 && !mtime.After(history[n-1].Created) {
+		return nil, nil, NewError(ErrConflict, "a newer version exists at %s", path)
+	}
+
+	// r collects the changes of the paths and pins of files made by the
+	// write, files left with neither are orphans
+	r := refs{}
+	r.add(hash, 1, 0)
+	if old != nil {
+		r.add(old.Checksum, -1, 0)
+	}
+	var versions, dropped []*Version
+	current := &Version{ID: VersionID(mtime), Created: mtime}
+	if keep > 0 {
+		versions = append(history, current)
+		if old != nil {
+			r.add(old.Checksum, 0, 1)
+		}
+		if n := len(versions) - 1 - keep; n > 0 {
+			dropped, versions = versions[:n], versions[n:]
+		}
+	} else if len(history) > 1 {
+		// versioning was turned off, the history goes with the replaced file
+		dropped = history[:len(history)-1]
+	}
+	for _, v := range dropped {
+		r.add(v.File.Checksum, 0, -1)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var file *File
+	var orphans []*File
+	err = f.update(func(tx MetaTx) error {
+		var err error
+		file, err = f.readFile(tx, hash)
+		if errors.Is(err, ErrNotFound) {
+			file, err = f.newFile(tx, hash, size, mtime, chunks, e)
+		}
+		if err != nil {
+			return err
+		}
+		current.File = file
+		if orphans, err = f.putRefs(tx, r); err != nil {
+			return err
+		}
+		if err := putVersions(tx, path, versions); err != nil {
+			return err
+		}
+		if len(opts.Xattrs) != 0 {
+			if err := putXattrs(tx, path, xattrs); err != nil {
+				return err
+			}
+		}
+		if old != nil {
+			if err := deleteLink(tx, path, old.Checksum); err != nil {
+				return err
+			}
+		}
+		return putLink(tx, path, hash)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// the replaced file stays cached until the versions hold it
+	f.setVersions(dir, fname, versions)
+	if old != nil {
+		f.unlink(dir, fname, old)
+	}
+	file = f.linkFile(dir, fname, file)
+	f.applyRefs(r)
+	dir.setXattrs(fname, xattrs)
+	return file, orphans, nil
+}
+
+// newFile writes the record of a file new to the store whose content is
+// chunks, or a single object if there are none, and returns it. f.mu should
+// be held by the caller
+func (f *FS) newFile(tx MetaTx, hash string, size uint64, mtime time.Time, chunks []ChunkRef, e Erasure) (*File, error) {
+	file := NewFile(hash, size)
+	file.ModTime = mtime
+	if len(chunks) != 0 {
+		file.Chunks = make([]*Chunk, len(chunks))
+		for i, ref := range chunks {
+			c, err := f.readChunk(tx, ref.Hash)
+			if errors.Is(err, ErrNotFound) {
+				c, err = &Chunk{Hash: ref.Hash, Size: ref.Size}, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			file.Chunks[i] = c
+		}
+		file.Erasure = e
+	}
+	return file, putNewFile(tx, file)
+}
@@ -0,0 +1,82 @@
+package sdfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFsWriteFileOverwrite(t *testing.T) {
+	fs := NewFS()
+	fs.AddSizedFile("/a", "h1", 10)
+	fs.AddSizedFile("/b", "h2", 20)
+	fs.Link("/b", "/b2")
+	now := time.Unix(1, 0)
+
+	if _, _, err := fs.WriteFile("/a", "h3", 30, now, WriteOptions{}); !errors.Is(err, ErrConflict) {
+		t.Errorf("want conflict without overwrite, have: %v", err)
+	}
+	file, orphans, err := fs.WriteFile("/a", "h3", 30, now, WriteOptions{Overwrite: true})
+	if err != nil || file.Checksum != "h3" {
+		t.Fatalf("want h3 written, have: %v, %v", file, err)
+	}
+	if len(orphans) != 1 || orphans[0].Checksum != "h1" {
+		t.Errorf("want h1 orphaned, have: %v", orphans)
+	}
+	if _, ok := fs.ChecksumDB["h1"]; ok {
+		t.Errorf("replaced file still in ChecksumDB")
+	}
+	// the content of /b is still linked at /b2
+	if _, orphans, err = fs.WriteFile("/b", "h3", 30, now, WriteOptions{Overwrite: true}); err != nil || len(orphans) != 0 {
+		t.Errorf("want no orphans, have: %v, %v", orphans, err)
+	}
+	if f, _ := fs.GetFile("/a"); f.SemaphoreReplica != 2 {
+		t.Errorf("want h3 shared by /a and /b, have: %d", f.SemaphoreReplica)
+	}
+	if fs.Roots[0].TotalSize() != 80 || fs.Roots[0].FileCount != 3 {
+		t.Errorf("want 80 bytes in 3 files, have: %d, %d", fs.Roots[0].TotalSize(), fs.Roots[0].FileCount)
+	}
+}
+
+func TestFsWriteFileConditions(t *testing.T) {
+	fs := NewFS()
+	now := time.Unix(1, 0)
+	tests := []struct {
+		path string
+		hash string
+		opts WriteOptions
+		err  error
+	}{
+		{"/a", "h1", WriteOptions{IfNoneMatch: true}, nil},
+		{"/a", "h1", WriteOptions{IfNoneMatch: true}, ErrPreconditionFailed},
+		{"/a", "h2", WriteOptions{IfMatch: "h0"}, ErrPreconditionFailed},
+		{"/a", "h2", WriteOptions{IfMatch: "h1"}, nil},
+		{"/b", "h2", WriteOptions{IfMatch: "h1"}, ErrPreconditionFailed},
+	}
+	for _, tt := range tests {
+		if err := fs.CheckWrite(tt.path, tt.opts); !errors.Is(err, tt.err) {
+			t.Errorf("CheckWrite(%q, %+v) = %v, want %v", tt.path, tt.opts, err, tt.err)
+		}
+		if _, _, err := fs.WriteFile(tt.path, tt.hash, 10, now, tt.opts); !errors.Is(err, tt.err) && !(err == nil && tt.err == nil) {
+			t.Errorf("WriteFile(%q, %q, %+v) = %v, want %v", tt.path, tt.hash, tt.opts, err, tt.err)
+		}
+	}
+	if f, _ := fs.GetFile("/a"); f.Checksum != "h2" {
+		t.Errorf("want h2 at /a, have: %s", f.Checksum)
+	}
+}
+
+func TestFsWriteFileVersioningOff(t *testing.T) {
+	fs := NewFS()
+	fs.SetVersioning("/", 3)
+	fs.WriteFile("/f", "h1", 10, time.Unix(1, 0), WriteOptions{})
+	fs.WriteFile("/f", "h2", 10, time.Unix(2, 0), WriteOptions{})
+	fs.SetVersioning("/", 0)
+	_, orphans, err := fs.WriteFile("/f", "h3", 10, time.Unix(3, 0), WriteOptions{Overwrite: true})
+	if err != nil || len(orphans) != 2 {
+		t.Errorf("want h1 and h2 orphaned, have: %v, %v", orphans, err)
+	}
+	if l, _ := fs.ListVersions("/f"); len(l) != 1 || l[0].Checksum != "h3" {
+		t.Errorf("want history dropped, have: %+v", l)
+	}
+}